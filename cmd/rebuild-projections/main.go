@@ -0,0 +1,68 @@
+// Command rebuild-projections regenerates the companies table and the company
+// snapshots from the company event log used by COMPANY_STORAGE=eventstore.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/ktsiligkos/xm_project/internal/correlation"
+	"github.com/ktsiligkos/xm_project/internal/platform/database"
+	companyeventstore "github.com/ktsiligkos/xm_project/internal/repository/company/eventstore"
+	"github.com/ktsiligkos/xm_project/pkg/config"
+)
+
+func main() {
+	var (
+		batchSize   = flag.Int("batch-size", 500, "number of companies replayed per query")
+		importFirst = flag.Bool("import", true, "first seed the log with a created event for every company that has no events yet")
+	)
+	flag.Parse()
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("failed to load configuration: %v", err)
+	}
+
+	// the projection is rebuilt for every tenant at once
+	ctx, stop := signal.NotifyContext(correlation.WithAllTenants(context.Background()), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := run(ctx, cfg, *batchSize, *importFirst); err != nil {
+		log.Fatalf("rebuild failed: %v", err)
+	}
+}
+
+func run(ctx context.Context, cfg config.Config, batchSize int, importFirst bool) error {
+	db, err := database.OpenMySQL(cfg.MySQLDSN)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	store := companyeventstore.NewEventStore(db, cfg.CompanySnapshotInterval)
+
+	// an existing database has rows but no events, without this step the rebuild refuses to run
+	if importFirst {
+		imported, err := store.ImportProjection(ctx, batchSize)
+		if err != nil {
+			return fmt.Errorf("import stopped after %d companies: %w", imported, err)
+		}
+		log.Printf("imported %d companies into the event log", imported)
+	}
+
+	replayed, err := store.RebuildProjections(ctx, batchSize, func(n int) {
+		log.Printf("replayed %d companies", n)
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("rebuild finished, %d companies replayed", replayed)
+	return nil
+}
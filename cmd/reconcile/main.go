@@ -0,0 +1,136 @@
+// Command reconcile compares the company events on Kafka with the companies
+// table and reports missing, extra and divergent companies. With -fix it
+// publishes corrective events so consumers of the topic converge on the table.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/ktsiligkos/xm_project/internal/correlation"
+	"github.com/ktsiligkos/xm_project/internal/platform/app"
+	"github.com/ktsiligkos/xm_project/internal/platform/database"
+	kafkaevents "github.com/ktsiligkos/xm_project/internal/platform/events/kafka"
+	companymysql "github.com/ktsiligkos/xm_project/internal/repository/company/mysql"
+	companyservice "github.com/ktsiligkos/xm_project/internal/service/company"
+	"github.com/ktsiligkos/xm_project/pkg/config"
+)
+
+func main() {
+	var (
+		topics    = flag.String("topics", "", "comma separated topics to fold (default: KAFKA_TOPIC and every routed topic)")
+		batchSize = flag.Int("batch-size", 500, "number of companies read per query")
+		format    = flag.String("format", "text", "report format: text or json")
+		fix       = flag.Bool("fix", false, "publish snapshot events for missing and divergent companies and deleted events for extra ones")
+	)
+	flag.Parse()
+
+	if *format != "text" && *format != "json" {
+		log.Fatalf("unknown report format %q", *format)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("failed to load configuration: %v", err)
+	}
+
+	// the topics hold the events of every tenant, so does the comparison
+	ctx, stop := signal.NotifyContext(correlation.WithAllTenants(context.Background()), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	opts := companyservice.ReconcileOptions{BatchSize: *batchSize, Fix: *fix}
+	if err := run(ctx, cfg, eventTopics(cfg, *topics), opts, *format); err != nil {
+		log.Fatalf("reconcile failed: %v", err)
+	}
+}
+
+func run(ctx context.Context, cfg config.Config, topics []string, opts companyservice.ReconcileOptions, format string) error {
+	expected := companyservice.NewExpectedState()
+	for _, topic := range topics {
+		stats, err := kafkaevents.ReadCompanyEvents(ctx, cfg.KafkaBrokers, topic, expected.Apply)
+		if err != nil {
+			return err
+		}
+		log.Printf("read %d messages from %s (%d undecodable)", stats.Messages, topic, stats.Undecodable)
+	}
+	log.Printf("folded %d events, %d stale or unknown skipped", expected.Applied(), expected.Skipped())
+
+	db, err := database.OpenMySQL(cfg.MySQLDSN)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	publisher := kafkaevents.NewPublisher(cfg.KafkaBrokers, cfg.KafkaTopic, app.KafkaPublisherOptions(cfg)...)
+	defer func() {
+		if err := publisher.Close(); err != nil {
+			log.Printf("error while closing publisher: %v", err)
+		}
+	}()
+
+	service := companyservice.NewService(companymysql.NewMySQL(db), publisher)
+	report, err := service.Reconcile(ctx, expected, opts)
+	if err != nil {
+		return fmt.Errorf("after %d corrections: %w", report.Corrected, err)
+	}
+
+	if format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+	printReport(os.Stdout, report)
+	return nil
+}
+
+// eventTopics returns the topics named on the command line, or every topic company events are routed to
+func eventTopics(cfg config.Config, flagValue string) []string {
+	var topics []string
+	seen := map[string]bool{}
+	add := func(topic string) {
+		if topic = strings.TrimSpace(topic); topic != "" && !seen[topic] {
+			seen[topic] = true
+			topics = append(topics, topic)
+		}
+	}
+
+	if flagValue != "" {
+		for _, topic := range strings.Split(flagValue, ",") {
+			add(topic)
+		}
+		return topics
+	}
+
+	add(cfg.KafkaTopic)
+	for _, route := range cfg.KafkaRoutes {
+		add(route.Topic)
+	}
+	return topics
+}
+
+func printReport(w io.Writer, report companyservice.ReconcileReport) {
+	fmt.Fprintf(w, "checked %d companies: %d missing, %d extra, %d divergent\n",
+		report.Checked, len(report.Missing), len(report.Extra), len(report.Divergent))
+
+	for _, drift := range report.Missing {
+		fmt.Fprintf(w, "missing    %s  stored but not live in the events\n", drift.CompanyID)
+	}
+	for _, drift := range report.Extra {
+		fmt.Fprintf(w, "extra      %s  live in the events but not stored\n", drift.CompanyID)
+	}
+	for _, drift := range report.Divergent {
+		fmt.Fprintf(w, "divergent  %s  %s\n", drift.CompanyID, strings.Join(drift.Fields, ", "))
+	}
+
+	if report.Corrected > 0 {
+		fmt.Fprintf(w, "published %d corrective events\n", report.Corrected)
+	}
+}
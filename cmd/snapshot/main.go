@@ -0,0 +1,122 @@
+// Command snapshot republishes the current state of every company as
+// company.snapshot events, so new consumers can bootstrap from Kafka.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"github.com/ktsiligkos/xm_project/internal/correlation"
+	"github.com/ktsiligkos/xm_project/internal/domain"
+	"github.com/ktsiligkos/xm_project/internal/platform/app"
+	"github.com/ktsiligkos/xm_project/internal/platform/checkpoint"
+	"github.com/ktsiligkos/xm_project/internal/platform/database"
+	kafkaevents "github.com/ktsiligkos/xm_project/internal/platform/events/kafka"
+	companymysql "github.com/ktsiligkos/xm_project/internal/repository/company/mysql"
+	companyservice "github.com/ktsiligkos/xm_project/internal/service/company"
+	"github.com/ktsiligkos/xm_project/pkg/config"
+)
+
+func main() {
+	var (
+		batchSize      = flag.Int("batch-size", 500, "number of companies read per query")
+		rate           = flag.Float64("rate", 100, "maximum events published per second (0 disables throttling)")
+		checkpointPath = flag.String("checkpoint", "snapshot.checkpoint.json", "file used to resume an interrupted run")
+		reset          = flag.Bool("reset", false, "discard an existing checkpoint and start from the first company")
+		companyType    = flag.String("type", "", "only publish companies of this type")
+		registered     = flag.String("registered", "", "only publish registered (true) or unregistered (false) companies")
+		tenant         = flag.String("tenant", "", "only publish the companies of this tenant (default: every tenant)")
+	)
+	flag.Parse()
+
+	filter, err := parseFilter(*companyType, *registered)
+	if err != nil {
+		log.Fatalf("invalid filter: %v", err)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("failed to load configuration: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *tenant != "" {
+		ctx = correlation.WithTenant(ctx, *tenant)
+	} else {
+		ctx = correlation.WithAllTenants(ctx)
+	}
+
+	opts := companyservice.SnapshotOptions{
+		Filter:     filter,
+		BatchSize:  *batchSize,
+		Rate:       *rate,
+		Checkpoint: checkpoint.NewFileStore(*checkpointPath),
+		Progress: func(p companyservice.SnapshotProgress) {
+			log.Printf("published %d companies, last id %s", p.Published, p.LastID)
+		},
+	}
+
+	if err := run(ctx, cfg, opts, *reset); err != nil {
+		log.Fatalf("snapshot failed: %v", err)
+	}
+}
+
+func run(ctx context.Context, cfg config.Config, opts companyservice.SnapshotOptions, reset bool) error {
+	db, err := database.OpenMySQL(cfg.MySQLDSN)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	publisher := kafkaevents.NewPublisher(cfg.KafkaBrokers, cfg.KafkaTopic, app.KafkaPublisherOptions(cfg)...)
+	defer func() {
+		if err := publisher.Close(); err != nil {
+			log.Printf("error while closing publisher: %v", err)
+		}
+	}()
+
+	if reset {
+		if err := opts.Checkpoint.Clear(ctx); err != nil {
+			return fmt.Errorf("reset checkpoint: %w", err)
+		}
+	}
+
+	service := companyservice.NewService(companymysql.NewMySQL(db), publisher)
+
+	progress, err := service.PublishSnapshot(ctx, opts)
+	if err != nil {
+		return fmt.Errorf("stopped after %d companies, rerun to resume after %q: %w", progress.Published, progress.LastID, err)
+	}
+
+	log.Printf("snapshot finished, %d companies published", progress.Published)
+	return nil
+}
+
+func parseFilter(companyType, registered string) (domain.CompanyFilter, error) {
+	var filter domain.CompanyFilter
+
+	if companyType != "" {
+		t := domain.CompanyType(companyType)
+		if !t.IsValid() {
+			return filter, fmt.Errorf("unknown company type %q", companyType)
+		}
+		filter.Type = &t
+	}
+
+	if registered != "" {
+		r, err := strconv.ParseBool(registered)
+		if err != nil {
+			return filter, fmt.Errorf("registered must be true or false, got %q", registered)
+		}
+		filter.Registered = &r
+	}
+
+	return filter, nil
+}
@@ -7,6 +7,7 @@ require (
 	github.com/go-sql-driver/mysql v1.9.3
 	github.com/golang-jwt/jwt/v5 v5.3.0
 	github.com/google/uuid v1.6.0
+	github.com/gorilla/websocket v1.5.3
 	github.com/segmentio/kafka-go v0.4.45
 	go.uber.org/zap v1.27.0
 	golang.org/x/crypto v0.41.0
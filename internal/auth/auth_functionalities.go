@@ -1,61 +1,76 @@
 package auth
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
 var (
 	ErrMissingSecret = errors.New("jwt secret is required")
 	ErrInvalidToken  = errors.New("invalid token")
+	ErrExpiredToken  = errors.New("token is expired")
 )
 
 // Claims represents the payload embedded in JWTs.
 type Claims struct {
 	UserID string `json:"user_id"`
+	// TenantID scopes every company and user the token reaches, see domain.DefaultTenantID for tokens without it
+	TenantID string `json:"tenant_id,omitempty"`
+	// Roles are informational, access decisions are made on Permissions
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
 	jwt.RegisteredClaims
 }
 
-// GenerateJWT creates a signed token for the specified user
-func GenerateJWT(userID string, secret []byte, ttl time.Duration) (string, error) {
-	if len(secret) == 0 {
+// TokenVerifier checks an access token and returns its claims, the error wraps
+// ErrInvalidToken or ErrExpiredToken when the token is not accepted
+type TokenVerifier interface {
+	VerifyToken(ctx context.Context, token string) (*Claims, error)
+}
+
+// GenerateJWT creates a signed token for the specified user of the tenant, carrying the permissions
+// of their roles. Every token gets a unique jti so it can be revoked on its own.
+func GenerateJWT(userID, tenantID string, roles []string, keys *KeySet, ttl time.Duration) (string, error) {
+	if !keys.configured() {
 		return "", ErrMissingSecret
 	}
 
 	now := time.Now()
 
 	claims := Claims{
-		UserID: userID,
+		UserID:      userID,
+		TenantID:    tenantID,
+		Roles:       roles,
+		Permissions: PermissionsForRoles(roles),
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
 			IssuedAt:  jwt.NewNumericDate(now),
 			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(secret)
+	return keys.sign(claims)
 }
 
-// ParseJWT validates an incoming token string and returns the claims.
-func ParseJWT(tokenString string, secret []byte) (*Claims, error) {
-	if len(secret) == 0 {
+// ParseJWT validates an incoming token string against the keys and returns the claims.
+func ParseJWT(tokenString string, keys *KeySet) (*Claims, error) {
+	if !keys.configured() {
 		return nil, ErrMissingSecret
 	}
 
 	claims := &Claims{}
 
-	parsedToken, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %s", t.Header["alg"])
-		}
-
-		return secret, nil
-	})
+	parsedToken, err := jwt.ParseWithClaims(tokenString, claims, keys.keyFunc)
 	if err != nil {
-		return nil, err
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, fmt.Errorf("%w: %v", ErrExpiredToken, err)
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
 	}
 
 	if !parsedToken.Valid {
@@ -64,3 +79,8 @@ func ParseJWT(tokenString string, secret []byte) (*Claims, error) {
 
 	return claims, nil
 }
+
+// VerifyToken makes the key set a TokenVerifier for the tokens this service signs
+func (k *KeySet) VerifyToken(_ context.Context, token string) (*Claims, error) {
+	return ParseJWT(token, k)
+}
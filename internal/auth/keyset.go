@@ -0,0 +1,422 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Supported signing algorithms
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmES256 = "ES256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// minRSABits is the smallest RSA modulus accepted for signing or verification
+const minRSABits = 2048
+
+// KeySetConfig selects the signing key and the extra keys tokens may be verified with
+type KeySetConfig struct {
+	// Algorithm defaults to HS256, which signs with Secret and publishes no keys
+	Algorithm string
+	Secret    []byte
+	// SigningKeyFile is a PEM encoded private key (PKCS#8, PKCS#1 or SEC 1) for the asymmetric algorithms
+	SigningKeyFile string
+	// VerificationKeyFiles are PEM encoded public (or private) keys of earlier signing keys,
+	// tokens they signed stay valid while a rotation is under way
+	VerificationKeyFiles []string
+}
+
+// KeySet signs access tokens with one key and verifies them with any of its keys.
+// Asymmetric keys are identified by the kid header, which is the RFC 7638 thumbprint
+// of the public key, so the same key always gets the same kid.
+type KeySet struct {
+	signing      *verificationKey
+	signer       any
+	verification map[string]*verificationKey
+	// secret is set in HS256 mode only, HS256 tokens carry no kid
+	secret []byte
+}
+
+type verificationKey struct {
+	id        string
+	algorithm string
+	public    crypto.PublicKey
+}
+
+// NewHMACKeySet signs and verifies with a shared secret, as the service always did
+func NewHMACKeySet(secret []byte) *KeySet {
+	return &KeySet{secret: secret, verification: map[string]*verificationKey{}}
+}
+
+// NewKeySet builds the key set described by cfg
+func NewKeySet(cfg KeySetConfig) (*KeySet, error) {
+	if cfg.Algorithm == "" || cfg.Algorithm == AlgorithmHS256 {
+		if len(cfg.Secret) == 0 {
+			return nil, ErrMissingSecret
+		}
+		return NewHMACKeySet(cfg.Secret), nil
+	}
+	if cfg.SigningKeyFile == "" {
+		return nil, fmt.Errorf("%s requires a signing key file", cfg.Algorithm)
+	}
+
+	private, err := readPrivateKey(cfg.SigningKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	signing, err := newVerificationKey(cfg.Algorithm, private.Public())
+	if err != nil {
+		return nil, fmt.Errorf("signing key %s: %w", cfg.SigningKeyFile, err)
+	}
+
+	keys := &KeySet{
+		signing:      signing,
+		signer:       private,
+		verification: map[string]*verificationKey{signing.id: signing},
+	}
+	for _, path := range cfg.VerificationKeyFiles {
+		public, err := readPublicKey(path)
+		if err != nil {
+			return nil, err
+		}
+		algorithm, err := algorithmFor(public)
+		if err != nil {
+			return nil, fmt.Errorf("verification key %s: %w", path, err)
+		}
+		key, err := newVerificationKey(algorithm, public)
+		if err != nil {
+			return nil, fmt.Errorf("verification key %s: %w", path, err)
+		}
+		keys.verification[key.id] = key
+	}
+
+	return keys, nil
+}
+
+// sign signs the claims with the signing key
+func (k *KeySet) sign(claims jwt.Claims) (string, error) {
+	if k.signing == nil {
+		if len(k.secret) == 0 {
+			return "", ErrMissingSecret
+		}
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(k.secret)
+	}
+
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(k.signing.algorithm), claims)
+	token.Header["kid"] = k.signing.id
+	return token.SignedString(k.signer)
+}
+
+// keyFunc picks the verification key named by the kid header, and only accepts
+// the algorithm that key was registered with
+func (k *KeySet) keyFunc(t *jwt.Token) (any, error) {
+	if k.signing == nil {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %s", t.Header["alg"])
+		}
+		return k.secret, nil
+	}
+
+	kid, _ := t.Header["kid"].(string)
+	key, ok := k.verification[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	if t.Method.Alg() != key.algorithm {
+		return nil, fmt.Errorf("unexpected signing method %s for key %s", t.Method.Alg(), kid)
+	}
+	return key.public, nil
+}
+
+// configured reports whether tokens can be signed and verified at all
+func (k *KeySet) configured() bool {
+	return k != nil && (k.signing != nil || len(k.secret) > 0)
+}
+
+// JWK is a public key in the JSON Web Key format (RFC 7517)
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// EC and OKP
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	Y     string `json:"y,omitempty"`
+}
+
+// PublicKey decodes the key and returns the algorithm it signs with: the alg member when
+// present, otherwise the only algorithm the service uses for this type of key
+func (j JWK) PublicKey() (crypto.PublicKey, string, error) {
+	var public crypto.PublicKey
+	switch j.KeyType {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(j.N)
+		if err != nil {
+			return nil, "", fmt.Errorf("decode n: %w", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(j.E)
+		if err != nil {
+			return nil, "", fmt.Errorf("decode e: %w", err)
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return nil, "", errors.New("RSA exponent is too large")
+		}
+		public = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}
+	case "EC":
+		if j.Curve != "P-256" {
+			return nil, "", fmt.Errorf("unsupported curve %q", j.Curve)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil {
+			return nil, "", fmt.Errorf("decode x: %w", err)
+		}
+		y, err := base64.RawURLEncoding.DecodeString(j.Y)
+		if err != nil {
+			return nil, "", fmt.Errorf("decode y: %w", err)
+		}
+		if len(x) != 32 || len(y) != 32 {
+			return nil, "", errors.New("P-256 coordinates must be 32 bytes")
+		}
+		key, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), append(append([]byte{4}, x...), y...))
+		if err != nil {
+			return nil, "", err
+		}
+		public = key
+	case "OKP":
+		if j.Curve != "Ed25519" {
+			return nil, "", fmt.Errorf("unsupported curve %q", j.Curve)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil {
+			return nil, "", fmt.Errorf("decode x: %w", err)
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, "", errors.New("Ed25519 keys must be 32 bytes")
+		}
+		public = ed25519.PublicKey(x)
+	default:
+		return nil, "", fmt.Errorf("unsupported key type %q", j.KeyType)
+	}
+
+	algorithm, err := algorithmFor(public)
+	if err != nil {
+		return nil, "", err
+	}
+	if j.Algorithm != "" && j.Algorithm != algorithm {
+		// RSA keys may also sign with the longer SHA-2 variants
+		if _, ok := public.(*rsa.PublicKey); !ok || (j.Algorithm != "RS384" && j.Algorithm != "RS512") {
+			return nil, "", fmt.Errorf("algorithm %s does not match the %s key", j.Algorithm, j.KeyType)
+		}
+		algorithm = j.Algorithm
+	}
+	return public, algorithm, nil
+}
+
+// JWKS is the document served at /.well-known/jwks.json
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys tokens are verified with, none in HS256 mode
+func (k *KeySet) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	if k == nil || k.signing == nil {
+		return set
+	}
+
+	// the signing key first, then the keys kept for the rotation
+	set.Keys = append(set.Keys, k.signing.jwk())
+	ids := make([]string, 0, len(k.verification))
+	for id := range k.verification {
+		if id != k.signing.id {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		set.Keys = append(set.Keys, k.verification[id].jwk())
+	}
+	return set
+}
+
+func newVerificationKey(algorithm string, public crypto.PublicKey) (*verificationKey, error) {
+	expected, err := algorithmFor(public)
+	if err != nil {
+		return nil, err
+	}
+	if expected != algorithm {
+		return nil, fmt.Errorf("a %s key cannot sign %s tokens", expected, algorithm)
+	}
+
+	key := &verificationKey{algorithm: algorithm, public: public}
+	key.id, err = thumbprint(key.jwk())
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// algorithmFor returns the only algorithm a public key is used with
+func algorithmFor(public crypto.PublicKey) (string, error) {
+	switch key := public.(type) {
+	case *rsa.PublicKey:
+		if key.N.BitLen() < minRSABits {
+			return "", fmt.Errorf("RSA keys must have at least %d bits", minRSABits)
+		}
+		return AlgorithmRS256, nil
+	case *ecdsa.PublicKey:
+		if key.Curve != elliptic.P256() {
+			return "", errors.New("EC keys must use the P-256 curve")
+		}
+		return AlgorithmES256, nil
+	case ed25519.PublicKey:
+		return AlgorithmEdDSA, nil
+	default:
+		return "", fmt.Errorf("unsupported key type %T", public)
+	}
+}
+
+func (k *verificationKey) jwk() JWK {
+	jwk := JWK{KeyID: k.id, Use: "sig", Algorithm: k.algorithm}
+	switch key := k.public.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = encodeBigInt(key.N)
+		jwk.E = encodeBigInt(big.NewInt(int64(key.E)))
+	case *ecdsa.PublicKey:
+		jwk.KeyType = "EC"
+		jwk.Curve = "P-256"
+		// the uncompressed point is 0x04 followed by the 32 byte X and Y coordinates
+		if point, err := key.Bytes(); err == nil && len(point) == 65 {
+			jwk.X = base64.RawURLEncoding.EncodeToString(point[1:33])
+			jwk.Y = base64.RawURLEncoding.EncodeToString(point[33:])
+		}
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(key)
+	}
+	return jwk
+}
+
+// thumbprint computes the RFC 7638 thumbprint: the SHA-256 of the required members
+// of the key, serialised in lexicographic order without whitespace
+func thumbprint(jwk JWK) (string, error) {
+	var members any
+	switch jwk.KeyType {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.KeyType, jwk.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Curve, jwk.KeyType, jwk.X, jwk.Y}
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Curve, jwk.KeyType, jwk.X}
+	default:
+		return "", fmt.Errorf("unsupported key type %q", jwk.KeyType)
+	}
+
+	encoded, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(encoded)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// encodeBigInt encodes n big endian without leading zeros
+func encodeBigInt(n *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(n.Bytes())
+}
+
+func readPrivateKey(path string) (crypto.Signer, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var key any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse private key %s: %w", path, err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("private key %s: unsupported key type %T", path, key)
+	}
+	return signer, nil
+}
+
+// readPublicKey also accepts private keys, so the old signing key file can be kept as it is
+func readPublicKey(path string) (crypto.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	if block.Type != "PUBLIC KEY" {
+		private, err := readPrivateKey(path)
+		if err != nil {
+			return nil, err
+		}
+		return private.Public(), nil
+	}
+
+	public, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse public key %s: %w", path, err)
+	}
+	return public, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("read key %s: no PEM data", path)
+	}
+	return block, nil
+}
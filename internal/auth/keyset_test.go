@@ -0,0 +1,183 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// writeKey stores the private key as a PKCS#8 PEM file and returns its path
+func writeKey(t *testing.T, name string, key crypto.Signer) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	return path
+}
+
+// writePublicKey stores the public part of the key as a PKIX PEM file and returns its path
+func writePublicKey(t *testing.T, name string, key crypto.Signer) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatalf("marshal public key: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("write public key: %v", err)
+	}
+	return path
+}
+
+func givenKeySet(t *testing.T, cfg KeySetConfig) *KeySet {
+	t.Helper()
+	keys, err := NewKeySet(cfg)
+	if err != nil {
+		t.Fatalf("new key set: %v", err)
+	}
+	return keys
+}
+
+func TestKeySet_SignsAndVerifiesWithEveryAlgorithm(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate RSA key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate EC key: %v", err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate Ed25519 key: %v", err)
+	}
+
+	for _, tc := range []struct {
+		algorithm string
+		key       crypto.Signer
+		kty       string
+	}{
+		{AlgorithmRS256, rsaKey, "RSA"},
+		{AlgorithmES256, ecKey, "EC"},
+		{AlgorithmEdDSA, edKey, "OKP"},
+	} {
+		t.Run(tc.algorithm, func(t *testing.T) {
+			keys := givenKeySet(t, KeySetConfig{Algorithm: tc.algorithm, SigningKeyFile: writeKey(t, "signing.pem", tc.key)})
+
+			token, err := GenerateJWT("user-1", "tenant-1", []string{RoleViewer}, keys, time.Minute)
+			if err != nil {
+				t.Fatalf("GenerateJWT returned error: %v", err)
+			}
+			claims, err := ParseJWT(token, keys)
+			if err != nil {
+				t.Fatalf("ParseJWT returned error: %v", err)
+			}
+			if claims.UserID != "user-1" {
+				t.Fatalf("expected user-1, got %q", claims.UserID)
+			}
+
+			set := keys.JWKS()
+			if len(set.Keys) != 1 {
+				t.Fatalf("expected one published key, got %d", len(set.Keys))
+			}
+			header, _, err := jwt.NewParser().ParseUnverified(token, &Claims{})
+			if err != nil {
+				t.Fatalf("parse header: %v", err)
+			}
+			jwk := set.Keys[0]
+			if jwk.KeyID != header.Header["kid"] || jwk.Algorithm != tc.algorithm || jwk.KeyType != tc.kty {
+				t.Fatalf("published key %+v does not match the token header %v", jwk, header.Header)
+			}
+		})
+	}
+}
+
+func TestKeySet_RejectsUnknownKeysAndAlgorithms(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	keys := givenKeySet(t, KeySetConfig{Algorithm: AlgorithmES256, SigningKeyFile: writeKey(t, "signing.pem", ecKey)})
+	other := givenKeySet(t, KeySetConfig{Algorithm: AlgorithmES256, SigningKeyFile: writeKey(t, "other.pem", otherKey)})
+	hmac := NewHMACKeySet([]byte("secret"))
+
+	foreign, _ := GenerateJWT("user-1", "tenant-1", nil, other, time.Minute)
+	symmetric, _ := GenerateJWT("user-1", "tenant-1", nil, hmac, time.Minute)
+	kidless, _ := jwt.NewWithClaims(jwt.SigningMethodES256, &Claims{UserID: "user-1"}).SignedString(ecKey)
+
+	for name, token := range map[string]string{
+		"unknown kid": foreign,
+		"HS256 token": symmetric,
+		"missing kid": kidless,
+	} {
+		if _, err := ParseJWT(token, keys); !errors.Is(err, ErrInvalidToken) {
+			t.Fatalf("%s: expected ErrInvalidToken, got %v", name, err)
+		}
+	}
+
+	asymmetric, _ := GenerateJWT("user-1", "tenant-1", nil, keys, time.Minute)
+	if _, err := ParseJWT(asymmetric, hmac); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("HS256 mode must reject asymmetric tokens, got %v", err)
+	}
+}
+
+func TestKeySet_KeepsRotatedKeysValid(t *testing.T) {
+	oldKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	newKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	before := givenKeySet(t, KeySetConfig{Algorithm: AlgorithmRS256, SigningKeyFile: writeKey(t, "old.pem", oldKey)})
+	oldToken, err := GenerateJWT("user-1", "tenant-1", nil, before, time.Minute)
+	if err != nil {
+		t.Fatalf("GenerateJWT returned error: %v", err)
+	}
+
+	after := givenKeySet(t, KeySetConfig{
+		Algorithm:            AlgorithmES256,
+		SigningKeyFile:       writeKey(t, "new.pem", newKey),
+		VerificationKeyFiles: []string{writePublicKey(t, "old.pub.pem", oldKey)},
+	})
+	if _, err := ParseJWT(oldToken, after); err != nil {
+		t.Fatalf("a token of the previous key must stay valid: %v", err)
+	}
+
+	set := after.JWKS()
+	if len(set.Keys) != 2 || set.Keys[0].Algorithm != AlgorithmES256 || set.Keys[1].Algorithm != AlgorithmRS256 {
+		t.Fatalf("expected the signing key followed by the previous key, got %+v", set.Keys)
+	}
+	if set.Keys[1].KeyID != before.JWKS().Keys[0].KeyID {
+		t.Fatal("the kid of a key must not depend on how it was loaded")
+	}
+}
+
+func TestNewKeySet_RejectsMismatchedKeys(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	weakKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+
+	for name, cfg := range map[string]KeySetConfig{
+		"missing key file":     {Algorithm: AlgorithmRS256},
+		"wrong key type":       {Algorithm: AlgorithmRS256, SigningKeyFile: writeKey(t, "ec.pem", ecKey)},
+		"RSA key too small":    {Algorithm: AlgorithmRS256, SigningKeyFile: writeKey(t, "weak.pem", weakKey)},
+		"HS256 without secret": {Algorithm: AlgorithmHS256},
+	} {
+		if _, err := NewKeySet(cfg); err == nil {
+			t.Fatalf("%s: expected an error", name)
+		} else if strings.TrimSpace(err.Error()) == "" {
+			t.Fatalf("%s: expected a descriptive error", name)
+		}
+	}
+}
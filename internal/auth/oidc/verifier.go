@@ -0,0 +1,345 @@
+// Package oidc accepts access tokens issued by an external OpenID Connect identity provider.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/ktsiligkos/xm_project/internal/auth"
+)
+
+// discoveryPath is appended to the issuer URL to find its metadata (OpenID Connect Discovery 1.0)
+const discoveryPath = "/.well-known/openid-configuration"
+
+// supportedAlgorithms are the signing algorithms accepted from the identity provider
+var supportedAlgorithms = []string{"RS256", "RS384", "RS512", auth.AlgorithmES256, auth.AlgorithmEdDSA}
+
+// Config describes the identity provider and how its tokens map to users of the service
+type Config struct {
+	// Issuer is the issuer URL, it must match the iss claim of the tokens exactly
+	Issuer string
+	// Audience must be one of the aud values of the tokens
+	Audience string
+	// Leeway tolerates clock skew when checking exp, nbf and iat
+	Leeway time.Duration
+	// UserIDClaim holds the user ID, sub by default
+	UserIDClaim string
+	// RolesClaim holds the roles as a list or a space separated string, roles by default.
+	// Nested claims are addressed with dots, e.g. realm_access.roles.
+	RolesClaim string
+	// TenantClaim holds the tenant ID, tokens without it are rejected
+	TenantClaim string
+	// Tenant is the tenant of every token of a single-tenant deployment, used instead of
+	// TenantClaim. One of the two is required, so no token falls into a tenant by accident.
+	Tenant string
+	// RoleMapping translates role names of the identity provider to roles of the service.
+	// Only mapped names grant a role, an IdP group that happens to be called "admin" grants nothing.
+	RoleMapping map[string]string
+	// CacheTTL is how long the keys are used before they are fetched again, 1 hour by default
+	CacheTTL time.Duration
+	// MinRefreshInterval limits how often a token with an unknown kid can trigger a fetch, 1 minute by default
+	MinRefreshInterval time.Duration
+	// HTTPClient fetches the discovery document and the keys, a client with a 10 second timeout by default
+	HTTPClient *http.Client
+	// Fallback verifies tokens of other issuers, e.g. the ones signed by this service. Nil rejects them.
+	Fallback auth.TokenVerifier
+}
+
+// Verifier checks tokens of the identity provider with the keys it publishes. The keys are
+// cached, fetched again after CacheTTL, and immediately when a token names an unknown kid
+// so that a key rotation of the identity provider is picked up without a restart.
+type Verifier struct {
+	cfg    Config
+	parser *jwt.Parser
+
+	// refreshMu serialises fetches and guards attemptedAt, mu guards the cache
+	refreshMu   sync.Mutex
+	attemptedAt time.Time
+	mu          sync.RWMutex
+	jwksURI     string
+	keys        map[string]cachedKey
+	fetchedAt   time.Time
+}
+
+type cachedKey struct {
+	public    crypto.PublicKey
+	algorithm string
+}
+
+type discoveryDocument struct {
+	Issuer  string `json:"issuer"`
+	JWKSURI string `json:"jwks_uri"`
+}
+
+// NewVerifier validates the configuration, the keys are fetched on first use or by Refresh
+func NewVerifier(cfg Config) (*Verifier, error) {
+	if cfg.Issuer == "" {
+		return nil, errors.New("oidc issuer is required")
+	}
+	if cfg.Audience == "" {
+		return nil, errors.New("oidc audience is required")
+	}
+	if cfg.Leeway < 0 {
+		return nil, errors.New("oidc leeway must not be negative")
+	}
+	if (cfg.TenantClaim == "") == (cfg.Tenant == "") {
+		return nil, errors.New("oidc needs either a tenant claim or a fixed tenant")
+	}
+	if cfg.UserIDClaim == "" {
+		cfg.UserIDClaim = "sub"
+	}
+	if cfg.RolesClaim == "" {
+		cfg.RolesClaim = "roles"
+	}
+	if cfg.CacheTTL <= 0 {
+		cfg.CacheTTL = time.Hour
+	}
+	if cfg.MinRefreshInterval <= 0 {
+		cfg.MinRefreshInterval = time.Minute
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	for from, to := range cfg.RoleMapping {
+		if !auth.IsRole(to) {
+			return nil, fmt.Errorf("oidc role mapping %s=%s: unknown role %q", from, to, to)
+		}
+	}
+
+	return &Verifier{
+		cfg: cfg,
+		parser: jwt.NewParser(
+			jwt.WithValidMethods(supportedAlgorithms),
+			jwt.WithIssuer(cfg.Issuer),
+			jwt.WithAudience(cfg.Audience),
+			jwt.WithLeeway(cfg.Leeway),
+			jwt.WithExpirationRequired(),
+			jwt.WithIssuedAt(),
+		),
+		keys: map[string]cachedKey{},
+	}, nil
+}
+
+// VerifyToken checks a token of the identity provider, tokens of other issuers go to the Fallback
+func (v *Verifier) VerifyToken(ctx context.Context, token string) (*auth.Claims, error) {
+	unverified := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, unverified); err != nil {
+		return nil, fmt.Errorf("%w: %v", auth.ErrInvalidToken, err)
+	}
+	if issuer, _ := unverified.GetIssuer(); issuer != v.cfg.Issuer {
+		if v.cfg.Fallback != nil {
+			return v.cfg.Fallback.VerifyToken(ctx, token)
+		}
+		return nil, fmt.Errorf("%w: unexpected issuer %q", auth.ErrInvalidToken, issuer)
+	}
+
+	var fetchErr error
+	claims := jwt.MapClaims{}
+	_, err := v.parser.ParseWithClaims(token, claims, func(t *jwt.Token) (any, error) {
+		key, err := v.key(ctx, t)
+		fetchErr = err
+		return key, err
+	})
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, fmt.Errorf("%w: %v", auth.ErrExpiredToken, err)
+		}
+		if fetchErr != nil && !errors.Is(fetchErr, auth.ErrInvalidToken) {
+			// not the token's fault, the keys could not be loaded
+			return nil, fetchErr
+		}
+		return nil, fmt.Errorf("%w: %v", auth.ErrInvalidToken, err)
+	}
+
+	return v.mapClaims(claims)
+}
+
+// Refresh fetches the discovery document, when not known yet, and the keys
+func (v *Verifier) Refresh(ctx context.Context) error {
+	v.refreshMu.Lock()
+	defer v.refreshMu.Unlock()
+	return v.refresh(ctx)
+}
+
+// key returns the key named by the kid header, fetching the keys when they are stale or the kid is unknown
+func (v *Verifier) key(ctx context.Context, t *jwt.Token) (crypto.PublicKey, error) {
+	kid, _ := t.Header["kid"].(string)
+
+	key, ok, fresh := v.cached(kid)
+	if !ok || !fresh {
+		v.refreshMu.Lock()
+		// another request may have fetched the keys while this one waited
+		key, ok, fresh = v.cached(kid)
+		throttled := time.Since(v.attemptedAt) < v.cfg.MinRefreshInterval
+		if (!ok || !fresh) && !throttled {
+			if err := v.refresh(ctx); err != nil && !ok {
+				v.refreshMu.Unlock()
+				return nil, err
+			}
+			// a failed refresh keeps using the stale keys rather than rejecting every token
+			key, ok, _ = v.cached(kid)
+		}
+		v.refreshMu.Unlock()
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key id %q", auth.ErrInvalidToken, kid)
+	}
+	if t.Method.Alg() != key.algorithm {
+		return nil, fmt.Errorf("%w: unexpected signing method %s for key %s", auth.ErrInvalidToken, t.Method.Alg(), kid)
+	}
+	return key.public, nil
+}
+
+func (v *Verifier) cached(kid string) (cachedKey, bool, bool) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	key, ok := v.keys[kid]
+	return key, ok, !v.fetchedAt.IsZero() && time.Since(v.fetchedAt) < v.cfg.CacheTTL
+}
+
+// refresh must be called with refreshMu held
+func (v *Verifier) refresh(ctx context.Context) error {
+	// failed attempts count too, so an unreachable provider is not asked on every request
+	v.attemptedAt = time.Now()
+
+	v.mu.RLock()
+	jwksURI := v.jwksURI
+	v.mu.RUnlock()
+
+	if jwksURI == "" {
+		var doc discoveryDocument
+		if err := v.getJSON(ctx, strings.TrimSuffix(v.cfg.Issuer, "/")+discoveryPath, &doc); err != nil {
+			return fmt.Errorf("fetch oidc discovery document: %w", err)
+		}
+		if doc.Issuer != v.cfg.Issuer {
+			return fmt.Errorf("oidc discovery document is for issuer %q, expected %q", doc.Issuer, v.cfg.Issuer)
+		}
+		if doc.JWKSURI == "" {
+			return errors.New("oidc discovery document has no jwks_uri")
+		}
+		jwksURI = doc.JWKSURI
+	}
+
+	var set auth.JWKS
+	if err := v.getJSON(ctx, jwksURI, &set); err != nil {
+		return fmt.Errorf("fetch oidc keys: %w", err)
+	}
+	keys := make(map[string]cachedKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		// keys of unsupported types are skipped, the provider may publish them for other clients
+		public, algorithm, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.KeyID] = cachedKey{public: public, algorithm: algorithm}
+	}
+
+	v.mu.Lock()
+	v.jwksURI = jwksURI
+	v.keys = keys
+	v.fetchedAt = time.Now()
+	v.mu.Unlock()
+	return nil
+}
+
+func (v *Verifier) getJSON(ctx context.Context, url string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := v.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: unexpected status %s", url, resp.Status)
+	}
+	// key sets are small, a larger body is not what we asked for
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out)
+}
+
+// mapClaims builds the claims of the service from the configured claims of the token
+func (v *Verifier) mapClaims(claims jwt.MapClaims) (*auth.Claims, error) {
+	userID, _ := lookup(claims, v.cfg.UserIDClaim).(string)
+	if userID == "" {
+		return nil, fmt.Errorf("%w: claim %s is missing", auth.ErrInvalidToken, v.cfg.UserIDClaim)
+	}
+
+	var roles []string
+	seen := map[string]bool{}
+	for _, name := range stringList(lookup(claims, v.cfg.RolesClaim)) {
+		role, ok := v.cfg.RoleMapping[name]
+		if ok && !seen[role] {
+			seen[role] = true
+			roles = append(roles, role)
+		}
+	}
+
+	mapped := &auth.Claims{
+		UserID:      userID,
+		Roles:       roles,
+		Permissions: auth.PermissionsForRoles(roles),
+	}
+	if v.cfg.TenantClaim == "" {
+		mapped.TenantID = v.cfg.Tenant
+	} else if mapped.TenantID, _ = lookup(claims, v.cfg.TenantClaim).(string); mapped.TenantID == "" {
+		return nil, fmt.Errorf("%w: claim %s is missing", auth.ErrInvalidToken, v.cfg.TenantClaim)
+	}
+	mapped.Issuer, _ = claims.GetIssuer()
+	mapped.Subject, _ = claims.GetSubject()
+	mapped.Audience, _ = claims.GetAudience()
+	mapped.ExpiresAt, _ = claims.GetExpirationTime()
+	mapped.NotBefore, _ = claims.GetNotBefore()
+	mapped.IssuedAt, _ = claims.GetIssuedAt()
+	mapped.ID, _ = claims["jti"].(string)
+	return mapped, nil
+}
+
+// lookup follows a dotted path through nested claims
+func lookup(claims map[string]any, path string) any {
+	var value any = claims
+	for _, part := range strings.Split(path, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		value = object[part]
+	}
+	return value
+}
+
+// stringList accepts a JSON list of strings or a space separated string, as used by scope
+func stringList(value any) []string {
+	switch v := value.(type) {
+	case string:
+		return strings.Fields(v)
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
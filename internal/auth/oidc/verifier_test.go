@@ -0,0 +1,304 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/ktsiligkos/xm_project/internal/auth"
+)
+
+// fakeIdP serves a discovery document and the public keys of the keys it signs with
+type fakeIdP struct {
+	server *httptest.Server
+
+	mu         sync.Mutex
+	keys       map[string]*ecdsa.PrivateKey
+	jwksServed int
+}
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	t.Helper()
+	idp := &fakeIdP{keys: map[string]*ecdsa.PrivateKey{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(discoveryDocument{Issuer: idp.server.URL, JWKSURI: idp.server.URL + "/keys"})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, _ *http.Request) {
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+		idp.jwksServed++
+		set := auth.JWKS{Keys: []auth.JWK{}}
+		for kid, key := range idp.keys {
+			point, _ := key.PublicKey.Bytes()
+			set.Keys = append(set.Keys, auth.JWK{
+				KeyType: "EC", KeyID: kid, Use: "sig", Algorithm: auth.AlgorithmES256, Curve: "P-256",
+				X: base64.RawURLEncoding.EncodeToString(point[1:33]),
+				Y: base64.RawURLEncoding.EncodeToString(point[33:]),
+			})
+		}
+		_ = json.NewEncoder(w).Encode(set)
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// addKey publishes a new signing key
+func (idp *fakeIdP) addKey(t *testing.T, kid string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	idp.mu.Lock()
+	idp.keys[kid] = key
+	idp.mu.Unlock()
+}
+
+func (idp *fakeIdP) served() int {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	return idp.jwksServed
+}
+
+// token signs claims with the key kid, filling in iss, aud, iat and exp unless given
+func (idp *fakeIdP) token(t *testing.T, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	defaults := jwt.MapClaims{
+		"iss": idp.server.URL,
+		"aud": "xm-api",
+		"sub": "idp-user-1",
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Minute).Unix(),
+	}
+	for name, value := range claims {
+		defaults[name] = value
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, defaults)
+	token.Header["kid"] = kid
+	idp.mu.Lock()
+	key := idp.keys[kid]
+	idp.mu.Unlock()
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	return signed
+}
+
+func givenVerifier(t *testing.T, idp *fakeIdP, cfg Config) *Verifier {
+	t.Helper()
+	cfg.Issuer = idp.server.URL
+	cfg.Audience = "xm-api"
+	if cfg.TenantClaim == "" {
+		cfg.Tenant = "tenant-1"
+	}
+	verifier, err := NewVerifier(cfg)
+	if err != nil {
+		t.Fatalf("new verifier: %v", err)
+	}
+	return verifier
+}
+
+func TestVerifier_MapsClaimsOfValidTokens(t *testing.T) {
+	idp := newFakeIdP(t)
+	idp.addKey(t, "k1")
+	verifier := givenVerifier(t, idp, Config{
+		UserIDClaim: "email",
+		RolesClaim:  "realm_access.roles",
+		TenantClaim: "org",
+		RoleMapping: map[string]string{"xm-admins": auth.RoleAdmin, "xm-readers": auth.RoleViewer},
+	})
+
+	claims, err := verifier.VerifyToken(context.Background(), idp.token(t, "k1", jwt.MapClaims{
+		"email":        "jane@example.com",
+		"org":          "tenant-1",
+		"jti":          "jti-1",
+		"realm_access": map[string]any{"roles": []string{"xm-admins", "xm-readers", "offline_access"}},
+	}))
+	if err != nil {
+		t.Fatalf("VerifyToken returned error: %v", err)
+	}
+	if claims.UserID != "jane@example.com" || claims.TenantID != "tenant-1" || claims.ID != "jti-1" || claims.IssuedAt == nil {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+	if len(claims.Roles) != 2 || claims.Roles[0] != auth.RoleAdmin || claims.Roles[1] != auth.RoleViewer {
+		t.Fatalf("expected the admin and viewer roles, got %v", claims.Roles)
+	}
+	if !claims.HasPermission(auth.PermissionUsersAdmin) {
+		t.Fatalf("expected the permissions of the mapped roles, got %v", claims.Permissions)
+	}
+}
+
+func TestVerifier_RejectsTokensWithoutTheTenantClaim(t *testing.T) {
+	idp := newFakeIdP(t)
+	idp.addKey(t, "k1")
+	verifier := givenVerifier(t, idp, Config{TenantClaim: "org"})
+
+	_, err := verifier.VerifyToken(context.Background(), idp.token(t, "k1", jwt.MapClaims{"sub": "jane"}))
+	if !errors.Is(err, auth.ErrInvalidToken) {
+		t.Fatalf("expected a token without a tenant to be rejected, got %v", err)
+	}
+}
+
+func TestVerifier_IgnoresUnmappedRoleNames(t *testing.T) {
+	idp := newFakeIdP(t)
+	idp.addKey(t, "k1")
+	verifier := givenVerifier(t, idp, Config{RoleMapping: map[string]string{"xm-readers": auth.RoleViewer}})
+
+	claims, err := verifier.VerifyToken(context.Background(), idp.token(t, "k1", jwt.MapClaims{
+		"sub":   "user-1",
+		"roles": []string{"operator", "admin", "xm-readers"},
+	}))
+	if err != nil {
+		t.Fatalf("VerifyToken returned error: %v", err)
+	}
+	if len(claims.Roles) != 1 || claims.Roles[0] != auth.RoleViewer {
+		t.Fatalf("expected only the mapped viewer role, got %v", claims.Roles)
+	}
+	if claims.HasPermission(auth.PermissionTenantsAdmin) || claims.HasPermission(auth.PermissionUsersAdmin) {
+		t.Fatalf("expected IdP groups named like service roles to grant nothing, got %v", claims.Permissions)
+	}
+}
+
+func TestVerifier_RejectsInvalidTokens(t *testing.T) {
+	idp := newFakeIdP(t)
+	idp.addKey(t, "k1")
+	verifier := givenVerifier(t, idp, Config{Leeway: 30 * time.Second})
+
+	other := newFakeIdP(t)
+	other.addKey(t, "k1")
+
+	cases := map[string]struct {
+		token string
+		want  error
+	}{
+		"wrong audience":     {idp.token(t, "k1", jwt.MapClaims{"aud": "someone-else"}), auth.ErrInvalidToken},
+		"other issuer":       {other.token(t, "k1", nil), auth.ErrInvalidToken},
+		"forged signature":   {other.token(t, "k1", jwt.MapClaims{"iss": idp.server.URL}), auth.ErrInvalidToken},
+		"not yet valid":      {idp.token(t, "k1", jwt.MapClaims{"nbf": time.Now().Add(time.Minute).Unix()}), auth.ErrInvalidToken},
+		"expired":            {idp.token(t, "k1", jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()}), auth.ErrExpiredToken},
+		"no expiry":          {idp.token(t, "k1", jwt.MapClaims{"exp": nil}), auth.ErrInvalidToken},
+		"missing user id":    {idp.token(t, "k1", jwt.MapClaims{"sub": nil}), auth.ErrInvalidToken},
+		"unknown key":        {tokenWithKid(t, idp, "k1", "k2"), auth.ErrInvalidToken},
+		"not a token at all": {"garbage", auth.ErrInvalidToken},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			if _, err := verifier.VerifyToken(context.Background(), tc.token); !errors.Is(err, tc.want) {
+				t.Fatalf("expected %v, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+// tokenWithKid signs with the key kid but names another key in the header
+func tokenWithKid(t *testing.T, idp *fakeIdP, kid, header string) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"iss": idp.server.URL, "aud": "xm-api", "sub": "idp-user-1", "exp": time.Now().Add(time.Minute).Unix(),
+	})
+	token.Header["kid"] = header
+	signed, err := token.SignedString(idp.keys[kid])
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	return signed
+}
+
+func TestVerifier_AcceptsClockSkewWithinTheLeeway(t *testing.T) {
+	idp := newFakeIdP(t)
+	idp.addKey(t, "k1")
+	verifier := givenVerifier(t, idp, Config{Leeway: time.Minute})
+
+	token := idp.token(t, "k1", jwt.MapClaims{
+		"exp": time.Now().Add(-30 * time.Second).Unix(),
+		"nbf": time.Now().Add(30 * time.Second).Unix(),
+	})
+	if _, err := verifier.VerifyToken(context.Background(), token); err != nil {
+		t.Fatalf("expected the token to be accepted within the leeway: %v", err)
+	}
+}
+
+func TestVerifier_CachesKeysAndPicksUpRotations(t *testing.T) {
+	idp := newFakeIdP(t)
+	idp.addKey(t, "k1")
+	verifier := givenVerifier(t, idp, Config{MinRefreshInterval: time.Nanosecond})
+
+	for i := 0; i < 3; i++ {
+		if _, err := verifier.VerifyToken(context.Background(), idp.token(t, "k1", nil)); err != nil {
+			t.Fatalf("VerifyToken returned error: %v", err)
+		}
+	}
+	if served := idp.served(); served != 1 {
+		t.Fatalf("expected the keys to be fetched once, got %d fetches", served)
+	}
+
+	idp.addKey(t, "k2")
+	if _, err := verifier.VerifyToken(context.Background(), idp.token(t, "k2", nil)); err != nil {
+		t.Fatalf("a token of the new key must be accepted: %v", err)
+	}
+	if served := idp.served(); served != 2 {
+		t.Fatalf("expected an unknown kid to fetch the keys again, got %d fetches", served)
+	}
+}
+
+func TestVerifier_ThrottlesFetchesForUnknownKeys(t *testing.T) {
+	idp := newFakeIdP(t)
+	idp.addKey(t, "k1")
+	verifier := givenVerifier(t, idp, Config{MinRefreshInterval: time.Hour})
+
+	for i := 0; i < 3; i++ {
+		_, _ = verifier.VerifyToken(context.Background(), tokenWithKid(t, idp, "k1", "unknown"))
+	}
+	if served := idp.served(); served != 1 {
+		t.Fatalf("expected a single fetch within the refresh interval, got %d", served)
+	}
+}
+
+func TestVerifier_PassesOtherIssuersToTheFallback(t *testing.T) {
+	idp := newFakeIdP(t)
+	idp.addKey(t, "k1")
+	local := auth.NewHMACKeySet([]byte("secret"))
+	verifier := givenVerifier(t, idp, Config{Fallback: local})
+
+	token, err := auth.GenerateJWT("local-user", "tenant-1", []string{auth.RoleEditor}, local, time.Minute)
+	if err != nil {
+		t.Fatalf("GenerateJWT returned error: %v", err)
+	}
+	claims, err := verifier.VerifyToken(context.Background(), token)
+	if err != nil || claims.UserID != "local-user" {
+		t.Fatalf("expected the local token to be accepted, got %+v, %v", claims, err)
+	}
+	if served := idp.served(); served != 0 {
+		t.Fatalf("local tokens must not fetch the keys of the identity provider, got %d fetches", served)
+	}
+}
+
+func TestNewVerifier_RequiresExactlyOneTenantSource(t *testing.T) {
+	for _, cfg := range []Config{{}, {TenantClaim: "org", Tenant: "tenant-1"}} {
+		cfg.Issuer, cfg.Audience = "https://idp.example.com", "xm-api"
+		if _, err := NewVerifier(cfg); err == nil {
+			t.Fatalf("expected %+v to be rejected", cfg)
+		}
+	}
+}
+
+func TestNewVerifier_RejectsUnknownMappedRoles(t *testing.T) {
+	_, err := NewVerifier(Config{Issuer: "https://idp.example.com", Audience: "xm-api", Tenant: "tenant-1", RoleMapping: map[string]string{"ops": "superuser"}})
+	if err == nil {
+		t.Fatal("expected an error for a mapping to an unknown role")
+	}
+}
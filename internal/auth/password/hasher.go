@@ -0,0 +1,228 @@
+// Package password hashes and verifies user passwords and checks them against a policy.
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Supported hashing algorithms
+const (
+	AlgorithmBcrypt   = "bcrypt"
+	AlgorithmArgon2id = "argon2id"
+)
+
+// ErrUnknownHash is returned for stored hashes none of the algorithms recognise
+var ErrUnknownHash = errors.New("unknown password hash format")
+
+// Hasher hashes new passwords and verifies them against stored hashes
+type Hasher interface {
+	Hash(password string) (string, error)
+	// Verify reports whether password matches encoded, and whether encoded should be
+	// replaced by a fresh hash because it uses another algorithm or outdated parameters
+	Verify(password, encoded string) (ok bool, needsRehash bool, err error)
+	// VerifyDummy verifies password against a throwaway hash of the configured algorithm.
+	// Logins call it instead of Verify for unknown emails, so they take as long as a login
+	// to an account whose hash is up to date.
+	VerifyDummy(password string)
+}
+
+// Argon2Params tunes argon2id, see RFC 9106 for guidance
+type Argon2Params struct {
+	// Memory is in KiB
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2Params follows the second recommended option of RFC 9106
+var DefaultArgon2Params = Argon2Params{Memory: 64 * 1024, Iterations: 3, Parallelism: 2, SaltLength: 16, KeyLength: 32}
+
+// Config selects the algorithm used for new hashes and its parameters
+type Config struct {
+	Algorithm  string
+	BcryptCost int
+	Argon2     Argon2Params
+}
+
+// hasher hashes with the configured algorithm and verifies hashes of either algorithm,
+// so users keep logging in while their hashes are migrated
+type hasher struct {
+	cfg Config
+
+	dummyOnce sync.Once
+	// dummy is a hash of a random password with the configured algorithm and parameters
+	dummy string
+}
+
+// NewHasher validates cfg and fills in defaults for the unset parameters
+func NewHasher(cfg Config) (Hasher, error) {
+	if cfg.Algorithm == "" {
+		cfg.Algorithm = AlgorithmBcrypt
+	}
+	if cfg.BcryptCost == 0 {
+		cfg.BcryptCost = bcrypt.DefaultCost
+	}
+	if cfg.Argon2.Memory == 0 {
+		cfg.Argon2.Memory = DefaultArgon2Params.Memory
+	}
+	if cfg.Argon2.Iterations == 0 {
+		cfg.Argon2.Iterations = DefaultArgon2Params.Iterations
+	}
+	if cfg.Argon2.Parallelism == 0 {
+		cfg.Argon2.Parallelism = DefaultArgon2Params.Parallelism
+	}
+	if cfg.Argon2.SaltLength == 0 {
+		cfg.Argon2.SaltLength = DefaultArgon2Params.SaltLength
+	}
+	if cfg.Argon2.KeyLength == 0 {
+		cfg.Argon2.KeyLength = DefaultArgon2Params.KeyLength
+	}
+
+	switch cfg.Algorithm {
+	case AlgorithmBcrypt, AlgorithmArgon2id:
+	default:
+		return nil, fmt.Errorf("unknown password hash algorithm %q", cfg.Algorithm)
+	}
+	if cfg.BcryptCost < bcrypt.MinCost || cfg.BcryptCost > bcrypt.MaxCost {
+		return nil, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+
+	return &hasher{cfg: cfg}, nil
+}
+
+// Hash returns the encoded hash of password using the configured algorithm
+func (h *hasher) Hash(password string) (string, error) {
+	if h.cfg.Algorithm == AlgorithmArgon2id {
+		return hashArgon2id(password, h.cfg.Argon2)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.cfg.BcryptCost)
+	if err != nil {
+		return "", fmt.Errorf("bcrypt: %w", err)
+	}
+	return string(hash), nil
+}
+
+// Verify detects the algorithm from the prefix of encoded
+func (h *hasher) Verify(password, encoded string) (bool, bool, error) {
+	switch algorithmOf(encoded) {
+	case AlgorithmArgon2id:
+		params, salt, key, err := decodeArgon2id(encoded)
+		if err != nil {
+			return false, false, err
+		}
+		derived := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+		if subtle.ConstantTimeCompare(derived, key) != 1 {
+			return false, false, nil
+		}
+		current := h.cfg.Argon2
+		outdated := h.cfg.Algorithm != AlgorithmArgon2id ||
+			params.Memory != current.Memory ||
+			params.Iterations != current.Iterations ||
+			params.Parallelism != current.Parallelism ||
+			uint32(len(key)) != current.KeyLength
+		return true, outdated, nil
+
+	case AlgorithmBcrypt:
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, false, nil
+		}
+		if err != nil {
+			return false, false, fmt.Errorf("bcrypt: %w", err)
+		}
+		cost, err := bcrypt.Cost([]byte(encoded))
+		if err != nil {
+			return false, false, fmt.Errorf("bcrypt: %w", err)
+		}
+		return true, h.cfg.Algorithm != AlgorithmBcrypt || cost != h.cfg.BcryptCost, nil
+
+	default:
+		return false, false, ErrUnknownHash
+	}
+}
+
+// VerifyDummy hashes the dummy on first use. Stored hashes of the other algorithm or with
+// outdated parameters still differ in timing until the next login replaces them.
+func (h *hasher) VerifyDummy(password string) {
+	h.dummyOnce.Do(func() {
+		secret := make([]byte, 16)
+		_, _ = rand.Read(secret)
+		if hash, err := h.Hash(base64.RawStdEncoding.EncodeToString(secret)); err == nil {
+			h.dummy = hash
+		}
+	})
+
+	if h.dummy != "" {
+		_, _, _ = h.Verify(password, h.dummy)
+	}
+}
+
+// algorithmOf names the algorithm of an encoded hash, "" when it is none of them
+func algorithmOf(encoded string) string {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		return AlgorithmArgon2id
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		return AlgorithmBcrypt
+	default:
+		return ""
+	}
+}
+
+// hashArgon2id encodes the hash in the PHC string format used by the reference implementation:
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+func hashArgon2id(password string, params Argon2Params) (string, error) {
+	salt := make([]byte, params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("argon2id salt: %w", err)
+	}
+
+	key := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, params.Memory, params.Iterations, params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func decodeArgon2id(encoded string) (Argon2Params, []byte, []byte, error) {
+	var params Argon2Params
+
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return params, nil, nil, fmt.Errorf("%w: malformed argon2id hash", ErrUnknownHash)
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("%w: unsupported argon2 version", ErrUnknownHash)
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, fmt.Errorf("%w: malformed argon2id parameters", ErrUnknownHash)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("%w: malformed argon2id salt", ErrUnknownHash)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, fmt.Errorf("%w: malformed argon2id key", ErrUnknownHash)
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
@@ -0,0 +1,139 @@
+package password
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// cheap parameters keep the tests fast, they are not meant for production
+var testArgon2 = Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1}
+
+func givenHasher(t *testing.T, cfg Config) Hasher {
+	t.Helper()
+	h, err := NewHasher(cfg)
+	if err != nil {
+		t.Fatalf("new hasher: %v", err)
+	}
+	return h
+}
+
+func TestHasher_RoundTrip(t *testing.T) {
+	for _, cfg := range []Config{
+		{Algorithm: AlgorithmBcrypt, BcryptCost: bcrypt.MinCost},
+		{Algorithm: AlgorithmArgon2id, Argon2: testArgon2},
+	} {
+		t.Run(cfg.Algorithm, func(t *testing.T) {
+			h := givenHasher(t, cfg)
+			encoded, err := h.Hash("correct horse")
+			if err != nil {
+				t.Fatalf("hash: %v", err)
+			}
+
+			ok, rehash, err := h.Verify("correct horse", encoded)
+			if err != nil || !ok || rehash {
+				t.Fatalf("expected a match without rehash, got ok=%v rehash=%v err=%v", ok, rehash, err)
+			}
+			if ok, _, err := h.Verify("wrong horse", encoded); err != nil || ok {
+				t.Fatalf("expected a mismatch, got ok=%v err=%v", ok, err)
+			}
+		})
+	}
+}
+
+func TestHasher_FlagsOutdatedHashesForRehash(t *testing.T) {
+	oldBcrypt, _ := givenHasher(t, Config{Algorithm: AlgorithmBcrypt, BcryptCost: bcrypt.MinCost}).Hash("secret")
+	oldArgon2, _ := givenHasher(t, Config{Algorithm: AlgorithmArgon2id, Argon2: testArgon2}).Hash("secret")
+
+	stronger := testArgon2
+	stronger.Iterations = 2
+
+	cases := map[string]struct {
+		cfg     Config
+		encoded string
+	}{
+		"bcrypt to argon2id":     {Config{Algorithm: AlgorithmArgon2id, Argon2: testArgon2}, oldBcrypt},
+		"higher bcrypt cost":     {Config{Algorithm: AlgorithmBcrypt, BcryptCost: bcrypt.MinCost + 1}, oldBcrypt},
+		"argon2id to bcrypt":     {Config{Algorithm: AlgorithmBcrypt, BcryptCost: bcrypt.MinCost}, oldArgon2},
+		"more argon2 iterations": {Config{Algorithm: AlgorithmArgon2id, Argon2: stronger}, oldArgon2},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ok, rehash, err := givenHasher(t, tc.cfg).Verify("secret", tc.encoded)
+			if err != nil || !ok || !rehash {
+				t.Fatalf("expected a match that needs rehash, got ok=%v rehash=%v err=%v", ok, rehash, err)
+			}
+		})
+	}
+}
+
+func TestHasher_RejectsUnknownHashes(t *testing.T) {
+	h := givenHasher(t, Config{})
+	for _, encoded := range []string{"plain", "$argon2id$v=19$m=1,t=1$salt", "$argon2id$v=16$m=1024,t=1,p=1$c2FsdA$a2V5"} {
+		if _, _, err := h.Verify("secret", encoded); !errors.Is(err, ErrUnknownHash) {
+			t.Fatalf("%q: expected ErrUnknownHash, got %v", encoded, err)
+		}
+	}
+	if _, err := NewHasher(Config{Algorithm: "md5"}); err == nil {
+		t.Fatal("expected an unknown algorithm to be rejected")
+	}
+}
+
+func TestPolicy_LengthAndBreachedList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	list := strings.Join([]string{
+		"# common passwords",
+		"password123",
+		"",
+		// SHA-1 digests as published by Have I Been Pwned, in either case and with a count
+		"909a1cf42797b2ccdcf89b78e9dfbded1b47339e:12",
+		digest("qwertyuiop") + ":3",
+	}, "\n")
+	if err := os.WriteFile(path, []byte(list), 0o600); err != nil {
+		t.Fatalf("write list: %v", err)
+	}
+
+	policy, err := NewPolicy(9, path)
+	if err != nil {
+		t.Fatalf("new policy: %v", err)
+	}
+	if policy.BreachedCount() != 3 {
+		t.Fatalf("expected 3 breached entries, got %d", policy.BreachedCount())
+	}
+
+	if err := policy.Validate("short"); !errors.Is(err, ErrTooShort) {
+		t.Fatalf("expected ErrTooShort, got %v", err)
+	}
+	if err := policy.Validate("password123"); !errors.Is(err, ErrBreached) {
+		t.Fatalf("expected a plain text entry to match, got %v", err)
+	}
+	for _, breached := range []string{"letmein12", "qwertyuiop"} {
+		if err := policy.Validate(breached); !errors.Is(err, ErrBreached) {
+			t.Fatalf("expected the SHA-1 entry of %q to match, got %v", breached, err)
+		}
+	}
+	if err := policy.Validate("a much better passphrase"); err != nil {
+		t.Fatalf("expected a good password to pass, got %v", err)
+	}
+}
+
+func TestHasher_VerifyDummyUsesTheConfiguredAlgorithm(t *testing.T) {
+	h := givenHasher(t, Config{Algorithm: AlgorithmBcrypt, BcryptCost: bcrypt.MinCost, Argon2: testArgon2}).(*hasher)
+
+	h.VerifyDummy("secret")
+
+	if algorithmOf(h.dummy) != AlgorithmBcrypt {
+		t.Fatalf("expected a bcrypt dummy hash, got %q", h.dummy)
+	}
+	if ok, _, _ := h.Verify("secret", h.dummy); ok {
+		t.Fatal("expected the dummy hash not to match a real password")
+	}
+	cost, err := bcrypt.Cost([]byte(h.dummy))
+	if err != nil || cost != bcrypt.MinCost {
+		t.Fatalf("expected the dummy bcrypt hash to use the configured cost, got %d (%v)", cost, err)
+	}
+}
@@ -0,0 +1,94 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// Errors returned by Policy.Validate
+var (
+	ErrTooShort = errors.New("password is too short")
+	ErrBreached = errors.New("password appears in a list of breached passwords")
+)
+
+// DefaultMinLength is used when a policy does not set one
+const DefaultMinLength = 8
+
+// Policy decides which new passwords are acceptable
+type Policy struct {
+	MinLength int
+	// breached holds upper case SHA-1 hex digests of known breached passwords
+	breached map[string]struct{}
+}
+
+// NewPolicy creates a policy, breachedListPath may name a local file with one breached
+// password per line, either in plain text or as a SHA-1 hex digest (as published by
+// Have I Been Pwned, an optional ":count" suffix is ignored). Empty lines and lines
+// starting with # are skipped.
+func NewPolicy(minLength int, breachedListPath string) (*Policy, error) {
+	if minLength <= 0 {
+		minLength = DefaultMinLength
+	}
+	policy := &Policy{MinLength: minLength, breached: map[string]struct{}{}}
+	if breachedListPath == "" {
+		return policy, nil
+	}
+
+	file, err := os.Open(breachedListPath)
+	if err != nil {
+		return nil, fmt.Errorf("open breached password list: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		policy.breached[breachedKey(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read breached password list: %w", err)
+	}
+
+	return policy, nil
+}
+
+// Validate returns ErrTooShort or ErrBreached for unacceptable passwords
+func (p *Policy) Validate(password string) error {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return fmt.Errorf("%w: at least %d characters are required", ErrTooShort, p.MinLength)
+	}
+	if _, ok := p.breached[digest(password)]; ok {
+		return ErrBreached
+	}
+	return nil
+}
+
+// BreachedCount returns the number of entries loaded from the breached list
+func (p *Policy) BreachedCount() int {
+	return len(p.breached)
+}
+
+// breachedKey turns a line of the list into the key it is looked up by
+func breachedKey(line string) string {
+	candidate, _, _ := strings.Cut(line, ":")
+	if len(candidate) == sha1.Size*2 {
+		if _, err := hex.DecodeString(candidate); err == nil {
+			return strings.ToUpper(candidate)
+		}
+	}
+	return digest(line)
+}
+
+func digest(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
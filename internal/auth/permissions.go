@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"slices"
+	"sort"
+)
+
+// Permissions carried in access tokens and required by the routes
+const (
+	PermissionCompaniesRead   = "companies:read"
+	PermissionCompaniesWrite  = "companies:write"
+	PermissionCompaniesDelete = "companies:delete"
+	// PermissionUsersAdmin opens the administration of the caller's own tenant under /admin
+	PermissionUsersAdmin = "users:admin"
+	// PermissionTenantsAdmin opens what spans tenants: tenants, dead letters and the MFA policy
+	PermissionTenantsAdmin = "tenants:admin"
+)
+
+// Built-in roles, users hold any number of them
+const (
+	RoleViewer  = "viewer"
+	RoleEditor  = "editor"
+	RoleManager = "manager"
+	RoleAdmin   = "admin"
+	// RoleOperator runs the deployment for every tenant
+	RoleOperator = "operator"
+)
+
+// rolePermissions lists what each role grants
+var rolePermissions = map[string][]string{
+	RoleViewer:  {PermissionCompaniesRead},
+	RoleEditor:  {PermissionCompaniesRead, PermissionCompaniesWrite},
+	RoleManager: {PermissionCompaniesRead, PermissionCompaniesWrite, PermissionCompaniesDelete},
+	RoleAdmin:   {PermissionCompaniesRead, PermissionCompaniesWrite, PermissionCompaniesDelete, PermissionUsersAdmin},
+	RoleOperator: {PermissionCompaniesRead, PermissionCompaniesWrite, PermissionCompaniesDelete, PermissionUsersAdmin,
+		PermissionTenantsAdmin},
+}
+
+// IsPermission reports whether permission is granted by any of the built-in roles
+func IsPermission(permission string) bool {
+	return slices.Contains(rolePermissions[RoleOperator], permission)
+}
+
+// IsRole reports whether role is one of the built-in roles
+func IsRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// PermissionsForRoles returns the sorted union of the permissions of the roles, unknown roles grant nothing
+func PermissionsForRoles(roles []string) []string {
+	var permissions []string
+	for _, role := range roles {
+		for _, permission := range rolePermissions[role] {
+			if !slices.Contains(permissions, permission) {
+				permissions = append(permissions, permission)
+			}
+		}
+	}
+	sort.Strings(permissions)
+	return permissions
+}
+
+// HasPermission reports whether the token grants permission
+func (c *Claims) HasPermission(permission string) bool {
+	return slices.Contains(c.Permissions, permission)
+}
@@ -0,0 +1,162 @@
+// Package revocation keeps track of access tokens that were revoked before they expire.
+package revocation
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ktsiligkos/xm_project/internal/auth"
+	"github.com/ktsiligkos/xm_project/internal/domain"
+	revokedtokenrepository "github.com/ktsiligkos/xm_project/internal/repository/revokedtoken"
+)
+
+// Options tunes the Store
+type Options struct {
+	// MaxTokenAge is the longest life of an access token, user cutoffs older than that
+	// cannot match a token that is still valid and are not kept in memory
+	MaxTokenAge time.Duration
+	// OnError is called when a background sync fails
+	OnError func(err error)
+}
+
+// Store answers IsRevoked from memory, so checking a request costs no database round trip.
+// Revocations are written through to the repository and the cache is reloaded periodically
+// by Run, which is how revocations made by other instances reach this one.
+type Store struct {
+	repo revokedtokenrepository.Repository
+	opts Options
+
+	mu sync.RWMutex
+	// tokens maps a jti to its revocation
+	tokens map[string]domain.RevokedToken
+	// users maps a user ID to the time before which all of their tokens are revoked
+	users map[string]time.Time
+}
+
+// NewStore creates an empty store, call Sync to load the current revocations
+func NewStore(repo revokedtokenrepository.Repository, opts Options) *Store {
+	if opts.MaxTokenAge <= 0 {
+		opts.MaxTokenAge = time.Hour
+	}
+	return &Store{
+		repo:   repo,
+		opts:   opts,
+		tokens: map[string]domain.RevokedToken{},
+		users:  map[string]time.Time{},
+	}
+}
+
+// IsRevoked reports whether the token was revoked by its jti or by a cutoff of its user.
+// Issue times only have a precision of seconds, so a token issued in the same second as a
+// cutoff counts as revoked; a client logging in right after a revocation may need to retry.
+func (s *Store) IsRevoked(claims *auth.Claims) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if claims.ID != "" {
+		if revoked, ok := s.tokens[claims.ID]; ok && time.Now().Before(revoked.ExpiresAt) {
+			return true
+		}
+	}
+
+	cutoff, ok := s.users[claims.UserID]
+	if !ok {
+		return false
+	}
+	if claims.IssuedAt == nil {
+		// tokens that do not say when they were issued cannot be shown to be newer
+		return true
+	}
+	return !claims.IssuedAt.After(cutoff)
+}
+
+// RevokeToken rejects the token with the given jti until it expires
+func (s *Store) RevokeToken(ctx context.Context, jti, userID string, expiresAt time.Time) error {
+	revoked := domain.RevokedToken{JTI: jti, UserID: userID, ExpiresAt: expiresAt, RevokedAt: time.Now().UTC()}
+	if err := s.repo.RevokeToken(ctx, revoked); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if _, ok := s.tokens[jti]; !ok {
+		s.tokens[jti] = revoked
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// RevokeUser rejects every token of the user issued up to at
+func (s *Store) RevokeUser(ctx context.Context, userID string, at time.Time) error {
+	if err := s.repo.RevokeUserTokens(ctx, userID, at); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if at.After(s.users[userID]) {
+		s.users[userID] = at
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// Sync replaces the cache with the revocations stored in the repository and drops
+// the ones that expired. Revocations made while the sync runs are kept.
+func (s *Store) Sync(ctx context.Context) error {
+	started := time.Now().UTC()
+
+	if err := s.repo.DeleteExpiredRevokedTokens(ctx, started); err != nil {
+		return err
+	}
+	revokedTokens, err := s.repo.ListRevokedTokens(ctx, started)
+	if err != nil {
+		return err
+	}
+	userRevocations, err := s.repo.ListUserTokenRevocations(ctx, started.Add(-s.opts.MaxTokenAge))
+	if err != nil {
+		return err
+	}
+
+	tokens := make(map[string]domain.RevokedToken, len(revokedTokens))
+	for _, token := range revokedTokens {
+		tokens[token.JTI] = token
+	}
+	users := make(map[string]time.Time, len(userRevocations))
+	for _, revocation := range userRevocations {
+		users[revocation.UserID] = revocation.RevokedBefore
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for jti, token := range s.tokens {
+		if _, ok := tokens[jti]; !ok && !token.RevokedAt.Before(started) {
+			tokens[jti] = token
+		}
+	}
+	for userID, cutoff := range s.users {
+		if !cutoff.Before(started) && cutoff.After(users[userID]) {
+			users[userID] = cutoff
+		}
+	}
+	s.tokens = tokens
+	s.users = users
+	return nil
+}
+
+// Run calls Sync every interval until ctx is cancelled
+func (s *Store) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Sync(ctx); err != nil && ctx.Err() == nil && s.opts.OnError != nil {
+				s.opts.OnError(fmt.Errorf("sync token revocations: %w", err))
+			}
+		}
+	}
+}
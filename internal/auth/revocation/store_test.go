@@ -0,0 +1,167 @@
+package revocation
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/ktsiligkos/xm_project/internal/auth"
+	"github.com/ktsiligkos/xm_project/internal/domain"
+)
+
+// memoryRepository stands in for the database shared by all instances
+type memoryRepository struct {
+	mu     sync.Mutex
+	tokens map[string]domain.RevokedToken
+	users  map[string]time.Time
+}
+
+func newMemoryRepository() *memoryRepository {
+	return &memoryRepository{tokens: map[string]domain.RevokedToken{}, users: map[string]time.Time{}}
+}
+
+func (r *memoryRepository) RevokeToken(_ context.Context, token domain.RevokedToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.tokens[token.JTI]; !ok {
+		r.tokens[token.JTI] = token
+	}
+	return nil
+}
+
+func (r *memoryRepository) RevokeUserTokens(_ context.Context, userID string, before time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if before.After(r.users[userID]) {
+		r.users[userID] = before
+	}
+	return nil
+}
+
+func (r *memoryRepository) ListRevokedTokens(_ context.Context, now time.Time) ([]domain.RevokedToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var tokens []domain.RevokedToken
+	for _, token := range r.tokens {
+		if token.ExpiresAt.After(now) {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens, nil
+}
+
+func (r *memoryRepository) ListUserTokenRevocations(_ context.Context, since time.Time) ([]domain.UserTokenRevocation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var revocations []domain.UserTokenRevocation
+	for userID, before := range r.users {
+		if before.After(since) {
+			revocations = append(revocations, domain.UserTokenRevocation{UserID: userID, RevokedBefore: before})
+		}
+	}
+	return revocations, nil
+}
+
+func (r *memoryRepository) DeleteExpiredRevokedTokens(_ context.Context, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for jti, token := range r.tokens {
+		if !token.ExpiresAt.After(now) {
+			delete(r.tokens, jti)
+		}
+	}
+	return nil
+}
+
+func claims(jti, userID string, issuedAt time.Time) *auth.Claims {
+	return &auth.Claims{
+		UserID: userID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			IssuedAt:  jwt.NewNumericDate(issuedAt),
+			ExpiresAt: jwt.NewNumericDate(issuedAt.Add(time.Hour)),
+		},
+	}
+}
+
+func TestStore_RevokeToken(t *testing.T) {
+	store := NewStore(newMemoryRepository(), Options{MaxTokenAge: time.Hour})
+	issued := time.Now().Add(-time.Minute)
+
+	if err := store.RevokeToken(context.Background(), "jti-1", "u1", issued.Add(time.Hour)); err != nil {
+		t.Fatalf("revoke token: %v", err)
+	}
+
+	if !store.IsRevoked(claims("jti-1", "u1", issued)) {
+		t.Fatal("expected the revoked token to be rejected")
+	}
+	if store.IsRevoked(claims("jti-2", "u1", issued)) {
+		t.Fatal("another token of the same user must stay valid")
+	}
+}
+
+func TestStore_RevokeUserRejectsOnlyOlderTokens(t *testing.T) {
+	store := NewStore(newMemoryRepository(), Options{MaxTokenAge: time.Hour})
+	cutoff := time.Now()
+
+	if err := store.RevokeUser(context.Background(), "u1", cutoff); err != nil {
+		t.Fatalf("revoke user: %v", err)
+	}
+
+	if !store.IsRevoked(claims("a", "u1", cutoff.Add(-time.Minute))) {
+		t.Fatal("expected a token issued before the cutoff to be rejected")
+	}
+	if store.IsRevoked(claims("b", "u1", cutoff.Add(2*time.Second))) {
+		t.Fatal("a token issued after the cutoff must stay valid")
+	}
+	if store.IsRevoked(claims("c", "u2", cutoff.Add(-time.Minute))) {
+		t.Fatal("tokens of other users must stay valid")
+	}
+}
+
+func TestStore_SyncLoadsRevocationsOfOtherInstances(t *testing.T) {
+	repo := newMemoryRepository()
+	instanceA := NewStore(repo, Options{MaxTokenAge: time.Hour})
+	instanceB := NewStore(repo, Options{MaxTokenAge: time.Hour})
+	issued := time.Now().Add(-time.Minute)
+
+	if err := instanceA.RevokeToken(context.Background(), "jti-1", "u1", issued.Add(time.Hour)); err != nil {
+		t.Fatalf("revoke token: %v", err)
+	}
+	if err := instanceA.RevokeUser(context.Background(), "u2", time.Now()); err != nil {
+		t.Fatalf("revoke user: %v", err)
+	}
+	if instanceB.IsRevoked(claims("jti-1", "u1", issued)) {
+		t.Fatal("the other instance should not know about the revocation before syncing")
+	}
+
+	if err := instanceB.Sync(context.Background()); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	if !instanceB.IsRevoked(claims("jti-1", "u1", issued)) {
+		t.Fatal("expected the token to be revoked after the sync")
+	}
+	if !instanceB.IsRevoked(claims("x", "u2", issued)) {
+		t.Fatal("expected the user cutoff to be loaded by the sync")
+	}
+}
+
+func TestStore_SyncDropsExpiredRevocations(t *testing.T) {
+	repo := newMemoryRepository()
+	store := NewStore(repo, Options{MaxTokenAge: time.Hour})
+	issued := time.Now().Add(-2 * time.Hour)
+
+	if err := repo.RevokeToken(context.Background(), domain.RevokedToken{JTI: "old", ExpiresAt: issued.Add(time.Hour), RevokedAt: issued}); err != nil {
+		t.Fatalf("revoke token: %v", err)
+	}
+	if err := store.Sync(context.Background()); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+
+	if len(store.tokens) != 0 || len(repo.tokens) != 0 {
+		t.Fatalf("expected the expired revocation to be dropped, cache %d, repository %d", len(store.tokens), len(repo.tokens))
+	}
+}
@@ -0,0 +1,70 @@
+package totp
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key of the RFC 6238 test vectors, "12345678901234567890" in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode_MatchesTheRFCTestVectors(t *testing.T) {
+	// the RFC lists 8 digit codes, the last 6 digits are the 6 digit codes
+	for unix, want := range map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	} {
+		got, err := Code(rfcSecret, Step(time.Unix(unix, 0)))
+		if err != nil {
+			t.Fatalf("Code returned error: %v", err)
+		}
+		if got != want {
+			t.Fatalf("at %d: want %s, got %s", unix, want, got)
+		}
+	}
+}
+
+func TestValidate_AcceptsNeighbouringStepsOnly(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret returned error: %v", err)
+	}
+	now := time.Unix(1_700_000_000, 0)
+
+	for offset, accepted := range map[int64]bool{-2: false, -1: true, 0: true, 1: true, 2: false} {
+		code, _ := Code(secret, Step(now)+offset)
+		step, ok := Validate(secret, code, now, 1)
+		if ok != accepted {
+			t.Fatalf("code of step offset %d: want accepted=%v, got %v", offset, accepted, ok)
+		}
+		if ok && step != Step(now)+offset {
+			t.Fatalf("expected the matched step %d, got %d", Step(now)+offset, step)
+		}
+	}
+
+	if _, ok := Validate(secret, "12345", now, 1); ok {
+		t.Fatal("expected codes of the wrong length to be rejected")
+	}
+	if _, ok := Validate("not base32!", "123456", now, 1); ok {
+		t.Fatal("expected an invalid secret to reject every code")
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri, err := url.Parse(ProvisioningURI("XM Companies", "jane@example.com", rfcSecret))
+	if err != nil {
+		t.Fatalf("parse uri: %v", err)
+	}
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/XM Companies:jane@example.com" {
+		t.Fatalf("unexpected uri %s", uri)
+	}
+	query := uri.Query()
+	if query.Get("secret") != rfcSecret || query.Get("issuer") != "XM Companies" || query.Get("digits") != "6" || query.Get("period") != "30" {
+		t.Fatalf("unexpected parameters %v", query)
+	}
+}
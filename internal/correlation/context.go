@@ -0,0 +1,97 @@
+// Package correlation carries the identifiers that tie work back to the
+// request that caused it (request ID, acting user, client address, W3C trace context).
+package correlation
+
+import (
+	"context"
+	"errors"
+)
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	userIDKey
+	traceParentKey
+	tenantKey
+	allTenantsKey
+	clientIPKey
+)
+
+// ErrNoTenant is returned by data access that ctx neither scopes to a tenant nor opens to all of them
+var ErrNoTenant = errors.New("no tenant in context")
+
+// WithRequestID returns a copy of ctx carrying the request ID
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey, requestID)
+}
+
+// RequestID returns the request ID stored in ctx, if any
+func RequestID(ctx context.Context) string {
+	value, _ := ctx.Value(requestIDKey).(string)
+	return value
+}
+
+// WithUserID returns a copy of ctx carrying the authenticated user's ID
+func WithUserID(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, userIDKey, userID)
+}
+
+// UserID returns the authenticated user's ID stored in ctx, if any
+func UserID(ctx context.Context) string {
+	value, _ := ctx.Value(userIDKey).(string)
+	return value
+}
+
+// WithTraceParent returns a copy of ctx carrying a W3C traceparent value
+func WithTraceParent(ctx context.Context, traceParent string) context.Context {
+	return context.WithValue(ctx, traceParentKey, traceParent)
+}
+
+// TraceParent returns the W3C traceparent stored in ctx, if any
+func TraceParent(ctx context.Context) string {
+	value, _ := ctx.Value(traceParentKey).(string)
+	return value
+}
+
+// WithTenant returns a copy of ctx carrying the tenant the request acts for
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey, tenant)
+}
+
+// Tenant returns the tenant stored in ctx, if any
+func Tenant(ctx context.Context) string {
+	value, _ := ctx.Value(tenantKey).(string)
+	return value
+}
+
+// WithAllTenants returns a copy of ctx that may read and change the data of every tenant.
+// It is meant for operator tooling such as cmd/snapshot and for the identity lookups that
+// find out the tenant in the first place (login, refresh tokens, API keys); request
+// handlers act for the tenant of their principal instead.
+func WithAllTenants(ctx context.Context) context.Context {
+	return context.WithValue(ctx, allTenantsKey, true)
+}
+
+// TenantScope returns the tenant that data access in ctx is limited to, an empty tenant
+// for a ctx from WithAllTenants and ErrNoTenant when ctx has neither
+func TenantScope(ctx context.Context) (string, error) {
+	if tenant := Tenant(ctx); tenant != "" {
+		return tenant, nil
+	}
+	if all, _ := ctx.Value(allTenantsKey).(bool); all {
+		return "", nil
+	}
+	return "", ErrNoTenant
+}
+
+// WithClientIP returns a copy of ctx carrying the address of the client that made the request
+func WithClientIP(ctx context.Context, clientIP string) context.Context {
+	return context.WithValue(ctx, clientIPKey, clientIP)
+}
+
+// ClientIP returns the client address stored in ctx, if any
+func ClientIP(ctx context.Context) string {
+	value, _ := ctx.Value(clientIPKey).(string)
+	return value
+}
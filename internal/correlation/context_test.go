@@ -0,0 +1,29 @@
+package correlation
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestTenantScope_RequiresATenantOrAllTenants(t *testing.T) {
+	if _, err := TenantScope(context.Background()); !errors.Is(err, ErrNoTenant) {
+		t.Fatalf("expected ErrNoTenant without a tenant, got %v", err)
+	}
+
+	tenant, err := TenantScope(WithTenant(context.Background(), "t1"))
+	if err != nil || tenant != "t1" {
+		t.Fatalf("expected the tenant of ctx, got %q, %v", tenant, err)
+	}
+
+	tenant, err = TenantScope(WithAllTenants(context.Background()))
+	if err != nil || tenant != "" {
+		t.Fatalf("expected every tenant, got %q, %v", tenant, err)
+	}
+
+	// a tenant set later, as the login does once it found the user, narrows the scope again
+	tenant, err = TenantScope(WithTenant(WithAllTenants(context.Background()), "t2"))
+	if err != nil || tenant != "t2" {
+		t.Fatalf("expected the tenant to win, got %q, %v", tenant, err)
+	}
+}
@@ -0,0 +1,76 @@
+package correlation
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+)
+
+const (
+	traceParentVersion = "00"
+	defaultTraceFlags  = "01"
+)
+
+// ChildTraceParent continues the trace described by the incoming traceparent
+// header with a new span ID for this service. A missing or malformed header
+// starts a new trace, as the W3C Trace Context specification requires.
+func ChildTraceParent(header string) string {
+	traceID, flags, ok := parseTraceParent(header)
+	if !ok {
+		traceID = randomHex(16)
+		flags = defaultTraceFlags
+	}
+
+	return traceParentVersion + "-" + traceID + "-" + randomHex(8) + "-" + flags
+}
+
+// parseTraceParent extracts the trace ID and flags from a version 00 traceparent
+func parseTraceParent(header string) (traceID, flags string, ok bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 {
+		return "", "", false
+	}
+
+	version, traceID, parentID, flags := parts[0], parts[1], parts[2], parts[3]
+	if !isLowerHex(version, 2) || version == "ff" {
+		return "", "", false
+	}
+	// only version 00 has a fixed layout, later versions may append fields
+	if version == traceParentVersion && len(parts) != 4 {
+		return "", "", false
+	}
+	if !isLowerHex(traceID, 32) || isZero(traceID) {
+		return "", "", false
+	}
+	if !isLowerHex(parentID, 16) || isZero(parentID) {
+		return "", "", false
+	}
+	if !isLowerHex(flags, 2) {
+		return "", "", false
+	}
+
+	return traceID, flags, true
+}
+
+func isLowerHex(value string, length int) bool {
+	if len(value) != length {
+		return false
+	}
+	for _, r := range value {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func isZero(value string) bool {
+	return strings.Trim(value, "0") == ""
+}
+
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	// crypto/rand.Read never returns an error on supported platforms
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,54 @@
+package correlation
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestChildTraceParent_ContinuesValidTrace(t *testing.T) {
+	incoming := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	got := ChildTraceParent(incoming)
+
+	traceID, flags, ok := parseTraceParent(got)
+	if !ok {
+		t.Fatalf("child traceparent %q is not valid", got)
+	}
+	if traceID != "4bf92f3577b34da6a3ce929d0e0e4736" || flags != "01" {
+		t.Fatalf("child must keep trace id and flags, got %q", got)
+	}
+	if strings.Contains(got, "00f067aa0ba902b7") {
+		t.Fatalf("child must use a new parent id, got %q", got)
+	}
+}
+
+func TestChildTraceParent_StartsNewTraceForInvalidHeaders(t *testing.T) {
+	for _, header := range []string{
+		"",
+		"garbage",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	} {
+		got := ChildTraceParent(header)
+		traceID, _, ok := parseTraceParent(got)
+		if !ok {
+			t.Fatalf("header %q: generated traceparent %q is not valid", header, got)
+		}
+		if strings.Contains(header, traceID) {
+			t.Fatalf("header %q: expected a new trace id, got %q", header, got)
+		}
+	}
+}
+
+func TestChildTraceParent_AcceptsFutureVersions(t *testing.T) {
+	incoming := "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future"
+
+	got := ChildTraceParent(incoming)
+
+	if !strings.HasPrefix(got, "00-4bf92f3577b34da6a3ce929d0e0e4736-") {
+		t.Fatalf("expected the trace to be continued, got %q", got)
+	}
+}
@@ -0,0 +1,39 @@
+package domain
+
+import "time"
+
+// APIKey lets a service act as a user without logging in. Only a hash of the key is stored,
+// the key itself is returned once, when it is created.
+type APIKey struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Prefix is the start of the key, enough to recognise it in listings
+	Prefix string `json:"prefix"`
+	// KeyHash is the SHA-256 of the key, it is never serialised
+	KeyHash string `json:"-"`
+	// UserID is the user the key acts as
+	UserID string `json:"user_id"`
+	// TenantID is the tenant of the user, it is only filled in when the key is authenticated
+	TenantID string `json:"-"`
+	// Scopes are the permissions the key grants
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// CreateAPIKeyRequest is sent by admins to issue a key
+type CreateAPIKeyRequest struct {
+	Name string `json:"name" binding:"required"`
+	// UserID defaults to the admin creating the key
+	UserID    string     `json:"user_id"`
+	Scopes    []string   `json:"scopes" binding:"required"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// CreatedAPIKey is the response to a create, the only time the key is shown
+type CreatedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
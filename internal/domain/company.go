@@ -19,6 +19,7 @@ const (
 
 type Company struct {
 	ID                string      `json:"id" binding:"required"`
+	TenantID          string      `json:"tenant_id,omitempty"`
 	Name              string      `json:"name" binding:"required"`
 	Description       *string     `json:"description,omitempty"`
 	AmountOfEmployees int         `json:"amount_of_employees" binding:"required"`
@@ -33,3 +34,9 @@ type PatchCompanyRequest struct {
 	Registered        *bool        `json:"registered,omitempty"`
 	Type              *CompanyType `json:"type,omitempty"`
 }
+
+// CompanyFilter narrows a listing of companies, nil fields are not applied
+type CompanyFilter struct {
+	Type       *CompanyType
+	Registered *bool
+}
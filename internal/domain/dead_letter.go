@@ -0,0 +1,26 @@
+package domain
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Streams of the events kept as dead letters, each is replayed to its own topic
+const (
+	DeadLetterStreamCompany = "company"
+	DeadLetterStreamUser    = "user"
+)
+
+// DeadLetter is an event that could not be published, kept so it can be replayed
+type DeadLetter struct {
+	ID        int64  `json:"id"`
+	Stream    string `json:"stream"`
+	Operation string `json:"operation"`
+	// CompanyID is empty for user events, their payload names the user
+	CompanyID     string          `json:"company_id"`
+	Payload       json.RawMessage `json:"payload"`
+	LastError     string          `json:"last_error"`
+	Attempts      int             `json:"attempts"`
+	CreatedAt     time.Time       `json:"created_at"`
+	LastAttemptAt time.Time       `json:"last_attempt_at"`
+}
@@ -0,0 +1,25 @@
+package domain
+
+import "time"
+
+// Scopes failed logins and password reset requests are counted in
+const (
+	// LoginScopeAccount counts the failures for one email address, whether or not an account uses it
+	LoginScopeAccount = "account"
+	// LoginScopeIP counts the failures from one client IP, across all email addresses
+	LoginScopeIP = "ip"
+	// ResetScopeAccount and ResetScopeIP count password reset requests the same way
+	ResetScopeAccount = "reset_account"
+	ResetScopeIP      = "reset_ip"
+)
+
+// LoginAttempts tracks the recent failed logins of an account or a client IP
+type LoginAttempts struct {
+	Scope string
+	Key   string
+	// Failures counts the failures since the counter was last reset
+	Failures      int
+	LastFailureAt time.Time
+	// BlockedUntil is set while further logins are refused without checking the password
+	BlockedUntil *time.Time
+}
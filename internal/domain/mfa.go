@@ -0,0 +1,80 @@
+package domain
+
+import "time"
+
+// MFAEnrollment is the TOTP secret of a user. It is pending until the user proved with a
+// code that their authenticator app holds the secret, only confirmed enrollments are asked for at login.
+type MFAEnrollment struct {
+	UserID string
+	Secret string
+	// ConfirmedAt is nil while the enrollment is pending
+	ConfirmedAt *time.Time
+	// LastUsedStep is the time step of the last accepted code, a code is never accepted twice
+	LastUsedStep int64
+	CreatedAt    time.Time
+}
+
+// Confirmed reports whether logins of the user need a second factor
+func (e MFAEnrollment) Confirmed() bool {
+	return e.ConfirmedAt != nil
+}
+
+// MFARecoveryCode replaces a TOTP code once, when the authenticator app is lost. Only a hash is stored.
+type MFARecoveryCode struct {
+	ID        string
+	UserID    string
+	CodeHash  string
+	CreatedAt time.Time
+	UsedAt    *time.Time
+}
+
+// MFAChallenge is a login whose password was right and that waits for the second factor
+type MFAChallenge struct {
+	ID        string
+	UserID    string
+	TokenHash string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	// Attempts counts the wrong codes, the challenge is dropped after too many
+	Attempts int
+	UsedAt   *time.Time
+}
+
+// MFALoginRequest completes a login with the challenge token and a TOTP or recovery code
+type MFALoginRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+// MFAChallengeRequest names a pending login, to enroll in MFA before completing it
+type MFAChallengeRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+}
+
+// MFACodeRequest carries a TOTP code, or a recovery code where the endpoint accepts one
+type MFACodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// MFARequiredRolesRequest replaces the roles whose users must use MFA, an empty list requires it of nobody
+type MFARequiredRolesRequest struct {
+	Roles []string `json:"roles"`
+}
+
+// TOTPEnrollment is shown once when an enrollment starts, the secret cannot be read back later
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	// ProvisioningURI is the otpauth:// URI to render as a QR code for authenticator apps
+	ProvisioningURI string `json:"provisioning_uri"`
+	// RecoveryCodes become valid together with the enrollment
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// MFAStatus tells a user whether MFA is on and whether a role of theirs requires it
+type MFAStatus struct {
+	Enabled bool `json:"enabled"`
+	// Pending is set while an enrollment waits for its first code
+	Pending                bool `json:"pending"`
+	Required               bool `json:"required"`
+	RecoveryCodesRemaining int  `json:"recovery_codes_remaining"`
+}
@@ -0,0 +1,25 @@
+package domain
+
+import "time"
+
+// PasswordResetToken is the stored form of a reset token mailed to a user, only a hash of the token is kept
+type PasswordResetToken struct {
+	ID        string
+	UserID    string
+	TokenHash string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	// UsedAt is set once the token reset the password, or when a newer token replaced it
+	UsedAt *time.Time
+}
+
+// PasswordResetRequest asks for a reset link to be mailed to the address
+type PasswordResetRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+// ConfirmPasswordResetRequest sets a new password with the token from the reset mail
+type ConfirmPasswordResetRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
@@ -0,0 +1,25 @@
+package domain
+
+import "time"
+
+// DefaultTenantID is the tenant of everything that existed before tenants were introduced.
+// Access tokens without a tenant claim act for it.
+const DefaultTenantID = "00000000-0000-0000-0000-000000000001"
+
+// Tenant is a business unit, its users only see the companies of the same tenant
+type Tenant struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// CreateTenantRequest is sent by operators to add a tenant
+type CreateTenantRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+// UpdateTenantRequest renames a tenant
+type UpdateTenantRequest struct {
+	Name string `json:"name" binding:"required"`
+}
@@ -0,0 +1,64 @@
+package domain
+
+import "time"
+
+// TokenPair is handed out at login and on every refresh
+type TokenPair struct {
+	AccessToken string
+	// AccessTokenTTL is how long the access token stays valid
+	AccessTokenTTL time.Duration
+	// RefreshToken is empty when refresh tokens are not enabled
+	RefreshToken string
+	// MFA is set instead of the tokens when the password was right but a second factor is due
+	MFA *PendingMFA
+}
+
+// PendingMFA asks the client to complete the login with a TOTP or recovery code
+type PendingMFA struct {
+	// Token names the login at POST /login/mfa, it can be used once
+	Token string
+	TTL   time.Duration
+}
+
+// RefreshToken is the stored form of a refresh token, only a hash of the token is kept.
+// Every rotation creates a new token in the same family, so a reused token can revoke all of them.
+type RefreshToken struct {
+	ID        string
+	FamilyID  string
+	UserID    string
+	TokenHash string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	// UsedAt is set once the token was exchanged for a new pair
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+	// MFAVerified is set when the login that started the family completed a second factor
+	MFAVerified bool
+}
+
+// RefreshTokenRequest carries a refresh token to rotate or revoke
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// RevokedToken is an access token that must be rejected before it expires
+type RevokedToken struct {
+	// JTI is the unique ID of the token
+	JTI string
+	// UserID is empty when the token was revoked by its ID alone
+	UserID    string
+	ExpiresAt time.Time
+	RevokedAt time.Time
+}
+
+// UserTokenRevocation rejects every access token of the user issued before RevokedBefore
+type UserTokenRevocation struct {
+	UserID        string
+	RevokedBefore time.Time
+}
+
+// RevokeTokenRequest names the access token to revoke, either the token itself or its jti
+type RevokeTokenRequest struct {
+	Token string `json:"token"`
+	JTI   string `json:"jti"`
+}
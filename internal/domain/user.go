@@ -1,11 +1,27 @@
 package domain
 
+import "time"
+
 // This is the user with the passowrd and its hashed password
 type User struct {
-	ID       string
-	Name     string
-	Email    string
-	Password string
+	ID string `json:"id"`
+	// TenantID is the tenant the user belongs to, their tokens only reach its companies
+	TenantID string `json:"tenant_id"`
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	// Password holds the hash, it is never serialised
+	Password string `json:"-"`
+	// Roles decide the permissions in the access tokens of the user
+	Roles []string `json:"roles"`
+	// DisabledAt is set once an admin disabled the user, disabled users cannot log in
+	DisabledAt *time.Time `json:"disabled_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// Disabled reports whether the user was disabled
+func (u User) Disabled() bool {
+	return u.DisabledAt != nil
 }
 
 // This is the user login request
@@ -13,3 +29,20 @@ type UserLoginRequest struct {
 	Email    string `json:"email" binding:"required"`
 	Password string `json:"password" binding:"required"`
 }
+
+// CreateUserRequest is sent by admins to register a user
+type CreateUserRequest struct {
+	Name     string `json:"name" binding:"required"`
+	Email    string `json:"email" binding:"required"`
+	Password string `json:"password" binding:"required"`
+	// Roles defaults to viewer when left out, an empty list grants nothing
+	Roles []string `json:"roles"`
+}
+
+// UpdateUserRequest changes the given fields of a user, nil fields are left as they are
+type UpdateUserRequest struct {
+	Name     *string   `json:"name,omitempty"`
+	Email    *string   `json:"email,omitempty"`
+	Password *string   `json:"password,omitempty"`
+	Roles    *[]string `json:"roles,omitempty"`
+}
@@ -0,0 +1,55 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// FileMailer writes every message as an .eml file into an outbox directory instead of
+// sending it, for local development and tests. Mail clients open the files as they are.
+type FileMailer struct {
+	dir  string
+	from string
+}
+
+// NewFileMailer creates the outbox directory when it does not exist yet
+func NewFileMailer(dir, from string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("create mail outbox: %w", err)
+	}
+	return &FileMailer{dir: dir, from: from}, nil
+}
+
+// Send writes the message to the outbox, replacing the file atomically so readers never see half a message
+func (m *FileMailer) Send(_ context.Context, msg Message) error {
+	now := time.Now()
+	data, err := msg.format(m.from, now)
+	if err != nil {
+		return err
+	}
+
+	// the timestamp keeps the files in the order they were sent
+	name := fmt.Sprintf("%s-%s.eml", now.UTC().Format("20060102T150405.000000000"), uuid.NewString())
+	tmp, err := os.CreateTemp(m.dir, ".outgoing-*")
+	if err != nil {
+		return fmt.Errorf("write mail: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write mail: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write mail: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(m.dir, name)); err != nil {
+		return fmt.Errorf("write mail: %w", err)
+	}
+	return nil
+}
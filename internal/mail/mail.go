@@ -0,0 +1,73 @@
+// Package mail delivers the messages the service sends to users, such as password reset links.
+package mail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrInvalidMessage is returned for messages that cannot be sent as given
+var ErrInvalidMessage = errors.New("invalid mail message")
+
+// Message is a plain text mail to a single recipient
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages, Send returns once the message was handed over
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// format renders the message as RFC 5322 text with CRLF line endings
+func (m Message) format(from string, date time.Time) ([]byte, error) {
+	if m.To == "" {
+		return nil, fmt.Errorf("%w: recipient is required", ErrInvalidMessage)
+	}
+	// a line break in a header would let the value add headers or recipients of its own
+	for _, value := range []string{from, m.To, m.Subject} {
+		if strings.ContainsAny(value, "\r\n") {
+			return nil, fmt.Errorf("%w: headers must not contain line breaks", ErrInvalidMessage)
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", m.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s@%s>\r\n", uuid.NewString(), domainOf(from))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	buf.WriteString("\r\n")
+
+	body := strings.ReplaceAll(m.Body, "\r\n", "\n")
+	for _, line := range strings.Split(body, "\n") {
+		// a lone dot would end the SMTP DATA section early
+		if strings.HasPrefix(line, ".") {
+			line = "." + line
+		}
+		buf.WriteString(line)
+		buf.WriteString("\r\n")
+	}
+	return buf.Bytes(), nil
+}
+
+// domainOf returns the domain of an address, used to make Message-IDs unique per sender
+func domainOf(address string) string {
+	address = strings.TrimSuffix(address, ">")
+	if at := strings.LastIndex(address, "@"); at >= 0 && at < len(address)-1 {
+		return address[at+1:]
+	}
+	return "localhost"
+}
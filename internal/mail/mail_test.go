@@ -0,0 +1,135 @@
+package mail
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileMailer_WritesMessagesToTheOutbox(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "outbox")
+	mailer, err := NewFileMailer(dir, "XM <no-reply@xm.example.com>")
+	if err != nil {
+		t.Fatalf("NewFileMailer returned error: %v", err)
+	}
+
+	if err := mailer.Send(context.Background(), Message{To: "jane@example.com", Subject: "Reset your password", Body: "Hello\n.hidden line"}); err != nil {
+		t.Fatalf("Send returned error: %v", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected one message in the outbox, got %v (%v)", files, err)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("read message: %v", err)
+	}
+	for _, want := range []string{"To: jane@example.com\r\n", "Subject: Reset your password\r\n", "\r\n\r\nHello\r\n..hidden line\r\n"} {
+		if !strings.Contains(string(data), want) {
+			t.Fatalf("expected the message to contain %q, got:\n%s", want, data)
+		}
+	}
+}
+
+func TestMessage_RejectsHeaderInjection(t *testing.T) {
+	mailer, err := NewFileMailer(t.TempDir(), "no-reply@xm.example.com")
+	if err != nil {
+		t.Fatalf("NewFileMailer returned error: %v", err)
+	}
+
+	err = mailer.Send(context.Background(), Message{To: "jane@example.com\r\nBcc: everyone@example.com", Subject: "hi"})
+	if !errors.Is(err, ErrInvalidMessage) {
+		t.Fatalf("expected ErrInvalidMessage, got %v", err)
+	}
+}
+
+// fakeSMTPServer accepts one message and hands the envelope and data to the channel
+func fakeSMTPServer(t *testing.T) (string, <-chan []string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(line string) { _, _ = conn.Write([]byte(line + "\r\n")) }
+
+		var lines []string
+		reply("220 fake ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			switch command := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); {
+			case command == "EHLO" || command == "HELO":
+				reply("250 fake")
+			case strings.HasPrefix(strings.ToUpper(line), "MAIL FROM:"), strings.HasPrefix(strings.ToUpper(line), "RCPT TO:"):
+				lines = append(lines, line)
+				reply("250 ok")
+			case command == "DATA":
+				reply("354 go ahead")
+				for {
+					data, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if data == ".\r\n" {
+						break
+					}
+					lines = append(lines, strings.TrimRight(data, "\r\n"))
+				}
+				reply("250 queued")
+			case command == "QUIT":
+				reply("221 bye")
+				received <- lines
+				return
+			default:
+				reply("502 not implemented")
+			}
+		}
+	}()
+	return listener.Addr().String(), received
+}
+
+func TestSMTPMailer_SendsThroughTheRelay(t *testing.T) {
+	addr, received := fakeSMTPServer(t)
+	mailer, err := NewSMTPMailer(SMTPConfig{Addr: addr, From: "XM <no-reply@xm.example.com>"})
+	if err != nil {
+		t.Fatalf("NewSMTPMailer returned error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := mailer.Send(ctx, Message{To: "jane@example.com", Subject: "Reset your password", Body: "Follow the link"}); err != nil {
+		t.Fatalf("Send returned error: %v", err)
+	}
+
+	select {
+	case lines := <-received:
+		transcript := strings.Join(lines, "\n")
+		for _, want := range []string{"MAIL FROM:<no-reply@xm.example.com>", "RCPT TO:<jane@example.com>", "Subject: Reset your password", "Follow the link"} {
+			if !strings.Contains(transcript, want) {
+				t.Fatalf("expected the relay to receive %q, got:\n%s", want, transcript)
+			}
+		}
+	case <-ctx.Done():
+		t.Fatal("the relay did not receive the message")
+	}
+}
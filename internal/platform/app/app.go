@@ -1,6 +1,7 @@
 package app
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
@@ -8,26 +9,56 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
-	_ "github.com/go-sql-driver/mysql"
 	"go.uber.org/zap"
 
+	apikeymysql "github.com/ktsiligkos/xm_project/internal/repository/apikey/mysql"
+	companyrepository "github.com/ktsiligkos/xm_project/internal/repository/company"
+	companyeventstore "github.com/ktsiligkos/xm_project/internal/repository/company/eventstore"
 	companymysql "github.com/ktsiligkos/xm_project/internal/repository/company/mysql"
+	deadlettermysql "github.com/ktsiligkos/xm_project/internal/repository/deadletter/mysql"
+	apikeyservice "github.com/ktsiligkos/xm_project/internal/service/apikey"
 	companyservice "github.com/ktsiligkos/xm_project/internal/service/company"
+	deadletterservice "github.com/ktsiligkos/xm_project/internal/service/deadletter"
 
+	"github.com/ktsiligkos/xm_project/internal/auth"
+	"github.com/ktsiligkos/xm_project/internal/auth/oidc"
+	"github.com/ktsiligkos/xm_project/internal/auth/password"
+	"github.com/ktsiligkos/xm_project/internal/auth/revocation"
+	"github.com/ktsiligkos/xm_project/internal/mail"
+	"github.com/ktsiligkos/xm_project/internal/platform/database"
+	asyncevents "github.com/ktsiligkos/xm_project/internal/platform/events/async"
+	"github.com/ktsiligkos/xm_project/internal/platform/events/broadcast"
+	"github.com/ktsiligkos/xm_project/internal/platform/events/bus"
 	kafkaevents "github.com/ktsiligkos/xm_project/internal/platform/events/kafka"
+	loginattemptmysql "github.com/ktsiligkos/xm_project/internal/repository/loginattempt/mysql"
+	mfamysql "github.com/ktsiligkos/xm_project/internal/repository/mfa/mysql"
+	passwordresetmysql "github.com/ktsiligkos/xm_project/internal/repository/passwordreset/mysql"
+	refreshtokenmysql "github.com/ktsiligkos/xm_project/internal/repository/refreshtoken/mysql"
+	revokedtokenmysql "github.com/ktsiligkos/xm_project/internal/repository/revokedtoken/mysql"
+	tenantmysql "github.com/ktsiligkos/xm_project/internal/repository/tenant/mysql"
 	usermysql "github.com/ktsiligkos/xm_project/internal/repository/user/mysql"
+	tenantservice "github.com/ktsiligkos/xm_project/internal/service/tenant"
 	userservice "github.com/ktsiligkos/xm_project/internal/service/user"
 	httptransport "github.com/ktsiligkos/xm_project/internal/transport/http"
+	kafkatransport "github.com/ktsiligkos/xm_project/internal/transport/kafka"
 	"github.com/ktsiligkos/xm_project/pkg/config"
 )
 
 // Application owns the assembled HTTP server and its dependencies.
 type Application struct {
-	engine           *gin.Engine
-	cfg              config.Config
-	db               *sql.DB
-	logger           *zap.Logger
-	companyPublisher *kafkaevents.Publisher
+	engine             *gin.Engine
+	cfg                config.Config
+	db                 *sql.DB
+	logger             *zap.Logger
+	companyPublisher   *kafkaevents.Publisher
+	userPublisher      *kafkaevents.UserPublisher
+	asyncPublisher     *asyncevents.Publisher
+	asyncUserPublisher *asyncevents.UserPublisher
+	broadcaster        *broadcast.Broadcaster
+	eventBus           *bus.Bus
+	commandConsumer    *kafkatransport.Consumer
+	revocations        *revocation.Store
+	userService        *userservice.Service
 }
 
 // New wires dependencies together and prepares the HTTP server.
@@ -38,36 +69,355 @@ func New(cfg config.Config) (*Application, error) {
 		return nil, fmt.Errorf("init zap logger: %w", err)
 	}
 
-	db, err := sql.Open("mysql", cfg.MySQLDSN)
+	db, err := database.OpenMySQL(cfg.MySQLDSN)
 	if err != nil {
 		log.Printf("mysql setup failed, using in-memory repository: %v", err)
 	}
 
+	// create the topics the service writes to before anything is published
+	if cfg.KafkaProvisionTopics {
+		created, err := provisionKafkaTopics(cfg)
+		if err != nil {
+			return nil, err
+		}
+		if len(created) > 0 {
+			logger.Info("kafka topics created", zap.Strings("topics", created))
+		}
+	}
+
+	// events that cannot be published are kept in the dead letter store, replays go straight
+	// to Kafka so the outcome is known immediately, and only to the event topics
+	eventPublisher := kafkaevents.NewPublisher(cfg.KafkaBrokers, cfg.KafkaTopic, KafkaPublisherOptions(cfg)...)
+	var deadLetterOpts []deadletterservice.Option
+	var userPublisher *kafkaevents.UserPublisher
+	if cfg.KafkaUsersTopic != "" {
+		userPublisher = kafkaevents.NewUserPublisher(cfg.KafkaBrokers, cfg.KafkaUsersTopic)
+		deadLetterOpts = append(deadLetterOpts, deadletterservice.WithUserPublisher(userPublisher))
+	}
+	deadLetterService := deadletterservice.NewService(deadlettermysql.NewMySQL(db), eventPublisher.WithoutState(), deadLetterOpts...)
+	deadLettersHandler := httptransport.NewDeadLettersHandler(deadLetterService, logger.Named("dead_letters_handler"))
+
+	var companyEvents companyservice.EventPublisher = eventPublisher
+
+	// keep the broker out of the request path, events are queued and flushed in the background
+	var asyncPublisher *asyncevents.Publisher
+	if cfg.EventsAsync {
+		asyncPublisher, err = asyncevents.NewPublisher(eventPublisher, asyncevents.Options{
+			QueueSize:        cfg.EventsQueueSize,
+			Overflow:         asyncevents.OverflowPolicy(cfg.EventsOverflowPolicy),
+			SpillPath:        cfg.EventsSpillPath,
+			BreakerThreshold: cfg.EventsBreakerThreshold,
+			BreakerCooldown:  cfg.EventsBreakerCooldown,
+			OnError: func(ctx context.Context, event companyservice.CompanyEvent, err error, attempts int) {
+				logger.Error("publish company event failed",
+					zap.String("operation", event.Operation),
+					zap.String("company_id", event.Company.ID),
+					zap.Int("attempts", attempts),
+					zap.Error(err),
+				)
+				if recordErr := deadLetterService.Record(ctx, event, err, attempts); recordErr != nil {
+					logger.Error("store dead letter failed", zap.String("company_id", event.Company.ID), zap.Error(recordErr))
+				}
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("init async publisher: %w", err)
+		}
+		companyEvents = asyncPublisher
+	}
+
+	// changes are also fanned out in-process to the clients of the live stream
+	broadcaster := broadcast.NewBroadcaster(broadcast.Options{ReplaySize: cfg.StreamReplayBuffer})
+	companyStreamHandler := httptransport.NewCompanyStreamHandler(broadcaster, cfg.StreamHeartbeatInterval, logger.Named("company_stream_handler"))
+	companySocketHandler := httptransport.NewCompanySocketHandler(broadcaster, httptransport.SocketOptions{
+		MaxSubscriptions: cfg.SocketMaxSubscriptions,
+		SendBuffer:       cfg.SocketSendBuffer,
+		AllowedOrigins:   cfg.SocketAllowedOrigins,
+	}, logger.Named("company_socket_handler"))
+
+	// side effects of company changes subscribe to the event bus, Kafka is one of them;
+	// events it rejects up front (open circuit, full queue) are dead lettered too
+	eventBus := bus.New(bus.Options{
+		OnError: func(_ context.Context, subscriber string, event companyservice.CompanyEvent, err error) {
+			logger.Error("company event subscriber failed",
+				zap.String("subscriber", subscriber),
+				zap.String("operation", event.Operation),
+				zap.String("company_id", event.Company.ID),
+				zap.Error(err),
+			)
+		},
+	})
+	for _, sub := range []bus.Subscriber{
+		{Name: "broadcast", Handler: broadcaster, Delivery: bus.DeliverySync},
+		{Name: "kafka", Handler: deadletterservice.NewPublisher(companyEvents, deadLetterService), Delivery: bus.DeliverySync},
+	} {
+		if err := eventBus.Subscribe(sub); err != nil {
+			return nil, fmt.Errorf("subscribe to company events: %w", err)
+		}
+	}
+
 	// wire the company service
-	companyRepo := companymysql.NewMySQL(db)
-	eventPublisher := kafkaevents.NewPublisher(cfg.KafkaBrokers, cfg.KafkaTopic)
-	companyService := companyservice.NewService(companyRepo, eventPublisher)
+	var companyStore companyrepository.Repository = companymysql.NewMySQL(db)
+	if cfg.CompanyStorage == config.CompanyStorageEventStore {
+		companyStore = companyeventstore.NewEventStore(db, cfg.CompanySnapshotInterval)
+	}
+	companyService := companyservice.NewService(companyStore, eventBus)
 	companiesHandler := httptransport.NewCompaniesHandler(companyService, logger.Named("companies_handler"))
 
+	// upstream systems may also change companies by producing commands to Kafka
+	var commandConsumer *kafkatransport.Consumer
+	if cfg.KafkaCommandsTopic != "" {
+		commandConsumer = kafkatransport.NewConsumer(cfg.KafkaBrokers, kafkatransport.Options{
+			CommandsTopic:   cfg.KafkaCommandsTopic,
+			GroupID:         cfg.KafkaCommandsGroup,
+			RepliesTopic:    cfg.KafkaRepliesTopic,
+			DeadLetterTopic: cfg.KafkaCommandsDLQTopic,
+		}, companyService, logger.Named("company_commands"))
+	}
+
 	// wire the user service
 	userRepo := usermysql.NewMySQL(db)
-	userService := userservice.NewService(userRepo, []byte(cfg.JWTSecret), time.Hour*1)
+	passwordHasher, err := password.NewHasher(password.Config{
+		Algorithm:  cfg.PasswordHashAlgorithm,
+		BcryptCost: cfg.PasswordBcryptCost,
+		Argon2: password.Argon2Params{
+			Memory:      uint32(cfg.PasswordArgon2Memory),
+			Iterations:  uint32(cfg.PasswordArgon2Time),
+			Parallelism: uint8(cfg.PasswordArgon2Threads),
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("init password hasher: %w", err)
+	}
+	passwordPolicy, err := password.NewPolicy(cfg.PasswordMinLength, cfg.PasswordBreachedList)
+	if err != nil {
+		return nil, fmt.Errorf("init password policy: %w", err)
+	}
+	tokenKeys, err := auth.NewKeySet(auth.KeySetConfig{
+		Algorithm:            cfg.JWTSigningAlgorithm,
+		Secret:               []byte(cfg.JWTSecret),
+		SigningKeyFile:       cfg.JWTSigningKeyFile,
+		VerificationKeyFiles: cfg.JWTVerificationKeyFiles,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("init jwt keys: %w", err)
+	}
+	// tokens of an external identity provider are accepted next to, or instead of, our own
+	var tokenVerifier auth.TokenVerifier = tokenKeys
+	if cfg.OIDCIssuerURL != "" {
+		oidcCfg := oidc.Config{
+			Issuer:      cfg.OIDCIssuerURL,
+			Audience:    cfg.OIDCAudience,
+			Leeway:      cfg.OIDCLeeway,
+			UserIDClaim: cfg.OIDCUserIDClaim,
+			RolesClaim:  cfg.OIDCRolesClaim,
+			TenantClaim: cfg.OIDCTenantClaim,
+			Tenant:      cfg.OIDCTenant,
+			RoleMapping: cfg.OIDCRoleMapping,
+			CacheTTL:    cfg.OIDCJWKSCacheTTL,
+		}
+		if cfg.OIDCAcceptLocalTokens {
+			oidcCfg.Fallback = tokenKeys
+		}
+		oidcVerifier, err := oidc.NewVerifier(oidcCfg)
+		if err != nil {
+			return nil, fmt.Errorf("init oidc verifier: %w", err)
+		}
+		// a provider that is down at startup is retried when the first token arrives
+		refreshCtx, cancelRefresh := context.WithTimeout(context.Background(), 10*time.Second)
+		err = oidcVerifier.Refresh(refreshCtx)
+		cancelRefresh()
+		if err != nil {
+			logger.Error("load oidc keys failed", zap.Error(err))
+		}
+		tokenVerifier = oidcVerifier
+	}
+	// revoked access tokens are checked from memory on every request, the store is
+	// loaded here and kept in sync with the other instances while the server runs
+	revocations := revocation.NewStore(revokedtokenmysql.NewMySQL(db), revocation.Options{
+		MaxTokenAge: cfg.AccessTokenTTL,
+		OnError: func(err error) {
+			logger.Error("token revocation sync failed", zap.Error(err))
+		},
+	})
+	syncCtx, cancelSync := context.WithTimeout(context.Background(), 5*time.Second)
+	err = revocations.Sync(syncCtx)
+	cancelSync()
+	if err != nil {
+		logger.Error("load token revocations failed", zap.Error(err))
+	}
+
+	userOpts := []userservice.Option{
+		userservice.WithPasswordHasher(passwordHasher),
+		userservice.WithPasswordPolicy(passwordPolicy),
+		userservice.WithRefreshTokens(refreshtokenmysql.NewMySQL(db), cfg.RefreshTokenTTL),
+		userservice.WithTokenRevoker(revocations),
+	}
+	var mailer mail.Mailer
+	if cfg.Mailer == config.MailerSMTP {
+		mailer, err = mail.NewSMTPMailer(mail.SMTPConfig{Addr: cfg.SMTPAddr, Username: cfg.SMTPUsername, Password: cfg.SMTPPassword, From: cfg.MailFrom})
+	} else {
+		mailer, err = mail.NewFileMailer(cfg.MailOutboxDir, cfg.MailFrom)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("init mailer: %w", err)
+	}
+	userOpts = append(userOpts, userservice.WithPasswordReset(passwordresetmysql.NewMySQL(db), mailer, userservice.PasswordResetConfig{
+		TokenTTL:         cfg.PasswordResetTokenTTL,
+		ResetURL:         cfg.PasswordResetURL,
+		RequestsPerEmail: cfg.PasswordResetRequestsPerEmail,
+		RequestsPerIP:    cfg.PasswordResetRequestsPerIP,
+		RequestWindow:    cfg.PasswordResetRequestWindow,
+	}))
+	if cfg.MFAEnabled {
+		userOpts = append(userOpts, userservice.WithMFA(mfamysql.NewMySQL(db), userservice.MFAConfig{
+			Issuer:       cfg.MFAIssuer,
+			ChallengeTTL: cfg.MFAChallengeTTL,
+			MaxAttempts:  cfg.MFAMaxAttempts,
+		}))
+	}
+	if cfg.LoginProtectionEnabled {
+		userOpts = append(userOpts, userservice.WithLoginProtection(loginattemptmysql.NewMySQL(db), userservice.LoginProtection{
+			Account:         userservice.LoginLimits{FreeAttempts: cfg.LoginFreeAttempts, LockoutThreshold: cfg.LoginLockoutThreshold},
+			IP:              userservice.LoginLimits{FreeAttempts: cfg.LoginIPFreeAttempts, LockoutThreshold: cfg.LoginIPLockoutThreshold},
+			BaseDelay:       cfg.LoginBaseDelay,
+			MaxDelay:        cfg.LoginMaxDelay,
+			LockoutDuration: cfg.LoginLockoutDuration,
+		}))
+	}
+	var asyncUserPublisher *asyncevents.UserPublisher
+	if userPublisher != nil {
+		var userEvents userservice.EventPublisher = userPublisher
+		// logins must not wait for the broker either, user events share the queue setup of company events
+		if cfg.EventsAsync {
+			asyncUserPublisher, err = asyncevents.NewUserPublisher(userPublisher, asyncevents.UserOptions{
+				QueueSize:        cfg.EventsQueueSize,
+				Overflow:         asyncevents.OverflowPolicy(cfg.EventsOverflowPolicy),
+				SpillPath:        cfg.EventsUserSpillPath,
+				BreakerThreshold: cfg.EventsBreakerThreshold,
+				BreakerCooldown:  cfg.EventsBreakerCooldown,
+				OnError: func(ctx context.Context, event userservice.UserEvent, err error, attempts int) {
+					logger.Error("publish user event failed",
+						zap.String("operation", event.Operation),
+						zap.String("user_id", event.User.ID),
+						zap.Int("attempts", attempts),
+						zap.Error(err),
+					)
+					if recordErr := deadLetterService.RecordUserEvent(ctx, event, err, attempts); recordErr != nil {
+						logger.Error("store dead letter failed", zap.String("user_id", event.User.ID), zap.Error(recordErr))
+					}
+				},
+			})
+			if err != nil {
+				return nil, fmt.Errorf("init async user publisher: %w", err)
+			}
+			userEvents = asyncUserPublisher
+		}
+		userOpts = append(userOpts, userservice.WithEventPublisher(userEvents))
+	}
+	userService := userservice.NewService(userRepo, tokenKeys, cfg.AccessTokenTTL, userOpts...)
 	usersHandler := httptransport.NewUsersHandler(userService, logger.Named("users_handler"))
+	userAdminHandler := httptransport.NewUserAdminHandler(userService, logger.Named("user_admin_handler"))
+	mfaHandler := httptransport.NewMFAHandler(userService, logger.Named("mfa_handler"))
+
+	// batch jobs and other services authenticate with API keys instead of logging in
+	apiKeyService := apikeyservice.NewService(apikeymysql.NewMySQL(db), userRepo)
+	apiKeysHandler := httptransport.NewAPIKeysHandler(apiKeyService, userService, logger.Named("api_keys_handler"))
 
-	router := httptransport.NewRouter(companiesHandler, usersHandler, []byte(cfg.JWTSecret))
+	tenantService := tenantservice.NewService(tenantmysql.NewMySQL(db), userService)
+	tenantsHandler := httptransport.NewTenantsHandler(tenantService, logger.Named("tenants_handler"))
+
+	router := httptransport.NewRouter(httptransport.Handlers{
+		Companies:     companiesHandler,
+		CompanyStream: companyStreamHandler,
+		CompanySocket: companySocketHandler,
+		Users:         usersHandler,
+		UserAdmin:     userAdminHandler,
+		DeadLetters:   deadLettersHandler,
+		APIKeys:       apiKeysHandler,
+		MFA:           mfaHandler,
+		Tenants:       tenantsHandler,
+	}, httptransport.AuthOptions{
+		Keys:        tokenKeys,
+		Verifier:    tokenVerifier,
+		Revocations: revocations,
+		APIKeys:     apiKeyService,
+	})
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("init trusted proxies: %w", err)
+	}
 
 	return &Application{
-		engine:           router,
-		cfg:              cfg,
-		db:               db,
-		logger:           logger,
-		companyPublisher: eventPublisher,
+		engine:             router,
+		cfg:                cfg,
+		db:                 db,
+		logger:             logger,
+		companyPublisher:   eventPublisher,
+		userPublisher:      userPublisher,
+		asyncPublisher:     asyncPublisher,
+		asyncUserPublisher: asyncUserPublisher,
+		broadcaster:        broadcaster,
+		eventBus:           eventBus,
+		commandConsumer:    commandConsumer,
+		revocations:        revocations,
+		userService:        userService,
 	}, nil
 }
 
-// Run starts the HTTP server.
+// Run starts the HTTP server and the background workers and blocks until the server
+// fails or the process is asked to stop, see serve.
 func (a *Application) Run() error {
-	return a.engine.Run(a.cfg.HTTPAddr)
+	ctx, stop := stopSignal()
+	defer stop()
+
+	server := &http.Server{Addr: a.cfg.HTTPAddr, Handler: a.engine}
+	// open change streams never finish on their own, end them so Shutdown does not wait for them
+	server.RegisterOnShutdown(a.broadcaster.Close)
+
+	// the command consumer stops together with the server, before Close drains the publishers
+	consumerCtx, stopConsumer := context.WithCancel(ctx)
+	defer stopConsumer()
+	consumerDone := make(chan struct{})
+	go func() {
+		defer close(consumerDone)
+		if a.commandConsumer == nil {
+			return
+		}
+		if err := a.commandConsumer.Run(consumerCtx); err != nil {
+			a.logger.Error("company command consumer stopped", zap.Error(err))
+		}
+	}()
+
+	// revocations made by other instances are picked up in the background
+	if a.cfg.TokenRevocationSyncInterval > 0 {
+		go a.revocations.Run(consumerCtx, a.cfg.TokenRevocationSyncInterval)
+	}
+	go a.pruneLoginAttempts(consumerCtx)
+
+	err := serve(ctx, server)
+	stopConsumer()
+	<-consumerDone
+	return err
+}
+
+// pruneLoginAttempts drops stale failed login counters and expired mfa challenges every hour until ctx is cancelled
+func (a *Application) pruneLoginAttempts(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := a.userService.PruneLoginAttempts(ctx); err != nil && ctx.Err() == nil {
+				a.logger.Error("prune login attempts failed", zap.Error(err))
+			}
+			if err := a.userService.PruneMFAChallenges(ctx); err != nil && ctx.Err() == nil {
+				a.logger.Error("prune mfa challenges failed", zap.Error(err))
+			}
+		}
+	}
 }
 
 // Handler exposes the underlying HTTP handler for tests.
@@ -79,12 +429,52 @@ func (a *Application) Handler() http.Handler {
 func (a *Application) Close() error {
 	var firstErr error
 
+	if a.commandConsumer != nil {
+		if err := a.commandConsumer.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	// drain the bus and then the queued events before the Kafka writer underneath goes away
+	if a.eventBus != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), a.cfg.EventsDrainTimeout)
+		err := a.eventBus.Close(ctx)
+		cancel()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if a.asyncPublisher != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), a.cfg.EventsDrainTimeout)
+		err := a.asyncPublisher.Close(ctx)
+		cancel()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if a.asyncUserPublisher != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), a.cfg.EventsDrainTimeout)
+		err := a.asyncUserPublisher.Close(ctx)
+		cancel()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
 	if a.companyPublisher != nil {
 		if err := a.companyPublisher.Close(); err != nil && firstErr == nil {
 			firstErr = err
 		}
 	}
 
+	if a.userPublisher != nil {
+		if err := a.userPublisher.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
 	if a.logger != nil {
 		_ = a.logger.Sync()
 	}
@@ -0,0 +1,124 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	kafkaevents "github.com/ktsiligkos/xm_project/internal/platform/events/kafka"
+	"github.com/ktsiligkos/xm_project/pkg/config"
+)
+
+// provisionTimeout bounds how long the startup waits for the brokers to come up
+const provisionTimeout = 2 * time.Minute
+
+// KafkaPublisherOptions translates the configuration into Kafka publisher options
+func KafkaPublisherOptions(cfg config.Config) []kafkaevents.Option {
+	opts := []kafkaevents.Option{kafkaevents.WithRoutes(kafkaRoutes(cfg)...)}
+	if cfg.KafkaStateTopic != "" {
+		opts = append(opts, kafkaevents.WithStateTopic(cfg.KafkaStateTopic))
+	}
+	return opts
+}
+
+func kafkaRoutes(cfg config.Config) []kafkaevents.Route {
+	routes := make([]kafkaevents.Route, 0, len(cfg.KafkaRoutes))
+	for _, r := range cfg.KafkaRoutes {
+		routes = append(routes, kafkaevents.Route{
+			Operation:   r.Operation,
+			CompanyType: r.Type,
+			Tenant:      r.Tenant,
+			Topic:       r.Topic,
+		})
+	}
+	return routes
+}
+
+// kafkaTopicSpecs lists every topic the service reads from or writes to. Topics declared in
+// the topics config keep their settings, the rest get the configured defaults.
+func kafkaTopicSpecs(cfg config.Config) []kafkaevents.TopicSpec {
+	specs := make([]kafkaevents.TopicSpec, 0, len(cfg.KafkaTopics)+len(cfg.KafkaRoutes)+1)
+	declared := make(map[string]bool, len(cfg.KafkaTopics))
+
+	for _, t := range cfg.KafkaTopics {
+		spec := kafkaevents.TopicSpec{
+			Name:              t.Name,
+			Partitions:        t.Partitions,
+			ReplicationFactor: t.ReplicationFactor,
+			Configs:           make(map[string]string, len(t.Configs)+2),
+		}
+		if spec.Partitions <= 0 {
+			spec.Partitions = cfg.KafkaTopicPartitions
+		}
+		if spec.ReplicationFactor <= 0 {
+			spec.ReplicationFactor = cfg.KafkaTopicReplicationFactor
+		}
+		for name, value := range t.Configs {
+			spec.Configs[name] = value
+		}
+		if t.CleanupPolicy != "" {
+			spec.Configs["cleanup.policy"] = t.CleanupPolicy
+		}
+		if t.RetentionMs != nil {
+			spec.Configs["retention.ms"] = strconv.FormatInt(*t.RetentionMs, 10)
+		}
+
+		specs = append(specs, spec)
+		declared[t.Name] = true
+	}
+
+	// the state topic only works when compacted, whatever the topics config says
+	if cfg.KafkaStateTopic != "" {
+		if !declared[cfg.KafkaStateTopic] {
+			specs = append(specs, kafkaevents.TopicSpec{
+				Name:              cfg.KafkaStateTopic,
+				Partitions:        cfg.KafkaTopicPartitions,
+				ReplicationFactor: cfg.KafkaTopicReplicationFactor,
+				Configs:           map[string]string{},
+			})
+			declared[cfg.KafkaStateTopic] = true
+		}
+		for i := range specs {
+			if specs[i].Name == cfg.KafkaStateTopic {
+				specs[i].Configs["cleanup.policy"] = "compact"
+			}
+		}
+	}
+
+	referenced := []string{cfg.KafkaTopic}
+	if cfg.KafkaUsersTopic != "" {
+		referenced = append(referenced, cfg.KafkaUsersTopic)
+	}
+	for _, r := range cfg.KafkaRoutes {
+		referenced = append(referenced, r.Topic)
+	}
+	if cfg.KafkaCommandsTopic != "" {
+		referenced = append(referenced, cfg.KafkaCommandsTopic, cfg.KafkaRepliesTopic, cfg.KafkaCommandsDLQTopic)
+	}
+	for _, name := range referenced {
+		if declared[name] {
+			continue
+		}
+		specs = append(specs, kafkaevents.TopicSpec{
+			Name:              name,
+			Partitions:        cfg.KafkaTopicPartitions,
+			ReplicationFactor: cfg.KafkaTopicReplicationFactor,
+		})
+		declared[name] = true
+	}
+
+	return specs
+}
+
+// provisionKafkaTopics creates the missing topics before the first event is published
+func provisionKafkaTopics(cfg config.Config) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), provisionTimeout)
+	defer cancel()
+
+	created, err := kafkaevents.EnsureTopics(ctx, cfg.KafkaBrokers, kafkaTopicSpecs(cfg))
+	if err != nil {
+		return nil, fmt.Errorf("provision kafka topics: %w", err)
+	}
+	return created, nil
+}
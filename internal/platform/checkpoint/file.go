@@ -0,0 +1,79 @@
+package checkpoint
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileStore keeps the position of a long running job in a small JSON file
+type FileStore struct {
+	path string
+}
+
+type fileState struct {
+	LastID    string    `json:"last_id"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// NewFileStore creates a store backed by the file at path
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+// Load returns the last saved position, or an empty string when nothing was saved
+func (s *FileStore) Load(_ context.Context) (string, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		return "", fmt.Errorf("read checkpoint: %w", err)
+	}
+
+	var state fileState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return "", fmt.Errorf("decode checkpoint %s: %w", s.path, err)
+	}
+
+	return state.LastID, nil
+}
+
+// Save records the position, replacing the file atomically so a crash never leaves it half written
+func (s *FileStore) Save(_ context.Context, lastID string) error {
+	data, err := json.Marshal(fileState{LastID: lastID, UpdatedAt: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create checkpoint: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write checkpoint: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write checkpoint: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("replace checkpoint: %w", err)
+	}
+	return nil
+}
+
+// Clear removes the checkpoint so the next run starts from the beginning
+func (s *FileStore) Clear(_ context.Context) error {
+	if err := os.Remove(s.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove checkpoint: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+
+	driver "github.com/go-sql-driver/mysql"
+)
+
+// OpenMySQL opens a connection pool for the DSN. Time columns are always
+// parsed into time.Time, whatever the DSN says, because the repositories scan them.
+func OpenMySQL(dsn string) (*sql.DB, error) {
+	cfg, err := driver.ParseDSN(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("parse mysql dsn: %w", err)
+	}
+	cfg.ParseTime = true
+
+	connector, err := driver.NewConnector(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("mysql connector: %w", err)
+	}
+
+	return sql.OpenDB(connector), nil
+}
@@ -0,0 +1,117 @@
+package async
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	companyservice "github.com/ktsiligkos/xm_project/internal/service/company"
+)
+
+// Errors returned to the caller instead of blocking on the broker
+var (
+	ErrQueueFull   = errors.New("event queue is full")
+	ErrCircuitOpen = errors.New("event publisher circuit is open")
+	ErrClosed      = errors.New("event publisher is closed")
+)
+
+// OverflowPolicy decides what happens to an event when the queue is full
+type OverflowPolicy string
+
+const (
+	// OverflowBlock waits for room in the queue (or for the caller's context to end)
+	OverflowBlock OverflowPolicy = "block"
+	// OverflowDrop rejects the event with ErrQueueFull
+	OverflowDrop OverflowPolicy = "drop"
+	// OverflowSpill appends the event to a file that is replayed once the broker recovers
+	OverflowSpill OverflowPolicy = "spill"
+)
+
+// IsValid reports whether the policy is one of the supported values
+func (p OverflowPolicy) IsValid() bool {
+	switch p {
+	case OverflowBlock, OverflowDrop, OverflowSpill:
+		return true
+	}
+	return false
+}
+
+// QueueOptions tunes the queue, retries and circuit breaker of a publisher
+type QueueOptions[E any] struct {
+	QueueSize int
+	Overflow  OverflowPolicy
+	// SpillPath is the file used by OverflowSpill, it is required for that policy
+	SpillPath       string
+	MaxAttempts     int
+	RetryBackoff    time.Duration
+	DeliveryTimeout time.Duration
+	// BreakerThreshold consecutive failures open the circuit for BreakerCooldown
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	// OnError is called for every event that is given up, it defaults to logging
+	OnError func(ctx context.Context, event E, err error, attempts int)
+}
+
+// Options configures a Publisher of company events
+type Options = QueueOptions[companyservice.CompanyEvent]
+
+func (o QueueOptions[E]) withDefaults() QueueOptions[E] {
+	if o.QueueSize <= 0 {
+		o.QueueSize = 1024
+	}
+	if o.Overflow == "" {
+		o.Overflow = OverflowBlock
+	}
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = 3
+	}
+	if o.RetryBackoff <= 0 {
+		o.RetryBackoff = 100 * time.Millisecond
+	}
+	if o.DeliveryTimeout <= 0 {
+		o.DeliveryTimeout = 10 * time.Second
+	}
+	if o.BreakerThreshold <= 0 {
+		o.BreakerThreshold = 5
+	}
+	if o.BreakerCooldown <= 0 {
+		o.BreakerCooldown = 30 * time.Second
+	}
+	return o
+}
+
+// Publisher decorates a company EventPublisher so that callers only enqueue events,
+// a background flusher delivers them in order to the wrapped publisher.
+type Publisher struct {
+	queue *queue[companyservice.CompanyEvent]
+}
+
+// NewPublisher wraps next and starts the background flusher
+func NewPublisher(next companyservice.EventPublisher, opts Options) (*Publisher, error) {
+	if opts.OnError == nil {
+		opts.OnError = func(_ context.Context, event companyservice.CompanyEvent, err error, attempts int) {
+			log.Printf("publish company event %s for %s failed after %d attempts: %v", event.Operation, event.Company.ID, attempts, err)
+		}
+	}
+
+	q, err := newQueue("company", next.PublishCompanyEvent, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Publisher{queue: q}, nil
+}
+
+// PublishCompanyEvent enqueues the event without waiting for the broker
+func (p *Publisher) PublishCompanyEvent(ctx context.Context, event companyservice.CompanyEvent) error {
+	return p.queue.publish(ctx, event)
+}
+
+// Close stops accepting events and waits until the queue has been drained.
+// When ctx ends first the remaining events are spilled or reported as failed.
+func (p *Publisher) Close(ctx context.Context) error {
+	if p == nil {
+		return nil
+	}
+	return p.queue.close(ctx)
+}
@@ -0,0 +1,281 @@
+package async
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	companyservice "github.com/ktsiligkos/xm_project/internal/service/company"
+)
+
+// recordingPublisher stands in for Kafka, optionally blocking or failing
+type recordingPublisher struct {
+	mu      sync.Mutex
+	events  []companyservice.CompanyEvent
+	err     error
+	release chan struct{}
+}
+
+func (r *recordingPublisher) PublishCompanyEvent(ctx context.Context, event companyservice.CompanyEvent) error {
+	if r.release != nil {
+		select {
+		case <-r.release:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	r.events = append(r.events, event)
+	return nil
+}
+
+func (r *recordingPublisher) published() []companyservice.CompanyEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]companyservice.CompanyEvent(nil), r.events...)
+}
+
+func event(id string) companyservice.CompanyEvent {
+	return companyservice.CompanyEvent{Operation: companyservice.OperationCreated, Company: companyservice.EventCompany{ID: id}}
+}
+
+func closeWithin(t *testing.T, p *Publisher, d time.Duration) error {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	return p.Close(ctx)
+}
+
+func TestPublisher_DeliversInOrderAndDrainsOnClose(t *testing.T) {
+	next := &recordingPublisher{}
+	p, err := NewPublisher(next, Options{QueueSize: 10})
+	if err != nil {
+		t.Fatalf("NewPublisher returned error: %v", err)
+	}
+
+	for _, id := range []string{"a", "b", "c"} {
+		if err := p.PublishCompanyEvent(context.Background(), event(id)); err != nil {
+			t.Fatalf("PublishCompanyEvent(%s) returned error: %v", id, err)
+		}
+	}
+
+	if err := closeWithin(t, p, time.Second); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+
+	got := next.published()
+	if len(got) != 3 || got[0].Company.ID != "a" || got[1].Company.ID != "b" || got[2].Company.ID != "c" {
+		t.Fatalf("unexpected delivery order: %+v", got)
+	}
+
+	if err := p.PublishCompanyEvent(context.Background(), event("d")); !errors.Is(err, ErrClosed) {
+		t.Fatalf("want ErrClosed after Close, got %v", err)
+	}
+}
+
+func TestPublisher_DropPolicyRejectsWhenFull(t *testing.T) {
+	next := &recordingPublisher{release: make(chan struct{})}
+	p, err := NewPublisher(next, Options{QueueSize: 1, Overflow: OverflowDrop})
+	if err != nil {
+		t.Fatalf("NewPublisher returned error: %v", err)
+	}
+
+	// the first event is picked up by the flusher and blocks there, the second fills the queue
+	_ = p.PublishCompanyEvent(context.Background(), event("a"))
+	deadline := time.Now().Add(time.Second)
+	for len(p.queue.events) != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if err := p.PublishCompanyEvent(context.Background(), event("b")); err != nil {
+		t.Fatalf("second event should fit in the queue, got %v", err)
+	}
+
+	if err := p.PublishCompanyEvent(context.Background(), event("c")); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("want ErrQueueFull, got %v", err)
+	}
+
+	close(next.release)
+	if err := closeWithin(t, p, time.Second); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+}
+
+func TestPublisher_CircuitOpensAfterFailures(t *testing.T) {
+	next := &recordingPublisher{err: errors.New("broker down")}
+
+	var mu sync.Mutex
+	var failed []string
+	p, err := NewPublisher(next, Options{
+		MaxAttempts:      1,
+		BreakerThreshold: 2,
+		BreakerCooldown:  time.Hour,
+		OnError: func(_ context.Context, ev companyservice.CompanyEvent, _ error, _ int) {
+			mu.Lock()
+			failed = append(failed, ev.Company.ID)
+			mu.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewPublisher returned error: %v", err)
+	}
+
+	_ = p.PublishCompanyEvent(context.Background(), event("a"))
+	_ = p.PublishCompanyEvent(context.Background(), event("b"))
+
+	deadline := time.Now().Add(time.Second)
+	for !p.queue.breaker.isOpen() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := p.PublishCompanyEvent(context.Background(), event("c")); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("want ErrCircuitOpen once the breaker is open, got %v", err)
+	}
+
+	if err := closeWithin(t, p, time.Second); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(failed) != 2 {
+		t.Fatalf("expected both queued events to be reported, got %v", failed)
+	}
+}
+
+func TestPublisher_SpillsAndReplaysWhenBrokerRecovers(t *testing.T) {
+	spillPath := filepath.Join(t.TempDir(), "events.spill")
+	next := &recordingPublisher{err: errors.New("broker down")}
+
+	p, err := NewPublisher(next, Options{
+		Overflow:         OverflowSpill,
+		SpillPath:        spillPath,
+		MaxAttempts:      1,
+		BreakerThreshold: 1,
+		BreakerCooldown:  20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("NewPublisher returned error: %v", err)
+	}
+
+	if err := p.PublishCompanyEvent(context.Background(), event("a")); err != nil {
+		t.Fatalf("PublishCompanyEvent returned error: %v", err)
+	}
+
+	// wait for the failed delivery to land in the spill file, then heal the broker
+	deadline := time.Now().Add(time.Second)
+	for !p.queue.breaker.isOpen() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	next.mu.Lock()
+	next.err = nil
+	next.mu.Unlock()
+
+	deadline = time.Now().Add(2 * time.Second)
+	for len(next.published()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if err := closeWithin(t, p, time.Second); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+
+	got := next.published()
+	if len(got) != 1 || got[0].Company.ID != "a" {
+		t.Fatalf("expected the spilled event to be replayed, got %+v", got)
+	}
+}
+
+func TestPublisher_ReplaysSpilledEventsInPublicationOrder(t *testing.T) {
+	spillPath := filepath.Join(t.TempDir(), "events.spill")
+	next := &recordingPublisher{err: errors.New("broker down")}
+
+	p, err := NewPublisher(next, Options{
+		Overflow:         OverflowSpill,
+		SpillPath:        spillPath,
+		MaxAttempts:      1,
+		BreakerThreshold: 1,
+		BreakerCooldown:  20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("NewPublisher returned error: %v", err)
+	}
+
+	// a fails in the flusher and is spilled, b and c are spilled directly while the circuit is open
+	_ = p.PublishCompanyEvent(context.Background(), event("a"))
+	deadline := time.Now().Add(time.Second)
+	for !p.queue.spill.isPending() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	for _, id := range []string{"b", "c"} {
+		if err := p.PublishCompanyEvent(context.Background(), event(id)); err != nil {
+			t.Fatalf("PublishCompanyEvent(%s) returned error: %v", id, err)
+		}
+	}
+
+	// d arrives after the broker healed but before the replay, it must not overtake the spilled events
+	next.mu.Lock()
+	next.err = nil
+	next.mu.Unlock()
+	if err := p.PublishCompanyEvent(context.Background(), event("d")); err != nil {
+		t.Fatalf("PublishCompanyEvent(d) returned error: %v", err)
+	}
+
+	deadline = time.Now().Add(2 * time.Second)
+	for len(next.published()) < 4 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := closeWithin(t, p, time.Second); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+
+	var got []string
+	for _, ev := range next.published() {
+		got = append(got, ev.Company.ID)
+	}
+	if strings.Join(got, ",") != "a,b,c,d" {
+		t.Fatalf("expected the publication order a,b,c,d, got %v", got)
+	}
+}
+
+func TestPublisher_CloseTimeoutReportsRemainingEvents(t *testing.T) {
+	next := &recordingPublisher{release: make(chan struct{})}
+
+	var mu sync.Mutex
+	var failed int
+	p, err := NewPublisher(next, Options{
+		QueueSize:       4,
+		DeliveryTimeout: 50 * time.Millisecond,
+		MaxAttempts:     1,
+		OnError: func(context.Context, companyservice.CompanyEvent, error, int) {
+			mu.Lock()
+			failed++
+			mu.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewPublisher returned error: %v", err)
+	}
+
+	for _, id := range []string{"a", "b", "c"} {
+		_ = p.PublishCompanyEvent(context.Background(), event(id))
+	}
+
+	if err := closeWithin(t, p, 10*time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want deadline exceeded from Close, got %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if failed != 3 {
+		t.Fatalf("expected every undelivered event to be reported, got %d", failed)
+	}
+}
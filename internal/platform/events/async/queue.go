@@ -0,0 +1,262 @@
+package async
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type envelope[E any] struct {
+	ctx   context.Context
+	seq   uint64
+	event E
+}
+
+// queue is the flusher shared by the company and user publishers. Callers only enqueue
+// events and a single background goroutine delivers them in order with send.
+// With OverflowSpill the order survives the spill file too: once an event is spilled,
+// newer events follow it to disk until the file has been replayed.
+type queue[E any] struct {
+	name    string
+	send    func(ctx context.Context, event E) error
+	opts    QueueOptions[E]
+	events  chan envelope[E]
+	breaker *breaker
+	spill   *spillFile[E]
+	seq     atomic.Uint64
+
+	mu        sync.RWMutex
+	closed    bool
+	closeOnce sync.Once
+	abortOnce sync.Once
+	stopping  chan struct{}
+	abort     chan struct{}
+	done      chan struct{}
+}
+
+// newQueue validates opts and starts the background flusher, name only appears in errors and logs
+func newQueue[E any](name string, send func(ctx context.Context, event E) error, opts QueueOptions[E]) (*queue[E], error) {
+	opts = opts.withDefaults()
+	if !opts.Overflow.IsValid() {
+		return nil, fmt.Errorf("unknown overflow policy %q", opts.Overflow)
+	}
+	if opts.Overflow == OverflowSpill && opts.SpillPath == "" {
+		return nil, errors.New("spill overflow policy requires a spill path")
+	}
+
+	q := &queue[E]{
+		name:     name,
+		send:     send,
+		opts:     opts,
+		events:   make(chan envelope[E], opts.QueueSize),
+		breaker:  newBreaker(opts.BreakerThreshold, opts.BreakerCooldown),
+		stopping: make(chan struct{}),
+		abort:    make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if opts.Overflow == OverflowSpill {
+		q.spill = &spillFile[E]{path: opts.SpillPath}
+		last, err := q.spill.restore()
+		if err != nil {
+			return nil, err
+		}
+		q.seq.Store(last)
+	}
+
+	go q.run()
+	return q, nil
+}
+
+// publish enqueues the event without waiting for the broker
+func (q *queue[E]) publish(ctx context.Context, event E) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closed {
+		return ErrClosed
+	}
+
+	seq := q.seq.Add(1)
+	if q.spill != nil && (q.breaker.isOpen() || q.spill.isPending()) {
+		return q.spill.append(seq, event)
+	}
+	if q.breaker.isOpen() {
+		return ErrCircuitOpen
+	}
+
+	// the request context is cancelled as soon as the response is written,
+	// delivery happens later so only its values are carried over
+	env := envelope[E]{ctx: context.WithoutCancel(ctx), seq: seq, event: event}
+
+	select {
+	case q.events <- env:
+		return nil
+	default:
+	}
+
+	switch q.opts.Overflow {
+	case OverflowDrop:
+		return ErrQueueFull
+	case OverflowSpill:
+		return q.spill.append(seq, event)
+	default:
+		select {
+		case q.events <- env:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-q.stopping:
+			return ErrClosed
+		}
+	}
+}
+
+// close stops accepting events and waits until the queue has been drained.
+// When ctx ends first the remaining events are spilled or reported as failed.
+func (q *queue[E]) close(ctx context.Context) error {
+	q.closeOnce.Do(func() {
+		close(q.stopping)
+		q.mu.Lock()
+		q.closed = true
+		close(q.events)
+		q.mu.Unlock()
+	})
+
+	select {
+	case <-q.done:
+		return nil
+	case <-ctx.Done():
+		q.abortOnce.Do(func() { close(q.abort) })
+		<-q.done
+		return fmt.Errorf("drain %s events: %w", q.name, ctx.Err())
+	}
+}
+
+func (q *queue[E]) run() {
+	defer close(q.done)
+
+	var replay <-chan time.Time
+	if q.spill != nil {
+		ticker := time.NewTicker(q.opts.BreakerCooldown)
+		defer ticker.Stop()
+		replay = ticker.C
+	}
+
+	for {
+		select {
+		case env, ok := <-q.events:
+			if !ok {
+				return
+			}
+			if q.aborted() {
+				q.fail(env, 0, ErrClosed)
+				continue
+			}
+			// older events wait on disk, this one is sorted in behind them
+			if q.spill != nil && q.spill.isPending() {
+				q.fail(env, 0, nil)
+				continue
+			}
+			if attempts, err := q.deliver(env); err != nil {
+				q.fail(env, attempts, err)
+			}
+		case <-replay:
+			q.replaySpill()
+		}
+	}
+}
+
+// deliver retries send until it succeeds, the attempts run out or the circuit opens
+func (q *queue[E]) deliver(env envelope[E]) (int, error) {
+	var err error
+	attempts := 0
+
+	for attempts < q.opts.MaxAttempts {
+		if !q.breaker.allow() {
+			if err == nil {
+				err = ErrCircuitOpen
+			}
+			return attempts, err
+		}
+
+		attempts++
+		ctx, cancel := context.WithTimeout(env.ctx, q.opts.DeliveryTimeout)
+		err = q.send(ctx, env.event)
+		cancel()
+		if err == nil {
+			q.breaker.success()
+			return attempts, nil
+		}
+		q.breaker.failure()
+
+		if attempts < q.opts.MaxAttempts {
+			select {
+			case <-time.After(q.opts.RetryBackoff * time.Duration(attempts)):
+			case <-q.abort:
+				return attempts, err
+			}
+		}
+	}
+
+	return attempts, err
+}
+
+// fail keeps the event on disk when spilling is enabled, otherwise hands it to OnError
+func (q *queue[E]) fail(env envelope[E], attempts int, err error) {
+	if q.spill != nil {
+		spillErr := q.spill.append(env.seq, env.event)
+		if spillErr == nil {
+			return
+		}
+		err = errors.Join(err, spillErr)
+	}
+	q.opts.OnError(env.ctx, env.event, err, attempts)
+}
+
+// replaySpill re-delivers spilled events in publication order while the broker stays healthy.
+// Events spilled during the replay are picked up before new events may use the queue again.
+func (q *queue[E]) replaySpill() {
+	for !q.breaker.isOpen() {
+		events, err := q.spill.take()
+		if err != nil {
+			log.Printf("replay spilled %s events: %v", q.name, err)
+			return
+		}
+
+		for i, spilled := range events {
+			if q.aborted() {
+				q.respill(events[i:])
+				return
+			}
+			if _, err := q.deliver(envelope[E]{ctx: context.Background(), seq: spilled.Seq, event: spilled.Event}); err != nil {
+				q.respill(events[i:])
+				return
+			}
+		}
+
+		if q.spill.settle() {
+			return
+		}
+	}
+}
+
+func (q *queue[E]) respill(events []spilledEvent[E]) {
+	for _, spilled := range events {
+		if err := q.spill.append(spilled.Seq, spilled.Event); err != nil {
+			q.opts.OnError(context.Background(), spilled.Event, err, 0)
+		}
+	}
+}
+
+func (q *queue[E]) aborted() bool {
+	select {
+	case <-q.abort:
+		return true
+	default:
+		return false
+	}
+}
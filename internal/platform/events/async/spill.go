@@ -0,0 +1,133 @@
+package async
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+// spilledEvent is one line of the spill file, seq is the position of the event in publication order
+type spilledEvent[E any] struct {
+	Seq   uint64 `json:"seq"`
+	Event E      `json:"event"`
+}
+
+// spillFile stores events that could not be queued as JSON lines on disk.
+// While it holds events it is pending, and newer events have to follow them there.
+type spillFile[E any] struct {
+	mu      sync.Mutex
+	path    string
+	pending bool
+}
+
+// restore marks the file pending when a previous run left events behind and returns their highest seq
+func (s *spillFile[E]) restore() (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events, err := s.read()
+	if err != nil {
+		return 0, err
+	}
+
+	var last uint64
+	for _, spilled := range events {
+		last = max(last, spilled.Seq)
+	}
+	s.pending = len(events) > 0
+	return last, nil
+}
+
+func (s *spillFile[E]) append(seq uint64, event E) error {
+	line, err := json.Marshal(spilledEvent[E]{Seq: seq, Event: event})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("open spill file: %w", err)
+	}
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("write spill file: %w", err)
+	}
+	s.pending = true
+	return f.Close()
+}
+
+// isPending reports whether spilled events still wait for delivery
+func (s *spillFile[E]) isPending() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pending
+}
+
+// take returns every spilled event in publication order and truncates the file.
+// The file stays pending until settle confirms that nothing was spilled meanwhile.
+func (s *spillFile[E]) take() ([]spilledEvent[E], error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events, err := s.read()
+	if err != nil || len(events) == 0 {
+		return nil, err
+	}
+
+	if err := os.Truncate(s.path, 0); err != nil {
+		return nil, fmt.Errorf("truncate spill file: %w", err)
+	}
+	return events, nil
+}
+
+// settle clears pending when the file is empty, so new events may use the queue again
+func (s *spillFile[E]) settle() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := os.Stat(s.path)
+	if err == nil && info.Size() > 0 {
+		return false
+	}
+	s.pending = false
+	return true
+}
+
+func (s *spillFile[E]) read() ([]spilledEvent[E], error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("open spill file: %w", err)
+	}
+	defer f.Close()
+
+	var events []spilledEvent[E]
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var spilled spilledEvent[E]
+		// a torn last line after a crash must not block the rest of the file,
+		// lines without a seq are corrupt as well
+		if err := json.Unmarshal(scanner.Bytes(), &spilled); err != nil || spilled.Seq == 0 {
+			continue
+		}
+		events = append(events, spilled)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read spill file: %w", err)
+	}
+
+	// events are spilled by publishers, the flusher and failed replays, their seq restores the order
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Seq < events[j].Seq })
+	return events, nil
+}
@@ -0,0 +1,30 @@
+package async
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	companyservice "github.com/ktsiligkos/xm_project/internal/service/company"
+)
+
+func TestSpillFile_SkipsCorruptLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.spill")
+	lines := `{"seq":2,"event":{"operation":"company.patched","company":{"id":"b"}}}
+{"operation":"company.created","company":{"id":"no-seq"}}
+{"seq":1,"event":{"operation":"company.created","company":{"id":"a"}}}
+{"seq":3,"event":{"operation":"comp`
+	if err := os.WriteFile(path, []byte(lines), 0o600); err != nil {
+		t.Fatalf("write spill file: %v", err)
+	}
+
+	spill := &spillFile[companyservice.CompanyEvent]{path: path}
+	events, err := spill.read()
+	if err != nil {
+		t.Fatalf("read returned error: %v", err)
+	}
+
+	if len(events) != 2 || events[0].Event.Company.ID != "a" || events[1].Event.Company.ID != "b" {
+		t.Fatalf("expected a and b in seq order, got %+v", events)
+	}
+}
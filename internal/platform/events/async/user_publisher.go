@@ -0,0 +1,45 @@
+package async
+
+import (
+	"context"
+	"log"
+
+	userservice "github.com/ktsiligkos/xm_project/internal/service/user"
+)
+
+// UserOptions configures a UserPublisher, its spill file must not be the one of the company events
+type UserOptions = QueueOptions[userservice.UserEvent]
+
+// UserPublisher keeps the broker out of logins and the other user requests the same
+// way Publisher does for company changes
+type UserPublisher struct {
+	queue *queue[userservice.UserEvent]
+}
+
+// NewUserPublisher wraps next and starts the background flusher
+func NewUserPublisher(next userservice.EventPublisher, opts UserOptions) (*UserPublisher, error) {
+	if opts.OnError == nil {
+		opts.OnError = func(_ context.Context, event userservice.UserEvent, err error, attempts int) {
+			log.Printf("publish user event %s for %s failed after %d attempts: %v", event.Operation, event.User.ID, attempts, err)
+		}
+	}
+
+	q, err := newQueue("user", next.PublishUserEvent, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &UserPublisher{queue: q}, nil
+}
+
+// PublishUserEvent enqueues the event without waiting for the broker
+func (p *UserPublisher) PublishUserEvent(ctx context.Context, event userservice.UserEvent) error {
+	return p.queue.publish(ctx, event)
+}
+
+// Close stops accepting events and waits until the queue has been drained
+func (p *UserPublisher) Close(ctx context.Context) error {
+	if p == nil {
+		return nil
+	}
+	return p.queue.close(ctx)
+}
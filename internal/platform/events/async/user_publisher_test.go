@@ -0,0 +1,68 @@
+package async
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	userservice "github.com/ktsiligkos/xm_project/internal/service/user"
+)
+
+// stalledUserPublisher blocks until released, like a broker that stopped answering
+type stalledUserPublisher struct {
+	mu      sync.Mutex
+	events  []userservice.UserEvent
+	release chan struct{}
+}
+
+func (s *stalledUserPublisher) PublishUserEvent(ctx context.Context, event userservice.UserEvent) error {
+	select {
+	case <-s.release:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, event)
+	return nil
+}
+
+func TestUserPublisher_DoesNotWaitForTheBroker(t *testing.T) {
+	// Given a broker that does not answer
+	next := &stalledUserPublisher{release: make(chan struct{})}
+	p, err := NewUserPublisher(next, UserOptions{
+		QueueSize: 1,
+		Overflow:  OverflowSpill,
+		SpillPath: filepath.Join(t.TempDir(), "user-events.spill"),
+	})
+	if err != nil {
+		t.Fatalf("NewUserPublisher returned error: %v", err)
+	}
+
+	// When more logins happen than the queue holds
+	start := time.Now()
+	for _, id := range []string{"u1", "u2", "u3"} {
+		event := userservice.UserEvent{Operation: userservice.OperationLoginSucceeded, User: userservice.EventUser{ID: id}}
+		if err := p.PublishUserEvent(context.Background(), event); err != nil {
+			t.Fatalf("PublishUserEvent returned error: %v", err)
+		}
+	}
+
+	// Then none of them waited and the overflow went to the spill file
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("publishing took %s, expected it not to wait for the broker", elapsed)
+	}
+	if !p.queue.spill.isPending() {
+		t.Fatal("expected the overflow to be spilled")
+	}
+
+	close(next.release)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := p.Close(ctx); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+}
@@ -0,0 +1,212 @@
+package broadcast
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	companyservice "github.com/ktsiligkos/xm_project/internal/service/company"
+)
+
+const (
+	defaultReplaySize       = 1000
+	defaultSubscriberBuffer = 64
+)
+
+// Event is a company event together with the ID used to resume a stream after it
+type Event struct {
+	ID    string
+	Event companyservice.CompanyEvent
+}
+
+// Filter narrows the events delivered to a subscriber, empty sets match everything
+type Filter struct {
+	// Tenant, when set, only matches the events of that tenant
+	Tenant     string
+	Types      map[string]bool
+	CompanyIDs map[string]bool
+}
+
+func (f Filter) matches(event companyservice.CompanyEvent) bool {
+	if f.Tenant != "" && f.Tenant != event.Metadata.Tenant {
+		return false
+	}
+	if len(f.Types) > 0 && !f.Types[event.Company.Type] {
+		return false
+	}
+	if len(f.CompanyIDs) > 0 && !f.CompanyIDs[event.Company.ID] {
+		return false
+	}
+	return true
+}
+
+// Options sizes the replay buffer and the per-subscriber queue
+type Options struct {
+	// ReplaySize is the number of recent events kept for clients resuming with Last-Event-ID
+	ReplaySize int
+	// SubscriberBuffer is how many events a subscriber may fall behind before it is dropped
+	SubscriberBuffer int
+}
+
+// Broadcaster fans company events out to in-process subscribers, such as
+// streaming HTTP clients. It implements companyservice.EventPublisher.
+type Broadcaster struct {
+	opts Options
+	// epoch identifies this process, IDs handed out before a restart cannot be resumed
+	epoch string
+
+	mu     sync.Mutex
+	seq    int64
+	ring   []Event
+	start  int
+	count  int
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+// NewBroadcaster creates a broadcaster with an empty replay buffer
+func NewBroadcaster(opts Options) *Broadcaster {
+	if opts.ReplaySize <= 0 {
+		opts.ReplaySize = defaultReplaySize
+	}
+	if opts.SubscriberBuffer <= 0 {
+		opts.SubscriberBuffer = defaultSubscriberBuffer
+	}
+
+	return &Broadcaster{
+		opts:  opts,
+		epoch: strconv.FormatInt(time.Now().UnixNano(), 36),
+		ring:  make([]Event, opts.ReplaySize),
+		subs:  map[*Subscription]struct{}{},
+	}
+}
+
+// PublishCompanyEvent records the event for replay and hands it to every matching subscriber.
+// It never blocks: a subscriber whose queue is full is dropped and has to reconnect.
+func (b *Broadcaster) PublishCompanyEvent(_ context.Context, event companyservice.CompanyEvent) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil
+	}
+
+	b.seq++
+	ev := Event{ID: b.eventID(b.seq), Event: event}
+	b.push(ev)
+
+	for sub := range b.subs {
+		if !sub.filter.matches(event) {
+			continue
+		}
+		select {
+		case sub.ch <- ev:
+		default:
+			b.remove(sub)
+		}
+	}
+
+	return nil
+}
+
+// Subscribe registers a subscriber. When lastEventID is set the buffered events
+// after it are returned for replay; resync is true when that ID can no longer be
+// resumed (too old, or from before a restart) and the client should reload its state.
+func (b *Broadcaster) Subscribe(filter Filter, lastEventID string) (sub *Subscription, replay []Event, resync bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub = &Subscription{b: b, filter: filter, ch: make(chan Event, b.opts.SubscriberBuffer)}
+	if b.closed {
+		close(sub.ch)
+		return sub, nil, false
+	}
+	b.subs[sub] = struct{}{}
+
+	if lastEventID == "" {
+		return sub, nil, false
+	}
+
+	after, ok := b.parseEventID(lastEventID)
+	oldest := b.seq - int64(b.count) + 1
+	if !ok || after > b.seq || after < oldest-1 {
+		return sub, nil, true
+	}
+
+	for i := 0; i < b.count; i++ {
+		ev := b.ring[(b.start+i)%len(b.ring)]
+		if oldest+int64(i) > after && filter.matches(ev.Event) {
+			replay = append(replay, ev)
+		}
+	}
+	return sub, replay, false
+}
+
+// Close ends every subscription, used on shutdown so streaming requests return
+func (b *Broadcaster) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for sub := range b.subs {
+		b.remove(sub)
+	}
+}
+
+func (b *Broadcaster) push(ev Event) {
+	if b.count < len(b.ring) {
+		b.ring[(b.start+b.count)%len(b.ring)] = ev
+		b.count++
+		return
+	}
+	b.ring[b.start] = ev
+	b.start = (b.start + 1) % len(b.ring)
+}
+
+// remove must be called with mu held
+func (b *Broadcaster) remove(sub *Subscription) {
+	if _, ok := b.subs[sub]; !ok {
+		return
+	}
+	delete(b.subs, sub)
+	close(sub.ch)
+}
+
+func (b *Broadcaster) eventID(seq int64) string {
+	return fmt.Sprintf("%s-%d", b.epoch, seq)
+}
+
+func (b *Broadcaster) parseEventID(id string) (int64, bool) {
+	epoch, seq, found := strings.Cut(id, "-")
+	if !found || epoch != b.epoch {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(seq, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// Subscription receives the events matching its filter on C
+type Subscription struct {
+	b      *Broadcaster
+	filter Filter
+	ch     chan Event
+}
+
+// C is closed when the subscription ends, either through Close, because the
+// subscriber fell too far behind or because the broadcaster was closed
+func (s *Subscription) C() <-chan Event {
+	return s.ch
+}
+
+// Close unregisters the subscription, it is safe to call more than once
+func (s *Subscription) Close() {
+	s.b.mu.Lock()
+	defer s.b.mu.Unlock()
+	s.b.remove(s)
+}
@@ -0,0 +1,120 @@
+package broadcast
+
+import (
+	"context"
+	"testing"
+
+	companyservice "github.com/ktsiligkos/xm_project/internal/service/company"
+)
+
+func event(id, companyType string) companyservice.CompanyEvent {
+	return companyservice.CompanyEvent{
+		Operation: companyservice.OperationCreated,
+		Company:   companyservice.EventCompany{ID: id, Name: "Name-" + id, Type: companyType},
+	}
+}
+
+func publish(t *testing.T, b *Broadcaster, events ...companyservice.CompanyEvent) {
+	t.Helper()
+	for _, ev := range events {
+		if err := b.PublishCompanyEvent(context.Background(), ev); err != nil {
+			t.Fatalf("PublishCompanyEvent returned error: %v", err)
+		}
+	}
+}
+
+func ids(events []Event) []string {
+	out := make([]string, 0, len(events))
+	for _, ev := range events {
+		out = append(out, ev.Event.Company.ID)
+	}
+	return out
+}
+
+func TestBroadcaster_DeliversMatchingEvents(t *testing.T) {
+	b := NewBroadcaster(Options{})
+	sub, _, _ := b.Subscribe(Filter{Types: map[string]bool{"NonProfit": true}}, "")
+	defer sub.Close()
+
+	publish(t, b, event("a", "Corporations"), event("b", "NonProfit"))
+
+	select {
+	case ev := <-sub.C():
+		if ev.Event.Company.ID != "b" {
+			t.Fatalf("expected only the NonProfit company, got %s", ev.Event.Company.ID)
+		}
+	default:
+		t.Fatal("expected an event to be delivered")
+	}
+	if len(sub.C()) != 0 {
+		t.Fatal("the filtered out event must not be delivered")
+	}
+}
+
+func TestBroadcaster_ReplaysAfterLastEventID(t *testing.T) {
+	b := NewBroadcaster(Options{ReplaySize: 10})
+	first, _, _ := b.Subscribe(Filter{}, "")
+	publish(t, b, event("a", "NonProfit"), event("b", "NonProfit"), event("c", "NonProfit"))
+	lastSeen := (<-first.C()).ID
+	first.Close()
+
+	sub, replay, resync := b.Subscribe(Filter{}, lastSeen)
+	defer sub.Close()
+
+	if resync {
+		t.Fatal("a buffered ID must not require a resync")
+	}
+	if got := ids(replay); len(got) != 2 || got[0] != "b" || got[1] != "c" {
+		t.Fatalf("expected b and c to be replayed, got %v", got)
+	}
+}
+
+func TestBroadcaster_ResyncWhenIDIsNoLongerBuffered(t *testing.T) {
+	b := NewBroadcaster(Options{ReplaySize: 2})
+	first, _, _ := b.Subscribe(Filter{}, "")
+	publish(t, b, event("a", "NonProfit"))
+	oldest := (<-first.C()).ID
+	first.Close()
+	publish(t, b, event("b", "NonProfit"), event("c", "NonProfit"), event("d", "NonProfit"))
+
+	for _, lastEventID := range []string{oldest, "garbage", "otherepoch-1"} {
+		sub, replay, resync := b.Subscribe(Filter{}, lastEventID)
+		sub.Close()
+		if !resync || len(replay) != 0 {
+			t.Fatalf("Last-Event-ID %q: want resync without replay, got resync=%v replay=%v", lastEventID, resync, ids(replay))
+		}
+	}
+}
+
+func TestBroadcaster_DropsSlowSubscriber(t *testing.T) {
+	b := NewBroadcaster(Options{SubscriberBuffer: 1})
+	sub, _, _ := b.Subscribe(Filter{}, "")
+
+	publish(t, b, event("a", "NonProfit"), event("b", "NonProfit"))
+
+	<-sub.C()
+	if _, ok := <-sub.C(); ok {
+		t.Fatal("expected the subscription to be closed after overflowing")
+	}
+	if len(b.subs) != 0 {
+		t.Fatalf("expected the slow subscriber to be removed, %d left", len(b.subs))
+	}
+	sub.Close()
+}
+
+func TestBroadcaster_CloseEndsSubscriptions(t *testing.T) {
+	b := NewBroadcaster(Options{})
+	sub, _, _ := b.Subscribe(Filter{}, "")
+
+	b.Close()
+
+	if _, ok := <-sub.C(); ok {
+		t.Fatal("expected the subscription to be closed")
+	}
+	sub.Close()
+
+	late, _, _ := b.Subscribe(Filter{}, "")
+	if _, ok := <-late.C(); ok {
+		t.Fatal("subscribing after Close must return a closed subscription")
+	}
+}
@@ -0,0 +1,197 @@
+package bus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	companyservice "github.com/ktsiligkos/xm_project/internal/service/company"
+)
+
+const defaultQueueSize = 256
+
+// Errors returned when an event cannot be handed to a subscriber
+var (
+	ErrQueueFull = errors.New("subscriber queue is full")
+	ErrClosed    = errors.New("event bus is closed")
+)
+
+// Delivery decides in which goroutine a subscriber receives its events
+type Delivery string
+
+const (
+	// DeliverySync calls the subscriber inside PublishCompanyEvent, its error is returned to the publisher
+	DeliverySync Delivery = "sync"
+	// DeliveryAsync queues the event and calls the subscriber from its own goroutine
+	DeliveryAsync Delivery = "async"
+)
+
+// Subscriber is a named side effect of company changes
+type Subscriber struct {
+	Name     string
+	Handler  companyservice.EventPublisher
+	Delivery Delivery
+	// QueueSize bounds how far an async subscriber may fall behind before events are dropped
+	QueueSize int
+}
+
+// Options configures a Bus
+type Options struct {
+	// OnError is called for every event a subscriber failed to handle, it defaults to logging
+	OnError func(ctx context.Context, subscriber string, event companyservice.CompanyEvent, err error)
+}
+
+type envelope struct {
+	ctx   context.Context
+	event companyservice.CompanyEvent
+}
+
+type subscription struct {
+	Subscriber
+	queue chan envelope
+	done  chan struct{}
+}
+
+// Bus fans company events out to its subscribers in the order they were registered.
+// Every subscriber sees the events in the order they were published; a failing
+// or panicking subscriber does not keep the event from the others, and a slow
+// async subscriber only ever delays itself. Bus implements companyservice.EventPublisher.
+type Bus struct {
+	opts Options
+
+	mu          sync.RWMutex
+	subscribers []*subscription
+	closed      bool
+}
+
+// New creates a bus without subscribers
+func New(opts Options) *Bus {
+	if opts.OnError == nil {
+		opts.OnError = func(_ context.Context, subscriber string, event companyservice.CompanyEvent, err error) {
+			log.Printf("subscriber %s failed on %s for %s: %v", subscriber, event.Operation, event.Company.ID, err)
+		}
+	}
+	return &Bus{opts: opts}
+}
+
+// Subscribe registers a subscriber, async subscribers start their goroutine right away
+func (b *Bus) Subscribe(sub Subscriber) error {
+	if sub.Name == "" {
+		return errors.New("subscriber name is required")
+	}
+	if sub.Handler == nil {
+		return fmt.Errorf("subscriber %s has no handler", sub.Name)
+	}
+	if sub.Delivery == "" {
+		sub.Delivery = DeliverySync
+	}
+	if sub.Delivery != DeliverySync && sub.Delivery != DeliveryAsync {
+		return fmt.Errorf("subscriber %s: unknown delivery %q", sub.Name, sub.Delivery)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrClosed
+	}
+	for _, existing := range b.subscribers {
+		if existing.Name == sub.Name {
+			return fmt.Errorf("subscriber %s is already registered", sub.Name)
+		}
+	}
+
+	s := &subscription{Subscriber: sub}
+	if sub.Delivery == DeliveryAsync {
+		if s.QueueSize <= 0 {
+			s.QueueSize = defaultQueueSize
+		}
+		s.queue = make(chan envelope, s.QueueSize)
+		s.done = make(chan struct{})
+		go b.run(s)
+	}
+	b.subscribers = append(b.subscribers, s)
+	return nil
+}
+
+// PublishCompanyEvent hands the event to every subscriber. The returned error
+// joins the failures of sync subscribers and the events async ones had to drop.
+func (b *Bus) PublishCompanyEvent(ctx context.Context, event companyservice.CompanyEvent) error {
+	// the read lock keeps Close from closing a queue while we are sending to it
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return ErrClosed
+	}
+
+	var errs []error
+	for _, s := range b.subscribers {
+		if s.Delivery == DeliverySync {
+			if err := b.deliver(ctx, s, event); err != nil {
+				errs = append(errs, fmt.Errorf("subscriber %s: %w", s.Name, err))
+			}
+			continue
+		}
+
+		// async subscribers outlive the request, only its values are kept
+		select {
+		case s.queue <- envelope{ctx: context.WithoutCancel(ctx), event: event}:
+		default:
+			b.opts.OnError(ctx, s.Name, event, ErrQueueFull)
+			errs = append(errs, fmt.Errorf("subscriber %s: %w", s.Name, ErrQueueFull))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close stops accepting events and waits until the async subscribers have
+// handled everything queued, or until ctx ends.
+func (b *Bus) Close(ctx context.Context) error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	for _, s := range b.subscribers {
+		if s.queue != nil {
+			close(s.queue)
+		}
+	}
+	subscribers := b.subscribers
+	b.mu.Unlock()
+
+	for _, s := range subscribers {
+		if s.done == nil {
+			continue
+		}
+		select {
+		case <-s.done:
+		case <-ctx.Done():
+			return fmt.Errorf("drain subscriber %s: %w", s.Name, ctx.Err())
+		}
+	}
+	return nil
+}
+
+// run delivers the queued events of one async subscriber in order
+func (b *Bus) run(s *subscription) {
+	defer close(s.done)
+	for env := range s.queue {
+		_ = b.deliver(env.ctx, s, env.event)
+	}
+}
+
+// deliver calls the handler, turning a panic into an error so it stays with this subscriber
+func (b *Bus) deliver(ctx context.Context, s *subscription, event companyservice.CompanyEvent) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+		if err != nil {
+			b.opts.OnError(ctx, s.Name, event, err)
+		}
+	}()
+	return s.Handler.PublishCompanyEvent(ctx, event)
+}
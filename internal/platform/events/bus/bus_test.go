@@ -0,0 +1,151 @@
+package bus
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	companyservice "github.com/ktsiligkos/xm_project/internal/service/company"
+)
+
+// recordingHandler remembers the events it saw, optionally failing, panicking or blocking
+type recordingHandler struct {
+	mu      sync.Mutex
+	events  []string
+	err     error
+	panics  bool
+	release chan struct{}
+}
+
+func (h *recordingHandler) PublishCompanyEvent(_ context.Context, event companyservice.CompanyEvent) error {
+	if h.release != nil {
+		<-h.release
+	}
+	if h.panics {
+		panic("boom")
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.events = append(h.events, event.Company.ID)
+	return h.err
+}
+
+func (h *recordingHandler) seen() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]string(nil), h.events...)
+}
+
+func event(id string) companyservice.CompanyEvent {
+	return companyservice.CompanyEvent{Operation: companyservice.OperationCreated, Company: companyservice.EventCompany{ID: id}}
+}
+
+func subscribe(t *testing.T, b *Bus, sub Subscriber) {
+	t.Helper()
+	if err := b.Subscribe(sub); err != nil {
+		t.Fatalf("subscribe %s: %v", sub.Name, err)
+	}
+}
+
+func TestBus_DeliversInOrderToEverySubscriber(t *testing.T) {
+	b := New(Options{})
+	syncHandler, asyncHandler := &recordingHandler{}, &recordingHandler{}
+	subscribe(t, b, Subscriber{Name: "sync", Handler: syncHandler})
+	subscribe(t, b, Subscriber{Name: "async", Handler: asyncHandler, Delivery: DeliveryAsync})
+
+	for _, id := range []string{"a", "b", "c"} {
+		if err := b.PublishCompanyEvent(context.Background(), event(id)); err != nil {
+			t.Fatalf("publish: %v", err)
+		}
+	}
+	if err := b.Close(context.Background()); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	for name, handler := range map[string]*recordingHandler{"sync": syncHandler, "async": asyncHandler} {
+		if got := strings.Join(handler.seen(), ","); got != "a,b,c" {
+			t.Fatalf("%s subscriber saw %q", name, got)
+		}
+	}
+	if err := b.PublishCompanyEvent(context.Background(), event("d")); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed after Close, got %v", err)
+	}
+}
+
+func TestBus_IsolatesFailingSubscribers(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		failed []string
+	)
+	b := New(Options{OnError: func(_ context.Context, subscriber string, _ companyservice.CompanyEvent, _ error) {
+		mu.Lock()
+		defer mu.Unlock()
+		failed = append(failed, subscriber)
+	}})
+	healthy := &recordingHandler{}
+	subscribe(t, b, Subscriber{Name: "failing", Handler: &recordingHandler{err: errors.New("down")}})
+	subscribe(t, b, Subscriber{Name: "panicking", Handler: &recordingHandler{panics: true}})
+	subscribe(t, b, Subscriber{Name: "healthy", Handler: healthy})
+
+	err := b.PublishCompanyEvent(context.Background(), event("a"))
+	if err == nil || !strings.Contains(err.Error(), "failing") || !strings.Contains(err.Error(), "panicking") {
+		t.Fatalf("expected both failures to be returned, got %v", err)
+	}
+	if got := healthy.seen(); len(got) != 1 {
+		t.Fatalf("healthy subscriber should still get the event, got %v", got)
+	}
+	if strings.Join(failed, ",") != "failing,panicking" {
+		t.Fatalf("unexpected OnError calls: %v", failed)
+	}
+}
+
+func TestBus_SlowAsyncSubscriberDropsInsteadOfBlocking(t *testing.T) {
+	b := New(Options{OnError: func(context.Context, string, companyservice.CompanyEvent, error) {}})
+	slow := &recordingHandler{release: make(chan struct{})}
+	fast := &recordingHandler{}
+	subscribe(t, b, Subscriber{Name: "slow", Handler: slow, Delivery: DeliveryAsync, QueueSize: 1})
+	subscribe(t, b, Subscriber{Name: "fast", Handler: fast})
+
+	var dropped int
+	for _, id := range []string{"a", "b", "c", "d"} {
+		if err := b.PublishCompanyEvent(context.Background(), event(id)); errors.Is(err, ErrQueueFull) {
+			dropped++
+		}
+	}
+	if dropped == 0 {
+		t.Fatal("expected the slow subscriber to drop events once its queue is full")
+	}
+	if got := len(fast.seen()); got != 4 {
+		t.Fatalf("fast subscriber should see every event, got %d", got)
+	}
+
+	close(slow.release)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := b.Close(ctx); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if got := len(slow.seen()); got != 4-dropped {
+		t.Fatalf("expected the queued events to be drained, got %d", got)
+	}
+}
+
+func TestBus_RejectsInvalidSubscribers(t *testing.T) {
+	b := New(Options{})
+	subscribe(t, b, Subscriber{Name: "kafka", Handler: &recordingHandler{}})
+
+	for _, sub := range []Subscriber{
+		{Handler: &recordingHandler{}},
+		{Name: "nil"},
+		{Name: "kafka", Handler: &recordingHandler{}},
+		{Name: "other", Handler: &recordingHandler{}, Delivery: "later"},
+	} {
+		if err := b.Subscribe(sub); err == nil {
+			t.Fatalf("expected %+v to be rejected", sub)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package kafka
 import (
 	"context"
 	"encoding/json"
+	"strconv"
 	"time"
 
 	"github.com/segmentio/kafka-go"
@@ -10,23 +11,59 @@ import (
 	companyservice "github.com/ktsiligkos/xm_project/internal/service/company"
 )
 
-// Publisher writes company events to a Kafka topic
+// Publisher writes company events to Kafka, choosing the topic of each event from its routes.
+// With a state topic configured it also maintains the latest state of every company there.
 type Publisher struct {
-	topic string
-	w     *kafka.Writer
+	topic      string
+	routes     []Route
+	stateTopic string
+	w          *kafka.Writer
 }
 
-// NewPublisher constructs a Publisher
-func NewPublisher(brokers []string, topic string) *Publisher {
-	return &Publisher{
-		topic: topic,
-		w: &kafka.Writer{
-			Addr:         kafka.TCP(brokers...),
-			Topic:        topic,
-			Balancer:     &kafka.Hash{},
-			RequiredAcks: kafka.RequireOne,
-			BatchTimeout: 10 * time.Millisecond,
-		},
+// Option customises a Publisher
+type Option func(*Publisher)
+
+// WithRoutes sends the events matching a route to the route's topic instead of the default one
+func WithRoutes(routes ...Route) Option {
+	return func(p *Publisher) {
+		p.routes = append(p.routes, routes...)
+	}
+}
+
+// WithStateTopic writes the full company to a log-compacted topic keyed by company ID,
+// and a tombstone when the company is deleted
+func WithStateTopic(topic string) Option {
+	return func(p *Publisher) {
+		p.stateTopic = topic
+	}
+}
+
+// NewPublisher constructs a Publisher, events that match none of the routes go to topic
+func NewPublisher(brokers []string, topic string, opts ...Option) *Publisher {
+	p := &Publisher{topic: topic, w: newWriter(brokers)}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// WithoutState returns a publisher sharing the writer that leaves the state topic alone.
+// Replayed dead letters go through it: their company may have changed or been deleted since,
+// and writing it would put outdated state back into the compacted topic.
+func (p *Publisher) WithoutState() *Publisher {
+	replay := *p
+	replay.stateTopic = ""
+	return &replay
+}
+
+// newWriter creates the writer shared by the publishers, the topic is set per message
+func newWriter(brokers []string) *kafka.Writer {
+	return &kafka.Writer{
+		Addr: kafka.TCP(brokers...),
+		// messages are keyed by entity ID, so the events of one entity stay ordered on one partition
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireOne,
+		BatchTimeout: 10 * time.Millisecond,
 	}
 }
 
@@ -37,13 +74,79 @@ func (p *Publisher) PublishCompanyEvent(ctx context.Context, event companyservic
 		return err
 	}
 
-	msg := kafka.Message{
-		Key:   []byte(event.Company.ID),
-		Value: payload,
-		Time:  time.Now(),
+	now := time.Now()
+	headers := eventHeaders(event)
+	msgs := []kafka.Message{{
+		Topic:   p.topicFor(event),
+		Key:     []byte(event.Company.ID),
+		Value:   payload,
+		Headers: headers,
+		Time:    now,
+	}}
+
+	if p.stateTopic != "" {
+		state, ok, err := stateValue(event)
+		if err != nil {
+			return err
+		}
+		if ok {
+			msgs = append(msgs, kafka.Message{
+				Topic:   p.stateTopic,
+				Key:     []byte(event.Company.ID),
+				Value:   state,
+				Headers: headers,
+				Time:    now,
+			})
+		}
+	}
+
+	return p.w.WriteMessages(ctx, msgs...)
+}
+
+// stateValue returns the value to compact under the company ID: the full company,
+// or nil (a tombstone) for a delete. Events without the full company are skipped.
+func stateValue(event companyservice.CompanyEvent) ([]byte, bool, error) {
+	switch event.Operation {
+	case companyservice.OperationDeleted:
+		return nil, true, nil
+	case companyservice.OperationCreated, companyservice.OperationPatched, companyservice.OperationSnapshot:
+		if !event.HasFullCompany() {
+			return nil, false, nil
+		}
+		value, err := json.Marshal(event.Company)
+		if err != nil {
+			return nil, false, err
+		}
+		return value, true, nil
+	default:
+		return nil, false, nil
+	}
+}
+
+// eventHeaders copies the identity and correlation data of the event into
+// message headers, so consumers can dedupe, order and trace without decoding the body
+func eventHeaders(event companyservice.CompanyEvent) []kafka.Header {
+	headers := make([]kafka.Header, 0, 8)
+	add := func(key, value string) {
+		if value != "" {
+			headers = append(headers, kafka.Header{Key: key, Value: []byte(value)})
+		}
+	}
+
+	add("event_id", event.EventID)
+	add("operation", event.Operation)
+	if !event.OccurredAt.IsZero() {
+		add("occurred_at", event.OccurredAt.UTC().Format(time.RFC3339Nano))
+	}
+	if event.Sequence > 0 {
+		add("sequence", strconv.FormatInt(event.Sequence, 10))
 	}
+	add("request_id", event.Metadata.RequestID)
+	add("user_id", event.Metadata.UserID)
+	add("traceparent", event.Metadata.TraceParent)
+	add("tenant", event.Metadata.Tenant)
 
-	return p.w.WriteMessages(ctx, msg)
+	return headers
 }
 
 // Safely close the publisher
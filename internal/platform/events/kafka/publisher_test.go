@@ -0,0 +1,59 @@
+package kafka
+
+import (
+	"encoding/json"
+	"testing"
+
+	companyservice "github.com/ktsiligkos/xm_project/internal/service/company"
+)
+
+func TestStateValue(t *testing.T) {
+	full := companyservice.EventCompany{ID: "c1", Name: "Acme", AmountOfEmployees: 10, Registered: true, Type: "Corporations"}
+
+	t.Run("created carries the full company", func(t *testing.T) {
+		value, ok, err := stateValue(companyservice.CompanyEvent{Operation: companyservice.OperationCreated, Company: full, FullState: true})
+		if err != nil || !ok {
+			t.Fatalf("expected a state value, got ok=%v err=%v", ok, err)
+		}
+		var got companyservice.EventCompany
+		if err := json.Unmarshal(value, &got); err != nil {
+			t.Fatalf("state value is not a company: %v", err)
+		}
+		if got != full {
+			t.Fatalf("state mismatch: got %+v want %+v", got, full)
+		}
+	})
+
+	t.Run("deleted is a tombstone", func(t *testing.T) {
+		value, ok, err := stateValue(companyservice.CompanyEvent{Operation: companyservice.OperationDeleted, Company: companyservice.EventCompany{ID: "c1"}})
+		if err != nil || !ok {
+			t.Fatalf("expected a tombstone, got ok=%v err=%v", ok, err)
+		}
+		if value != nil {
+			t.Fatalf("tombstone must have a nil value, got %q", value)
+		}
+	})
+
+	t.Run("patch without the full company is skipped", func(t *testing.T) {
+		_, ok, err := stateValue(companyservice.CompanyEvent{Operation: companyservice.OperationPatched, Company: companyservice.EventCompany{ID: "c1"}})
+		if err != nil || ok {
+			t.Fatalf("expected no state value, got ok=%v err=%v", ok, err)
+		}
+	})
+}
+
+func TestPublisher_WithoutStateLeavesTheOriginal(t *testing.T) {
+	p := &Publisher{topic: "companies", stateTopic: "company-state"}
+
+	replay := p.WithoutState()
+
+	if replay.stateTopic != "" {
+		t.Fatalf("replay publisher must not write state, got %q", replay.stateTopic)
+	}
+	if replay.topic != p.topic {
+		t.Fatalf("replay publisher must keep the event topic, got %q", replay.topic)
+	}
+	if p.stateTopic != "company-state" {
+		t.Fatalf("original publisher lost its state topic: %q", p.stateTopic)
+	}
+}
@@ -0,0 +1,97 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/segmentio/kafka-go"
+
+	companyservice "github.com/ktsiligkos/xm_project/internal/service/company"
+)
+
+// ReadStats counts what ReadCompanyEvents saw
+type ReadStats struct {
+	Messages    int
+	Undecodable int
+}
+
+// ReadCompanyEvents reads every partition of topic from its first offset up to
+// the end offset at the time of the call and hands each decoded event to fn.
+// Events of one company share a partition, so fn sees them in publication order.
+// The metadata comes from the first broker that answers.
+func ReadCompanyEvents(ctx context.Context, brokers []string, topic string, fn func(companyservice.CompanyEvent)) (ReadStats, error) {
+	var stats ReadStats
+	if len(brokers) == 0 {
+		return stats, errors.New("no kafka brokers configured")
+	}
+
+	var dialer kafka.Dialer
+	conn, err := dialAny(ctx, &dialer, brokers)
+	if err != nil {
+		return stats, err
+	}
+	partitions, err := conn.ReadPartitions(topic)
+	conn.Close()
+	if err != nil {
+		return stats, fmt.Errorf("read partitions of %s: %w", topic, err)
+	}
+
+	for _, partition := range partitions {
+		if err := readPartition(ctx, &dialer, brokers, partition, fn, &stats); err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+// readPartition asks the leader named in the metadata for the offsets, so it does not
+// depend on the first configured broker being up
+func readPartition(ctx context.Context, dialer *kafka.Dialer, brokers []string, p kafka.Partition, fn func(companyservice.CompanyEvent), stats *ReadStats) error {
+	topic, partition := p.Topic, p.ID
+	leader, err := dialer.DialPartition(ctx, "tcp", "", p)
+	if err != nil {
+		return fmt.Errorf("dial leader of %s/%d: %w", topic, partition, err)
+	}
+	first, last, err := leader.ReadOffsets()
+	leader.Close()
+	if err != nil {
+		return fmt.Errorf("read offsets of %s/%d: %w", topic, partition, err)
+	}
+	if first >= last {
+		return nil
+	}
+
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:   brokers,
+		Topic:     topic,
+		Partition: partition,
+		MaxBytes:  10e6,
+	})
+	defer reader.Close()
+
+	if err := reader.SetOffset(first); err != nil {
+		return fmt.Errorf("seek %s/%d: %w", topic, partition, err)
+	}
+
+	for {
+		msg, err := reader.ReadMessage(ctx)
+		if err != nil {
+			return fmt.Errorf("read %s/%d: %w", topic, partition, err)
+		}
+		stats.Messages++
+
+		// tombstones and foreign payloads are counted but do not stop the read
+		var event companyservice.CompanyEvent
+		if len(msg.Value) == 0 || json.Unmarshal(msg.Value, &event) != nil || event.Company.ID == "" {
+			stats.Undecodable++
+		} else {
+			fn(event)
+		}
+
+		if msg.Offset >= last-1 {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package kafka
+
+import companyservice "github.com/ktsiligkos/xm_project/internal/service/company"
+
+// Route sends the events matching every non-empty criterion to Topic
+type Route struct {
+	Operation   string
+	CompanyType string
+	Tenant      string
+	Topic       string
+}
+
+func (r Route) matches(event companyservice.CompanyEvent) bool {
+	if r.Operation != "" && r.Operation != event.Operation {
+		return false
+	}
+	if r.CompanyType != "" && r.CompanyType != event.Company.Type {
+		return false
+	}
+	if r.Tenant != "" && r.Tenant != event.Metadata.Tenant {
+		return false
+	}
+	return true
+}
+
+// topicFor returns the topic of the first matching route, or the default topic
+func (p *Publisher) topicFor(event companyservice.CompanyEvent) string {
+	for _, route := range p.routes {
+		if route.matches(event) {
+			return route.Topic
+		}
+	}
+	return p.topic
+}
@@ -0,0 +1,79 @@
+package kafka
+
+import (
+	"testing"
+
+	companyservice "github.com/ktsiligkos/xm_project/internal/service/company"
+)
+
+func TestTopicFor_FirstMatchingRouteWins(t *testing.T) {
+	p := NewPublisher([]string{"localhost:9092"}, "company-events", WithRoutes(
+		Route{Operation: companyservice.OperationDeleted, Topic: "company-deletes"},
+		Route{CompanyType: "NonProfit", Tenant: "emea", Topic: "emea-nonprofits"},
+		Route{CompanyType: "NonProfit", Topic: "nonprofits"},
+	))
+	defer p.Close()
+
+	tests := []struct {
+		name  string
+		event companyservice.CompanyEvent
+		want  string
+	}{
+		{
+			name:  "operation route",
+			event: companyservice.CompanyEvent{Operation: companyservice.OperationDeleted, Company: companyservice.EventCompany{Type: "NonProfit"}},
+			want:  "company-deletes",
+		},
+		{
+			name: "all criteria of a route must match",
+			event: companyservice.CompanyEvent{
+				Operation: companyservice.OperationCreated,
+				Company:   companyservice.EventCompany{Type: "NonProfit"},
+				Metadata:  companyservice.EventMetadata{Tenant: "emea"},
+			},
+			want: "emea-nonprofits",
+		},
+		{
+			name:  "falls through to a less specific route",
+			event: companyservice.CompanyEvent{Operation: companyservice.OperationCreated, Company: companyservice.EventCompany{Type: "NonProfit"}},
+			want:  "nonprofits",
+		},
+		{
+			name:  "default topic when nothing matches",
+			event: companyservice.CompanyEvent{Operation: companyservice.OperationPatched, Company: companyservice.EventCompany{Type: "Cooperative"}},
+			want:  "company-events",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.topicFor(tt.event); got != tt.want {
+				t.Fatalf("topicFor: got %q want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEventHeaders_SkipsEmptyValues(t *testing.T) {
+	headers := eventHeaders(companyservice.CompanyEvent{
+		EventID:   "evt-1",
+		Operation: companyservice.OperationCreated,
+		Sequence:  3,
+		Metadata:  companyservice.EventMetadata{RequestID: "req-1"},
+	})
+
+	got := map[string]string{}
+	for _, h := range headers {
+		got[h.Key] = string(h.Value)
+	}
+
+	want := map[string]string{"event_id": "evt-1", "operation": "company.created", "sequence": "3", "request_id": "req-1"}
+	if len(got) != len(want) {
+		t.Fatalf("headers: got %v want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Fatalf("header %s: got %q want %q", k, got[k], v)
+		}
+	}
+}
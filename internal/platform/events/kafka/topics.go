@@ -0,0 +1,132 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// TopicSpec describes a topic to create when it does not exist yet
+type TopicSpec struct {
+	Name              string
+	Partitions        int
+	ReplicationFactor int
+	// Configs holds topic level settings such as cleanup.policy or retention.ms
+	Configs map[string]string
+}
+
+// provisionRetryInterval spaces out the attempts while the cluster is still starting
+const provisionRetryInterval = 2 * time.Second
+
+// EnsureTopics creates every topic in specs that the cluster does not know
+// about yet and returns the names of the topics it created. Existing topics
+// are left untouched, their settings are not reconciled. Every broker is tried
+// in turn and the whole attempt is repeated until it succeeds or ctx ends,
+// so a cluster that is still starting does not fail the service.
+func EnsureTopics(ctx context.Context, brokers []string, specs []TopicSpec) ([]string, error) {
+	if len(brokers) == 0 {
+		return nil, errors.New("no kafka brokers configured")
+	}
+
+	for {
+		created, err := ensureTopicsOnce(ctx, brokers, specs)
+		if err == nil {
+			return created, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w: %v", err, ctx.Err())
+		case <-time.After(provisionRetryInterval):
+		}
+	}
+}
+
+func ensureTopicsOnce(ctx context.Context, brokers []string, specs []TopicSpec) ([]string, error) {
+	var dialer kafka.Dialer
+	conn, err := dialAny(ctx, &dialer, brokers)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	existing, err := existingTopics(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []kafka.TopicConfig
+	var created []string
+	for _, spec := range specs {
+		if existing[spec.Name] {
+			continue
+		}
+		missing = append(missing, topicConfig(spec))
+		created = append(created, spec.Name)
+	}
+	if len(missing) == 0 {
+		return nil, nil
+	}
+
+	// topics can only be created through the controller broker
+	controller, err := conn.Controller()
+	if err != nil {
+		return nil, fmt.Errorf("find kafka controller: %w", err)
+	}
+	controllerConn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(controller.Host, strconv.Itoa(controller.Port)))
+	if err != nil {
+		return nil, fmt.Errorf("dial kafka controller: %w", err)
+	}
+	defer controllerConn.Close()
+
+	// another instance starting at the same time may have created them first
+	if err := controllerConn.CreateTopics(missing...); err != nil && !errors.Is(err, kafka.TopicAlreadyExists) {
+		return nil, fmt.Errorf("create kafka topics: %w", err)
+	}
+
+	return created, nil
+}
+
+// dialAny connects to the first broker that answers
+func dialAny(ctx context.Context, dialer *kafka.Dialer, brokers []string) (*kafka.Conn, error) {
+	failures := make([]string, 0, len(brokers))
+	for _, broker := range brokers {
+		conn, err := dialer.DialContext(ctx, "tcp", broker)
+		if err == nil {
+			return conn, nil
+		}
+		failures = append(failures, fmt.Sprintf("%s: %v", broker, err))
+	}
+	return nil, fmt.Errorf("dial kafka: %s", strings.Join(failures, "; "))
+}
+
+func existingTopics(conn *kafka.Conn) (map[string]bool, error) {
+	partitions, err := conn.ReadPartitions()
+	if err != nil {
+		return nil, fmt.Errorf("list kafka topics: %w", err)
+	}
+
+	topics := make(map[string]bool, len(partitions))
+	for _, p := range partitions {
+		topics[p.Topic] = true
+	}
+	return topics, nil
+}
+
+func topicConfig(spec TopicSpec) kafka.TopicConfig {
+	config := kafka.TopicConfig{
+		Topic:             spec.Name,
+		NumPartitions:     spec.Partitions,
+		ReplicationFactor: spec.ReplicationFactor,
+	}
+	for name, value := range spec.Configs {
+		config.ConfigEntries = append(config.ConfigEntries, kafka.ConfigEntry{ConfigName: name, ConfigValue: value})
+	}
+	return config
+}
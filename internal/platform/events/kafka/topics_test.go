@@ -0,0 +1,28 @@
+package kafka
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnsureTopics_TriesEveryBrokerUntilTheContextEnds(t *testing.T) {
+	// nothing listens on these ports, every attempt fails right away
+	brokers := []string{"127.0.0.1:1", "127.0.0.1:2"}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, err := EnsureTopics(ctx, brokers, []TopicSpec{{Name: "company-events", Partitions: 1, ReplicationFactor: 1}})
+	if err == nil {
+		t.Fatal("expected an error without reachable brokers")
+	}
+	if !strings.Contains(err.Error(), context.DeadlineExceeded.Error()) {
+		t.Fatalf("expected to retry until the deadline, got %v", err)
+	}
+	for _, broker := range brokers {
+		if !strings.Contains(err.Error(), broker) {
+			t.Fatalf("expected broker %s to be tried, got %v", broker, err)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	userservice "github.com/ktsiligkos/xm_project/internal/service/user"
+)
+
+// UserPublisher writes user lifecycle events to their own topic
+type UserPublisher struct {
+	topic string
+	w     *kafka.Writer
+}
+
+// NewUserPublisher constructs a UserPublisher writing to topic
+func NewUserPublisher(brokers []string, topic string) *UserPublisher {
+	return &UserPublisher{topic: topic, w: newWriter(brokers)}
+}
+
+// PublishUserEvent serialises the event and sends it to Kafka
+func (p *UserPublisher) PublishUserEvent(ctx context.Context, event userservice.UserEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	return p.w.WriteMessages(ctx, kafka.Message{
+		Topic:   p.topic,
+		Key:     []byte(userEventKey(event)),
+		Value:   payload,
+		Headers: userEventHeaders(event),
+		Time:    time.Now(),
+	})
+}
+
+// userEventKey keeps the events of one account on one partition, failed logins
+// for unknown emails have no user ID and are keyed by the email instead
+func userEventKey(event userservice.UserEvent) string {
+	if event.User.ID != "" {
+		return event.User.ID
+	}
+	return event.User.Email
+}
+
+func userEventHeaders(event userservice.UserEvent) []kafka.Header {
+	headers := make([]kafka.Header, 0, 5)
+	add := func(key, value string) {
+		if value != "" {
+			headers = append(headers, kafka.Header{Key: key, Value: []byte(value)})
+		}
+	}
+
+	add("event_id", event.EventID)
+	add("operation", event.Operation)
+	if !event.OccurredAt.IsZero() {
+		add("occurred_at", event.OccurredAt.UTC().Format(time.RFC3339Nano))
+	}
+	add("request_id", event.Metadata.RequestID)
+	add("traceparent", event.Metadata.TraceParent)
+
+	return headers
+}
+
+// Safely close the publisher
+func (p *UserPublisher) Close() error {
+	if p == nil || p.w == nil {
+		return nil
+	}
+	return p.w.Close()
+}
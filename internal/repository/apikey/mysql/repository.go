@@ -0,0 +1,162 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ktsiligkos/xm_project/internal/correlation"
+	"github.com/ktsiligkos/xm_project/internal/domain"
+	apikeyrepository "github.com/ktsiligkos/xm_project/internal/repository/apikey"
+)
+
+const selectAPIKey = `SELECT id, name, prefix, key_hash, user_id, scopes, expires_at, created_at, last_used_at, revoked_at FROM api_keys`
+
+// MySQLRepository persists API keys using a MySQL-compatible database.
+// Listing and revoking only reach the keys of users in the tenant found in the context.
+type MySQLRepository struct {
+	db *sql.DB
+}
+
+// NewMySQL creates a repository backed by the supplied database handle
+func NewMySQL(db *sql.DB) *MySQLRepository {
+	return &MySQLRepository{db: db}
+}
+
+// CreateAPIKey stores a new key
+func (r *MySQLRepository) CreateAPIKey(ctx context.Context, key domain.APIKey) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO api_keys (id, name, prefix, key_hash, user_id, scopes, expires_at, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		key.ID, key.Name, key.Prefix, key.KeyHash, key.UserID, strings.Join(key.Scopes, ","), key.ExpiresAt, key.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("insert api key: %w", err)
+	}
+	return nil
+}
+
+// GetAPIKeyByHash returns the key with the given hash
+func (r *MySQLRepository) GetAPIKeyByHash(ctx context.Context, keyHash string) (domain.APIKey, error) {
+	key, err := scanAPIKey(r.db.QueryRowContext(ctx, selectAPIKey+` WHERE key_hash = ?`, keyHash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.APIKey{}, apikeyrepository.ErrNotFound
+		}
+		return domain.APIKey{}, fmt.Errorf("query api key: %w", err)
+	}
+	return key, nil
+}
+
+// ListAPIKeys returns every key, newest first
+func (r *MySQLRepository) ListAPIKeys(ctx context.Context) ([]domain.APIKey, error) {
+	where, args, err := tenantScope(ctx, `TRUE`)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.db.QueryContext(ctx, selectAPIKey+` WHERE `+where+` ORDER BY created_at DESC`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list api keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []domain.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan api key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list api keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+// RevokeAPIKey marks the key as revoked
+func (r *MySQLRepository) RevokeAPIKey(ctx context.Context, id string, at time.Time) error {
+	where, args, err := tenantScope(ctx, `id = ?`, id)
+	if err != nil {
+		return err
+	}
+	result, err := r.db.ExecContext(ctx,
+		`UPDATE api_keys SET revoked_at = COALESCE(revoked_at, ?) WHERE `+where, append([]any{at}, args...)...,
+	)
+	if err != nil {
+		return fmt.Errorf("revoke api key: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("revoke api key, rows affected: %w", err)
+	}
+	if affected == 0 {
+		// revoking twice changes nothing, tell that apart from an unknown ID
+		var exists bool
+		if err := r.db.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM api_keys WHERE `+where+`)`, args...).Scan(&exists); err != nil {
+			return fmt.Errorf("revoke api key: %w", err)
+		}
+		if !exists {
+			return apikeyrepository.ErrNotFound
+		}
+	}
+	return nil
+}
+
+// TouchAPIKey records a use of the key
+func (r *MySQLRepository) TouchAPIKey(ctx context.Context, id string, at, notBefore time.Time) error {
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE api_keys SET last_used_at = ? WHERE id = ? AND (last_used_at IS NULL OR last_used_at < ?)`,
+		at, id, notBefore,
+	)
+	if err != nil {
+		return fmt.Errorf("touch api key: %w", err)
+	}
+	return nil
+}
+
+// tenantScope keeps a query to the keys whose user belongs to the tenant of ctx
+func tenantScope(ctx context.Context, condition string, args ...any) (string, []any, error) {
+	tenant, err := correlation.TenantScope(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	if tenant == "" {
+		return condition, args, nil
+	}
+	return condition + ` AND user_id IN (SELECT id FROM users WHERE tenant_id = ?)`, append(args, tenant), nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAPIKey(row rowScanner) (domain.APIKey, error) {
+	var (
+		key                              domain.APIKey
+		scopes                           string
+		expiresAt, lastUsedAt, revokedAt sql.NullTime
+	)
+	if err := row.Scan(&key.ID, &key.Name, &key.Prefix, &key.KeyHash, &key.UserID, &scopes, &expiresAt, &key.CreatedAt, &lastUsedAt, &revokedAt); err != nil {
+		return domain.APIKey{}, err
+	}
+
+	key.Scopes = []string{}
+	if scopes != "" {
+		key.Scopes = strings.Split(scopes, ",")
+	}
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+	return key, nil
+}
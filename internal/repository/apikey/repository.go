@@ -0,0 +1,25 @@
+package apikey
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ktsiligkos/xm_project/internal/domain"
+)
+
+// ErrNotFound indicates that no API key matches.
+var ErrNotFound = errors.New("api key not found")
+
+// Repository defines the contract the service layer relies on for API key storage.
+type Repository interface {
+	CreateAPIKey(ctx context.Context, key domain.APIKey) error
+	GetAPIKeyByHash(ctx context.Context, keyHash string) (domain.APIKey, error)
+	// ListAPIKeys returns every key, newest first, revoked ones included
+	ListAPIKeys(ctx context.Context) ([]domain.APIKey, error)
+	// RevokeAPIKey keeps the first revocation time when called twice
+	RevokeAPIKey(ctx context.Context, id string, at time.Time) error
+	// TouchAPIKey records a use, unless the key was already used after notBefore,
+	// which keeps busy keys from writing on every request
+	TouchAPIKey(ctx context.Context, id string, at, notBefore time.Time) error
+}
@@ -0,0 +1,101 @@
+package eventstore
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ktsiligkos/xm_project/internal/domain"
+)
+
+// Event types stored in the company_events table
+const (
+	eventCreated = "created"
+	eventPatched = "patched"
+	eventDeleted = "deleted"
+)
+
+// storedEvent is a single row of the append-only log
+type storedEvent struct {
+	Version   int64
+	EventType string
+	Payload   json.RawMessage
+}
+
+// aggregate is the state of one company folded from its events
+type aggregate struct {
+	company domain.Company
+	version int64
+	exists  bool
+	deleted bool
+}
+
+// live reports whether the company exists and has not been deleted
+func (a aggregate) live() bool {
+	return a.exists && !a.deleted
+}
+
+// liveIn reports whether the company is live and belongs to tenant, the empty tenant of
+// a context opened to all tenants matches every company
+func (a aggregate) liveIn(tenant string) bool {
+	return a.live() && (tenant == "" || a.company.TenantID == tenant)
+}
+
+// apply folds the next event of the company into the aggregate
+func (a *aggregate) apply(event storedEvent) error {
+	if event.Version != a.version+1 {
+		return fmt.Errorf("event version %d does not follow %d", event.Version, a.version)
+	}
+
+	switch event.EventType {
+	case eventCreated:
+		var company domain.Company
+		if err := json.Unmarshal(event.Payload, &company); err != nil {
+			return fmt.Errorf("decode created event %d: %w", event.Version, err)
+		}
+		a.company = withTenant(company)
+		a.exists = true
+		a.deleted = false
+	case eventPatched:
+		var patch domain.PatchCompanyRequest
+		if err := json.Unmarshal(event.Payload, &patch); err != nil {
+			return fmt.Errorf("decode patched event %d: %w", event.Version, err)
+		}
+		a.company = applyPatch(a.company, patch)
+	case eventDeleted:
+		a.deleted = true
+	default:
+		return fmt.Errorf("unknown event type %q at version %d", event.EventType, event.Version)
+	}
+
+	a.version = event.Version
+	return nil
+}
+
+// withTenant puts companies created before tenants were introduced into the default tenant
+func withTenant(company domain.Company) domain.Company {
+	if company.TenantID == "" {
+		company.TenantID = domain.DefaultTenantID
+	}
+	return company
+}
+
+// applyPatch returns the company with the fields present in the patch replaced
+func applyPatch(company domain.Company, patch domain.PatchCompanyRequest) domain.Company {
+	if patch.Name != nil {
+		company.Name = *patch.Name
+	}
+	if patch.Description != nil {
+		description := *patch.Description
+		company.Description = &description
+	}
+	if patch.AmountOfEmployees != nil {
+		company.AmountOfEmployees = *patch.AmountOfEmployees
+	}
+	if patch.Registered != nil {
+		company.Registered = *patch.Registered
+	}
+	if patch.Type != nil {
+		company.Type = *patch.Type
+	}
+	return company
+}
@@ -0,0 +1,95 @@
+package eventstore
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/ktsiligkos/xm_project/internal/domain"
+)
+
+func ptr[T any](v T) *T { return &v }
+
+func mustEvent(t *testing.T, version int64, eventType string, payload any) storedEvent {
+	t.Helper()
+	data, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatalf("encode payload: %v", err)
+	}
+	return storedEvent{Version: version, EventType: eventType, Payload: data}
+}
+
+func TestAggregate_FoldsCreatePatchDelete(t *testing.T) {
+	created := domain.Company{ID: "c1", TenantID: "t1", Name: "Acme", AmountOfEmployees: 10, Registered: true, Type: domain.Corporations}
+
+	var agg aggregate
+	if err := agg.apply(mustEvent(t, 1, eventCreated, created)); err != nil {
+		t.Fatalf("apply created: %v", err)
+	}
+	if err := agg.apply(mustEvent(t, 2, eventPatched, domain.PatchCompanyRequest{Name: ptr("Acme2"), Description: ptr("new")})); err != nil {
+		t.Fatalf("apply patched: %v", err)
+	}
+
+	want := created
+	want.Name = "Acme2"
+	want.Description = ptr("new")
+	if !agg.live() || agg.version != 2 || !reflect.DeepEqual(agg.company, want) {
+		t.Fatalf("unexpected aggregate after patch: %+v", agg)
+	}
+
+	if err := agg.apply(mustEvent(t, 3, eventDeleted, struct{}{})); err != nil {
+		t.Fatalf("apply deleted: %v", err)
+	}
+	if agg.live() || agg.version != 3 {
+		t.Fatalf("expected a deleted aggregate at version 3, got %+v", agg)
+	}
+
+	if err := agg.apply(mustEvent(t, 4, eventCreated, created)); err != nil {
+		t.Fatalf("apply re-created: %v", err)
+	}
+	if !agg.live() || !reflect.DeepEqual(agg.company, created) {
+		t.Fatalf("expected the company to be live again, got %+v", agg)
+	}
+}
+
+func TestAggregate_PutsCompaniesWithoutTenantIntoTheDefaultTenant(t *testing.T) {
+	var agg aggregate
+	if err := agg.apply(mustEvent(t, 1, eventCreated, domain.Company{ID: "c1", Name: "Acme", Type: domain.NonProfit})); err != nil {
+		t.Fatalf("apply created: %v", err)
+	}
+
+	if agg.company.TenantID != domain.DefaultTenantID {
+		t.Fatalf("expected the default tenant, got %q", agg.company.TenantID)
+	}
+	if !agg.liveIn(domain.DefaultTenantID) || !agg.liveIn("") || agg.liveIn("t2") {
+		t.Fatalf("expected the company to be visible to its own tenant and to unscoped work only")
+	}
+}
+
+func TestAggregate_ContinuesFromSnapshot(t *testing.T) {
+	agg := aggregate{
+		company: domain.Company{ID: "c1", Name: "Acme", AmountOfEmployees: 10, Type: domain.NonProfit},
+		version: 20,
+		exists:  true,
+	}
+
+	if err := agg.apply(mustEvent(t, 21, eventPatched, domain.PatchCompanyRequest{AmountOfEmployees: ptr(11)})); err != nil {
+		t.Fatalf("apply patched: %v", err)
+	}
+	if agg.company.AmountOfEmployees != 11 || agg.company.Name != "Acme" {
+		t.Fatalf("patch not applied on top of the snapshot: %+v", agg.company)
+	}
+}
+
+func TestAggregate_RejectsGapsAndUnknownEvents(t *testing.T) {
+	var agg aggregate
+	if err := agg.apply(mustEvent(t, 2, eventCreated, domain.Company{ID: "c1"})); err == nil {
+		t.Fatal("expected a version gap to be rejected")
+	}
+	if err := agg.apply(mustEvent(t, 1, "renamed", struct{}{})); err == nil {
+		t.Fatal("expected an unknown event type to be rejected")
+	}
+	if agg.exists || agg.version != 0 {
+		t.Fatalf("rejected events must not change the aggregate: %+v", agg)
+	}
+}
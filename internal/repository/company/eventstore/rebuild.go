@@ -0,0 +1,175 @@
+package eventstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ktsiligkos/xm_project/internal/domain"
+)
+
+const defaultRebuildBatchSize = 500
+
+// ErrUnloggedCompanies means the companies table holds rows the event log knows nothing about,
+// rebuilding would drop them. ImportProjection adds them to the log first.
+var ErrUnloggedCompanies = errors.New("companies without events")
+
+// RebuildProjections replaces the companies table and the snapshots with the
+// state folded from the event log. Everything happens in one transaction, so
+// readers see either the old or the new projection, never a half-built one.
+// It refuses to run while the table has companies without events, see ErrUnloggedCompanies.
+// progress, when set, is called after every batch with the number of companies replayed.
+func (r *EventStoreRepository) RebuildProjections(ctx context.Context, batchSize int, progress func(replayed int)) (int, error) {
+	if batchSize <= 0 {
+		batchSize = defaultRebuildBatchSize
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin rebuild transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// the shared lock keeps writers in mysql mode from adding such rows until the rebuild commits
+	var unlogged int
+	if err := tx.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM companies c WHERE NOT EXISTS (SELECT 1 FROM company_events e WHERE e.company_id = c.id) LOCK IN SHARE MODE`,
+	).Scan(&unlogged); err != nil {
+		return 0, fmt.Errorf("count companies without events: %w", err)
+	}
+	if unlogged > 0 {
+		return 0, fmt.Errorf("%w: %d companies would be deleted", ErrUnloggedCompanies, unlogged)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM companies`); err != nil {
+		return 0, fmt.Errorf("clear companies projection: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM company_snapshots`); err != nil {
+		return 0, fmt.Errorf("clear company snapshots: %w", err)
+	}
+
+	replayed := 0
+	afterID := ""
+	for {
+		ids, err := companyIDs(ctx, tx, afterID, batchSize)
+		if err != nil {
+			return replayed, err
+		}
+
+		for _, id := range ids {
+			agg, err := r.load(ctx, tx, id)
+			if err != nil {
+				return replayed, err
+			}
+			if agg.live() {
+				if err := project(ctx, tx, eventCreated, agg); err != nil {
+					return replayed, fmt.Errorf("project company %s: %w", id, err)
+				}
+			}
+			if err := saveSnapshot(ctx, tx, id, agg); err != nil {
+				return replayed, err
+			}
+		}
+
+		replayed += len(ids)
+		if len(ids) > 0 {
+			afterID = ids[len(ids)-1]
+			if progress != nil {
+				progress(replayed)
+			}
+		}
+		if len(ids) < batchSize {
+			break
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return replayed, fmt.Errorf("commit rebuild: %w", err)
+	}
+	return replayed, nil
+}
+
+// ImportProjection seeds the event log from the companies table: every company
+// without events gets a created event holding its current state. It is meant to
+// be run once when an existing database switches to event sourced storage, with a
+// context opened to all tenants.
+func (r *EventStoreRepository) ImportProjection(ctx context.Context, batchSize int) (int, error) {
+	if batchSize <= 0 {
+		batchSize = defaultRebuildBatchSize
+	}
+
+	imported := 0
+	afterID := ""
+	for {
+		companies, err := r.projection.ListCompanies(ctx, domain.CompanyFilter{}, afterID, batchSize)
+		if err != nil {
+			return imported, err
+		}
+
+		for _, company := range companies {
+			ok, err := r.importCompany(ctx, company)
+			if err != nil {
+				return imported, err
+			}
+			if ok {
+				imported++
+			}
+		}
+
+		if len(companies) < batchSize {
+			break
+		}
+		afterID = companies[len(companies)-1].ID
+	}
+
+	return imported, nil
+}
+
+func (r *EventStoreRepository) importCompany(ctx context.Context, company domain.Company) (bool, error) {
+	data, err := encodeEvent(eventCreated, company)
+	if err != nil {
+		return false, err
+	}
+
+	result, err := r.db.ExecContext(ctx,
+		`INSERT INTO company_events (company_id, version, event_type, payload)
+		SELECT ?, 1, ?, ? FROM DUAL WHERE NOT EXISTS (SELECT 1 FROM company_events WHERE company_id = ?)`,
+		company.ID, eventCreated, []byte(data), company.ID,
+	)
+	if err != nil {
+		if duplicateKey(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("import company %s: %w", company.ID, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("import company %s, rows affected: %w", company.ID, err)
+	}
+	return rows > 0, nil
+}
+
+func companyIDs(ctx context.Context, q queryer, afterID string, limit int) ([]string, error) {
+	rows, err := q.QueryContext(ctx,
+		`SELECT DISTINCT company_id FROM company_events WHERE company_id > ? ORDER BY company_id LIMIT ?`,
+		afterID, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list company ids: %w", err)
+	}
+	defer rows.Close()
+
+	ids := make([]string, 0, limit)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan company id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list company ids: %w", err)
+	}
+	return ids, nil
+}
@@ -0,0 +1,317 @@
+package eventstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	driver "github.com/go-sql-driver/mysql"
+	"github.com/ktsiligkos/xm_project/internal/correlation"
+	"github.com/ktsiligkos/xm_project/internal/domain"
+	companyrepository "github.com/ktsiligkos/xm_project/internal/repository/company"
+	companymysql "github.com/ktsiligkos/xm_project/internal/repository/company/mysql"
+)
+
+const (
+	defaultSnapshotInterval = 20
+	// writes that lose the race for the next version are retried against the new state
+	maxWriteAttempts = 3
+)
+
+// errVersionConflict means another writer appended the same version first
+var errVersionConflict = errors.New("company event version conflict")
+
+// queryer is satisfied by both *sql.DB and *sql.Tx
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// EventStoreRepository keeps companies as an append-only log of events.
+// The log is the source of truth, the companies table is a projection that is
+// updated in the same transaction and serves listings and the uniqueness check.
+// Companies of another tenant than the one in the context are treated as missing.
+type EventStoreRepository struct {
+	db               *sql.DB
+	projection       *companymysql.MySQLRepository
+	snapshotInterval int64
+}
+
+// NewEventStore creates an event sourced repository. A snapshot of a company is
+// written every snapshotInterval events so loading it never replays the whole log.
+func NewEventStore(db *sql.DB, snapshotInterval int) *EventStoreRepository {
+	if snapshotInterval <= 0 {
+		snapshotInterval = defaultSnapshotInterval
+	}
+	return &EventStoreRepository{
+		db:               db,
+		projection:       companymysql.NewMySQL(db),
+		snapshotInterval: int64(snapshotInterval),
+	}
+}
+
+// Get rebuilds a single company from its snapshot and the events after it
+func (r *EventStoreRepository) GetCompanyByID(ctx context.Context, companyID string) (domain.Company, error) {
+	tenant, err := correlation.TenantScope(ctx)
+	if err != nil {
+		return domain.Company{}, err
+	}
+	agg, err := r.load(ctx, r.db, companyID)
+	if err != nil {
+		return domain.Company{}, err
+	}
+	if !agg.liveIn(tenant) {
+		return domain.Company{}, companyrepository.ErrNotFound
+	}
+
+	return agg.company, nil
+}
+
+// Create appends a created event, an ID that is already in use is a uniqueness violation
+func (r *EventStoreRepository) CreateCompany(ctx context.Context, company domain.Company) (companyrepository.Change, error) {
+	tenant, err := companymysql.TenantOf(ctx, company.TenantID)
+	if err != nil {
+		return companyrepository.Change{}, err
+	}
+	company.TenantID = tenant
+	change, err := r.write(ctx, company.ID, func(agg aggregate) (string, any, error) {
+		if agg.live() {
+			return "", nil, companyrepository.ErrUniquenessViolation
+		}
+		return eventCreated, company, nil
+	})
+	if err != nil {
+		return companyrepository.Change{}, err
+	}
+
+	return change, nil
+}
+
+// Delete appends a deleted event and removes the company from the projection, returning its last state
+func (r *EventStoreRepository) DeleteCompanyByID(ctx context.Context, companyID string) (companyrepository.Change, error) {
+	tenant, err := correlation.TenantScope(ctx)
+	if err != nil {
+		return companyrepository.Change{}, err
+	}
+	return r.write(ctx, companyID, func(agg aggregate) (string, any, error) {
+		if !agg.liveIn(tenant) {
+			return "", nil, companyrepository.ErrNotFound
+		}
+		return eventDeleted, struct{}{}, nil
+	})
+}
+
+// Patch appends a patched event holding only the fields that were supplied
+func (r *EventStoreRepository) PatchCompanyByID(ctx context.Context, patchCompanyRequest domain.PatchCompanyRequest, uuid string, _ int) (companyrepository.Change, error) {
+	tenant, err := correlation.TenantScope(ctx)
+	if err != nil {
+		return companyrepository.Change{}, err
+	}
+	return r.write(ctx, uuid, func(agg aggregate) (string, any, error) {
+		if !agg.liveIn(tenant) {
+			return "", nil, companyrepository.ErrNotFound
+		}
+		return eventPatched, patchCompanyRequest, nil
+	})
+}
+
+// List reads the projection, which holds the current state of every live company and is scoped like the MySQL repository
+func (r *EventStoreRepository) ListCompanies(ctx context.Context, filter domain.CompanyFilter, afterID string, limit int) ([]domain.Company, error) {
+	return r.projection.ListCompanies(ctx, filter, afterID, limit)
+}
+
+// decideFunc inspects the current state and returns the event to append
+type decideFunc func(agg aggregate) (eventType string, payload any, err error)
+
+func (r *EventStoreRepository) write(ctx context.Context, companyID string, decide decideFunc) (companyrepository.Change, error) {
+	var (
+		change companyrepository.Change
+		err    error
+	)
+	for attempt := 0; attempt < maxWriteAttempts; attempt++ {
+		change, err = r.writeOnce(ctx, companyID, decide)
+		if !errors.Is(err, errVersionConflict) {
+			return change, err
+		}
+	}
+	return change, err
+}
+
+// writeOnce appends the event, updates the projection and allocates the sequence of the
+// published event in one transaction. The version of the log is not reused as sequence,
+// so sequences keep increasing when a database switches between the storage modes.
+func (r *EventStoreRepository) writeOnce(ctx context.Context, companyID string, decide decideFunc) (companyrepository.Change, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return companyrepository.Change{}, fmt.Errorf("begin company event transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	agg, err := r.load(ctx, tx, companyID)
+	if err != nil {
+		return companyrepository.Change{}, err
+	}
+
+	eventType, payload, err := decide(agg)
+	if err != nil {
+		return companyrepository.Change{}, err
+	}
+
+	data, err := encodeEvent(eventType, payload)
+	if err != nil {
+		return companyrepository.Change{}, err
+	}
+	event := storedEvent{Version: agg.version + 1, EventType: eventType, Payload: data}
+
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO company_events (company_id, version, event_type, payload) VALUES (?, ?, ?, ?)`,
+		companyID, event.Version, event.EventType, []byte(event.Payload),
+	); err != nil {
+		if duplicateKey(err) {
+			return companyrepository.Change{}, errVersionConflict
+		}
+		return companyrepository.Change{}, fmt.Errorf("append company event: %w", err)
+	}
+
+	if err := agg.apply(event); err != nil {
+		return companyrepository.Change{}, err
+	}
+	if err := project(ctx, tx, eventType, agg); err != nil {
+		return companyrepository.Change{}, err
+	}
+	if agg.version%r.snapshotInterval == 0 {
+		if err := saveSnapshot(ctx, tx, companyID, agg); err != nil {
+			return companyrepository.Change{}, err
+		}
+	}
+
+	// a deleted aggregate keeps its last state, that is what the delete event carries
+	change := companyrepository.Change{Company: agg.company}
+	change.Sequence, err = companymysql.NextEventSequence(ctx, tx, companyID)
+	if err != nil {
+		return companyrepository.Change{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return companyrepository.Change{}, fmt.Errorf("commit company event: %w", err)
+	}
+	return change, nil
+}
+
+// load folds the latest snapshot and every later event of the company
+func (r *EventStoreRepository) load(ctx context.Context, q queryer, companyID string) (aggregate, error) {
+	var (
+		agg     aggregate
+		state   []byte
+		deleted bool
+	)
+
+	err := q.QueryRowContext(ctx,
+		`SELECT version, state, deleted FROM company_snapshots WHERE company_id = ?`,
+		companyID,
+	).Scan(&agg.version, &state, &deleted)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		return aggregate{}, fmt.Errorf("query company snapshot: %w", err)
+	default:
+		if err := json.Unmarshal(state, &agg.company); err != nil {
+			return aggregate{}, fmt.Errorf("decode company snapshot: %w", err)
+		}
+		agg.company = withTenant(agg.company)
+		agg.exists = true
+		agg.deleted = deleted
+	}
+
+	rows, err := q.QueryContext(ctx,
+		`SELECT version, event_type, payload FROM company_events WHERE company_id = ? AND version > ? ORDER BY version`,
+		companyID, agg.version,
+	)
+	if err != nil {
+		return aggregate{}, fmt.Errorf("query company events: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			event   storedEvent
+			payload []byte
+		)
+		if err := rows.Scan(&event.Version, &event.EventType, &payload); err != nil {
+			return aggregate{}, fmt.Errorf("scan company event: %w", err)
+		}
+		event.Payload = payload
+		if err := agg.apply(event); err != nil {
+			return aggregate{}, fmt.Errorf("replay company %s: %w", companyID, err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return aggregate{}, fmt.Errorf("query company events: %w", err)
+	}
+
+	return agg, nil
+}
+
+// project brings the companies table in line with the event that was just appended
+func project(ctx context.Context, tx *sql.Tx, eventType string, agg aggregate) error {
+	var err error
+	company := agg.company
+
+	switch eventType {
+	case eventCreated:
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO companies (id, tenant_id, name, description, amount_of_employees, registered, type) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			company.ID, company.TenantID, company.Name, company.Description, company.AmountOfEmployees, company.Registered, company.Type,
+		)
+	case eventPatched:
+		_, err = tx.ExecContext(ctx,
+			`UPDATE companies SET name = ?, description = ?, amount_of_employees = ?, registered = ?, type = ? WHERE id = ?`,
+			company.Name, company.Description, company.AmountOfEmployees, company.Registered, company.Type, company.ID,
+		)
+	case eventDeleted:
+		_, err = tx.ExecContext(ctx, `DELETE FROM companies WHERE id = ?`, company.ID)
+	}
+
+	if err != nil {
+		if duplicateKey(err) {
+			return companyrepository.ErrUniquenessViolation
+		}
+		return fmt.Errorf("project %s event: %w", eventType, err)
+	}
+	return nil
+}
+
+func encodeEvent(eventType string, payload any) (json.RawMessage, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("encode %s event: %w", eventType, err)
+	}
+	return data, nil
+}
+
+func saveSnapshot(ctx context.Context, tx *sql.Tx, companyID string, agg aggregate) error {
+	state, err := json.Marshal(agg.company)
+	if err != nil {
+		return fmt.Errorf("encode company snapshot: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO company_snapshots (company_id, version, state, deleted) VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE version = VALUES(version), state = VALUES(state), deleted = VALUES(deleted)`,
+		companyID, agg.version, state, agg.deleted,
+	); err != nil {
+		return fmt.Errorf("save company snapshot: %w", err)
+	}
+	return nil
+}
+
+// Returns true for MySQL's duplicate entry error
+func duplicateKey(err error) bool {
+	var mysqlErr *driver.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == 1062
+	}
+	return false
+}
@@ -8,6 +8,7 @@ import (
 	"strings"
 
 	driver "github.com/go-sql-driver/mysql"
+	"github.com/ktsiligkos/xm_project/internal/correlation"
 	"github.com/ktsiligkos/xm_project/internal/domain"
 	companyrepository "github.com/ktsiligkos/xm_project/internal/repository/company"
 )
@@ -15,6 +16,7 @@ import (
 // Making sure to prevent string errors when building queries
 const (
 	columnID                = "id"
+	columnTenantID          = "tenant_id"
 	columnName              = "name"
 	columnDescription       = "description"
 	columnAmountOfEmployees = "amount_of_employees"
@@ -22,7 +24,9 @@ const (
 	columnType              = "type"
 )
 
-// MySQLRepository persists companies using a MySQL-compatible database
+// MySQLRepository persists companies using a MySQL-compatible database.
+// Every query is scoped to the tenant found in the context, see correlation.TenantScope;
+// a context without a tenant is refused unless it was opened to all tenants.
 type MySQLRepository struct {
 	db *sql.DB
 }
@@ -32,25 +36,41 @@ func NewMySQL(db *sql.DB) *MySQLRepository {
 	return &MySQLRepository{db: db}
 }
 
+// queryRower is satisfied by both *sql.DB and *sql.Tx
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
 // Get returns a single company by ID
 func (r *MySQLRepository) GetCompanyByID(ctx context.Context, companyID string) (domain.Company, error) {
+	return selectCompany(ctx, r.db, companyID, "")
+}
 
+// selectCompany reads a company of the tenant of ctx, lock is appended to the query
+// so writes can hold the row for the rest of their transaction
+func selectCompany(ctx context.Context, q queryRower, companyID, lock string) (domain.Company, error) {
+	conditions, args, err := tenantScope(ctx, fmt.Sprintf("%s = ?", columnID), companyID)
+	if err != nil {
+		return domain.Company{}, err
+	}
 	query := fmt.Sprintf(
-		`SELECT %s, %s, %s, %s, %s, %s FROM companies WHERE %s = ?`,
+		`SELECT %s, %s, %s, %s, %s, %s, %s FROM companies WHERE %s %s`,
 		columnID,
+		columnTenantID,
 		columnName,
 		columnDescription,
 		columnAmountOfEmployees,
 		columnRegistered,
 		columnType,
-		columnID,
+		conditions,
+		lock,
 	)
 
 	var (
 		company domain.Company
 	)
 
-	if err := r.db.QueryRowContext(ctx, query, companyID).Scan(&company.ID, &company.Name, &company.Description, &company.AmountOfEmployees, &company.Registered, &company.Type); err != nil {
+	if err := q.QueryRowContext(ctx, query, args...).Scan(&company.ID, &company.TenantID, &company.Name, &company.Description, &company.AmountOfEmployees, &company.Registered, &company.Type); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return domain.Company{}, companyrepository.ErrNotFound
 		}
@@ -61,50 +81,167 @@ func (r *MySQLRepository) GetCompanyByID(ctx context.Context, companyID string)
 	return company, nil
 }
 
-// Delete removes a company record
-func (r *MySQLRepository) DeleteCompanyByID(ctx context.Context, companyID string) error {
-	query := fmt.Sprintf(
-		`DELETE FROM companies WHERE %s = ?`,
+// Delete removes a company record and returns its last state
+func (r *MySQLRepository) DeleteCompanyByID(ctx context.Context, companyID string) (companyrepository.Change, error) {
+	var change companyrepository.Change
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		company, err := selectCompany(ctx, tx, companyID, "FOR UPDATE")
+		if err != nil {
+			return err
+		}
+
+		query := fmt.Sprintf(`DELETE FROM companies WHERE %s = ?`, columnID)
+		if _, err := tx.ExecContext(ctx, query, companyID); err != nil {
+			return fmt.Errorf("delete company: %w", err)
+		}
+
+		change.Company = company
+		change.Sequence, err = NextEventSequence(ctx, tx, companyID)
+		return err
+	})
+	if err != nil {
+		return companyrepository.Change{}, err
+	}
+
+	return change, nil
+}
+
+// Create writes a new company record into the tenant of ctx and returns the stored record
+func (r *MySQLRepository) CreateCompany(ctx context.Context, company domain.Company) (companyrepository.Change, error) {
+	tenant, err := TenantOf(ctx, company.TenantID)
+	if err != nil {
+		return companyrepository.Change{}, err
+	}
+	company.TenantID = tenant
+
+	queryWithID := fmt.Sprintf(
+		`INSERT INTO companies (%s, %s, %s, %s, %s, %s, %s) VALUES (?, ?, ?, ?, ?, ?, ?)`,
 		columnID,
+		columnTenantID,
+		columnName,
+		columnDescription,
+		columnAmountOfEmployees,
+		columnRegistered,
+		columnType,
 	)
 
-	result, err := r.db.ExecContext(ctx, query, companyID)
+	change := companyrepository.Change{Company: company}
+	err = r.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, queryWithID, company.ID, company.TenantID, company.Name, company.Description, company.AmountOfEmployees, company.Registered, company.Type); err != nil {
+			if uniquenessViolation(err) {
+				return companyrepository.ErrUniquenessViolation
+			}
+			return fmt.Errorf("insert company with id: %w", err)
+		}
+
+		var err error
+		change.Sequence, err = NextEventSequence(ctx, tx, company.ID)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("delete company: %w", err)
+		return companyrepository.Change{}, err
 	}
 
-	rows, err := result.RowsAffected()
+	return change, nil
+}
+
+// inTx runs fn in a transaction, which is committed when fn succeeds
+func (r *MySQLRepository) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("delete company, rows affected: %w", err)
-	}
-	if rows == 0 {
-		return companyrepository.ErrNotFound
+		return fmt.Errorf("begin company transaction: %w", err)
 	}
+	defer tx.Rollback()
 
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit company transaction: %w", err)
+	}
 	return nil
 }
 
-// Create writes a new company record and returns the stored record
-func (r *MySQLRepository) CreateCompany(ctx context.Context, company domain.Company) (domain.Company, error) {
+// List returns up to limit companies ordered by ID, starting after afterID
+// Keyset pagination keeps every page cheap so the whole table can be walked
+func (r *MySQLRepository) ListCompanies(ctx context.Context, filter domain.CompanyFilter, afterID string, limit int) ([]domain.Company, error) {
+	condition, args, err := tenantScope(ctx, fmt.Sprintf("%s > ?", columnID), afterID)
+	if err != nil {
+		return nil, err
+	}
+	conditions := []string{condition}
 
-	queryWithID := fmt.Sprintf(
-		`INSERT INTO companies (%s, %s, %s, %s, %s, %s) VALUES (?, ?, ?, ?, ?, ?)`,
+	if filter.Type != nil {
+		conditions = append(conditions, fmt.Sprintf("%s = ?", columnType))
+		args = append(args, string(*filter.Type))
+	}
+	if filter.Registered != nil {
+		conditions = append(conditions, fmt.Sprintf("%s = ?", columnRegistered))
+		args = append(args, *filter.Registered)
+	}
+
+	query := fmt.Sprintf(
+		`SELECT %s, %s, %s, %s, %s, %s, %s FROM companies WHERE %s ORDER BY %s LIMIT ?`,
 		columnID,
+		columnTenantID,
 		columnName,
 		columnDescription,
 		columnAmountOfEmployees,
 		columnRegistered,
 		columnType,
+		strings.Join(conditions, " AND "),
+		columnID,
 	)
+	args = append(args, limit)
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list companies: %w", err)
+	}
+	defer rows.Close()
 
-	if _, err := r.db.ExecContext(ctx, queryWithID, company.ID, company.Name, company.Description, company.AmountOfEmployees, company.Registered, company.Type); err != nil {
-		if uniquenessViolation(err) {
-			return domain.Company{}, companyrepository.ErrUniquenessViolation
+	companies := make([]domain.Company, 0, limit)
+	for rows.Next() {
+		var company domain.Company
+		if err := rows.Scan(&company.ID, &company.TenantID, &company.Name, &company.Description, &company.AmountOfEmployees, &company.Registered, &company.Type); err != nil {
+			return nil, fmt.Errorf("scan company: %w", err)
 		}
-		return domain.Company{}, fmt.Errorf("insert company with id: %w", err)
+		companies = append(companies, company)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list companies: %w", err)
 	}
 
-	return company, nil
+	return companies, nil
+}
+
+// tenantScope adds the tenant of ctx to the condition of a query. Only the tools opened
+// to all tenants with correlation.WithAllTenants see the companies of every tenant.
+func tenantScope(ctx context.Context, condition string, args ...any) (string, []any, error) {
+	tenant, err := correlation.TenantScope(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	if tenant == "" {
+		return condition, args, nil
+	}
+	return fmt.Sprintf("%s AND %s = ?", condition, columnTenantID), append(args, tenant), nil
+}
+
+// TenantOf returns the tenant a new company of ctx belongs to: the tenant of the request,
+// or for a context opened to all tenants the tenant already set on the company
+func TenantOf(ctx context.Context, tenantID string) (string, error) {
+	tenant, err := correlation.TenantScope(ctx)
+	if err != nil {
+		return "", err
+	}
+	if tenant != "" {
+		return tenant, nil
+	}
+	if tenantID == "" {
+		return "", fmt.Errorf("%w: the company names no tenant", correlation.ErrNoTenant)
+	}
+	return tenantID, nil
 }
 
 // Returns true if the supplied name field already exists
@@ -116,33 +253,45 @@ func uniquenessViolation(err error) bool {
 	return false
 }
 
-// Patch partially updates an existing record
-func (r *MySQLRepository) PatchCompanyByID(ctx context.Context, patchCompanyRequest domain.PatchCompanyRequest, uuid string, maxNumOfFields int) error {
+// Patch partially updates an existing record and returns the state it left
+func (r *MySQLRepository) PatchCompanyByID(ctx context.Context, patchCompanyRequest domain.PatchCompanyRequest, uuid string, maxNumOfFields int) (companyrepository.Change, error) {
 
 	fields, field_values := createPatchRequestFields(patchCompanyRequest, uuid, maxNumOfFields)
 	query := fmt.Sprintf(
-		`UPDATE  companies SET %s WHERE id = ?`,
+		`UPDATE  companies SET %s WHERE %s = ?`,
 		fields,
+		columnID,
 	)
 	field_values = append(field_values, uuid)
 
-	result, err := r.db.ExecContext(ctx, query, field_values...)
-	if err != nil {
-		if uniquenessViolation(err) {
-			return companyrepository.ErrUniquenessViolation
+	var change companyrepository.Change
+	err := r.inTx(ctx, func(tx *sql.Tx) error {
+		// the lock keeps concurrent patches out until the state below has been read
+		if _, err := selectCompany(ctx, tx, uuid, "FOR UPDATE"); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, query, field_values...); err != nil {
+			if uniquenessViolation(err) {
+				return companyrepository.ErrUniquenessViolation
+			}
+			return fmt.Errorf("patch company: %w", err)
 		}
-		return fmt.Errorf("patch company: %w", err)
-	}
 
-	rows, err := result.RowsAffected()
+		company, err := selectCompany(ctx, tx, uuid, "")
+		if err != nil {
+			return err
+		}
+
+		change.Company = company
+		change.Sequence, err = NextEventSequence(ctx, tx, uuid)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("delete company, rows affected: %w", err)
-	}
-	if rows == 0 {
-		return companyrepository.ErrNotFound
+		return companyrepository.Change{}, err
 	}
 
-	return nil
+	return change, nil
 }
 
 func createPatchRequestFields(patchCompanyRequest domain.PatchCompanyRequest, uuid string, companyColumnSize int) (string, []any) {
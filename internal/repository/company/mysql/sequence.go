@@ -0,0 +1,34 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// execer is satisfied by *sql.Tx, sequences are only handed out inside the transaction of a write
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// NextEventSequence atomically increments and returns the event counter of a company.
+// Called in the transaction of the write, the row lock of the counter orders concurrent
+// writes to the same company. LAST_INSERT_ID(expr) hands the new value back on the same
+// connection without a second query.
+func NextEventSequence(ctx context.Context, tx execer, companyID string) (int64, error) {
+	result, err := tx.ExecContext(ctx,
+		`INSERT INTO company_event_sequences (company_id, last_sequence) VALUES (?, LAST_INSERT_ID(1))
+		ON DUPLICATE KEY UPDATE last_sequence = LAST_INSERT_ID(last_sequence + 1)`,
+		companyID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("next event sequence: %w", err)
+	}
+
+	sequence, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("next event sequence, last insert id: %w", err)
+	}
+
+	return sequence, nil
+}
@@ -11,10 +11,18 @@ import (
 var ErrNotFound = errors.New("company not found")
 var ErrUniquenessViolation = errors.New("name already exists")
 
+// Change is the outcome of a write. Sequence numbers the changes of one company; it is
+// allocated in the transaction of the write, so it follows the order in which writes commit.
+type Change struct {
+	Company  domain.Company
+	Sequence int64
+}
+
 // Repository defines the contract the service layer relies on for company data access.
 type Repository interface {
 	GetCompanyByID(ctx context.Context, companyID string) (domain.Company, error)
-	CreateCompany(ctx context.Context, company domain.Company) (domain.Company, error)
-	DeleteCompanyByID(ctx context.Context, companyID string) error
-	PatchCompanyByID(ctx context.Context, patchCompanyRequest domain.PatchCompanyRequest, uuid string, maxNumOfFields int) error
+	CreateCompany(ctx context.Context, company domain.Company) (Change, error)
+	DeleteCompanyByID(ctx context.Context, companyID string) (Change, error)
+	PatchCompanyByID(ctx context.Context, patchCompanyRequest domain.PatchCompanyRequest, uuid string, maxNumOfFields int) (Change, error)
+	ListCompanies(ctx context.Context, filter domain.CompanyFilter, afterID string, limit int) ([]domain.Company, error)
 }
@@ -0,0 +1,178 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ktsiligkos/xm_project/internal/domain"
+	deadletterrepository "github.com/ktsiligkos/xm_project/internal/repository/deadletter"
+)
+
+const selectDeadLetter = `SELECT id, stream, operation, company_id, payload, last_error, attempts, created_at, last_attempt_at FROM company_event_dead_letters`
+
+// MySQLRepository persists dead letters using a MySQL-compatible database
+type MySQLRepository struct {
+	db *sql.DB
+}
+
+// NewMySQL creates a repository backed by the supplied database handle
+func NewMySQL(db *sql.DB) *MySQLRepository {
+	return &MySQLRepository{db: db}
+}
+
+// Save stores a new dead letter and returns it with its generated ID
+func (r *MySQLRepository) SaveDeadLetter(ctx context.Context, deadLetter domain.DeadLetter) (domain.DeadLetter, error) {
+	now := time.Now().UTC()
+	if deadLetter.CreatedAt.IsZero() {
+		deadLetter.CreatedAt = now
+	}
+	if deadLetter.LastAttemptAt.IsZero() {
+		deadLetter.LastAttemptAt = now
+	}
+
+	result, err := r.db.ExecContext(ctx,
+		`INSERT INTO company_event_dead_letters (stream, operation, company_id, payload, last_error, attempts, created_at, last_attempt_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		deadLetter.Stream,
+		deadLetter.Operation,
+		deadLetter.CompanyID,
+		[]byte(deadLetter.Payload),
+		deadLetter.LastError,
+		deadLetter.Attempts,
+		deadLetter.CreatedAt,
+		deadLetter.LastAttemptAt,
+	)
+	if err != nil {
+		return domain.DeadLetter{}, fmt.Errorf("insert dead letter: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return domain.DeadLetter{}, fmt.Errorf("insert dead letter, last insert id: %w", err)
+	}
+	deadLetter.ID = id
+
+	return deadLetter, nil
+}
+
+// Get returns a single dead letter by ID
+func (r *MySQLRepository) GetDeadLetterByID(ctx context.Context, id int64) (domain.DeadLetter, error) {
+	row := r.db.QueryRowContext(ctx, selectDeadLetter+` WHERE id = ?`, id)
+
+	deadLetter, err := scanDeadLetter(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.DeadLetter{}, deadletterrepository.ErrNotFound
+		}
+		return domain.DeadLetter{}, fmt.Errorf("query dead letter: %w", err)
+	}
+
+	return deadLetter, nil
+}
+
+// List returns up to limit dead letters ordered by ID, starting after afterID
+func (r *MySQLRepository) ListDeadLetters(ctx context.Context, afterID int64, limit int) ([]domain.DeadLetter, error) {
+	rows, err := r.db.QueryContext(ctx, selectDeadLetter+` WHERE id > ? ORDER BY id LIMIT ?`, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list dead letters: %w", err)
+	}
+	defer rows.Close()
+
+	deadLetters := make([]domain.DeadLetter, 0, limit)
+	for rows.Next() {
+		deadLetter, err := scanDeadLetter(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan dead letter: %w", err)
+		}
+		deadLetters = append(deadLetters, deadLetter)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list dead letters: %w", err)
+	}
+
+	return deadLetters, nil
+}
+
+// RecordFailedAttempt bumps the attempt counter after an unsuccessful replay
+func (r *MySQLRepository) RecordFailedAttempt(ctx context.Context, id int64, lastError string) error {
+	result, err := r.db.ExecContext(ctx,
+		`UPDATE company_event_dead_letters SET attempts = attempts + 1, last_error = ?, last_attempt_at = ? WHERE id = ?`,
+		lastError, time.Now().UTC(), id,
+	)
+	if err != nil {
+		return fmt.Errorf("update dead letter: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("update dead letter, rows affected: %w", err)
+	}
+	if rows == 0 {
+		return deadletterrepository.ErrNotFound
+	}
+
+	return nil
+}
+
+// Delete removes a dead letter
+func (r *MySQLRepository) DeleteDeadLetterByID(ctx context.Context, id int64) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM company_event_dead_letters WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("delete dead letter: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("delete dead letter, rows affected: %w", err)
+	}
+	if rows == 0 {
+		return deadletterrepository.ErrNotFound
+	}
+
+	return nil
+}
+
+// DeleteAll removes every dead letter and returns how many were removed
+func (r *MySQLRepository) DeleteAllDeadLetters(ctx context.Context) (int64, error) {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM company_event_dead_letters`)
+	if err != nil {
+		return 0, fmt.Errorf("delete dead letters: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("delete dead letters, rows affected: %w", err)
+	}
+
+	return rows, nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanDeadLetter(row rowScanner) (domain.DeadLetter, error) {
+	var (
+		deadLetter domain.DeadLetter
+		payload    []byte
+	)
+
+	if err := row.Scan(
+		&deadLetter.ID,
+		&deadLetter.Stream,
+		&deadLetter.Operation,
+		&deadLetter.CompanyID,
+		&payload,
+		&deadLetter.LastError,
+		&deadLetter.Attempts,
+		&deadLetter.CreatedAt,
+		&deadLetter.LastAttemptAt,
+	); err != nil {
+		return domain.DeadLetter{}, err
+	}
+	deadLetter.Payload = payload
+
+	return deadLetter, nil
+}
@@ -0,0 +1,21 @@
+package deadletter
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ktsiligkos/xm_project/internal/domain"
+)
+
+// ErrNotFound indicates that the requested dead letter does not exist in the repository.
+var ErrNotFound = errors.New("dead letter not found")
+
+// Repository defines the contract the service layer relies on for dead letter storage.
+type Repository interface {
+	SaveDeadLetter(ctx context.Context, deadLetter domain.DeadLetter) (domain.DeadLetter, error)
+	GetDeadLetterByID(ctx context.Context, id int64) (domain.DeadLetter, error)
+	ListDeadLetters(ctx context.Context, afterID int64, limit int) ([]domain.DeadLetter, error)
+	RecordFailedAttempt(ctx context.Context, id int64, lastError string) error
+	DeleteDeadLetterByID(ctx context.Context, id int64) error
+	DeleteAllDeadLetters(ctx context.Context) (int64, error)
+}
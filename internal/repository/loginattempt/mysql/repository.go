@@ -0,0 +1,170 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ktsiligkos/xm_project/internal/domain"
+)
+
+// MySQLRepository persists failed login counters using a MySQL-compatible database
+type MySQLRepository struct {
+	db *sql.DB
+}
+
+// NewMySQL creates a repository backed by the supplied database handle
+func NewMySQL(db *sql.DB) *MySQLRepository {
+	return &MySQLRepository{db: db}
+}
+
+// GetLoginAttempts returns the counter of the key
+func (r *MySQLRepository) GetLoginAttempts(ctx context.Context, scope, key string) (domain.LoginAttempts, error) {
+	attempts := domain.LoginAttempts{Scope: scope, Key: key}
+	var blockedUntil sql.NullTime
+	err := r.db.QueryRowContext(ctx,
+		`SELECT failures, last_failure_at, blocked_until FROM login_attempts WHERE scope = ? AND attempt_key = ?`,
+		scope, key,
+	).Scan(&attempts.Failures, &attempts.LastFailureAt, &blockedUntil)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return attempts, nil
+		}
+		return domain.LoginAttempts{}, fmt.Errorf("get login attempts: %w", err)
+	}
+	if blockedUntil.Valid {
+		attempts.BlockedUntil = &blockedUntil.Time
+	}
+	return attempts, nil
+}
+
+// RecordLoginFailure increments the counter in one statement, so concurrent failures are all counted
+func (r *MySQLRepository) RecordLoginFailure(ctx context.Context, scope, key string, at, resetBefore time.Time) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin record login failure: %w", err)
+	}
+	defer tx.Rollback()
+
+	// failures is assigned before last_failure_at, so the IF sees the previous failure
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO login_attempts (scope, attempt_key, failures, last_failure_at) VALUES (?, ?, 1, ?)
+		 ON DUPLICATE KEY UPDATE failures = IF(last_failure_at < ?, 1, failures + 1), last_failure_at = VALUES(last_failure_at)`,
+		scope, key, at, resetBefore,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("record login failure: %w", err)
+	}
+
+	var failures int
+	if err := tx.QueryRowContext(ctx,
+		`SELECT failures FROM login_attempts WHERE scope = ? AND attempt_key = ?`, scope, key,
+	).Scan(&failures); err != nil {
+		return 0, fmt.Errorf("read login failures: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit record login failure: %w", err)
+	}
+	return failures, nil
+}
+
+// ReserveLoginAttempt locks the counter while it decides, creating it first so that
+// concurrent first attempts lock the same row
+func (r *MySQLRepository) ReserveLoginAttempt(ctx context.Context, scope, key string, at, resetBefore time.Time, blockFor func(failures int) time.Duration) (domain.LoginAttempts, bool, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return domain.LoginAttempts{}, false, fmt.Errorf("begin reserve login attempt: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO login_attempts (scope, attempt_key, failures, last_failure_at) VALUES (?, ?, 0, ?)
+		 ON DUPLICATE KEY UPDATE failures = failures`,
+		scope, key, at,
+	)
+	if err != nil {
+		return domain.LoginAttempts{}, false, fmt.Errorf("create login attempts: %w", err)
+	}
+
+	attempts := domain.LoginAttempts{Scope: scope, Key: key}
+	var blockedUntil sql.NullTime
+	if err := tx.QueryRowContext(ctx,
+		`SELECT failures, last_failure_at, blocked_until FROM login_attempts WHERE scope = ? AND attempt_key = ? FOR UPDATE`,
+		scope, key,
+	).Scan(&attempts.Failures, &attempts.LastFailureAt, &blockedUntil); err != nil {
+		return domain.LoginAttempts{}, false, fmt.Errorf("lock login attempts: %w", err)
+	}
+	if blockedUntil.Valid {
+		attempts.BlockedUntil = &blockedUntil.Time
+		if blockedUntil.Time.After(at) {
+			return attempts, false, nil
+		}
+	}
+
+	if attempts.LastFailureAt.Before(resetBefore) {
+		attempts.Failures = 0
+	}
+	attempts.Failures++
+	attempts.LastFailureAt = at
+	if block := blockFor(attempts.Failures); block > 0 {
+		until := at.Add(block)
+		attempts.BlockedUntil = &until
+	}
+
+	var until any
+	if attempts.BlockedUntil != nil {
+		until = *attempts.BlockedUntil
+	}
+	_, err = tx.ExecContext(ctx,
+		`UPDATE login_attempts SET failures = ?, last_failure_at = ?, blocked_until = ? WHERE scope = ? AND attempt_key = ?`,
+		attempts.Failures, at, until, scope, key,
+	)
+	if err != nil {
+		return domain.LoginAttempts{}, false, fmt.Errorf("reserve login attempt: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return domain.LoginAttempts{}, false, fmt.Errorf("commit reserve login attempt: %w", err)
+	}
+	return attempts, true, nil
+}
+
+// ReleaseLoginAttempt leaves a block set since the reservation in place
+func (r *MySQLRepository) ReleaseLoginAttempt(ctx context.Context, scope, key string, blockedUntil *time.Time) error {
+	var until any
+	if blockedUntil != nil {
+		until = *blockedUntil
+	}
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE login_attempts SET failures = GREATEST(failures - 1, 0), blocked_until = IF(blocked_until <=> ?, NULL, blocked_until)
+		 WHERE scope = ? AND attempt_key = ?`,
+		until, scope, key,
+	)
+	if err != nil {
+		return fmt.Errorf("release login attempt: %w", err)
+	}
+	return nil
+}
+
+// ResetLoginAttempts deletes the counter of the key
+func (r *MySQLRepository) ResetLoginAttempts(ctx context.Context, scope, key string) error {
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM login_attempts WHERE scope = ? AND attempt_key = ?`, scope, key); err != nil {
+		return fmt.Errorf("reset login attempts: %w", err)
+	}
+	return nil
+}
+
+// DeleteStaleLoginAttempts removes counters that no longer affect any login
+func (r *MySQLRepository) DeleteStaleLoginAttempts(ctx context.Context, before time.Time) error {
+	_, err := r.db.ExecContext(ctx,
+		`DELETE FROM login_attempts WHERE last_failure_at < ? AND (blocked_until IS NULL OR blocked_until < ?)`,
+		before, before,
+	)
+	if err != nil {
+		return fmt.Errorf("delete stale login attempts: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package loginattempt
+
+import (
+	"context"
+	"time"
+
+	"github.com/ktsiligkos/xm_project/internal/domain"
+)
+
+// Repository defines the contract the login protection relies on for persistence.
+type Repository interface {
+	// GetLoginAttempts returns the attempts of the key, a zero count when nothing is recorded
+	GetLoginAttempts(ctx context.Context, scope, key string) (domain.LoginAttempts, error)
+	// RecordLoginFailure counts a failure at the given time and returns the new count.
+	// A counter whose last failure is before resetBefore starts again at one.
+	RecordLoginFailure(ctx context.Context, scope, key string, at, resetBefore time.Time) (int, error)
+	// ReserveLoginAttempt counts a login as failed before its password is checked, unless the key
+	// is blocked at the given time, and blocks the key for blockFor(count) in the same transaction,
+	// so concurrent attempts each get their own count. It reports whether the attempt was counted,
+	// the returned BlockedUntil tells until when a refused one stays blocked.
+	// A counter whose last failure is before resetBefore starts again at one.
+	ReserveLoginAttempt(ctx context.Context, scope, key string, at, resetBefore time.Time, blockFor func(failures int) time.Duration) (domain.LoginAttempts, bool, error)
+	// ReleaseLoginAttempt takes back a reserved attempt that did not fail: the count drops by one
+	// and the block is lifted when it still ends at blockedUntil, the end the reservation set
+	ReleaseLoginAttempt(ctx context.Context, scope, key string, blockedUntil *time.Time) error
+	// ResetLoginAttempts forgets the failures of the key and lifts its block
+	ResetLoginAttempts(ctx context.Context, scope, key string) error
+	// DeleteStaleLoginAttempts drops counters whose last failure and block both ended before the given time
+	DeleteStaleLoginAttempts(ctx context.Context, before time.Time) error
+}
@@ -0,0 +1,310 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ktsiligkos/xm_project/internal/domain"
+	mfarepository "github.com/ktsiligkos/xm_project/internal/repository/mfa"
+)
+
+// MySQLRepository persists MFA enrollments, recovery codes and challenges using a MySQL-compatible database
+type MySQLRepository struct {
+	db *sql.DB
+}
+
+// NewMySQL creates a repository backed by the supplied database handle
+func NewMySQL(db *sql.DB) *MySQLRepository {
+	return &MySQLRepository{db: db}
+}
+
+// GetMFAEnrollment returns the enrollment of the user, pending or confirmed
+func (r *MySQLRepository) GetMFAEnrollment(ctx context.Context, userID string) (domain.MFAEnrollment, error) {
+	var (
+		enrollment  domain.MFAEnrollment
+		confirmedAt sql.NullTime
+	)
+	err := r.db.QueryRowContext(ctx,
+		`SELECT user_id, secret, confirmed_at, last_used_step, created_at FROM user_mfa WHERE user_id = ?`,
+		userID,
+	).Scan(&enrollment.UserID, &enrollment.Secret, &confirmedAt, &enrollment.LastUsedStep, &enrollment.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.MFAEnrollment{}, mfarepository.ErrNotFound
+		}
+		return domain.MFAEnrollment{}, fmt.Errorf("query mfa enrollment: %w", err)
+	}
+
+	if confirmedAt.Valid {
+		enrollment.ConfirmedAt = &confirmedAt.Time
+	}
+	return enrollment, nil
+}
+
+// SaveMFAEnrollment replaces the enrollment and the recovery codes of the user in one transaction
+func (r *MySQLRepository) SaveMFAEnrollment(ctx context.Context, enrollment domain.MFAEnrollment, codes []domain.MFARecoveryCode) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin save mfa enrollment: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO user_mfa (user_id, secret, confirmed_at, last_used_step, created_at) VALUES (?, ?, NULL, 0, ?)
+		 ON DUPLICATE KEY UPDATE secret = VALUES(secret), confirmed_at = NULL, last_used_step = 0, created_at = VALUES(created_at)`,
+		enrollment.UserID, enrollment.Secret, enrollment.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("save mfa enrollment: %w", err)
+	}
+	if err := replaceRecoveryCodes(ctx, tx, enrollment.UserID, codes); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit save mfa enrollment: %w", err)
+	}
+	return nil
+}
+
+// ConfirmMFAEnrollment sets confirmed_at of a pending enrollment
+func (r *MySQLRepository) ConfirmMFAEnrollment(ctx context.Context, userID string, at time.Time) error {
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE user_mfa SET confirmed_at = ? WHERE user_id = ? AND confirmed_at IS NULL`,
+		at, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("confirm mfa enrollment: %w", err)
+	}
+	return nil
+}
+
+// DeleteMFAEnrollment removes the enrollment together with the recovery codes
+func (r *MySQLRepository) DeleteMFAEnrollment(ctx context.Context, userID string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin delete mfa enrollment: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_mfa WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("delete mfa enrollment: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("delete mfa recovery codes: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit delete mfa enrollment: %w", err)
+	}
+	return nil
+}
+
+// UseTOTPStep moves last_used_step forward, the condition makes concurrent uses of a code fail but one
+func (r *MySQLRepository) UseTOTPStep(ctx context.Context, userID string, step int64) (bool, error) {
+	result, err := r.db.ExecContext(ctx,
+		`UPDATE user_mfa SET last_used_step = ? WHERE user_id = ? AND last_used_step < ?`,
+		step, userID, step,
+	)
+	if err != nil {
+		return false, fmt.Errorf("use totp step: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("use totp step, rows affected: %w", err)
+	}
+	return rows == 1, nil
+}
+
+// ReplaceRecoveryCodes swaps the recovery codes of the user in one transaction
+func (r *MySQLRepository) ReplaceRecoveryCodes(ctx context.Context, userID string, codes []domain.MFARecoveryCode) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin replace recovery codes: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, codes); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit replace recovery codes: %w", err)
+	}
+	return nil
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID string, codes []domain.MFARecoveryCode) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("delete recovery codes: %w", err)
+	}
+	for _, code := range codes {
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO mfa_recovery_codes (id, user_id, code_hash, created_at) VALUES (?, ?, ?, ?)`,
+			code.ID, userID, code.CodeHash, code.CreatedAt,
+		)
+		if err != nil {
+			return fmt.Errorf("insert recovery code: %w", err)
+		}
+	}
+	return nil
+}
+
+// UseRecoveryCode sets used_at unless the code was already used
+func (r *MySQLRepository) UseRecoveryCode(ctx context.Context, userID, codeHash string, at time.Time) (bool, error) {
+	result, err := r.db.ExecContext(ctx,
+		`UPDATE mfa_recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL`,
+		at, userID, codeHash,
+	)
+	if err != nil {
+		return false, fmt.Errorf("use recovery code: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("use recovery code, rows affected: %w", err)
+	}
+	return rows == 1, nil
+}
+
+// CountRecoveryCodes counts the unused recovery codes of the user
+func (r *MySQLRepository) CountRecoveryCodes(ctx context.Context, userID string) (int, error) {
+	var count int
+	if err := r.db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM mfa_recovery_codes WHERE user_id = ? AND used_at IS NULL`, userID,
+	).Scan(&count); err != nil {
+		return 0, fmt.Errorf("count recovery codes: %w", err)
+	}
+	return count, nil
+}
+
+// SaveMFAChallenge stores a pending login
+func (r *MySQLRepository) SaveMFAChallenge(ctx context.Context, challenge domain.MFAChallenge) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO mfa_challenges (id, user_id, token_hash, expires_at, created_at) VALUES (?, ?, ?, ?, ?)`,
+		challenge.ID, challenge.UserID, challenge.TokenHash, challenge.ExpiresAt, challenge.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("insert mfa challenge: %w", err)
+	}
+	return nil
+}
+
+// GetMFAChallengeByHash returns the challenge with the given token hash
+func (r *MySQLRepository) GetMFAChallengeByHash(ctx context.Context, tokenHash string) (domain.MFAChallenge, error) {
+	var (
+		challenge domain.MFAChallenge
+		usedAt    sql.NullTime
+	)
+	err := r.db.QueryRowContext(ctx,
+		`SELECT id, user_id, token_hash, expires_at, created_at, attempts, used_at FROM mfa_challenges WHERE token_hash = ?`,
+		tokenHash,
+	).Scan(&challenge.ID, &challenge.UserID, &challenge.TokenHash, &challenge.ExpiresAt, &challenge.CreatedAt, &challenge.Attempts, &usedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.MFAChallenge{}, mfarepository.ErrNotFound
+		}
+		return domain.MFAChallenge{}, fmt.Errorf("query mfa challenge: %w", err)
+	}
+
+	if usedAt.Valid {
+		challenge.UsedAt = &usedAt.Time
+	}
+	return challenge, nil
+}
+
+// RecordMFAChallengeAttempt increments the attempts in one statement, so concurrent attempts are all counted
+func (r *MySQLRepository) RecordMFAChallengeAttempt(ctx context.Context, id string) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin record mfa attempt: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `UPDATE mfa_challenges SET attempts = attempts + 1 WHERE id = ?`, id); err != nil {
+		return 0, fmt.Errorf("record mfa attempt: %w", err)
+	}
+	var attempts int
+	if err := tx.QueryRowContext(ctx, `SELECT attempts FROM mfa_challenges WHERE id = ?`, id).Scan(&attempts); err != nil {
+		return 0, fmt.Errorf("read mfa attempts: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit record mfa attempt: %w", err)
+	}
+	return attempts, nil
+}
+
+// MarkMFAChallengeUsed sets used_at unless the challenge was already used
+func (r *MySQLRepository) MarkMFAChallengeUsed(ctx context.Context, id string, at time.Time) (bool, error) {
+	result, err := r.db.ExecContext(ctx,
+		`UPDATE mfa_challenges SET used_at = ? WHERE id = ? AND used_at IS NULL`,
+		at, id,
+	)
+	if err != nil {
+		return false, fmt.Errorf("mark mfa challenge used: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("mark mfa challenge used, rows affected: %w", err)
+	}
+	return rows == 1, nil
+}
+
+// DeleteExpiredMFAChallenges removes challenges that can no longer be completed
+func (r *MySQLRepository) DeleteExpiredMFAChallenges(ctx context.Context, before time.Time) error {
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM mfa_challenges WHERE expires_at < ?`, before); err != nil {
+		return fmt.Errorf("delete expired mfa challenges: %w", err)
+	}
+	return nil
+}
+
+// GetMFARequiredRoles lists the roles whose users must use MFA
+func (r *MySQLRepository) GetMFARequiredRoles(ctx context.Context) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT role FROM mfa_required_roles ORDER BY role`)
+	if err != nil {
+		return nil, fmt.Errorf("query mfa required roles: %w", err)
+	}
+	defer rows.Close()
+
+	roles := []string{}
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, fmt.Errorf("scan mfa required role: %w", err)
+		}
+		roles = append(roles, role)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate mfa required roles: %w", err)
+	}
+	return roles, nil
+}
+
+// SetMFARequiredRoles replaces the roles in one transaction
+func (r *MySQLRepository) SetMFARequiredRoles(ctx context.Context, roles []string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin set mfa required roles: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM mfa_required_roles`); err != nil {
+		return fmt.Errorf("delete mfa required roles: %w", err)
+	}
+	for _, role := range roles {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO mfa_required_roles (role) VALUES (?)`, role); err != nil {
+			return fmt.Errorf("insert mfa required role: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit set mfa required roles: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package mfa
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ktsiligkos/xm_project/internal/domain"
+)
+
+// ErrNotFound indicates that the user has no enrollment or that no challenge has the given hash.
+var ErrNotFound = errors.New("mfa record not found")
+
+// Repository defines the contract the service layer relies on for MFA enrollments, recovery
+// codes, pending logins and the roles that require MFA.
+type Repository interface {
+	GetMFAEnrollment(ctx context.Context, userID string) (domain.MFAEnrollment, error)
+	// SaveMFAEnrollment stores a pending enrollment with its recovery codes, replacing a
+	// previous enrollment of the user and all of their recovery codes
+	SaveMFAEnrollment(ctx context.Context, enrollment domain.MFAEnrollment, codes []domain.MFARecoveryCode) error
+	// ConfirmMFAEnrollment activates a pending enrollment
+	ConfirmMFAEnrollment(ctx context.Context, userID string, at time.Time) error
+	// DeleteMFAEnrollment removes the enrollment and the recovery codes of the user
+	DeleteMFAEnrollment(ctx context.Context, userID string) error
+	// UseTOTPStep records the step of an accepted code. It reports false when the step is not
+	// later than the last one used, so a code cannot be replayed, not even concurrently.
+	UseTOTPStep(ctx context.Context, userID string, step int64) (bool, error)
+
+	// ReplaceRecoveryCodes drops every recovery code of the user and stores the given ones
+	ReplaceRecoveryCodes(ctx context.Context, userID string, codes []domain.MFARecoveryCode) error
+	// UseRecoveryCode marks the code used, it reports false for unknown or used codes
+	UseRecoveryCode(ctx context.Context, userID, codeHash string, at time.Time) (bool, error)
+	// CountRecoveryCodes returns how many unused recovery codes the user has
+	CountRecoveryCodes(ctx context.Context, userID string) (int, error)
+
+	SaveMFAChallenge(ctx context.Context, challenge domain.MFAChallenge) error
+	GetMFAChallengeByHash(ctx context.Context, tokenHash string) (domain.MFAChallenge, error)
+	// RecordMFAChallengeAttempt counts a wrong code and returns the new count
+	RecordMFAChallengeAttempt(ctx context.Context, id string) (int, error)
+	// MarkMFAChallengeUsed reports false when the challenge was already used
+	MarkMFAChallengeUsed(ctx context.Context, id string, at time.Time) (bool, error)
+	// DeleteExpiredMFAChallenges drops challenges that expired before the given time
+	DeleteExpiredMFAChallenges(ctx context.Context, before time.Time) error
+
+	GetMFARequiredRoles(ctx context.Context) ([]string, error)
+	SetMFARequiredRoles(ctx context.Context, roles []string) error
+}
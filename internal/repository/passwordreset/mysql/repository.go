@@ -0,0 +1,86 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ktsiligkos/xm_project/internal/domain"
+	passwordresetrepository "github.com/ktsiligkos/xm_project/internal/repository/passwordreset"
+)
+
+// MySQLRepository persists password reset tokens using a MySQL-compatible database
+type MySQLRepository struct {
+	db *sql.DB
+}
+
+// NewMySQL creates a repository backed by the supplied database handle
+func NewMySQL(db *sql.DB) *MySQLRepository {
+	return &MySQLRepository{db: db}
+}
+
+// SavePasswordResetToken stores a newly issued token
+func (r *MySQLRepository) SavePasswordResetToken(ctx context.Context, token domain.PasswordResetToken) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO password_reset_tokens (id, user_id, token_hash, expires_at, created_at) VALUES (?, ?, ?, ?, ?)`,
+		token.ID, token.UserID, token.TokenHash, token.ExpiresAt, token.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("insert password reset token: %w", err)
+	}
+	return nil
+}
+
+// GetPasswordResetTokenByHash returns the token with the given hash
+func (r *MySQLRepository) GetPasswordResetTokenByHash(ctx context.Context, tokenHash string) (domain.PasswordResetToken, error) {
+	var (
+		token  domain.PasswordResetToken
+		usedAt sql.NullTime
+	)
+	err := r.db.QueryRowContext(ctx,
+		`SELECT id, user_id, token_hash, expires_at, created_at, used_at FROM password_reset_tokens WHERE token_hash = ?`,
+		tokenHash,
+	).Scan(&token.ID, &token.UserID, &token.TokenHash, &token.ExpiresAt, &token.CreatedAt, &usedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.PasswordResetToken{}, passwordresetrepository.ErrNotFound
+		}
+		return domain.PasswordResetToken{}, fmt.Errorf("query password reset token: %w", err)
+	}
+
+	if usedAt.Valid {
+		token.UsedAt = &usedAt.Time
+	}
+	return token, nil
+}
+
+// MarkPasswordResetTokenUsed sets used_at unless the token was already used
+func (r *MySQLRepository) MarkPasswordResetTokenUsed(ctx context.Context, id string, at time.Time) (bool, error) {
+	result, err := r.db.ExecContext(ctx,
+		`UPDATE password_reset_tokens SET used_at = ? WHERE id = ? AND used_at IS NULL`,
+		at, id,
+	)
+	if err != nil {
+		return false, fmt.Errorf("mark password reset token used: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("mark password reset token used, rows affected: %w", err)
+	}
+	return rows == 1, nil
+}
+
+// InvalidateUserPasswordResetTokens retires every outstanding token of the user
+func (r *MySQLRepository) InvalidateUserPasswordResetTokens(ctx context.Context, userID string, at time.Time) error {
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE password_reset_tokens SET used_at = ? WHERE user_id = ? AND used_at IS NULL`,
+		at, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("invalidate password reset tokens: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package passwordreset
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ktsiligkos/xm_project/internal/domain"
+)
+
+// ErrNotFound indicates that no reset token has the given hash.
+var ErrNotFound = errors.New("password reset token not found")
+
+// Repository defines the contract the service layer relies on for password reset token storage.
+type Repository interface {
+	SavePasswordResetToken(ctx context.Context, token domain.PasswordResetToken) error
+	GetPasswordResetTokenByHash(ctx context.Context, tokenHash string) (domain.PasswordResetToken, error)
+	// MarkPasswordResetTokenUsed reports false when the token was already used,
+	// so of two concurrent resets with the same token only one succeeds
+	MarkPasswordResetTokenUsed(ctx context.Context, id string, at time.Time) (bool, error)
+	// InvalidateUserPasswordResetTokens marks every unused token of the user as used
+	InvalidateUserPasswordResetTokens(ctx context.Context, userID string, at time.Time) error
+}
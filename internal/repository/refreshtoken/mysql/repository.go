@@ -0,0 +1,100 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ktsiligkos/xm_project/internal/domain"
+	refreshtokenrepository "github.com/ktsiligkos/xm_project/internal/repository/refreshtoken"
+)
+
+// MySQLRepository persists refresh tokens using a MySQL-compatible database
+type MySQLRepository struct {
+	db *sql.DB
+}
+
+// NewMySQL creates a repository backed by the supplied database handle
+func NewMySQL(db *sql.DB) *MySQLRepository {
+	return &MySQLRepository{db: db}
+}
+
+// SaveRefreshToken stores a newly issued token
+func (r *MySQLRepository) SaveRefreshToken(ctx context.Context, token domain.RefreshToken) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO refresh_tokens (id, family_id, user_id, token_hash, expires_at, created_at, mfa_verified) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		token.ID, token.FamilyID, token.UserID, token.TokenHash, token.ExpiresAt, token.CreatedAt, token.MFAVerified,
+	)
+	if err != nil {
+		return fmt.Errorf("insert refresh token: %w", err)
+	}
+	return nil
+}
+
+// GetRefreshTokenByHash returns the token with the given hash
+func (r *MySQLRepository) GetRefreshTokenByHash(ctx context.Context, tokenHash string) (domain.RefreshToken, error) {
+	var (
+		token     domain.RefreshToken
+		usedAt    sql.NullTime
+		revokedAt sql.NullTime
+	)
+	err := r.db.QueryRowContext(ctx,
+		`SELECT id, family_id, user_id, token_hash, expires_at, created_at, used_at, revoked_at, mfa_verified FROM refresh_tokens WHERE token_hash = ?`,
+		tokenHash,
+	).Scan(&token.ID, &token.FamilyID, &token.UserID, &token.TokenHash, &token.ExpiresAt, &token.CreatedAt, &usedAt, &revokedAt, &token.MFAVerified)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.RefreshToken{}, refreshtokenrepository.ErrNotFound
+		}
+		return domain.RefreshToken{}, fmt.Errorf("query refresh token: %w", err)
+	}
+
+	if usedAt.Valid {
+		token.UsedAt = &usedAt.Time
+	}
+	if revokedAt.Valid {
+		token.RevokedAt = &revokedAt.Time
+	}
+	return token, nil
+}
+
+// MarkRefreshTokenUsed sets used_at unless the token was already used or revoked
+func (r *MySQLRepository) MarkRefreshTokenUsed(ctx context.Context, id string, at time.Time) (bool, error) {
+	result, err := r.db.ExecContext(ctx,
+		`UPDATE refresh_tokens SET used_at = ? WHERE id = ? AND used_at IS NULL AND revoked_at IS NULL`,
+		at, id,
+	)
+	if err != nil {
+		return false, fmt.Errorf("mark refresh token used: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("mark refresh token used, rows affected: %w", err)
+	}
+	return rows == 1, nil
+}
+
+// RevokeRefreshTokenFamily revokes every token issued from the same login
+func (r *MySQLRepository) RevokeRefreshTokenFamily(ctx context.Context, familyID string, at time.Time) error {
+	if _, err := r.db.ExecContext(ctx,
+		`UPDATE refresh_tokens SET revoked_at = ? WHERE family_id = ? AND revoked_at IS NULL`,
+		at, familyID,
+	); err != nil {
+		return fmt.Errorf("revoke refresh token family: %w", err)
+	}
+	return nil
+}
+
+// RevokeUserRefreshTokens revokes every token of the user, logging them out everywhere
+func (r *MySQLRepository) RevokeUserRefreshTokens(ctx context.Context, userID string, at time.Time) error {
+	if _, err := r.db.ExecContext(ctx,
+		`UPDATE refresh_tokens SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL`,
+		at, userID,
+	); err != nil {
+		return fmt.Errorf("revoke refresh tokens of user: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package refreshtoken
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ktsiligkos/xm_project/internal/domain"
+)
+
+// ErrNotFound indicates that no refresh token has the given hash.
+var ErrNotFound = errors.New("refresh token not found")
+
+// Repository defines the contract the service layer relies on for refresh token storage.
+type Repository interface {
+	SaveRefreshToken(ctx context.Context, token domain.RefreshToken) error
+	GetRefreshTokenByHash(ctx context.Context, tokenHash string) (domain.RefreshToken, error)
+	// MarkRefreshTokenUsed reports false when the token was already used or revoked,
+	// so of two concurrent refreshes with the same token only one succeeds
+	MarkRefreshTokenUsed(ctx context.Context, id string, at time.Time) (bool, error)
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string, at time.Time) error
+	RevokeUserRefreshTokens(ctx context.Context, userID string, at time.Time) error
+}
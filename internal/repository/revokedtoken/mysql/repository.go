@@ -0,0 +1,112 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/ktsiligkos/xm_project/internal/domain"
+)
+
+// MySQLRepository persists access token revocations using a MySQL-compatible database
+type MySQLRepository struct {
+	db *sql.DB
+}
+
+// NewMySQL creates a repository backed by the supplied database handle
+func NewMySQL(db *sql.DB) *MySQLRepository {
+	return &MySQLRepository{db: db}
+}
+
+// RevokeToken stores the revocation of a single token
+func (r *MySQLRepository) RevokeToken(ctx context.Context, token domain.RevokedToken) error {
+	var userID sql.NullString
+	if token.UserID != "" {
+		userID = sql.NullString{String: token.UserID, Valid: true}
+	}
+
+	_, err := r.db.ExecContext(ctx,
+		`INSERT IGNORE INTO revoked_tokens (jti, user_id, expires_at, revoked_at) VALUES (?, ?, ?, ?)`,
+		token.JTI, userID, token.ExpiresAt, token.RevokedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("insert revoked token: %w", err)
+	}
+	return nil
+}
+
+// RevokeUserTokens stores the cutoff of the user, keeping the later one on conflict
+func (r *MySQLRepository) RevokeUserTokens(ctx context.Context, userID string, before time.Time) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO user_token_revocations (user_id, revoked_before) VALUES (?, ?)
+		 ON DUPLICATE KEY UPDATE revoked_before = GREATEST(revoked_before, VALUES(revoked_before))`,
+		userID, before,
+	)
+	if err != nil {
+		return fmt.Errorf("upsert user token revocation: %w", err)
+	}
+	return nil
+}
+
+// ListRevokedTokens returns the revocations that still matter
+func (r *MySQLRepository) ListRevokedTokens(ctx context.Context, now time.Time) ([]domain.RevokedToken, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT jti, user_id, expires_at, revoked_at FROM revoked_tokens WHERE expires_at > ?`, now,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list revoked tokens: %w", err)
+	}
+	defer rows.Close()
+
+	var tokens []domain.RevokedToken
+	for rows.Next() {
+		var (
+			token  domain.RevokedToken
+			userID sql.NullString
+		)
+		if err := rows.Scan(&token.JTI, &userID, &token.ExpiresAt, &token.RevokedAt); err != nil {
+			return nil, fmt.Errorf("scan revoked token: %w", err)
+		}
+		token.UserID = userID.String
+		tokens = append(tokens, token)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list revoked tokens: %w", err)
+	}
+
+	return tokens, nil
+}
+
+// ListUserTokenRevocations returns the cutoffs later than since
+func (r *MySQLRepository) ListUserTokenRevocations(ctx context.Context, since time.Time) ([]domain.UserTokenRevocation, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT user_id, revoked_before FROM user_token_revocations WHERE revoked_before > ?`, since,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list user token revocations: %w", err)
+	}
+	defer rows.Close()
+
+	var revocations []domain.UserTokenRevocation
+	for rows.Next() {
+		var revocation domain.UserTokenRevocation
+		if err := rows.Scan(&revocation.UserID, &revocation.RevokedBefore); err != nil {
+			return nil, fmt.Errorf("scan user token revocation: %w", err)
+		}
+		revocations = append(revocations, revocation)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list user token revocations: %w", err)
+	}
+
+	return revocations, nil
+}
+
+// DeleteExpiredRevokedTokens removes revocations of tokens that are no longer accepted anyway
+func (r *MySQLRepository) DeleteExpiredRevokedTokens(ctx context.Context, now time.Time) error {
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM revoked_tokens WHERE expires_at <= ?`, now); err != nil {
+		return fmt.Errorf("delete expired revoked tokens: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package revokedtoken
+
+import (
+	"context"
+	"time"
+
+	"github.com/ktsiligkos/xm_project/internal/domain"
+)
+
+// Repository defines the contract the revocation store relies on for persistence.
+type Repository interface {
+	// RevokeToken stores the revocation, revoking a token twice keeps the first entry
+	RevokeToken(ctx context.Context, token domain.RevokedToken) error
+	// RevokeUserTokens moves the cutoff of the user forward, it never moves back
+	RevokeUserTokens(ctx context.Context, userID string, before time.Time) error
+	// ListRevokedTokens returns the revocations of tokens that have not expired at now
+	ListRevokedTokens(ctx context.Context, now time.Time) ([]domain.RevokedToken, error)
+	// ListUserTokenRevocations returns the cutoffs later than since
+	ListUserTokenRevocations(ctx context.Context, since time.Time) ([]domain.UserTokenRevocation, error)
+	// DeleteExpiredRevokedTokens drops revocations of tokens that expired before now
+	DeleteExpiredRevokedTokens(ctx context.Context, now time.Time) error
+}
@@ -0,0 +1,133 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	driver "github.com/go-sql-driver/mysql"
+
+	"github.com/ktsiligkos/xm_project/internal/domain"
+	tenantrepository "github.com/ktsiligkos/xm_project/internal/repository/tenant"
+)
+
+// MySQL error numbers of the unique and foreign key constraints on tenants
+const (
+	errDuplicateEntry  = 1062
+	errRowIsReferenced = 1451
+)
+
+const selectTenant = `SELECT id, name, created_at, updated_at FROM tenants`
+
+// MySQLRepository persists tenants using a MySQL-compatible database
+type MySQLRepository struct {
+	db *sql.DB
+}
+
+// NewMySQL creates a repository backed by the supplied database handle
+func NewMySQL(db *sql.DB) *MySQLRepository {
+	return &MySQLRepository{db: db}
+}
+
+// CreateTenant stores a new tenant, the caller generates the ID
+func (r *MySQLRepository) CreateTenant(ctx context.Context, tenant domain.Tenant) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO tenants (id, name, created_at, updated_at) VALUES (?, ?, ?, ?)`,
+		tenant.ID, tenant.Name, tenant.CreatedAt, tenant.UpdatedAt,
+	)
+	if err != nil {
+		if mysqlError(err, errDuplicateEntry) {
+			return tenantrepository.ErrDuplicateName
+		}
+		return fmt.Errorf("insert tenant: %w", err)
+	}
+	return nil
+}
+
+// GetTenantByID returns a single tenant
+func (r *MySQLRepository) GetTenantByID(ctx context.Context, id string) (domain.Tenant, error) {
+	var tenant domain.Tenant
+	err := r.db.QueryRowContext(ctx, selectTenant+` WHERE id = ?`, id).
+		Scan(&tenant.ID, &tenant.Name, &tenant.CreatedAt, &tenant.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.Tenant{}, tenantrepository.ErrNotFound
+		}
+		return domain.Tenant{}, fmt.Errorf("query tenant: %w", err)
+	}
+	return tenant, nil
+}
+
+// ListTenants returns every tenant ordered by name
+func (r *MySQLRepository) ListTenants(ctx context.Context) ([]domain.Tenant, error) {
+	rows, err := r.db.QueryContext(ctx, selectTenant+` ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("list tenants: %w", err)
+	}
+	defer rows.Close()
+
+	tenants := []domain.Tenant{}
+	for rows.Next() {
+		var tenant domain.Tenant
+		if err := rows.Scan(&tenant.ID, &tenant.Name, &tenant.CreatedAt, &tenant.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("scan tenant: %w", err)
+		}
+		tenants = append(tenants, tenant)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list tenants: %w", err)
+	}
+
+	return tenants, nil
+}
+
+// RenameTenant changes the name of the tenant
+func (r *MySQLRepository) RenameTenant(ctx context.Context, id, name string) error {
+	result, err := r.db.ExecContext(ctx,
+		`UPDATE tenants SET name = ?, updated_at = ? WHERE id = ?`,
+		name, time.Now().UTC(), id,
+	)
+	if err != nil {
+		if mysqlError(err, errDuplicateEntry) {
+			return tenantrepository.ErrDuplicateName
+		}
+		return fmt.Errorf("rename tenant: %w", err)
+	}
+
+	return expectOneRow(result, "rename tenant")
+}
+
+// DeleteTenant removes the tenant, the foreign keys of users and companies keep it while they reference it
+func (r *MySQLRepository) DeleteTenant(ctx context.Context, id string) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM tenants WHERE id = ?`, id)
+	if err != nil {
+		if mysqlError(err, errRowIsReferenced) {
+			return tenantrepository.ErrInUse
+		}
+		return fmt.Errorf("delete tenant: %w", err)
+	}
+
+	return expectOneRow(result, "delete tenant")
+}
+
+// expectOneRow turns a statement that matched nothing into ErrNotFound
+func expectOneRow(result sql.Result, operation string) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s, rows affected: %w", operation, err)
+	}
+	if affected == 0 {
+		return tenantrepository.ErrNotFound
+	}
+	return nil
+}
+
+func mysqlError(err error, number uint16) bool {
+	var mysqlErr *driver.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == number
+	}
+	return false
+}
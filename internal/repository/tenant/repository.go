@@ -0,0 +1,29 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ktsiligkos/xm_project/internal/domain"
+)
+
+// ErrNotFound indicates that no tenant has the given ID.
+var ErrNotFound = errors.New("tenant not found")
+
+// ErrDuplicateName indicates that another tenant already has the name
+var ErrDuplicateName = errors.New("tenant name already in use")
+
+// ErrInUse indicates that users or companies still belong to the tenant
+var ErrInUse = errors.New("tenant still has users or companies")
+
+// Repository defines the contract the service layer relies on for tenant storage.
+// Tenants span the deployment, so unlike companies and users they are never scoped.
+type Repository interface {
+	CreateTenant(ctx context.Context, tenant domain.Tenant) error
+	GetTenantByID(ctx context.Context, id string) (domain.Tenant, error)
+	// ListTenants returns every tenant ordered by name
+	ListTenants(ctx context.Context) ([]domain.Tenant, error)
+	RenameTenant(ctx context.Context, id, name string) error
+	// DeleteTenant refuses with ErrInUse while users or companies belong to the tenant
+	DeleteTenant(ctx context.Context, id string) error
+}
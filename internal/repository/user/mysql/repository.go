@@ -5,12 +5,27 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
+	driver "github.com/go-sql-driver/mysql"
+
+	"github.com/ktsiligkos/xm_project/internal/correlation"
 	"github.com/ktsiligkos/xm_project/internal/domain"
 	userrepository "github.com/ktsiligkos/xm_project/internal/repository/user"
 )
 
-// MySQLRepository persists companies using a MySQL-compatible database
+// selectUser reads the roles of a user as a comma separated list,
+// queries add their WHERE clause followed by groupBy
+const (
+	selectUser = `SELECT u.id, u.tenant_id, u.name, u.email, u.password_hash, COALESCE(GROUP_CONCAT(r.role ORDER BY r.role), ''), u.disabled_at, u.created_at, u.updated_at
+		FROM users u LEFT JOIN user_roles r ON r.user_id = u.id`
+	groupBy = ` GROUP BY u.id`
+)
+
+// MySQLRepository persists users using a MySQL-compatible database.
+// Every query is scoped to the tenant found in the context, see correlation.TenantScope;
+// logins and token refreshes open their context to all tenants to find the user.
 type MySQLRepository struct {
 	db *sql.DB
 }
@@ -22,20 +37,243 @@ func NewMySQL(db *sql.DB) *MySQLRepository {
 
 // Get returns a single company by ID
 func (r *MySQLRepository) GetUserByEmail(ctx context.Context, email string) (domain.User, error) {
+	where, args, err := tenantScope(ctx, `u.email = ?`, email)
+	if err != nil {
+		return domain.User{}, err
+	}
+	user, err := scanUser(r.db.QueryRowContext(ctx, selectUser+` WHERE `+where+groupBy, args...))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.User{}, userrepository.ErrNotFound
+		}
 
-	query := "SELECT id, name, email, password_hash FROM users WHERE email = ?"
+		return domain.User{}, fmt.Errorf("query user: %w", err)
+	}
 
-	var (
-		user domain.User
-	)
+	return user, nil
+}
 
-	if err := r.db.QueryRowContext(ctx, query, email).Scan(&user.ID, &user.Name, &user.Email, &user.Password); err != nil {
+// GetUserByID returns a single user by ID
+func (r *MySQLRepository) GetUserByID(ctx context.Context, id string) (domain.User, error) {
+	where, args, err := tenantScope(ctx, `u.id = ?`, id)
+	if err != nil {
+		return domain.User{}, err
+	}
+	user, err := scanUser(r.db.QueryRowContext(ctx, selectUser+` WHERE `+where+groupBy, args...))
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return domain.User{}, userrepository.ErrNotFound
 		}
 
-		return domain.User{}, fmt.Errorf("query company: %w", err)
+		return domain.User{}, fmt.Errorf("query user: %w", err)
+	}
+
+	return user, nil
+}
+
+// CreateUser stores a new user and their roles in the tenant of ctx, the caller generates the ID
+func (r *MySQLRepository) CreateUser(ctx context.Context, user domain.User) (domain.User, error) {
+	tenant, err := correlation.TenantScope(ctx)
+	if err != nil {
+		return domain.User{}, err
+	}
+	if tenant != "" {
+		user.TenantID = tenant
+	}
+	if user.TenantID == "" {
+		return domain.User{}, fmt.Errorf("%w: the user names no tenant", correlation.ErrNoTenant)
+	}
+	now := time.Now().UTC()
+	user.CreatedAt = now
+	user.UpdatedAt = now
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return domain.User{}, fmt.Errorf("begin create user transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO users (id, tenant_id, name, email, password_hash, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		user.ID, user.TenantID, user.Name, user.Email, user.Password, user.CreatedAt, user.UpdatedAt,
+	)
+	if err != nil {
+		if duplicateKey(err) {
+			return domain.User{}, userrepository.ErrDuplicateEmail
+		}
+		return domain.User{}, fmt.Errorf("insert user: %w", err)
+	}
+	if err := replaceRoles(ctx, tx, user.ID, user.Roles); err != nil {
+		return domain.User{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return domain.User{}, fmt.Errorf("commit user: %w", err)
+	}
+	return user, nil
+}
+
+// ListUsers returns up to limit users ordered by ID, starting after afterID
+func (r *MySQLRepository) ListUsers(ctx context.Context, afterID string, limit int) ([]domain.User, error) {
+	where, args, err := tenantScope(ctx, `u.id > ?`, afterID)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.db.QueryContext(ctx, selectUser+` WHERE `+where+groupBy+` ORDER BY u.id LIMIT ?`, append(args, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("list users: %w", err)
+	}
+	defer rows.Close()
+
+	users := make([]domain.User, 0, limit)
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan user: %w", err)
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list users: %w", err)
+	}
+
+	return users, nil
+}
+
+// UpdateUser stores the name, email, password hash and roles of the user
+func (r *MySQLRepository) UpdateUser(ctx context.Context, user domain.User) error {
+	where, args, err := tenantScope(ctx, `id = ?`, user.ID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin update user transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx,
+		`UPDATE users u SET name = ?, email = ?, password_hash = ?, updated_at = ? WHERE `+where,
+		append([]any{user.Name, user.Email, user.Password, time.Now().UTC()}, args...)...,
+	)
+	if err != nil {
+		if duplicateKey(err) {
+			return userrepository.ErrDuplicateEmail
+		}
+		return fmt.Errorf("update user: %w", err)
+	}
+	if err := expectOneRow(result, "update user"); err != nil {
+		return err
+	}
+	if err := replaceRoles(ctx, tx, user.ID, user.Roles); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit user: %w", err)
+	}
+	return nil
+}
+
+// DisableUser marks the user as disabled, disabling twice keeps the first time
+func (r *MySQLRepository) DisableUser(ctx context.Context, id string, at time.Time) error {
+	where, args, err := tenantScope(ctx, `id = ?`, id)
+	if err != nil {
+		return err
 	}
+	result, err := r.db.ExecContext(ctx,
+		`UPDATE users u SET disabled_at = COALESCE(disabled_at, ?), updated_at = ? WHERE `+where,
+		append([]any{at, at}, args...)...,
+	)
+	if err != nil {
+		return fmt.Errorf("disable user: %w", err)
+	}
+
+	return expectOneRow(result, "disable user")
+}
+
+// UpdatePasswordHash replaces only the password hash, leaving concurrent changes to other fields intact
+func (r *MySQLRepository) UpdatePasswordHash(ctx context.Context, id string, hash string) error {
+	where, args, err := tenantScope(ctx, `id = ?`, id)
+	if err != nil {
+		return err
+	}
+	result, err := r.db.ExecContext(ctx,
+		`UPDATE users u SET password_hash = ?, updated_at = ? WHERE `+where,
+		append([]any{hash, time.Now().UTC()}, args...)...,
+	)
+	if err != nil {
+		return fmt.Errorf("update password hash: %w", err)
+	}
+
+	return expectOneRow(result, "update password hash")
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
 
+func scanUser(row rowScanner) (domain.User, error) {
+	var (
+		user       domain.User
+		roles      string
+		disabledAt sql.NullTime
+	)
+	if err := row.Scan(&user.ID, &user.TenantID, &user.Name, &user.Email, &user.Password, &roles, &disabledAt, &user.CreatedAt, &user.UpdatedAt); err != nil {
+		return domain.User{}, err
+	}
+	user.Roles = []string{}
+	if roles != "" {
+		user.Roles = strings.Split(roles, ",")
+	}
+	if disabledAt.Valid {
+		user.DisabledAt = &disabledAt.Time
+	}
 	return user, nil
 }
+
+// tenantScope adds the tenant of ctx to the condition of a query on the users aliased u
+func tenantScope(ctx context.Context, condition string, args ...any) (string, []any, error) {
+	tenant, err := correlation.TenantScope(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	if tenant == "" {
+		return condition, args, nil
+	}
+	return condition + ` AND u.tenant_id = ?`, append(args, tenant), nil
+}
+
+// replaceRoles makes roles the complete set of roles of the user
+func replaceRoles(ctx context.Context, tx *sql.Tx, userID string, roles []string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_roles WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("delete user roles: %w", err)
+	}
+	for _, role := range roles {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO user_roles (user_id, role) VALUES (?, ?)`, userID, role); err != nil {
+			return fmt.Errorf("insert user role: %w", err)
+		}
+	}
+	return nil
+}
+
+// expectOneRow turns an update that matched nothing into ErrNotFound
+func expectOneRow(result sql.Result, operation string) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s, rows affected: %w", operation, err)
+	}
+	if affected == 0 {
+		return userrepository.ErrNotFound
+	}
+	return nil
+}
+
+func duplicateKey(err error) bool {
+	var mysqlErr *driver.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == 1062
+	}
+	return false
+}
@@ -3,6 +3,7 @@ package album
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/ktsiligkos/xm_project/internal/domain"
 )
@@ -10,6 +11,18 @@ import (
 // ErrNotFound indicates that the requested company does not exist in the repository.
 var ErrNotFound = errors.New("user not found")
 
+// ErrDuplicateEmail indicates that another user already has the email
+var ErrDuplicateEmail = errors.New("email already in use")
+
 type Repository interface {
 	GetUserByEmail(tx context.Context, email string) (user domain.User, err error)
+	GetUserByID(ctx context.Context, id string) (domain.User, error)
+	CreateUser(ctx context.Context, user domain.User) (domain.User, error)
+	// ListUsers returns up to limit users ordered by ID, starting after afterID
+	ListUsers(ctx context.Context, afterID string, limit int) ([]domain.User, error)
+	// UpdateUser stores the name, email, password hash and roles of the user
+	UpdateUser(ctx context.Context, user domain.User) error
+	DisableUser(ctx context.Context, id string, at time.Time) error
+	// UpdatePasswordHash replaces only the password hash, e.g. after a rehash on login
+	UpdatePasswordHash(ctx context.Context, id string, hash string) error
 }
@@ -0,0 +1,203 @@
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ktsiligkos/xm_project/internal/auth"
+	"github.com/ktsiligkos/xm_project/internal/correlation"
+	"github.com/ktsiligkos/xm_project/internal/domain"
+	apikeyrepository "github.com/ktsiligkos/xm_project/internal/repository/apikey"
+	userrepository "github.com/ktsiligkos/xm_project/internal/repository/user"
+)
+
+// Exported errors map internal failures to business-level concerns
+var (
+	ErrNotFound     = errors.New("api key not found")
+	ErrInvalidInput = errors.New("invalid input")
+	ErrInvalidKey   = errors.New("invalid api key")
+)
+
+const (
+	// keyPrefix marks API keys, so they are easy to spot in logs and secret scanners
+	keyPrefix = "xmk_"
+	keyBytes  = 32
+	// displayPrefixLength is how much of the key is kept in clear text to recognise it
+	displayPrefixLength = len(keyPrefix) + 8
+	// touchInterval limits how often the last use of a key is written
+	touchInterval = time.Minute
+)
+
+// UserLookup finds the users keys act as
+type UserLookup interface {
+	GetUserByID(ctx context.Context, id string) (domain.User, error)
+}
+
+// Service issues API keys and authenticates requests made with them
+type Service struct {
+	repo  apikeyrepository.Repository
+	users UserLookup
+}
+
+// NewService creates an API key service bound to the provided repositories
+func NewService(repo apikeyrepository.Repository, users UserLookup) *Service {
+	return &Service{repo: repo, users: users}
+}
+
+// CreateAPIKey issues a key acting as req.UserID, or as the calling admin when it is empty.
+// The scopes must be permissions the user holds. The key is only returned here.
+func (s *Service) CreateAPIKey(ctx context.Context, req domain.CreateAPIKeyRequest) (domain.CreatedAPIKey, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return domain.CreatedAPIKey{}, fmt.Errorf("%w: name is required", ErrInvalidInput)
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return domain.CreatedAPIKey{}, fmt.Errorf("%w: expires_at must be in the future", ErrInvalidInput)
+	}
+
+	userID := req.UserID
+	if userID == "" {
+		userID = correlation.UserID(ctx)
+	}
+	user, err := s.users.GetUserByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, userrepository.ErrNotFound) {
+			return domain.CreatedAPIKey{}, fmt.Errorf("%w: user %q does not exist", ErrInvalidInput, userID)
+		}
+		return domain.CreatedAPIKey{}, err
+	}
+	if user.Disabled() {
+		return domain.CreatedAPIKey{}, fmt.Errorf("%w: user %s is disabled", ErrInvalidInput, user.ID)
+	}
+
+	scopes, err := normalizeScopes(req.Scopes, auth.PermissionsForRoles(user.Roles))
+	if err != nil {
+		return domain.CreatedAPIKey{}, err
+	}
+
+	plain, err := newKey()
+	if err != nil {
+		return domain.CreatedAPIKey{}, fmt.Errorf("generate api key: %w", err)
+	}
+	key := domain.APIKey{
+		ID:        uuid.NewString(),
+		Name:      name,
+		Prefix:    plain[:displayPrefixLength],
+		KeyHash:   hashKey(plain),
+		UserID:    user.ID,
+		Scopes:    scopes,
+		ExpiresAt: req.ExpiresAt,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := s.repo.CreateAPIKey(ctx, key); err != nil {
+		return domain.CreatedAPIKey{}, err
+	}
+
+	return domain.CreatedAPIKey{APIKey: key, Key: plain}, nil
+}
+
+// ListAPIKeys returns every key without the key itself
+func (s *Service) ListAPIKeys(ctx context.Context) ([]domain.APIKey, error) {
+	return s.repo.ListAPIKeys(ctx)
+}
+
+// RevokeAPIKey stops the key from authenticating
+func (s *Service) RevokeAPIKey(ctx context.Context, id string) error {
+	if err := s.repo.RevokeAPIKey(ctx, id, time.Now().UTC()); err != nil {
+		if errors.Is(err, apikeyrepository.ErrNotFound) {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// AuthenticateAPIKey returns the key when it is valid. Its scopes are narrowed to the current
+// permissions of the user, so demoting or disabling the user also limits their keys.
+func (s *Service) AuthenticateAPIKey(ctx context.Context, plain string) (domain.APIKey, error) {
+	key, err := s.repo.GetAPIKeyByHash(ctx, hashKey(plain))
+	if err != nil {
+		if errors.Is(err, apikeyrepository.ErrNotFound) {
+			return domain.APIKey{}, ErrInvalidKey
+		}
+		return domain.APIKey{}, err
+	}
+
+	now := time.Now().UTC()
+	switch {
+	case key.RevokedAt != nil:
+		return domain.APIKey{}, fmt.Errorf("%w: revoked", ErrInvalidKey)
+	case key.ExpiresAt != nil && !now.Before(*key.ExpiresAt):
+		return domain.APIKey{}, fmt.Errorf("%w: expired", ErrInvalidKey)
+	}
+
+	// the key decides the tenant, so the lookup of its user spans all of them
+	user, err := s.users.GetUserByID(correlation.WithAllTenants(ctx), key.UserID)
+	if err != nil {
+		if errors.Is(err, userrepository.ErrNotFound) {
+			return domain.APIKey{}, fmt.Errorf("%w: user %s no longer exists", ErrInvalidKey, key.UserID)
+		}
+		return domain.APIKey{}, err
+	}
+	if user.Disabled() {
+		return domain.APIKey{}, fmt.Errorf("%w: user %s is disabled", ErrInvalidKey, user.ID)
+	}
+	key.TenantID = user.TenantID
+	held := auth.PermissionsForRoles(user.Roles)
+	key.Scopes = slices.DeleteFunc(key.Scopes, func(scope string) bool {
+		return !slices.Contains(held, scope)
+	})
+
+	if err := s.repo.TouchAPIKey(ctx, key.ID, now, now.Add(-touchInterval)); err != nil {
+		// the last use is informational, it must not fail the request
+		log.Printf("record use of api key %s: %v", key.ID, err)
+	}
+	return key, nil
+}
+
+// normalizeScopes requires at least one scope, each held by the user
+func normalizeScopes(scopes, held []string) ([]string, error) {
+	normalized := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		scope = strings.TrimSpace(scope)
+		if !auth.IsPermission(scope) {
+			return nil, fmt.Errorf("%w: unknown scope %q", ErrInvalidInput, scope)
+		}
+		if !slices.Contains(held, scope) {
+			return nil, fmt.Errorf("%w: the user does not have the %s permission", ErrInvalidInput, scope)
+		}
+		if !slices.Contains(normalized, scope) {
+			normalized = append(normalized, scope)
+		}
+	}
+	if len(normalized) == 0 {
+		return nil, fmt.Errorf("%w: at least one scope is required", ErrInvalidInput)
+	}
+	slices.Sort(normalized)
+	return normalized, nil
+}
+
+func newKey() (string, error) {
+	buf := make([]byte, keyBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return keyPrefix + base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashKey needs no salt or work factor, the key is random and long enough
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,189 @@
+package apikey
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ktsiligkos/xm_project/internal/auth"
+	"github.com/ktsiligkos/xm_project/internal/correlation"
+	"github.com/ktsiligkos/xm_project/internal/domain"
+	apikeyrepository "github.com/ktsiligkos/xm_project/internal/repository/apikey"
+	userrepository "github.com/ktsiligkos/xm_project/internal/repository/user"
+)
+
+// memoryRepository keeps keys in a map keyed by ID
+type memoryRepository struct {
+	keys map[string]domain.APIKey
+}
+
+func (r *memoryRepository) CreateAPIKey(_ context.Context, key domain.APIKey) error {
+	r.keys[key.ID] = key
+	return nil
+}
+
+func (r *memoryRepository) GetAPIKeyByHash(_ context.Context, keyHash string) (domain.APIKey, error) {
+	for _, key := range r.keys {
+		if key.KeyHash == keyHash {
+			key.Scopes = slices.Clone(key.Scopes)
+			return key, nil
+		}
+	}
+	return domain.APIKey{}, apikeyrepository.ErrNotFound
+}
+
+func (r *memoryRepository) ListAPIKeys(context.Context) ([]domain.APIKey, error) {
+	keys := []domain.APIKey{}
+	for _, key := range r.keys {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (r *memoryRepository) RevokeAPIKey(_ context.Context, id string, at time.Time) error {
+	key, ok := r.keys[id]
+	if !ok {
+		return apikeyrepository.ErrNotFound
+	}
+	if key.RevokedAt == nil {
+		key.RevokedAt = &at
+	}
+	r.keys[id] = key
+	return nil
+}
+
+func (r *memoryRepository) TouchAPIKey(_ context.Context, id string, at, notBefore time.Time) error {
+	key := r.keys[id]
+	if key.LastUsedAt == nil || key.LastUsedAt.Before(notBefore) {
+		key.LastUsedAt = &at
+		r.keys[id] = key
+	}
+	return nil
+}
+
+// users is a fixed set of users keyed by ID
+type users map[string]domain.User
+
+func (u users) GetUserByID(_ context.Context, id string) (domain.User, error) {
+	user, ok := u[id]
+	if !ok {
+		return domain.User{}, userrepository.ErrNotFound
+	}
+	return user, nil
+}
+
+func givenService() (*Service, *memoryRepository, users) {
+	repo := &memoryRepository{keys: map[string]domain.APIKey{}}
+	known := users{
+		"admin": {ID: "admin", Roles: []string{auth.RoleAdmin}},
+		"batch": {ID: "batch", Roles: []string{auth.RoleEditor}},
+	}
+	return NewService(repo, known), repo, known
+}
+
+func TestCreateAPIKey_StoresOnlyTheHash(t *testing.T) {
+	service, repo, _ := givenService()
+	ctx := correlation.WithUserID(context.Background(), "admin")
+
+	created, err := service.CreateAPIKey(ctx, domain.CreateAPIKeyRequest{Name: "nightly import", Scopes: []string{auth.PermissionCompaniesWrite}})
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+	if !strings.HasPrefix(created.Key, keyPrefix) || created.UserID != "admin" || !strings.HasPrefix(created.Key, created.Prefix) {
+		t.Fatalf("unexpected key: %+v", created)
+	}
+
+	stored := repo.keys[created.ID]
+	if stored.KeyHash == "" || stored.KeyHash == created.Key || strings.Contains(stored.KeyHash, created.Key) {
+		t.Fatalf("expected only a hash to be stored, got %q", stored.KeyHash)
+	}
+}
+
+func TestCreateAPIKey_RejectsInvalidInput(t *testing.T) {
+	service, _, _ := givenService()
+	past := time.Now().Add(-time.Hour)
+
+	cases := map[string]domain.CreateAPIKeyRequest{
+		"blank name":         {Name: " ", UserID: "batch", Scopes: []string{auth.PermissionCompaniesRead}},
+		"no scopes":          {Name: "job", UserID: "batch"},
+		"unknown scope":      {Name: "job", UserID: "batch", Scopes: []string{"companies:everything"}},
+		"scope not held":     {Name: "job", UserID: "batch", Scopes: []string{auth.PermissionCompaniesDelete}},
+		"unknown user":       {Name: "job", UserID: "nobody", Scopes: []string{auth.PermissionCompaniesRead}},
+		"expiry in the past": {Name: "job", UserID: "batch", Scopes: []string{auth.PermissionCompaniesRead}, ExpiresAt: &past},
+	}
+	for name, req := range cases {
+		t.Run(name, func(t *testing.T) {
+			if _, err := service.CreateAPIKey(context.Background(), req); !errors.Is(err, ErrInvalidInput) {
+				t.Fatalf("expected ErrInvalidInput, got %v", err)
+			}
+		})
+	}
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	service, repo, known := givenService()
+	created, err := service.CreateAPIKey(context.Background(), domain.CreateAPIKeyRequest{
+		Name:   "job",
+		UserID: "batch",
+		Scopes: []string{auth.PermissionCompaniesWrite, auth.PermissionCompaniesRead},
+	})
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+
+	key, err := service.AuthenticateAPIKey(context.Background(), created.Key)
+	if err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	if key.UserID != "batch" || !slices.Equal(key.Scopes, []string{auth.PermissionCompaniesRead, auth.PermissionCompaniesWrite}) {
+		t.Fatalf("unexpected key: %+v", key)
+	}
+	if repo.keys[created.ID].LastUsedAt == nil {
+		t.Fatal("expected the use to be recorded")
+	}
+
+	known["batch"] = domain.User{ID: "batch", Roles: []string{auth.RoleViewer}}
+	key, err = service.AuthenticateAPIKey(context.Background(), created.Key)
+	if err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	if !slices.Equal(key.Scopes, []string{auth.PermissionCompaniesRead}) {
+		t.Fatalf("expected the scopes to follow the demotion of the user, got %v", key.Scopes)
+	}
+
+	if _, err := service.AuthenticateAPIKey(context.Background(), "xmk_unknown"); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("expected ErrInvalidKey for an unknown key, got %v", err)
+	}
+	if err := service.RevokeAPIKey(context.Background(), created.ID); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	if _, err := service.AuthenticateAPIKey(context.Background(), created.Key); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("expected ErrInvalidKey for a revoked key, got %v", err)
+	}
+	if err := service.RevokeAPIKey(context.Background(), "missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestAuthenticateAPIKey_RejectsExpiredKeys(t *testing.T) {
+	service, repo, _ := givenService()
+	expiry := time.Now().Add(time.Hour)
+	created, err := service.CreateAPIKey(context.Background(), domain.CreateAPIKeyRequest{
+		Name: "job", UserID: "batch", Scopes: []string{auth.PermissionCompaniesRead}, ExpiresAt: &expiry,
+	})
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+
+	expired := repo.keys[created.ID]
+	past := time.Now().Add(-time.Second)
+	expired.ExpiresAt = &past
+	repo.keys[created.ID] = expired
+
+	if _, err := service.AuthenticateAPIKey(context.Background(), created.Key); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("expected ErrInvalidKey, got %v", err)
+	}
+}
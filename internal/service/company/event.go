@@ -1,20 +1,55 @@
 package company
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 // EventPublisher defines the capability needed for publishing company events.
 type EventPublisher interface {
 	PublishCompanyEvent(ctx context.Context, event CompanyEvent) error
 }
 
+// Operations carried by company events
+const (
+	OperationCreated  = "company.created"
+	OperationPatched  = "company.patched"
+	OperationDeleted  = "company.deleted"
+	OperationSnapshot = "company.snapshot"
+)
+
 // Models the event to be sent to Kafka
 type CompanyEvent struct {
+	EventID    string    `json:"event_id"`
+	OccurredAt time.Time `json:"occurred_at"`
+	// Sequence orders the changes of a single company, snapshots are not sequenced.
+	// It is allocated with the write, so it increases with every change but an event
+	// that was never delivered leaves a gap.
+	Sequence  int64        `json:"sequence,omitempty"`
 	Operation string       `json:"operation"`
 	Company   EventCompany `json:"company"`
+	// FullState is set when Company holds every field of the company, not only its ID
+	FullState bool          `json:"full_state,omitempty"`
+	Metadata  EventMetadata `json:"metadata"`
+}
+
+// HasFullCompany reports whether Company holds the complete state of the company
+func (e CompanyEvent) HasFullCompany() bool {
+	return e.FullState
+}
+
+// EventMetadata ties an event back to the request that caused it
+type EventMetadata struct {
+	RequestID   string `json:"request_id,omitempty"`
+	UserID      string `json:"user_id,omitempty"`
+	TraceParent string `json:"traceparent,omitempty"`
+	// Tenant is the tenant the request acted for, or the tenant of the company for background jobs
+	Tenant string `json:"tenant,omitempty"`
 }
 
 type EventCompany struct {
 	ID                string  `json:"id"`
+	TenantID          string  `json:"tenant_id,omitempty"`
 	Name              string  `json:"name"`
 	Description       *string `json:"description,omitempty"`
 	AmountOfEmployees int     `json:"amount_of_employees"`
@@ -0,0 +1,233 @@
+package company
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/ktsiligkos/xm_project/internal/domain"
+)
+
+// Kinds of drift between the event log and the repository
+const (
+	// DriftMissing is a stored company the events do not know about, or think is deleted
+	DriftMissing = "missing"
+	// DriftExtra is a company the events think exists but the repository does not have
+	DriftExtra = "extra"
+	// DriftDivergent is a company whose stored fields differ from the folded events
+	DriftDivergent = "divergent"
+)
+
+// ExpectedState is the state of every company as told by the event log.
+// Events are folded in order, sequenced events older than the last seen one are ignored.
+type ExpectedState struct {
+	companies map[string]expectedCompany
+	applied   int
+	skipped   int
+}
+
+type expectedCompany struct {
+	company  EventCompany
+	sequence int64
+	deleted  bool
+	// complete is false after a patch event that only carried the ID
+	complete bool
+}
+
+// NewExpectedState creates an empty state
+func NewExpectedState() *ExpectedState {
+	return &ExpectedState{companies: map[string]expectedCompany{}}
+}
+
+// Apply folds one event into the state
+func (s *ExpectedState) Apply(event CompanyEvent) {
+	id := event.Company.ID
+	current, known := s.companies[id]
+
+	// events of one company can be read out of order when they were routed to several topics
+	if event.Sequence > 0 && known && event.Sequence <= current.sequence {
+		s.skipped++
+		return
+	}
+
+	next := current
+	if event.Sequence > 0 {
+		next.sequence = event.Sequence
+	}
+
+	switch event.Operation {
+	case OperationCreated, OperationSnapshot, OperationPatched:
+		next.deleted = false
+		if event.HasFullCompany() {
+			next.company = event.Company
+			next.complete = true
+		} else {
+			next.company = EventCompany{ID: id}
+			next.complete = false
+		}
+	case OperationDeleted:
+		next.deleted = true
+	default:
+		s.skipped++
+		return
+	}
+
+	s.companies[id] = next
+	s.applied++
+}
+
+// Applied returns how many events changed the state
+func (s *ExpectedState) Applied() int {
+	return s.applied
+}
+
+// Skipped returns how many events were ignored as stale or unknown
+func (s *ExpectedState) Skipped() int {
+	return s.skipped
+}
+
+// Drift describes one company that differs between the event log and the repository
+type Drift struct {
+	Kind      string        `json:"kind"`
+	CompanyID string        `json:"company_id"`
+	Fields    []string      `json:"fields,omitempty"`
+	Expected  *EventCompany `json:"expected,omitempty"`
+	Actual    *EventCompany `json:"actual,omitempty"`
+}
+
+// ReconcileOptions controls a reconciliation run
+type ReconcileOptions struct {
+	BatchSize int
+	// Fix publishes a snapshot event for missing and divergent companies and a
+	// deleted event for extra ones, so consumers of the log converge on the repository
+	Fix bool
+}
+
+// ReconcileReport is the outcome of a reconciliation run
+type ReconcileReport struct {
+	Checked   int     `json:"checked"`
+	Missing   []Drift `json:"missing"`
+	Extra     []Drift `json:"extra"`
+	Divergent []Drift `json:"divergent"`
+	Corrected int     `json:"corrected"`
+}
+
+// HasDrift reports whether any difference was found
+func (r ReconcileReport) HasDrift() bool {
+	return len(r.Missing)+len(r.Extra)+len(r.Divergent) > 0
+}
+
+// Reconcile compares every stored company with the state folded from the event log
+func (s *Service) Reconcile(ctx context.Context, expected *ExpectedState, opts ReconcileOptions) (ReconcileReport, error) {
+	report := ReconcileReport{Missing: []Drift{}, Extra: []Drift{}, Divergent: []Drift{}}
+
+	if opts.Fix && s.publisher == nil {
+		return report, ErrPublisherNotConfigured
+	}
+
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultSnapshotBatchSize
+	}
+
+	stored := make(map[string]bool)
+	afterID := ""
+	for {
+		companies, err := s.repo.ListCompanies(ctx, domain.CompanyFilter{}, afterID, batchSize)
+		if err != nil {
+			return report, fmt.Errorf("list companies after %q: %w", afterID, err)
+		}
+
+		for _, company := range companies {
+			stored[company.ID] = true
+			report.Checked++
+
+			actual := toEventCompany(company)
+			want, known := expected.companies[company.ID]
+			switch {
+			case !known || want.deleted:
+				report.Missing = append(report.Missing, Drift{Kind: DriftMissing, CompanyID: company.ID, Actual: &actual})
+			case !want.complete:
+				report.Divergent = append(report.Divergent, Drift{Kind: DriftDivergent, CompanyID: company.ID, Fields: []string{"unknown"}, Actual: &actual})
+			default:
+				if fields := diffCompany(want.company, actual); len(fields) > 0 {
+					expectedCompany := want.company
+					report.Divergent = append(report.Divergent, Drift{Kind: DriftDivergent, CompanyID: company.ID, Fields: fields, Expected: &expectedCompany, Actual: &actual})
+				}
+			}
+		}
+
+		if len(companies) < batchSize {
+			break
+		}
+		afterID = companies[len(companies)-1].ID
+	}
+
+	extraIDs := make([]string, 0)
+	for id, want := range expected.companies {
+		if !want.deleted && !stored[id] {
+			extraIDs = append(extraIDs, id)
+		}
+	}
+	sort.Strings(extraIDs)
+	for _, id := range extraIDs {
+		expectedCompany := expected.companies[id].company
+		report.Extra = append(report.Extra, Drift{Kind: DriftExtra, CompanyID: id, Expected: &expectedCompany})
+	}
+
+	if opts.Fix {
+		corrected, err := s.publishCorrections(ctx, report)
+		report.Corrected = corrected
+		if err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+func (s *Service) publishCorrections(ctx context.Context, report ReconcileReport) (int, error) {
+	corrections := make([]CompanyEvent, 0, len(report.Missing)+len(report.Divergent)+len(report.Extra))
+	for _, drift := range append(append([]Drift{}, report.Missing...), report.Divergent...) {
+		corrections = append(corrections, CompanyEvent{Operation: OperationSnapshot, Company: *drift.Actual, FullState: true})
+	}
+	for _, drift := range report.Extra {
+		corrections = append(corrections, CompanyEvent{Operation: OperationDeleted, Company: *drift.Expected, FullState: true})
+	}
+
+	for i, event := range corrections {
+		stampEvent(ctx, &event)
+		if err := s.publisher.PublishCompanyEvent(ctx, event); err != nil {
+			return i, fmt.Errorf("publish correction for company %s: %w", event.Company.ID, err)
+		}
+	}
+	return len(corrections), nil
+}
+
+// diffCompany names the fields that differ, an absent description equals an empty one
+func diffCompany(expected, actual EventCompany) []string {
+	var fields []string
+	if expected.Name != actual.Name {
+		fields = append(fields, "name")
+	}
+	if deref(expected.Description) != deref(actual.Description) {
+		fields = append(fields, "description")
+	}
+	if expected.AmountOfEmployees != actual.AmountOfEmployees {
+		fields = append(fields, "amount_of_employees")
+	}
+	if expected.Registered != actual.Registered {
+		fields = append(fields, "registered")
+	}
+	if expected.Type != actual.Type {
+		fields = append(fields, "type")
+	}
+	return fields
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
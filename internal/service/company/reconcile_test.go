@@ -0,0 +1,103 @@
+package company
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ktsiligkos/xm_project/internal/domain"
+)
+
+func reconcileFixture(t *testing.T) (stubRepository, *ExpectedState) {
+	t.Helper()
+
+	stored := []domain.Company{
+		{ID: "a", Name: "Alpha", AmountOfEmployees: 1, Type: domain.NonProfit},
+		{ID: "b", Name: "Beta", AmountOfEmployees: 2, Type: domain.NonProfit},
+		{ID: "c", Name: "Gamma", AmountOfEmployees: 3, Type: domain.NonProfit},
+		{ID: "d", Name: "Delta", AmountOfEmployees: 4, Type: domain.NonProfit},
+	}
+
+	expected := NewExpectedState()
+	for _, event := range []CompanyEvent{
+		// a matches after a patch that arrived out of order
+		{Operation: OperationCreated, Sequence: 1, Company: EventCompany{ID: "a", Name: "Alpha", AmountOfEmployees: 5, Type: "NonProfit"}, FullState: true},
+		{Operation: OperationPatched, Sequence: 3, Company: EventCompany{ID: "a", Name: "Alpha", AmountOfEmployees: 1, Type: "NonProfit"}, FullState: true},
+		{Operation: OperationPatched, Sequence: 2, Company: EventCompany{ID: "a", Name: "Alpha", AmountOfEmployees: 9, Type: "NonProfit"}, FullState: true},
+		// b diverges on the number of employees
+		{Operation: OperationCreated, Sequence: 1, Company: EventCompany{ID: "b", Name: "Beta", AmountOfEmployees: 20, Type: "NonProfit"}, FullState: true},
+		// c was deleted according to the events, d never appeared
+		{Operation: OperationCreated, Sequence: 1, Company: EventCompany{ID: "c", Name: "Gamma", AmountOfEmployees: 3, Type: "NonProfit"}, FullState: true},
+		{Operation: OperationDeleted, Sequence: 2, Company: EventCompany{ID: "c"}},
+		// e is live in the events but not stored
+		{Operation: OperationSnapshot, Company: EventCompany{ID: "e", Name: "Epsilon", Type: "NonProfit"}, FullState: true},
+	} {
+		expected.Apply(event)
+	}
+
+	return pagedRepository(t, stored, ""), expected
+}
+
+func TestReconcile_ReportsDrift(t *testing.T) {
+	Given(t, "a table and an event log that drifted apart")
+	repo, expected := reconcileFixture(t)
+	pub := &stubPublisher{}
+	svc := NewService(repo, pub)
+
+	When(t, "Reconcile is run without fixing")
+	report, err := svc.Reconcile(context.Background(), expected, ReconcileOptions{BatchSize: 3})
+	if err != nil {
+		t.Fatalf("Reconcile returned error: %v", err)
+	}
+
+	Then(t, "every kind of drift is reported and nothing is published")
+	if report.Checked != 4 {
+		t.Fatalf("expected 4 checked companies, got %d", report.Checked)
+	}
+	if expected.Skipped() != 1 {
+		t.Fatalf("expected the stale patch to be skipped, got %d", expected.Skipped())
+	}
+	if len(report.Missing) != 2 || report.Missing[0].CompanyID != "c" || report.Missing[1].CompanyID != "d" {
+		t.Fatalf("unexpected missing: %+v", report.Missing)
+	}
+	if len(report.Extra) != 1 || report.Extra[0].CompanyID != "e" {
+		t.Fatalf("unexpected extra: %+v", report.Extra)
+	}
+	if len(report.Divergent) != 1 || report.Divergent[0].CompanyID != "b" {
+		t.Fatalf("unexpected divergent: %+v", report.Divergent)
+	}
+	assertDeepEqual(t, "divergent fields", report.Divergent[0].Fields, []string{"amount_of_employees"})
+	if len(pub.events) != 0 {
+		t.Fatalf("expected no events without Fix, got %d", len(pub.events))
+	}
+}
+
+func TestReconcile_FixPublishesCorrections(t *testing.T) {
+	Given(t, "a table and an event log that drifted apart")
+	repo, expected := reconcileFixture(t)
+	pub := &stubPublisher{}
+	svc := NewService(repo, pub)
+
+	When(t, "Reconcile is run with Fix")
+	report, err := svc.Reconcile(context.Background(), expected, ReconcileOptions{Fix: true})
+	if err != nil {
+		t.Fatalf("Reconcile returned error: %v", err)
+	}
+
+	Then(t, "stored companies are snapshotted and extra ones deleted")
+	if report.Corrected != 4 || len(pub.events) != 4 {
+		t.Fatalf("expected 4 corrections, got %d (%d events)", report.Corrected, len(pub.events))
+	}
+	got := map[string]string{}
+	for _, ev := range pub.events {
+		got[ev.Company.ID] = ev.Operation
+		if ev.EventID == "" {
+			t.Fatalf("corrections must be stamped, got %+v", ev)
+		}
+	}
+	assertDeepEqual(t, "corrections", got, map[string]string{
+		"b": OperationSnapshot,
+		"c": OperationSnapshot,
+		"d": OperationSnapshot,
+		"e": OperationDeleted,
+	})
+}
@@ -6,7 +6,11 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
+	"github.com/google/uuid"
+
+	"github.com/ktsiligkos/xm_project/internal/correlation"
 	"github.com/ktsiligkos/xm_project/internal/domain"
 	repository "github.com/ktsiligkos/xm_project/internal/repository/company"
 )
@@ -50,16 +54,17 @@ func (s *Service) GetCompanyByID(ctx context.Context, companyID string) (domain.
 
 // Delete removes the record from the persistent storage
 func (s *Service) DeleteCompanyByID(ctx context.Context, companyID string) error {
-	err := s.repo.DeleteCompanyByID(ctx, companyID)
+	change, err := s.repo.DeleteCompanyByID(ctx, companyID)
 	if err != nil {
 		return ErrNotFound
 	}
 
+	// the event carries the last state, so consumers filtering on e.g. type still see the delete
 	s.publish(ctx, CompanyEvent{
-		Operation: "company.deleted",
-		Company: EventCompany{
-			ID: companyID,
-		},
+		Sequence:  change.Sequence,
+		Operation: OperationDeleted,
+		Company:   toEventCompany(change.Company),
+		FullState: true,
 	})
 
 	return nil
@@ -73,7 +78,7 @@ func (s *Service) PatchCompanyByID(ctx context.Context, partial_company domain.P
 		return err
 	}
 
-	err := s.repo.PatchCompanyByID(ctx, partial_company, uuid, maxNumOfFields)
+	change, err := s.repo.PatchCompanyByID(ctx, partial_company, uuid, maxNumOfFields)
 	if err != nil {
 		if errors.Is(err, repository.ErrNotFound) {
 			return ErrNotFound
@@ -85,11 +90,13 @@ func (s *Service) PatchCompanyByID(ctx context.Context, partial_company domain.P
 		return err
 	}
 
+	// consumers get the full state after the patch, read in the transaction of the write
+	// so a concurrent patch cannot slip in between
 	s.publish(ctx, CompanyEvent{
-		Operation: "company.patched",
-		Company: EventCompany{
-			ID: uuid,
-		},
+		Sequence:  change.Sequence,
+		Operation: OperationPatched,
+		Company:   toEventCompany(change.Company),
+		FullState: true,
 	})
 
 	return nil
@@ -101,7 +108,7 @@ func (s *Service) CreateCompany(ctx context.Context, company domain.Company) (do
 		return domain.Company{}, err
 	}
 
-	company, err := s.repo.CreateCompany(ctx, company)
+	change, err := s.repo.CreateCompany(ctx, company)
 	if err != nil {
 		if errors.Is(err, repository.ErrUniquenessViolation) {
 			return domain.Company{}, fmt.Errorf("%w: %v", ErrUniquenessViolation, err)
@@ -111,11 +118,13 @@ func (s *Service) CreateCompany(ctx context.Context, company domain.Company) (do
 	}
 
 	s.publish(ctx, CompanyEvent{
-		Operation: "company.created",
-		Company:   toEventCompany(company),
+		Sequence:  change.Sequence,
+		Operation: OperationCreated,
+		Company:   toEventCompany(change.Company),
+		FullState: true,
 	})
 
-	return company, nil
+	return change.Company, nil
 }
 
 // Validate the fields of the PatchCompanyRequest
@@ -174,14 +183,32 @@ func (s *Service) publish(ctx context.Context, event CompanyEvent) {
 		return
 	}
 
+	stampEvent(ctx, &event)
+
 	if err := s.publisher.PublishCompanyEvent(ctx, event); err != nil {
 		log.Printf("publish company event failed: %v", err)
 	}
 }
 
+// stampEvent gives the event its identity and the correlation data found in ctx
+func stampEvent(ctx context.Context, event *CompanyEvent) {
+	event.EventID = uuid.NewString()
+	event.OccurredAt = time.Now().UTC()
+	event.Metadata = EventMetadata{
+		RequestID:   correlation.RequestID(ctx),
+		UserID:      correlation.UserID(ctx),
+		TraceParent: correlation.TraceParent(ctx),
+		Tenant:      correlation.Tenant(ctx),
+	}
+	if event.Metadata.Tenant == "" {
+		event.Metadata.Tenant = event.Company.TenantID
+	}
+}
+
 func toEventCompany(company domain.Company) EventCompany {
 	return EventCompany{
 		ID:                company.ID,
+		TenantID:          company.TenantID,
 		Name:              company.Name,
 		Description:       company.Description,
 		AmountOfEmployees: company.AmountOfEmployees,
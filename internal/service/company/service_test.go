@@ -6,6 +6,7 @@ import (
 	"reflect"
 	"testing"
 
+	"github.com/ktsiligkos/xm_project/internal/correlation"
 	"github.com/ktsiligkos/xm_project/internal/domain"
 	repoerrors "github.com/ktsiligkos/xm_project/internal/repository/company"
 )
@@ -15,6 +16,19 @@ type stubRepository struct {
 	createFn func(ctx context.Context, company domain.Company) (domain.Company, error)
 	deleteFn func(ctx context.Context, companyID string) error
 	patchFn  func(ctx context.Context, req domain.PatchCompanyRequest, uuid string, maxNumOfFields int) error
+	listFn   func(ctx context.Context, filter domain.CompanyFilter, afterID string, limit int) ([]domain.Company, error)
+	// state and sequence are returned by delete and patch, like the transaction of the real repository does
+	state    domain.Company
+	sequence int64
+}
+
+// changed is the outcome of a successful delete or patch of companyID
+func (s stubRepository) changed(companyID string) repoerrors.Change {
+	state := s.state
+	if state.ID == "" {
+		state.ID = companyID
+	}
+	return repoerrors.Change{Company: state, Sequence: s.sequence}
 }
 
 func (s stubRepository) GetCompanyByID(ctx context.Context, companyID string) (domain.Company, error) {
@@ -24,25 +38,42 @@ func (s stubRepository) GetCompanyByID(ctx context.Context, companyID string) (d
 	return domain.Company{}, errors.New("unexpected call to GetCompanyByID")
 }
 
-func (s stubRepository) CreateCompany(ctx context.Context, company domain.Company) (domain.Company, error) {
+func (s stubRepository) CreateCompany(ctx context.Context, company domain.Company) (repoerrors.Change, error) {
 	if s.createFn != nil {
-		return s.createFn(ctx, company)
+		created, err := s.createFn(ctx, company)
+		if err != nil {
+			return repoerrors.Change{}, err
+		}
+		return repoerrors.Change{Company: created, Sequence: s.sequence}, nil
 	}
-	return domain.Company{}, errors.New("unexpected call to CreateCompany")
+	return repoerrors.Change{}, errors.New("unexpected call to CreateCompany")
 }
 
-func (s stubRepository) DeleteCompanyByID(ctx context.Context, companyID string) error {
+func (s stubRepository) DeleteCompanyByID(ctx context.Context, companyID string) (repoerrors.Change, error) {
 	if s.deleteFn != nil {
-		return s.deleteFn(ctx, companyID)
+		if err := s.deleteFn(ctx, companyID); err != nil {
+			return repoerrors.Change{}, err
+		}
+		return s.changed(companyID), nil
 	}
-	return errors.New("unexpected call to DeleteCompanyByID")
+	return repoerrors.Change{}, errors.New("unexpected call to DeleteCompanyByID")
 }
 
-func (s stubRepository) PatchCompanyByID(ctx context.Context, req domain.PatchCompanyRequest, uuid string, maxNumOfFields int) error {
+func (s stubRepository) PatchCompanyByID(ctx context.Context, req domain.PatchCompanyRequest, uuid string, maxNumOfFields int) (repoerrors.Change, error) {
 	if s.patchFn != nil {
-		return s.patchFn(ctx, req, uuid, maxNumOfFields)
+		if err := s.patchFn(ctx, req, uuid, maxNumOfFields); err != nil {
+			return repoerrors.Change{}, err
+		}
+		return s.changed(uuid), nil
+	}
+	return repoerrors.Change{}, errors.New("unexpected call to PatchCompanyByID")
+}
+
+func (s stubRepository) ListCompanies(ctx context.Context, filter domain.CompanyFilter, afterID string, limit int) ([]domain.Company, error) {
+	if s.listFn != nil {
+		return s.listFn(ctx, filter, afterID, limit)
 	}
-	return errors.New("unexpected call to PatchCompanyByID")
+	return nil, errors.New("unexpected call to ListCompanies")
 }
 
 // Holds the number of published events
@@ -842,3 +873,138 @@ func TestPatchCompanyByID_GenericRepoError_Bubbles_NoPublish(t *testing.T) {
 	}
 	assertNoPublish(t, pub)
 }
+
+func TestPublish_StampsCorrelationMetadataAndSequence(t *testing.T) {
+	Given(t, "a request context carrying request id, user and trace")
+
+	ctx := correlation.WithRequestID(context.Background(), "req-1")
+	ctx = correlation.WithUserID(ctx, "user-1")
+	ctx = correlation.WithTraceParent(ctx, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	pub := &stubPublisher{}
+	repo := stubRepository{
+		deleteFn: func(context.Context, string) error { return nil },
+		sequence: 7,
+	}
+	svc := NewService(repo, pub)
+
+	When(t, "DeleteCompanyByID is called")
+	if err := svc.DeleteCompanyByID(ctx, "company-1"); err != nil {
+		t.Fatalf("DeleteCompanyByID returned error: %v", err)
+	}
+
+	Then(t, "the event carries its identity, sequence and correlation metadata")
+	ev := assertOneEvent(t, pub, OperationDeleted)
+	if ev.EventID == "" || ev.OccurredAt.IsZero() {
+		t.Fatalf("event id and occurred_at must be set, got %+v", ev)
+	}
+	if ev.Sequence != 7 {
+		t.Fatalf("expected sequence 7, got %d", ev.Sequence)
+	}
+	assertDeepEqual(t, "metadata", ev.Metadata, EventMetadata{
+		RequestID:   "req-1",
+		UserID:      "user-1",
+		TraceParent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	})
+}
+
+func TestPublish_FallsBackToTheTenantOfTheCompany(t *testing.T) {
+	Given(t, "a background job without a tenant in its context")
+
+	pub := &stubPublisher{}
+	repo := stubRepository{
+		createFn: func(_ context.Context, company domain.Company) (domain.Company, error) {
+			company.TenantID = "tenant-1"
+			return company, nil
+		},
+	}
+	svc := NewService(repo, pub)
+
+	When(t, "a company of tenant-1 is created")
+	if _, err := svc.CreateCompany(context.Background(), domain.Company{ID: "c1", Name: "Acme", Type: domain.NonProfit}); err != nil {
+		t.Fatalf("CreateCompany returned error: %v", err)
+	}
+
+	Then(t, "the event names the tenant of the company")
+	ev := assertOneEvent(t, pub, OperationCreated)
+	if ev.Metadata.Tenant != "tenant-1" || ev.Company.TenantID != "tenant-1" {
+		t.Fatalf("expected tenant-1 on the event, got %+v", ev)
+	}
+}
+
+func TestPublish_CarriesTheSequenceOfTheWrite(t *testing.T) {
+	Given(t, "a repository that allocates sequence 3 with the write")
+
+	pub := &stubPublisher{}
+	repo := stubRepository{
+		createFn: func(_ context.Context, company domain.Company) (domain.Company, error) { return company, nil },
+		sequence: 3,
+	}
+	svc := NewService(repo, pub)
+
+	When(t, "CreateCompany is called")
+	if _, err := svc.CreateCompany(context.Background(), domain.Company{ID: "c1", Name: "Acme", Type: domain.NonProfit}); err != nil {
+		t.Fatalf("CreateCompany returned error: %v", err)
+	}
+
+	Then(t, "the event carries the sequence of the write")
+	ev := assertOneEvent(t, pub, OperationCreated)
+	if ev.Sequence != 3 {
+		t.Fatalf("expected sequence 3, got %d", ev.Sequence)
+	}
+}
+
+func TestPatchCompanyByID_PublishesFullState(t *testing.T) {
+	Given(t, "a repo that returns the company as its transaction left it")
+
+	const id = "company-123"
+	updated := domain.Company{
+		ID: id, Name: "NewName", AmountOfEmployees: 12,
+		Registered: true, Type: domain.Cooperative,
+	}
+	repo := stubRepository{
+		patchFn: func(_ context.Context, _ domain.PatchCompanyRequest, got string, _ int) error {
+			if got != id {
+				t.Fatalf("repo received id %q, want %q", got, id)
+			}
+			return nil
+		},
+		state: updated,
+	}
+	pub := &stubPublisher{}
+	svc := NewService(repo, pub)
+
+	When(t, "PatchCompanyByID is called")
+	if err := svc.PatchCompanyByID(context.Background(), domain.PatchCompanyRequest{Name: ptr("NewName")}, id); err != nil {
+		t.Fatalf("PatchCompanyByID returned error: %v", err)
+	}
+
+	Then(t, "the patched event carries the complete company")
+	ev := assertOneEvent(t, pub, OperationPatched)
+	assertDeepEqual(t, "event company", ev.Company, toEventCompany(updated))
+	if !ev.HasFullCompany() {
+		t.Fatal("expected the event to report a full company")
+	}
+}
+
+func TestDeleteCompanyByID_PublishesLastState(t *testing.T) {
+	Given(t, "a company that exists until it is deleted")
+
+	const id = "company-123"
+	existing := domain.Company{ID: id, Name: "Acme", AmountOfEmployees: 3, Type: domain.NonProfit}
+	repo := stubRepository{
+		deleteFn: func(context.Context, string) error { return nil },
+		state:    existing,
+	}
+	pub := &stubPublisher{}
+	svc := NewService(repo, pub)
+
+	When(t, "DeleteCompanyByID is called")
+	if err := svc.DeleteCompanyByID(context.Background(), id); err != nil {
+		t.Fatalf("DeleteCompanyByID returned error: %v", err)
+	}
+
+	Then(t, "the deleted event carries the company as it was before the delete")
+	ev := assertOneEvent(t, pub, OperationDeleted)
+	assertDeepEqual(t, "event company", ev.Company, toEventCompany(existing))
+}
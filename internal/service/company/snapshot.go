@@ -0,0 +1,125 @@
+package company
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ktsiligkos/xm_project/internal/domain"
+)
+
+const defaultSnapshotBatchSize = 500
+
+// ErrPublisherNotConfigured is returned when a snapshot is requested without a publisher
+var ErrPublisherNotConfigured = errors.New("event publisher not configured")
+
+// CheckpointStore persists the progress of a snapshot run so it can be resumed
+type CheckpointStore interface {
+	Load(ctx context.Context) (lastID string, err error)
+	Save(ctx context.Context, lastID string) error
+	Clear(ctx context.Context) error
+}
+
+// SnapshotOptions controls how the repository is walked during a snapshot
+type SnapshotOptions struct {
+	Filter    domain.CompanyFilter
+	BatchSize int
+	// Rate caps the number of published events per second, zero or a rate above 1e9 disables throttling
+	Rate       float64
+	Checkpoint CheckpointStore
+	// Progress is invoked after every batch that has been published and checkpointed
+	Progress func(SnapshotProgress)
+}
+
+// SnapshotProgress reports how far a snapshot run has got
+type SnapshotProgress struct {
+	Published int
+	LastID    string
+}
+
+// PublishSnapshot streams every company matching the filter and publishes a
+// company.snapshot event for each one. Progress is checkpointed after every
+// batch, so an interrupted run continues after the last published company.
+func (s *Service) PublishSnapshot(ctx context.Context, opts SnapshotOptions) (SnapshotProgress, error) {
+	var progress SnapshotProgress
+
+	if s.publisher == nil {
+		return progress, ErrPublisherNotConfigured
+	}
+
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultSnapshotBatchSize
+	}
+
+	if opts.Checkpoint != nil {
+		lastID, err := opts.Checkpoint.Load(ctx)
+		if err != nil {
+			return progress, fmt.Errorf("load checkpoint: %w", err)
+		}
+		progress.LastID = lastID
+	}
+
+	var throttle <-chan time.Time
+	// rates beyond one event per nanosecond round the interval down to zero, they are unthrottled too
+	if interval := time.Duration(float64(time.Second) / opts.Rate); opts.Rate > 0 && interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		throttle = ticker.C
+	}
+
+	for {
+		companies, err := s.repo.ListCompanies(ctx, opts.Filter, progress.LastID, batchSize)
+		if err != nil {
+			return progress, fmt.Errorf("list companies after %q: %w", progress.LastID, err)
+		}
+
+		for _, company := range companies {
+			if throttle != nil {
+				select {
+				case <-ctx.Done():
+					return progress, ctx.Err()
+				case <-throttle:
+				}
+			}
+
+			event := CompanyEvent{
+				Operation: OperationSnapshot,
+				Company:   toEventCompany(company),
+				FullState: true,
+			}
+			stampEvent(ctx, &event)
+			if err := s.publisher.PublishCompanyEvent(ctx, event); err != nil {
+				return progress, fmt.Errorf("publish snapshot of company %s: %w", company.ID, err)
+			}
+		}
+
+		if len(companies) > 0 {
+			progress.Published += len(companies)
+			progress.LastID = companies[len(companies)-1].ID
+
+			if opts.Checkpoint != nil {
+				if err := opts.Checkpoint.Save(ctx, progress.LastID); err != nil {
+					return progress, fmt.Errorf("save checkpoint: %w", err)
+				}
+			}
+			if opts.Progress != nil {
+				opts.Progress(progress)
+			}
+		}
+
+		if len(companies) < batchSize {
+			break
+		}
+	}
+
+	// a finished run starts over the next time it is invoked
+	if opts.Checkpoint != nil {
+		if err := opts.Checkpoint.Clear(ctx); err != nil {
+			return progress, fmt.Errorf("clear checkpoint: %w", err)
+		}
+	}
+
+	return progress, nil
+}
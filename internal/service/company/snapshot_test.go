@@ -0,0 +1,184 @@
+package company
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ktsiligkos/xm_project/internal/domain"
+)
+
+type stubCheckpoint struct {
+	lastID  string
+	saved   []string
+	cleared bool
+}
+
+func (s *stubCheckpoint) Load(context.Context) (string, error) {
+	return s.lastID, nil
+}
+
+func (s *stubCheckpoint) Save(_ context.Context, lastID string) error {
+	s.lastID = lastID
+	s.saved = append(s.saved, lastID)
+	return nil
+}
+
+func (s *stubCheckpoint) Clear(context.Context) error {
+	s.lastID = ""
+	s.cleared = true
+	return nil
+}
+
+// pagedRepository serves the supplied companies using keyset pagination
+func pagedRepository(t *testing.T, companies []domain.Company, failAfter string) stubRepository {
+	t.Helper()
+	return stubRepository{
+		listFn: func(_ context.Context, _ domain.CompanyFilter, afterID string, limit int) ([]domain.Company, error) {
+			if failAfter != "" && afterID == failAfter {
+				return nil, errors.New("db down")
+			}
+			page := make([]domain.Company, 0, limit)
+			for _, c := range companies {
+				if c.ID > afterID && len(page) < limit {
+					page = append(page, c)
+				}
+			}
+			return page, nil
+		},
+	}
+}
+
+func snapshotCompanies() []domain.Company {
+	return []domain.Company{
+		{ID: "a", Name: "Alpha", Type: domain.Corporations},
+		{ID: "b", Name: "Beta", Type: domain.NonProfit},
+		{ID: "c", Name: "Gamma", Type: domain.Cooperative},
+	}
+}
+
+func TestPublishSnapshot_PublishesEveryCompanyAndClearsCheckpoint(t *testing.T) {
+	Given(t, "three companies and a batch size of two")
+
+	pub := &stubPublisher{}
+	cp := &stubCheckpoint{}
+	svc := NewService(pagedRepository(t, snapshotCompanies(), ""), pub)
+
+	When(t, "PublishSnapshot runs to completion")
+	progress, err := svc.PublishSnapshot(context.Background(), SnapshotOptions{BatchSize: 2, Checkpoint: cp})
+
+	Then(t, "every company is published as a snapshot event")
+	if err != nil {
+		t.Fatalf("PublishSnapshot returned unexpected error: %v", err)
+	}
+	if progress.Published != 3 {
+		t.Fatalf("expected 3 published companies, got %d", progress.Published)
+	}
+	if len(pub.events) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(pub.events))
+	}
+	for i, ev := range pub.events {
+		if ev.Operation != OperationSnapshot {
+			t.Fatalf("event %d: operation got %q, want %q", i, ev.Operation, OperationSnapshot)
+		}
+	}
+
+	Then(t, "a checkpoint is saved per batch and cleared at the end")
+	assertDeepEqual(t, "saved checkpoints", cp.saved, []string{"b", "c"})
+	if !cp.cleared {
+		t.Fatal("expected checkpoint to be cleared after a complete run")
+	}
+}
+
+func TestPublishSnapshot_ResumesFromCheckpoint(t *testing.T) {
+	Given(t, "a checkpoint left behind by an interrupted run")
+
+	pub := &stubPublisher{}
+	cp := &stubCheckpoint{lastID: "a"}
+	svc := NewService(pagedRepository(t, snapshotCompanies(), ""), pub)
+
+	When(t, "PublishSnapshot is called again")
+	_, err := svc.PublishSnapshot(context.Background(), SnapshotOptions{BatchSize: 10, Checkpoint: cp})
+
+	Then(t, "only the companies after the checkpoint are published")
+	if err != nil {
+		t.Fatalf("PublishSnapshot returned unexpected error: %v", err)
+	}
+	if len(pub.events) != 2 || pub.events[0].Company.ID != "b" || pub.events[1].Company.ID != "c" {
+		t.Fatalf("unexpected events: %+v", pub.events)
+	}
+}
+
+func TestPublishSnapshot_RepoError_KeepsCheckpoint(t *testing.T) {
+	Given(t, "a repository that fails on the second page")
+
+	pub := &stubPublisher{}
+	cp := &stubCheckpoint{}
+	svc := NewService(pagedRepository(t, snapshotCompanies(), "b"), pub)
+
+	When(t, "PublishSnapshot is called")
+	progress, err := svc.PublishSnapshot(context.Background(), SnapshotOptions{BatchSize: 2, Checkpoint: cp})
+
+	Then(t, "the error is returned and the checkpoint points at the last published company")
+	if err == nil {
+		t.Fatal("expected an error but got nil")
+	}
+	if progress.LastID != "b" || cp.lastID != "b" || cp.cleared {
+		t.Fatalf("unexpected checkpoint state: progress=%+v checkpoint=%+v", progress, cp)
+	}
+}
+
+func TestPublishSnapshot_PassesFilterToRepository(t *testing.T) {
+	Given(t, "a filter on company type")
+
+	nonProfit := domain.NonProfit
+	var seen domain.CompanyFilter
+	repo := stubRepository{
+		listFn: func(_ context.Context, filter domain.CompanyFilter, _ string, _ int) ([]domain.Company, error) {
+			seen = filter
+			return nil, nil
+		},
+	}
+	svc := NewService(repo, &stubPublisher{})
+
+	When(t, "PublishSnapshot is called with the filter")
+	if _, err := svc.PublishSnapshot(context.Background(), SnapshotOptions{Filter: domain.CompanyFilter{Type: &nonProfit}}); err != nil {
+		t.Fatalf("PublishSnapshot returned unexpected error: %v", err)
+	}
+
+	Then(t, "the repository receives the same filter")
+	if seen.Type == nil || *seen.Type != domain.NonProfit {
+		t.Fatalf("filter not passed through: %+v", seen)
+	}
+}
+
+func TestPublishSnapshot_NoPublisher(t *testing.T) {
+	Given(t, "a service without a publisher")
+	svc := NewService(stubRepository{}, nil)
+
+	When(t, "PublishSnapshot is called")
+	_, err := svc.PublishSnapshot(context.Background(), SnapshotOptions{})
+
+	Then(t, "it returns ErrPublisherNotConfigured")
+	if !errors.Is(err, ErrPublisherNotConfigured) {
+		t.Fatalf("want ErrPublisherNotConfigured, got %v", err)
+	}
+}
+
+func TestPublishSnapshot_HugeRateIsUnthrottled(t *testing.T) {
+	Given(t, "a rate too high for the ticker interval")
+	repo := stubRepository{
+		listFn: func(context.Context, domain.CompanyFilter, string, int) ([]domain.Company, error) {
+			return []domain.Company{{ID: "c1"}}, nil
+		},
+	}
+	svc := NewService(repo, &stubPublisher{})
+
+	When(t, "PublishSnapshot is called with it")
+	progress, err := svc.PublishSnapshot(context.Background(), SnapshotOptions{BatchSize: 2, Rate: 1e12})
+
+	Then(t, "the companies are published without a panic")
+	if err != nil || progress.Published != 1 {
+		t.Fatalf("unexpected outcome %+v, %v", progress, err)
+	}
+}
@@ -0,0 +1,35 @@
+package deadletter
+
+import (
+	"context"
+	"log"
+
+	companyservice "github.com/ktsiligkos/xm_project/internal/service/company"
+)
+
+// Publisher decorates an EventPublisher and stores every event it fails to publish
+type Publisher struct {
+	next    companyservice.EventPublisher
+	service *Service
+}
+
+// NewPublisher wraps next so that its failures end up in the dead letter store
+func NewPublisher(next companyservice.EventPublisher, service *Service) *Publisher {
+	return &Publisher{next: next, service: service}
+}
+
+// PublishCompanyEvent forwards the event and records it when publishing fails.
+// The original error is still returned so the caller can report it.
+func (p *Publisher) PublishCompanyEvent(ctx context.Context, event companyservice.CompanyEvent) error {
+	err := p.next.PublishCompanyEvent(ctx, event)
+	if err == nil {
+		return nil
+	}
+
+	// the caller's request may already be finishing, the record must still be written
+	if recordErr := p.service.Record(context.WithoutCancel(ctx), event, err, 1); recordErr != nil {
+		log.Printf("store dead letter for company event %s failed: %v", event.Company.ID, recordErr)
+	}
+
+	return err
+}
@@ -0,0 +1,215 @@
+package deadletter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/ktsiligkos/xm_project/internal/domain"
+	repository "github.com/ktsiligkos/xm_project/internal/repository/deadletter"
+	companyservice "github.com/ktsiligkos/xm_project/internal/service/company"
+	userservice "github.com/ktsiligkos/xm_project/internal/service/user"
+)
+
+// Exported errors map internal failures to business-level concerns
+var (
+	ErrNotFound     = errors.New("dead letter not found")
+	ErrReplayFailed = errors.New("replay failed")
+)
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 500
+	replayBatchSize  = 100
+)
+
+// ReplayResult summarises a replay of every stored dead letter
+type ReplayResult struct {
+	Replayed int `json:"replayed"`
+	Failed   int `json:"failed"`
+}
+
+// Service stores events that failed to publish and replays them on request
+type Service struct {
+	repo          repository.Repository
+	publisher     companyservice.EventPublisher
+	userPublisher userservice.EventPublisher
+}
+
+// Option customises the dead letter service
+type Option func(*Service)
+
+// WithUserPublisher replays dead user events to publisher, without it they stay stored
+func WithUserPublisher(publisher userservice.EventPublisher) Option {
+	return func(s *Service) {
+		s.userPublisher = publisher
+	}
+}
+
+// NewService creates a dead letter service, company events are replayed straight to publisher
+func NewService(repo repository.Repository, publisher companyservice.EventPublisher, opts ...Option) *Service {
+	s := &Service{repo: repo, publisher: publisher}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Record stores a company event that could not be published together with the error
+func (s *Service) Record(ctx context.Context, event companyservice.CompanyEvent, publishErr error, attempts int) error {
+	return s.save(ctx, domain.DeadLetter{
+		Stream:    domain.DeadLetterStreamCompany,
+		Operation: event.Operation,
+		CompanyID: event.Company.ID,
+	}, event, publishErr, attempts)
+}
+
+// RecordUserEvent stores a user event that could not be published together with the error
+func (s *Service) RecordUserEvent(ctx context.Context, event userservice.UserEvent, publishErr error, attempts int) error {
+	return s.save(ctx, domain.DeadLetter{
+		Stream:    domain.DeadLetterStreamUser,
+		Operation: event.Operation,
+	}, event, publishErr, attempts)
+}
+
+func (s *Service) save(ctx context.Context, deadLetter domain.DeadLetter, event any, publishErr error, attempts int) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("encode dead letter payload: %w", err)
+	}
+
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	deadLetter.Payload = payload
+	deadLetter.LastError = publishErr.Error()
+	deadLetter.Attempts = attempts
+	_, err = s.repo.SaveDeadLetter(ctx, deadLetter)
+	return err
+}
+
+// List returns a page of dead letters ordered by ID
+func (s *Service) List(ctx context.Context, afterID int64, limit int) ([]domain.DeadLetter, error) {
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+	if limit > maxListLimit {
+		limit = maxListLimit
+	}
+
+	return s.repo.ListDeadLetters(ctx, afterID, limit)
+}
+
+// Get returns a single dead letter
+func (s *Service) Get(ctx context.Context, id int64) (domain.DeadLetter, error) {
+	deadLetter, err := s.repo.GetDeadLetterByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return domain.DeadLetter{}, ErrNotFound
+		}
+		return domain.DeadLetter{}, err
+	}
+
+	return deadLetter, nil
+}
+
+// Replay publishes a dead letter again, removing it on success and counting the attempt on failure
+func (s *Service) Replay(ctx context.Context, id int64) error {
+	deadLetter, err := s.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return s.replay(ctx, deadLetter)
+}
+
+// ReplayAll walks every stored dead letter once and replays it
+func (s *Service) ReplayAll(ctx context.Context) (ReplayResult, error) {
+	var (
+		result  ReplayResult
+		afterID int64
+	)
+
+	for {
+		deadLetters, err := s.repo.ListDeadLetters(ctx, afterID, replayBatchSize)
+		if err != nil {
+			return result, err
+		}
+
+		for _, deadLetter := range deadLetters {
+			if err := s.replay(ctx, deadLetter); err != nil {
+				if !errors.Is(err, ErrReplayFailed) {
+					return result, err
+				}
+				result.Failed++
+				continue
+			}
+			result.Replayed++
+		}
+
+		if len(deadLetters) < replayBatchSize {
+			return result, nil
+		}
+		afterID = deadLetters[len(deadLetters)-1].ID
+	}
+}
+
+// Discard removes a dead letter without publishing it
+func (s *Service) Discard(ctx context.Context, id int64) error {
+	if err := s.repo.DeleteDeadLetterByID(ctx, id); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	return nil
+}
+
+// DiscardAll removes every dead letter and returns how many were removed
+func (s *Service) DiscardAll(ctx context.Context) (int64, error) {
+	return s.repo.DeleteAllDeadLetters(ctx)
+}
+
+func (s *Service) replay(ctx context.Context, deadLetter domain.DeadLetter) error {
+	publishErr, err := s.publish(ctx, deadLetter)
+	if err != nil {
+		return err
+	}
+
+	if publishErr != nil {
+		if err := s.repo.RecordFailedAttempt(ctx, deadLetter.ID, publishErr.Error()); err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: %v", ErrReplayFailed, publishErr)
+	}
+
+	if err := s.repo.DeleteDeadLetterByID(ctx, deadLetter.ID); err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return err
+	}
+
+	return nil
+}
+
+// publish sends the payload to the topic of its stream, decoding failures are returned as err
+func (s *Service) publish(ctx context.Context, deadLetter domain.DeadLetter) (publishErr error, err error) {
+	switch deadLetter.Stream {
+	case domain.DeadLetterStreamUser:
+		var event userservice.UserEvent
+		if err := json.Unmarshal(deadLetter.Payload, &event); err != nil {
+			return nil, fmt.Errorf("decode dead letter %d: %w", deadLetter.ID, err)
+		}
+		if s.userPublisher == nil {
+			return errors.New("user events are not published"), nil
+		}
+		return s.userPublisher.PublishUserEvent(ctx, event), nil
+	default:
+		var event companyservice.CompanyEvent
+		if err := json.Unmarshal(deadLetter.Payload, &event); err != nil {
+			return nil, fmt.Errorf("decode dead letter %d: %w", deadLetter.ID, err)
+		}
+		return s.publisher.PublishCompanyEvent(ctx, event), nil
+	}
+}
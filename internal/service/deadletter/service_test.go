@@ -0,0 +1,259 @@
+package deadletter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/ktsiligkos/xm_project/internal/domain"
+	repository "github.com/ktsiligkos/xm_project/internal/repository/deadletter"
+	companyservice "github.com/ktsiligkos/xm_project/internal/service/company"
+	userservice "github.com/ktsiligkos/xm_project/internal/service/user"
+)
+
+// memoryRepository keeps dead letters in a map
+type memoryRepository struct {
+	nextID  int64
+	letters map[int64]domain.DeadLetter
+}
+
+func newMemoryRepository() *memoryRepository {
+	return &memoryRepository{letters: map[int64]domain.DeadLetter{}}
+}
+
+func (r *memoryRepository) SaveDeadLetter(_ context.Context, dl domain.DeadLetter) (domain.DeadLetter, error) {
+	r.nextID++
+	dl.ID = r.nextID
+	r.letters[dl.ID] = dl
+	return dl, nil
+}
+
+func (r *memoryRepository) GetDeadLetterByID(_ context.Context, id int64) (domain.DeadLetter, error) {
+	dl, ok := r.letters[id]
+	if !ok {
+		return domain.DeadLetter{}, repository.ErrNotFound
+	}
+	return dl, nil
+}
+
+func (r *memoryRepository) ListDeadLetters(_ context.Context, afterID int64, limit int) ([]domain.DeadLetter, error) {
+	ids := make([]int64, 0, len(r.letters))
+	for id := range r.letters {
+		if id > afterID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	out := []domain.DeadLetter{}
+	for _, id := range ids {
+		if len(out) == limit {
+			break
+		}
+		out = append(out, r.letters[id])
+	}
+	return out, nil
+}
+
+func (r *memoryRepository) RecordFailedAttempt(_ context.Context, id int64, lastError string) error {
+	dl, ok := r.letters[id]
+	if !ok {
+		return repository.ErrNotFound
+	}
+	dl.Attempts++
+	dl.LastError = lastError
+	r.letters[id] = dl
+	return nil
+}
+
+func (r *memoryRepository) DeleteDeadLetterByID(_ context.Context, id int64) error {
+	if _, ok := r.letters[id]; !ok {
+		return repository.ErrNotFound
+	}
+	delete(r.letters, id)
+	return nil
+}
+
+func (r *memoryRepository) DeleteAllDeadLetters(context.Context) (int64, error) {
+	n := int64(len(r.letters))
+	r.letters = map[int64]domain.DeadLetter{}
+	return n, nil
+}
+
+// stubPublisher fails for the company IDs listed in failFor
+type stubPublisher struct {
+	failFor map[string]bool
+	events  []companyservice.CompanyEvent
+}
+
+func (s *stubPublisher) PublishCompanyEvent(_ context.Context, event companyservice.CompanyEvent) error {
+	if s.failFor[event.Company.ID] {
+		return errors.New("broker down")
+	}
+	s.events = append(s.events, event)
+	return nil
+}
+
+func event(id string) companyservice.CompanyEvent {
+	return companyservice.CompanyEvent{
+		Operation: companyservice.OperationCreated,
+		Company:   companyservice.EventCompany{ID: id, Name: "Name-" + id},
+	}
+}
+
+func TestRecord_StoresPayloadErrorAndAttempts(t *testing.T) {
+	repo := newMemoryRepository()
+	svc := NewService(repo, &stubPublisher{})
+
+	if err := svc.Record(context.Background(), event("c1"), errors.New("timeout"), 3); err != nil {
+		t.Fatalf("Record returned error: %v", err)
+	}
+
+	dl := repo.letters[1]
+	if dl.Operation != companyservice.OperationCreated || dl.CompanyID != "c1" || dl.LastError != "timeout" || dl.Attempts != 3 {
+		t.Fatalf("unexpected dead letter: %+v", dl)
+	}
+
+	var stored companyservice.CompanyEvent
+	if err := json.Unmarshal(dl.Payload, &stored); err != nil {
+		t.Fatalf("payload is not a company event: %v", err)
+	}
+	if stored != event("c1") {
+		t.Fatalf("payload mismatch: got %+v", stored)
+	}
+}
+
+func TestReplay_SuccessRemovesDeadLetter(t *testing.T) {
+	repo := newMemoryRepository()
+	pub := &stubPublisher{}
+	svc := NewService(repo, pub)
+	_ = svc.Record(context.Background(), event("c1"), errors.New("timeout"), 1)
+
+	if err := svc.Replay(context.Background(), 1); err != nil {
+		t.Fatalf("Replay returned error: %v", err)
+	}
+
+	if len(pub.events) != 1 || pub.events[0].Company.ID != "c1" {
+		t.Fatalf("expected the event to be republished, got %+v", pub.events)
+	}
+	if _, ok := repo.letters[1]; ok {
+		t.Fatal("expected the dead letter to be removed after a successful replay")
+	}
+}
+
+// stubUserPublisher records the user events it is given
+type stubUserPublisher struct {
+	events []userservice.UserEvent
+}
+
+func (s *stubUserPublisher) PublishUserEvent(_ context.Context, event userservice.UserEvent) error {
+	s.events = append(s.events, event)
+	return nil
+}
+
+func TestReplay_SendsUserEventsToTheUserPublisher(t *testing.T) {
+	// Given a dead user event next to a dead company event
+	repo := newMemoryRepository()
+	companies := &stubPublisher{}
+	users := &stubUserPublisher{}
+	svc := NewService(repo, companies, WithUserPublisher(users))
+	login := userservice.UserEvent{Operation: userservice.OperationLoginSucceeded, User: userservice.EventUser{ID: "u1"}}
+	_ = svc.RecordUserEvent(context.Background(), login, errors.New("timeout"), 2)
+	_ = svc.Record(context.Background(), event("c1"), errors.New("timeout"), 1)
+
+	// When every dead letter is replayed
+	result, err := svc.ReplayAll(context.Background())
+
+	// Then each event goes back to its own topic
+	if err != nil {
+		t.Fatalf("ReplayAll returned error: %v", err)
+	}
+	if result.Replayed != 2 {
+		t.Fatalf("expected 2 replayed dead letters, got %+v", result)
+	}
+	if len(users.events) != 1 || users.events[0].User.ID != "u1" {
+		t.Fatalf("expected the user event to be republished, got %+v", users.events)
+	}
+	if len(companies.events) != 1 || companies.events[0].Company.ID != "c1" {
+		t.Fatalf("expected only the company event on the company topic, got %+v", companies.events)
+	}
+}
+
+func TestReplay_KeepsUserEventsWithoutAUserPublisher(t *testing.T) {
+	repo := newMemoryRepository()
+	svc := NewService(repo, &stubPublisher{})
+	_ = svc.RecordUserEvent(context.Background(), userservice.UserEvent{Operation: userservice.OperationCreated}, errors.New("timeout"), 1)
+
+	err := svc.Replay(context.Background(), 1)
+
+	if !errors.Is(err, ErrReplayFailed) {
+		t.Fatalf("expected ErrReplayFailed, got %v", err)
+	}
+	if dl := repo.letters[1]; dl.Stream != domain.DeadLetterStreamUser || dl.Attempts != 2 {
+		t.Fatalf("expected the dead letter to stay with a counted attempt, got %+v", dl)
+	}
+}
+
+func TestReplay_FailureCountsAttempt(t *testing.T) {
+	repo := newMemoryRepository()
+	svc := NewService(repo, &stubPublisher{failFor: map[string]bool{"c1": true}})
+	_ = svc.Record(context.Background(), event("c1"), errors.New("timeout"), 1)
+
+	err := svc.Replay(context.Background(), 1)
+	if !errors.Is(err, ErrReplayFailed) {
+		t.Fatalf("want ErrReplayFailed, got %v", err)
+	}
+
+	dl := repo.letters[1]
+	if dl.Attempts != 2 || dl.LastError != "broker down" {
+		t.Fatalf("expected the failed attempt to be recorded, got %+v", dl)
+	}
+}
+
+func TestReplay_NotFound(t *testing.T) {
+	svc := NewService(newMemoryRepository(), &stubPublisher{})
+
+	if err := svc.Replay(context.Background(), 42); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("want ErrNotFound, got %v", err)
+	}
+}
+
+func TestReplayAll_ContinuesPastFailures(t *testing.T) {
+	repo := newMemoryRepository()
+	pub := &stubPublisher{failFor: map[string]bool{"c2": true}}
+	svc := NewService(repo, pub)
+	for _, id := range []string{"c1", "c2", "c3"} {
+		_ = svc.Record(context.Background(), event(id), errors.New("timeout"), 1)
+	}
+
+	result, err := svc.ReplayAll(context.Background())
+	if err != nil {
+		t.Fatalf("ReplayAll returned error: %v", err)
+	}
+
+	if result.Replayed != 2 || result.Failed != 1 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	if len(repo.letters) != 1 || repo.letters[2].CompanyID != "c2" {
+		t.Fatalf("only the failed dead letter should remain, got %+v", repo.letters)
+	}
+}
+
+func TestPublisher_RecordsFailuresAndReturnsError(t *testing.T) {
+	repo := newMemoryRepository()
+	svc := NewService(repo, &stubPublisher{})
+	pub := NewPublisher(&stubPublisher{failFor: map[string]bool{"c1": true}}, svc)
+
+	if err := pub.PublishCompanyEvent(context.Background(), event("c1")); err == nil {
+		t.Fatal("expected the publish error to be returned")
+	}
+	if err := pub.PublishCompanyEvent(context.Background(), event("c2")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(repo.letters) != 1 || repo.letters[1].CompanyID != "c1" {
+		t.Fatalf("expected only the failed event to be stored, got %+v", repo.letters)
+	}
+}
@@ -0,0 +1,126 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"github.com/ktsiligkos/xm_project/internal/correlation"
+	"github.com/ktsiligkos/xm_project/internal/domain"
+	tenantrepository "github.com/ktsiligkos/xm_project/internal/repository/tenant"
+)
+
+// Exported errors map internal failures to business-level concerns
+var (
+	ErrNotFound     = errors.New("tenant not found")
+	ErrInvalidInput = errors.New("invalid input")
+	ErrNameTaken    = errors.New("tenant name already in use")
+	ErrInUse        = errors.New("tenant still has users or companies")
+)
+
+const maxNameLength = 100
+
+// UserCreator registers users in the tenant found in the context
+type UserCreator interface {
+	CreateUser(ctx context.Context, req domain.CreateUserRequest) (domain.User, error)
+}
+
+// Service manages the tenants of the deployment
+type Service struct {
+	repo  tenantrepository.Repository
+	users UserCreator
+}
+
+// NewService creates a tenant service bound to the provided repository
+func NewService(repo tenantrepository.Repository, users UserCreator) *Service {
+	return &Service{repo: repo, users: users}
+}
+
+// CreateTenant adds a tenant with a generated ID
+func (s *Service) CreateTenant(ctx context.Context, req domain.CreateTenantRequest) (domain.Tenant, error) {
+	name, err := normalizeName(req.Name)
+	if err != nil {
+		return domain.Tenant{}, err
+	}
+
+	now := time.Now().UTC()
+	tenant := domain.Tenant{ID: uuid.NewString(), Name: name, CreatedAt: now, UpdatedAt: now}
+	if err := s.repo.CreateTenant(ctx, tenant); err != nil {
+		return domain.Tenant{}, mapError(err)
+	}
+	return tenant, nil
+}
+
+// GetTenant returns a single tenant
+func (s *Service) GetTenant(ctx context.Context, id string) (domain.Tenant, error) {
+	tenant, err := s.repo.GetTenantByID(ctx, id)
+	if err != nil {
+		return domain.Tenant{}, mapError(err)
+	}
+	return tenant, nil
+}
+
+// ListTenants returns every tenant ordered by name
+func (s *Service) ListTenants(ctx context.Context) ([]domain.Tenant, error) {
+	return s.repo.ListTenants(ctx)
+}
+
+// RenameTenant changes the name of a tenant, its ID and everything scoped by it stay the same
+func (s *Service) RenameTenant(ctx context.Context, id string, req domain.UpdateTenantRequest) (domain.Tenant, error) {
+	name, err := normalizeName(req.Name)
+	if err != nil {
+		return domain.Tenant{}, err
+	}
+	if err := s.repo.RenameTenant(ctx, id, name); err != nil {
+		return domain.Tenant{}, mapError(err)
+	}
+	return s.GetTenant(ctx, id)
+}
+
+// DeleteTenant removes a tenant once its users and companies are gone. The default
+// tenant is kept, tokens without a tenant act for it.
+func (s *Service) DeleteTenant(ctx context.Context, id string) error {
+	if id == domain.DefaultTenantID {
+		return fmt.Errorf("%w: the default tenant cannot be deleted", ErrInvalidInput)
+	}
+	return mapError(s.repo.DeleteTenant(ctx, id))
+}
+
+// CreateTenantUser registers a user in the tenant, e.g. the first admin of a new tenant
+func (s *Service) CreateTenantUser(ctx context.Context, tenantID string, req domain.CreateUserRequest) (domain.User, error) {
+	if _, err := s.GetTenant(ctx, tenantID); err != nil {
+		return domain.User{}, err
+	}
+	return s.users.CreateUser(correlation.WithTenant(ctx, tenantID), req)
+}
+
+func normalizeName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("%w: name is required", ErrInvalidInput)
+	}
+	if utf8.RuneCountInString(name) > maxNameLength {
+		return "", fmt.Errorf("%w: name exceeds the limit of %d characters", ErrInvalidInput, maxNameLength)
+	}
+	return name, nil
+}
+
+func mapError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, tenantrepository.ErrNotFound):
+		return ErrNotFound
+	case errors.Is(err, tenantrepository.ErrDuplicateName):
+		return ErrNameTaken
+	case errors.Is(err, tenantrepository.ErrInUse):
+		return ErrInUse
+	default:
+		return err
+	}
+}
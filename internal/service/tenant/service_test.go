@@ -0,0 +1,154 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ktsiligkos/xm_project/internal/correlation"
+	"github.com/ktsiligkos/xm_project/internal/domain"
+	tenantrepository "github.com/ktsiligkos/xm_project/internal/repository/tenant"
+)
+
+// memoryRepository keeps tenants in a map keyed by ID, inUse marks tenants that still have users
+type memoryRepository struct {
+	tenants map[string]domain.Tenant
+	inUse   map[string]bool
+}
+
+func (r *memoryRepository) CreateTenant(_ context.Context, tenant domain.Tenant) error {
+	for _, existing := range r.tenants {
+		if existing.Name == tenant.Name {
+			return tenantrepository.ErrDuplicateName
+		}
+	}
+	r.tenants[tenant.ID] = tenant
+	return nil
+}
+
+func (r *memoryRepository) GetTenantByID(_ context.Context, id string) (domain.Tenant, error) {
+	tenant, ok := r.tenants[id]
+	if !ok {
+		return domain.Tenant{}, tenantrepository.ErrNotFound
+	}
+	return tenant, nil
+}
+
+func (r *memoryRepository) ListTenants(context.Context) ([]domain.Tenant, error) {
+	tenants := []domain.Tenant{}
+	for _, tenant := range r.tenants {
+		tenants = append(tenants, tenant)
+	}
+	return tenants, nil
+}
+
+func (r *memoryRepository) RenameTenant(_ context.Context, id, name string) error {
+	tenant, ok := r.tenants[id]
+	if !ok {
+		return tenantrepository.ErrNotFound
+	}
+	tenant.Name = name
+	r.tenants[id] = tenant
+	return nil
+}
+
+func (r *memoryRepository) DeleteTenant(_ context.Context, id string) error {
+	if _, ok := r.tenants[id]; !ok {
+		return tenantrepository.ErrNotFound
+	}
+	if r.inUse[id] {
+		return tenantrepository.ErrInUse
+	}
+	delete(r.tenants, id)
+	return nil
+}
+
+// recordingUsers remembers the tenant of the context each user was created in
+type recordingUsers struct {
+	tenants []string
+}
+
+func (u *recordingUsers) CreateUser(ctx context.Context, req domain.CreateUserRequest) (domain.User, error) {
+	u.tenants = append(u.tenants, correlation.Tenant(ctx))
+	return domain.User{ID: "u1", TenantID: correlation.Tenant(ctx), Email: req.Email}, nil
+}
+
+func givenService() (*Service, *memoryRepository, *recordingUsers) {
+	repo := &memoryRepository{
+		tenants: map[string]domain.Tenant{domain.DefaultTenantID: {ID: domain.DefaultTenantID, Name: "Default"}},
+		inUse:   map[string]bool{},
+	}
+	users := &recordingUsers{}
+	return NewService(repo, users), repo, users
+}
+
+func TestCreateTenant_ValidatesAndRequiresUniqueNames(t *testing.T) {
+	service, _, _ := givenService()
+	ctx := context.Background()
+
+	tenant, err := service.CreateTenant(ctx, domain.CreateTenantRequest{Name: "  Retail  "})
+	if err != nil {
+		t.Fatalf("CreateTenant returned error: %v", err)
+	}
+	if tenant.ID == "" || tenant.Name != "Retail" || tenant.CreatedAt.IsZero() {
+		t.Fatalf("unexpected tenant %+v", tenant)
+	}
+
+	if _, err := service.CreateTenant(ctx, domain.CreateTenantRequest{Name: "Retail"}); !errors.Is(err, ErrNameTaken) {
+		t.Fatalf("expected ErrNameTaken, got %v", err)
+	}
+	if _, err := service.CreateTenant(ctx, domain.CreateTenantRequest{Name: " "}); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected ErrInvalidInput for a blank name, got %v", err)
+	}
+}
+
+func TestDeleteTenant_KeepsTheDefaultAndTenantsInUse(t *testing.T) {
+	service, repo, _ := givenService()
+	ctx := context.Background()
+	tenant, err := service.CreateTenant(ctx, domain.CreateTenantRequest{Name: "Retail"})
+	if err != nil {
+		t.Fatalf("CreateTenant returned error: %v", err)
+	}
+
+	if err := service.DeleteTenant(ctx, domain.DefaultTenantID); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected the default tenant to be kept, got %v", err)
+	}
+
+	repo.inUse[tenant.ID] = true
+	if err := service.DeleteTenant(ctx, tenant.ID); !errors.Is(err, ErrInUse) {
+		t.Fatalf("expected ErrInUse, got %v", err)
+	}
+
+	repo.inUse[tenant.ID] = false
+	if err := service.DeleteTenant(ctx, tenant.ID); err != nil {
+		t.Fatalf("DeleteTenant returned error: %v", err)
+	}
+	if err := service.DeleteTenant(ctx, tenant.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound once deleted, got %v", err)
+	}
+}
+
+func TestCreateTenantUser_CreatesTheUserInThatTenant(t *testing.T) {
+	service, _, users := givenService()
+	tenant, err := service.CreateTenant(context.Background(), domain.CreateTenantRequest{Name: "Retail"})
+	if err != nil {
+		t.Fatalf("CreateTenant returned error: %v", err)
+	}
+	// the operator acts for their own tenant
+	ctx := correlation.WithTenant(context.Background(), domain.DefaultTenantID)
+
+	user, err := service.CreateTenantUser(ctx, tenant.ID, domain.CreateUserRequest{Email: "jane@example.com"})
+	if err != nil {
+		t.Fatalf("CreateTenantUser returned error: %v", err)
+	}
+	if user.TenantID != tenant.ID || len(users.tenants) != 1 || users.tenants[0] != tenant.ID {
+		t.Fatalf("expected the user in tenant %s, got %+v", tenant.ID, user)
+	}
+
+	if _, err := service.CreateTenantUser(ctx, "unknown", domain.CreateUserRequest{Email: "joe@example.com"}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for an unknown tenant, got %v", err)
+	}
+	if len(users.tenants) != 1 {
+		t.Fatal("expected no user to be created in an unknown tenant")
+	}
+}
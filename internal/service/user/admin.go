@@ -0,0 +1,187 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ktsiligkos/xm_project/internal/auth"
+	"github.com/ktsiligkos/xm_project/internal/domain"
+	userrepository "github.com/ktsiligkos/xm_project/internal/repository/user"
+)
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 500
+)
+
+// CreateUser registers a user with a generated ID and a hash of the password
+func (s *Service) CreateUser(ctx context.Context, req domain.CreateUserRequest) (domain.User, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return domain.User{}, fmt.Errorf("%w: name is required", ErrInvalidInput)
+	}
+	email, err := normalizeEmail(req.Email)
+	if err != nil {
+		return domain.User{}, err
+	}
+	roles := []string{auth.RoleViewer}
+	if req.Roles != nil {
+		if roles, err = normalizeRoles(req.Roles); err != nil {
+			return domain.User{}, err
+		}
+	}
+	hash, err := s.hashPassword(req.Password)
+	if err != nil {
+		return domain.User{}, err
+	}
+
+	user, err := s.repo.CreateUser(ctx, domain.User{
+		ID:       uuid.NewString(),
+		Name:     name,
+		Email:    email,
+		Password: hash,
+		Roles:    roles,
+	})
+	if err != nil {
+		if errors.Is(err, userrepository.ErrDuplicateEmail) {
+			return domain.User{}, ErrEmailTaken
+		}
+		return domain.User{}, err
+	}
+
+	s.publish(ctx, UserEvent{Operation: OperationCreated, User: toEventUser(user)})
+	return user, nil
+}
+
+// GetUser returns a single user
+func (s *Service) GetUser(ctx context.Context, id string) (domain.User, error) {
+	user, err := s.repo.GetUserByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, userrepository.ErrNotFound) {
+			return domain.User{}, ErrNotFound
+		}
+		return domain.User{}, err
+	}
+	return user, nil
+}
+
+// ListUsers returns a page of users ordered by ID
+func (s *Service) ListUsers(ctx context.Context, afterID string, limit int) ([]domain.User, error) {
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+	if limit > maxListLimit {
+		limit = maxListLimit
+	}
+
+	return s.repo.ListUsers(ctx, afterID, limit)
+}
+
+// UpdateUser applies the given fields to a user, a new password is hashed before it is stored
+func (s *Service) UpdateUser(ctx context.Context, id string, req domain.UpdateUserRequest) (domain.User, error) {
+	if req.Name == nil && req.Email == nil && req.Password == nil && req.Roles == nil {
+		return domain.User{}, fmt.Errorf("%w: nothing to update", ErrInvalidInput)
+	}
+
+	user, err := s.GetUser(ctx, id)
+	if err != nil {
+		return domain.User{}, err
+	}
+
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" {
+			return domain.User{}, fmt.Errorf("%w: name must not be empty", ErrInvalidInput)
+		}
+		user.Name = name
+	}
+	if req.Email != nil {
+		if user.Email, err = normalizeEmail(*req.Email); err != nil {
+			return domain.User{}, err
+		}
+	}
+	if req.Password != nil {
+		if user.Password, err = s.hashPassword(*req.Password); err != nil {
+			return domain.User{}, err
+		}
+	}
+	rolesChanged := false
+	if req.Roles != nil {
+		roles, err := normalizeRoles(*req.Roles)
+		if err != nil {
+			return domain.User{}, err
+		}
+		rolesChanged = !slices.Equal(roles, user.Roles)
+		user.Roles = roles
+	}
+
+	if err := s.repo.UpdateUser(ctx, user); err != nil {
+		switch {
+		case errors.Is(err, userrepository.ErrDuplicateEmail):
+			return domain.User{}, ErrEmailTaken
+		case errors.Is(err, userrepository.ErrNotFound):
+			return domain.User{}, ErrNotFound
+		}
+		return domain.User{}, err
+	}
+
+	if req.Password != nil {
+		if err := s.revokeSessions(ctx, user.ID); err != nil {
+			return domain.User{}, fmt.Errorf("revoke sessions after password change: %w", err)
+		}
+		s.publish(ctx, UserEvent{Operation: OperationPasswordChanged, User: toEventUser(user)})
+	} else if rolesChanged && s.revoker != nil {
+		// access tokens carry the permissions of the old roles, a refresh picks up the new ones
+		if err := s.revoker.RevokeUser(ctx, user.ID, time.Now().UTC()); err != nil {
+			return domain.User{}, fmt.Errorf("revoke access tokens after role change: %w", err)
+		}
+	}
+	return s.GetUser(ctx, id)
+}
+
+// DisableUser keeps the user from logging in again and revokes their tokens
+func (s *Service) DisableUser(ctx context.Context, id string) error {
+	if err := s.repo.DisableUser(ctx, id, time.Now().UTC()); err != nil {
+		if errors.Is(err, userrepository.ErrNotFound) {
+			return ErrNotFound
+		}
+		return err
+	}
+	if err := s.revokeSessions(ctx, id); err != nil {
+		return fmt.Errorf("revoke sessions of disabled user: %w", err)
+	}
+	return nil
+}
+
+// normalizeRoles rejects unknown roles and returns the rest sorted and without duplicates
+func normalizeRoles(roles []string) ([]string, error) {
+	normalized := make([]string, 0, len(roles))
+	for _, role := range roles {
+		role = strings.ToLower(strings.TrimSpace(role))
+		if !auth.IsRole(role) {
+			return nil, fmt.Errorf("%w: unknown role %q", ErrInvalidInput, role)
+		}
+		if !slices.Contains(normalized, role) {
+			normalized = append(normalized, role)
+		}
+	}
+	slices.Sort(normalized)
+	return normalized, nil
+}
+
+// normalizeEmail accepts a bare address only, such as jane@example.com, and lowercases it
+func normalizeEmail(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return "", fmt.Errorf("%w: email is not a valid address", ErrInvalidInput)
+	}
+	return email, nil
+}
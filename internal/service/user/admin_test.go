@@ -0,0 +1,174 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/ktsiligkos/xm_project/internal/auth"
+	"github.com/ktsiligkos/xm_project/internal/auth/password"
+	"github.com/ktsiligkos/xm_project/internal/domain"
+)
+
+func givenAdminService() (*Service, *memoryRepository, *stubPublisher) {
+	repo := newMemoryRepository()
+	publisher := &stubPublisher{}
+	return NewService(repo, auth.NewHMACKeySet([]byte("secret")), time.Hour, WithEventPublisher(publisher), cheapHasher()), repo, publisher
+}
+
+func TestCreateUser_HashesPasswordAndPublishesCreated(t *testing.T) {
+	service, repo, publisher := givenAdminService()
+
+	user, err := service.CreateUser(context.Background(), domain.CreateUserRequest{Name: " Jane ", Email: "Jane@Example.com", Password: "long enough"})
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	stored := repo.users[user.ID]
+	if user.ID == "" || stored.Name != "Jane" || stored.Email != "jane@example.com" {
+		t.Fatalf("unexpected stored user: %+v", stored)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(stored.Password), []byte("long enough")) != nil {
+		t.Fatal("the stored password should be a bcrypt hash of the given one")
+	}
+	if len(publisher.events) != 1 || publisher.events[0].Operation != OperationCreated || publisher.events[0].User.ID != user.ID {
+		t.Fatalf("expected a user.created event, got %+v", publisher.events)
+	}
+}
+
+func TestCreateUser_RejectsInvalidInput(t *testing.T) {
+	service, _, _ := givenAdminService()
+	if _, err := service.CreateUser(context.Background(), domain.CreateUserRequest{Name: "Jane", Email: "jane@example.com", Password: "long enough"}); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	cases := map[string]struct {
+		req  domain.CreateUserRequest
+		want error
+	}{
+		"blank name":     {domain.CreateUserRequest{Name: " ", Email: "a@example.com", Password: "long enough"}, ErrInvalidInput},
+		"invalid email":  {domain.CreateUserRequest{Name: "A", Email: "not an email", Password: "long enough"}, ErrInvalidInput},
+		"display name":   {domain.CreateUserRequest{Name: "A", Email: "A <a@example.com>", Password: "long enough"}, ErrInvalidInput},
+		"short password": {domain.CreateUserRequest{Name: "A", Email: "a@example.com", Password: "short"}, ErrInvalidInput},
+		"unknown role":   {domain.CreateUserRequest{Name: "A", Email: "a@example.com", Password: "long enough", Roles: []string{"root"}}, ErrInvalidInput},
+		"taken email":    {domain.CreateUserRequest{Name: "B", Email: "JANE@example.com", Password: "long enough"}, ErrEmailTaken},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			if _, err := service.CreateUser(context.Background(), tc.req); !errors.Is(err, tc.want) {
+				t.Fatalf("expected %v, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestCreateUser_EnforcesPasswordPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	if err := os.WriteFile(path, []byte("iloveyou123\n"), 0o600); err != nil {
+		t.Fatalf("write list: %v", err)
+	}
+	policy, err := password.NewPolicy(10, path)
+	if err != nil {
+		t.Fatalf("new policy: %v", err)
+	}
+	service := NewService(newMemoryRepository(), auth.NewHMACKeySet([]byte("secret")), time.Hour, WithPasswordPolicy(policy))
+
+	for _, candidate := range []string{"too short", "iloveyou123"} {
+		if _, err := service.CreateUser(context.Background(), domain.CreateUserRequest{Name: "Jane", Email: "jane@example.com", Password: candidate}); !errors.Is(err, ErrInvalidInput) {
+			t.Fatalf("%q: expected ErrInvalidInput, got %v", candidate, err)
+		}
+	}
+}
+
+func TestUpdateUser_NewPasswordPublishesPasswordChanged(t *testing.T) {
+	service, repo, publisher := givenAdminService()
+	user, err := service.CreateUser(context.Background(), domain.CreateUserRequest{Name: "Jane", Email: "jane@example.com", Password: "long enough"})
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	password, roles := "another secret", []string{auth.RoleAdmin}
+	if _, err := service.UpdateUser(context.Background(), user.ID, domain.UpdateUserRequest{Password: &password, Roles: &roles}); err != nil {
+		t.Fatalf("update user: %v", err)
+	}
+
+	stored := repo.users[user.ID]
+	if !slices.Equal(stored.Roles, roles) || bcrypt.CompareHashAndPassword([]byte(stored.Password), []byte(password)) != nil {
+		t.Fatalf("update not applied: %+v", stored)
+	}
+	last := publisher.events[len(publisher.events)-1]
+	if last.Operation != OperationPasswordChanged || last.User.ID != user.ID {
+		t.Fatalf("expected a user.password_changed event, got %+v", last)
+	}
+}
+
+func TestDisableUser_BlocksLogin(t *testing.T) {
+	service, _, _ := givenAdminService()
+	user, err := service.CreateUser(context.Background(), domain.CreateUserRequest{Name: "Jane", Email: "jane@example.com", Password: "long enough"})
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	if err := service.DisableUser(context.Background(), user.ID); err != nil {
+		t.Fatalf("disable user: %v", err)
+	}
+
+	if _, err := service.AuthenticateUser(context.Background(), domain.UserLoginRequest{Email: user.Email, Password: "long enough"}); !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("expected a disabled user to fail authentication, got %v", err)
+	}
+	if err := service.DisableUser(context.Background(), "missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestCreateUser_DefaultsToTheViewerRole(t *testing.T) {
+	service, _, _ := givenAdminService()
+
+	user, err := service.CreateUser(context.Background(), domain.CreateUserRequest{Name: "Jane", Email: "jane@example.com", Password: "long enough"})
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	if !slices.Equal(user.Roles, []string{auth.RoleViewer}) {
+		t.Fatalf("expected the viewer role, got %v", user.Roles)
+	}
+
+	user, err = service.CreateUser(context.Background(), domain.CreateUserRequest{Name: "Joe", Email: "joe@example.com", Password: "long enough", Roles: []string{" Editor", "admin", "editor"}})
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	if !slices.Equal(user.Roles, []string{auth.RoleAdmin, auth.RoleEditor}) {
+		t.Fatalf("expected the roles to be normalised, got %v", user.Roles)
+	}
+}
+
+func TestUpdateUser_RoleChangeRevokesAccessTokens(t *testing.T) {
+	service, _, _ := givenAdminService()
+	revoker := &stubRevoker{tokens: map[string]string{}, users: map[string]time.Time{}}
+	WithTokenRevoker(revoker)(service)
+	user, err := service.CreateUser(context.Background(), domain.CreateUserRequest{Name: "Jane", Email: "jane@example.com", Password: "long enough"})
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	name := "Jane Doe"
+	if _, err := service.UpdateUser(context.Background(), user.ID, domain.UpdateUserRequest{Name: &name}); err != nil {
+		t.Fatalf("update user: %v", err)
+	}
+	if _, ok := revoker.users[user.ID]; ok {
+		t.Fatal("a change that keeps the roles must not revoke tokens")
+	}
+
+	roles := []string{auth.RoleManager}
+	if _, err := service.UpdateUser(context.Background(), user.ID, domain.UpdateUserRequest{Roles: &roles}); err != nil {
+		t.Fatalf("update user: %v", err)
+	}
+	if _, ok := revoker.users[user.ID]; !ok {
+		t.Fatal("expected the access tokens with the old permissions to be revoked")
+	}
+}
@@ -0,0 +1,70 @@
+package user
+
+import (
+	"context"
+	"time"
+)
+
+// EventPublisher defines the capability needed for publishing user events.
+type EventPublisher interface {
+	PublishUserEvent(ctx context.Context, event UserEvent) error
+}
+
+// Operations carried by user events
+const (
+	OperationCreated         = "user.created"
+	OperationLoginSucceeded  = "user.login_succeeded"
+	OperationLoginFailed     = "user.login_failed"
+	OperationPasswordChanged = "user.password_changed"
+	// OperationRefreshTokenReused reports a used refresh token presented again, its family is revoked
+	OperationRefreshTokenReused = "user.refresh_token_reused"
+	OperationMFAEnabled         = "user.mfa_enabled"
+	// OperationMFADisabled is published when the user turns MFA off or an admin resets it
+	OperationMFADisabled = "user.mfa_disabled"
+)
+
+// Reasons recorded on user.login_failed events
+const (
+	LoginFailedUnknownEmail  = "unknown_email"
+	LoginFailedWrongPassword = "wrong_password"
+	LoginFailedDisabled      = "disabled"
+	// LoginFailedThrottled is a login refused without checking the password, see LoginProtection
+	LoginFailedThrottled = "throttled"
+	// LoginFailedInvalidMFACode is a right password followed by a wrong TOTP or recovery code
+	LoginFailedInvalidMFACode = "invalid_mfa_code"
+	// LoginFailedMFAEnrollmentRequired is a right password of a user who must enroll in MFA first
+	LoginFailedMFAEnrollmentRequired = "mfa_enrollment_required"
+)
+
+// PasswordChangedReset is the reason of a user.password_changed event caused by a reset link,
+// changes made by an admin carry no reason
+const PasswordChangedReset = "reset"
+
+// UserEvent models the event sent to the users topic. It is deliberately built
+// from EventUser rather than domain.User, so password hashes can never leak into it.
+type UserEvent struct {
+	EventID    string    `json:"event_id"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Operation  string    `json:"operation"`
+	User       EventUser `json:"user"`
+	// Reason explains a user.login_failed event, or a password change the user made with a reset link
+	Reason   string        `json:"reason,omitempty"`
+	Metadata EventMetadata `json:"metadata"`
+}
+
+// EventUser is the public part of a user. Failed logins for unknown emails only carry the email.
+type EventUser struct {
+	ID       string `json:"id,omitempty"`
+	TenantID string `json:"tenant_id,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Email    string `json:"email"`
+}
+
+// EventMetadata ties an event back to the request that caused it
+type EventMetadata struct {
+	RequestID   string `json:"request_id,omitempty"`
+	TraceParent string `json:"traceparent,omitempty"`
+	// ActorID is the authenticated user who made the change, empty for logins
+	ActorID  string `json:"actor_id,omitempty"`
+	ClientIP string `json:"client_ip,omitempty"`
+}
@@ -0,0 +1,187 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/ktsiligkos/xm_project/internal/correlation"
+	"github.com/ktsiligkos/xm_project/internal/domain"
+	loginattemptrepository "github.com/ktsiligkos/xm_project/internal/repository/loginattempt"
+)
+
+// ErrLoginThrottled is wrapped by LoginThrottledError when too many logins failed recently
+var ErrLoginThrottled = errors.New("too many failed login attempts")
+
+// LoginThrottledError refuses a login without checking the password, RetryAfter tells when to try again
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginThrottledError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", ErrLoginThrottled, e.RetryAfter)
+}
+
+func (e *LoginThrottledError) Unwrap() error {
+	return ErrLoginThrottled
+}
+
+// LoginLimits are the failures tolerated in a scope before logins are delayed and then locked
+type LoginLimits struct {
+	// FreeAttempts fail without delay, each further failure doubles the wait before the next login
+	FreeAttempts int
+	// LockoutThreshold failures lock logins for LoginProtection.LockoutDuration, zero never locks
+	LockoutThreshold int
+}
+
+// LoginProtection slows down password guessing. Failures are counted per email address and
+// per client IP; past the free attempts every failure refuses logins for a growing delay,
+// and reaching the lockout threshold refuses them for the lockout duration.
+type LoginProtection struct {
+	Account LoginLimits
+	IP      LoginLimits
+	// BaseDelay is the first delay, it doubles with every failure up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// LockoutDuration is how long a lockout lasts, and how long failures are remembered
+	LockoutDuration time.Duration
+}
+
+// DefaultLoginProtection tolerates typos but stops guessing after a handful of tries
+var DefaultLoginProtection = LoginProtection{
+	Account:         LoginLimits{FreeAttempts: 3, LockoutThreshold: 10},
+	IP:              LoginLimits{FreeAttempts: 20, LockoutThreshold: 100},
+	BaseDelay:       time.Second,
+	MaxDelay:        time.Minute,
+	LockoutDuration: 15 * time.Minute,
+}
+
+// WithLoginProtection counts failed logins in the repository and refuses logins after too many
+func WithLoginProtection(attempts loginattemptrepository.Repository, protection LoginProtection) Option {
+	return func(s *Service) {
+		s.loginAttempts = attempts
+		s.loginProtection = protection
+	}
+}
+
+// UnlockUser lifts a delay or lockout of the user's account, failures from client IPs stay counted
+func (s *Service) UnlockUser(ctx context.Context, id string) error {
+	user, err := s.GetUser(ctx, id)
+	if err != nil {
+		return err
+	}
+	if s.loginAttempts == nil {
+		return nil
+	}
+
+	return s.loginAttempts.ResetLoginAttempts(ctx, domain.LoginScopeAccount, accountKey(user.Email))
+}
+
+// PruneLoginAttempts drops the counters that no longer affect any login or password reset request
+func (s *Service) PruneLoginAttempts(ctx context.Context) error {
+	if s.loginAttempts == nil {
+		return nil
+	}
+	retention := max(s.loginProtection.LockoutDuration, s.passwordResetCfg.RequestWindow)
+	return s.loginAttempts.DeleteStaleLoginAttempts(ctx, time.Now().UTC().Add(-retention))
+}
+
+// loginReservation holds the attempts a login reserved per scope, see reserveLogin
+type loginReservation map[string]domain.LoginAttempts
+
+// reserveLogin counts the login as failed in every scope before its password is checked, and
+// refuses it while the account or the client IP is blocked. Each reservation decides on its own
+// count, so concurrent guesses cannot all slip in before the first of them blocks the key.
+// The protection fails open: when a counter cannot be written the password is checked as usual.
+func (s *Service) reserveLogin(ctx context.Context, email string) (loginReservation, error) {
+	if s.loginAttempts == nil {
+		return nil, nil
+	}
+
+	now := time.Now().UTC()
+	reservation := loginReservation{}
+	var retryAfter time.Duration
+	for scope, key := range loginKeys(ctx, email) {
+		limits := s.loginProtection.Account
+		if scope == domain.LoginScopeIP {
+			limits = s.loginProtection.IP
+		}
+		blockFor := func(failures int) time.Duration { return s.loginProtection.delay(limits, failures) }
+
+		attempts, reserved, err := s.loginAttempts.ReserveLoginAttempt(ctx, scope, key, now, now.Add(-s.loginProtection.LockoutDuration), blockFor)
+		if err != nil {
+			log.Printf("reserve %s login attempt: %v", scope, err)
+			continue
+		}
+		if !reserved {
+			if attempts.BlockedUntil != nil {
+				retryAfter = max(retryAfter, attempts.BlockedUntil.Sub(now))
+			}
+			continue
+		}
+		reservation[scope] = attempts
+	}
+
+	if retryAfter > 0 {
+		s.releaseLogin(ctx, reservation)
+		return nil, &LoginThrottledError{RetryAfter: retryAfter}
+	}
+	return reservation, nil
+}
+
+// releaseLogin takes the reserved attempts back once the credentials turned out right,
+// or the login ended without judging them
+func (s *Service) releaseLogin(ctx context.Context, reservation loginReservation) {
+	for scope, attempts := range reservation {
+		if err := s.loginAttempts.ReleaseLoginAttempt(ctx, scope, attempts.Key, attempts.BlockedUntil); err != nil {
+			log.Printf("release %s login attempt: %v", scope, err)
+		}
+	}
+}
+
+// recordLoginSuccess resets the account, the client IP keeps its count so that one valid
+// account cannot be used to hide guesses against others
+func (s *Service) recordLoginSuccess(ctx context.Context, email string) {
+	if s.loginAttempts == nil {
+		return
+	}
+	if err := s.loginAttempts.ResetLoginAttempts(ctx, domain.LoginScopeAccount, accountKey(email)); err != nil {
+		log.Printf("reset account login attempts: %v", err)
+	}
+}
+
+// delay returns how long logins are refused after the given number of failures
+func (p LoginProtection) delay(limits LoginLimits, failures int) time.Duration {
+	if limits.LockoutThreshold > 0 && failures >= limits.LockoutThreshold {
+		return p.LockoutDuration
+	}
+	if failures <= limits.FreeAttempts || p.BaseDelay <= 0 {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := limits.FreeAttempts + 1; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 {
+		delay = min(delay, p.MaxDelay)
+	}
+	return delay
+}
+
+// loginKeys returns the keys a login is counted under, the IP only when it is known
+func loginKeys(ctx context.Context, email string) map[string]string {
+	keys := map[string]string{domain.LoginScopeAccount: accountKey(email)}
+	if ip := correlation.ClientIP(ctx); ip != "" {
+		keys[domain.LoginScopeIP] = ip
+	}
+	return keys
+}
+
+// accountKey lowercases like normalizeEmail, so case variants of an address share a counter
+func accountKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
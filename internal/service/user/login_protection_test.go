@@ -0,0 +1,236 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ktsiligkos/xm_project/internal/correlation"
+	"github.com/ktsiligkos/xm_project/internal/domain"
+)
+
+// memoryLoginAttempts keeps the counters in a map keyed by scope and key
+type memoryLoginAttempts struct {
+	mu       sync.Mutex
+	attempts map[[2]string]domain.LoginAttempts
+}
+
+func newMemoryLoginAttempts() *memoryLoginAttempts {
+	return &memoryLoginAttempts{attempts: map[[2]string]domain.LoginAttempts{}}
+}
+
+func (r *memoryLoginAttempts) GetLoginAttempts(_ context.Context, scope, key string) (domain.LoginAttempts, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if attempts, ok := r.attempts[[2]string{scope, key}]; ok {
+		return attempts, nil
+	}
+	return domain.LoginAttempts{Scope: scope, Key: key}, nil
+}
+
+func (r *memoryLoginAttempts) RecordLoginFailure(_ context.Context, scope, key string, at, resetBefore time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	attempts := r.attempts[[2]string{scope, key}]
+	if attempts.LastFailureAt.Before(resetBefore) {
+		attempts.Failures = 0
+	}
+	attempts.Scope, attempts.Key = scope, key
+	attempts.Failures++
+	attempts.LastFailureAt = at
+	r.attempts[[2]string{scope, key}] = attempts
+	return attempts.Failures, nil
+}
+
+func (r *memoryLoginAttempts) ReserveLoginAttempt(_ context.Context, scope, key string, at, resetBefore time.Time, blockFor func(int) time.Duration) (domain.LoginAttempts, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	attempts := r.attempts[[2]string{scope, key}]
+	if attempts.BlockedUntil != nil && attempts.BlockedUntil.After(at) {
+		return attempts, false, nil
+	}
+	if attempts.LastFailureAt.Before(resetBefore) {
+		attempts.Failures = 0
+	}
+	attempts.Scope, attempts.Key = scope, key
+	attempts.Failures++
+	attempts.LastFailureAt = at
+	if block := blockFor(attempts.Failures); block > 0 {
+		until := at.Add(block)
+		attempts.BlockedUntil = &until
+	}
+	r.attempts[[2]string{scope, key}] = attempts
+	return attempts, true, nil
+}
+
+func (r *memoryLoginAttempts) ReleaseLoginAttempt(_ context.Context, scope, key string, blockedUntil *time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	attempts, ok := r.attempts[[2]string{scope, key}]
+	if !ok {
+		return nil
+	}
+	attempts.Failures = max(attempts.Failures-1, 0)
+	if attempts.BlockedUntil != nil && blockedUntil != nil && attempts.BlockedUntil.Equal(*blockedUntil) {
+		attempts.BlockedUntil = nil
+	}
+	r.attempts[[2]string{scope, key}] = attempts
+	return nil
+}
+
+func (r *memoryLoginAttempts) ResetLoginAttempts(_ context.Context, scope, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.attempts, [2]string{scope, key})
+	return nil
+}
+
+func (r *memoryLoginAttempts) DeleteStaleLoginAttempts(_ context.Context, before time.Time) error {
+	for id, attempts := range r.attempts {
+		if attempts.LastFailureAt.Before(before) && (attempts.BlockedUntil == nil || attempts.BlockedUntil.Before(before)) {
+			delete(r.attempts, id)
+		}
+	}
+	return nil
+}
+
+// unblock ends every block, as if the delay had passed
+func (r *memoryLoginAttempts) unblock() {
+	for id, attempts := range r.attempts {
+		attempts.BlockedUntil = nil
+		r.attempts[id] = attempts
+	}
+}
+
+var testLoginProtection = LoginProtection{
+	Account:         LoginLimits{FreeAttempts: 2, LockoutThreshold: 5},
+	IP:              LoginLimits{FreeAttempts: 3, LockoutThreshold: 8},
+	BaseDelay:       time.Second,
+	MaxDelay:        4 * time.Second,
+	LockoutDuration: 15 * time.Minute,
+}
+
+func givenProtectedService(t *testing.T) (*Service, *memoryLoginAttempts, domain.User) {
+	t.Helper()
+	service, _, user := givenServiceWithUser(t, "correct")
+	attempts := newMemoryLoginAttempts()
+	WithLoginProtection(attempts, testLoginProtection)(service)
+	return service, attempts, user
+}
+
+// blockedFor returns how long logins of the key are still refused
+func (r *memoryLoginAttempts) blockedFor(scope, key string) time.Duration {
+	attempts := r.attempts[[2]string{scope, key}]
+	if attempts.BlockedUntil == nil {
+		return 0
+	}
+	return time.Until(*attempts.BlockedUntil)
+}
+
+func TestAuthenticateUser_DelaysAndLocksAfterRepeatedFailures(t *testing.T) {
+	service, attempts, user := givenProtectedService(t)
+	wrong := domain.UserLoginRequest{Email: user.Email, Password: "wrong"}
+
+	for i, want := range []time.Duration{0, 0, time.Second, 2 * time.Second, 15 * time.Minute} {
+		attempts.unblock()
+		if _, err := service.AuthenticateUser(context.Background(), wrong); !errors.Is(err, ErrAuthFailed) {
+			t.Fatalf("failure %d: expected ErrAuthFailed, got %v", i+1, err)
+		}
+		if got := attempts.blockedFor(domain.LoginScopeAccount, user.Email); got > want || (want > 0 && got <= want-time.Second) {
+			t.Fatalf("failure %d: expected a block of %s, got %s", i+1, want, got)
+		}
+	}
+
+	_, err := service.AuthenticateUser(context.Background(), domain.UserLoginRequest{Email: user.Email, Password: "correct"})
+	var throttled *LoginThrottledError
+	if !errors.As(err, &throttled) || !errors.Is(err, ErrLoginThrottled) {
+		t.Fatalf("expected the locked account to refuse even the right password, got %v", err)
+	}
+	if throttled.RetryAfter <= 14*time.Minute {
+		t.Fatalf("expected to retry after the lockout, got %s", throttled.RetryAfter)
+	}
+
+	if err := service.UnlockUser(context.Background(), user.ID); err != nil {
+		t.Fatalf("UnlockUser returned error: %v", err)
+	}
+	if _, err := service.AuthenticateUser(context.Background(), domain.UserLoginRequest{Email: user.Email, Password: "correct"}); err != nil {
+		t.Fatalf("expected the unlocked user to log in, got %v", err)
+	}
+}
+
+func TestAuthenticateUser_CountsFailuresPerClientIP(t *testing.T) {
+	service, attempts, user := givenProtectedService(t)
+	ctx := correlation.WithClientIP(context.Background(), "10.0.0.1")
+
+	// guesses spread over many addresses stay below the account limits but not the IP limits
+	for i := 0; i < testLoginProtection.IP.LockoutThreshold; i++ {
+		attempts.unblock()
+		email := fmt.Sprintf("guess-%d@example.com", i)
+		if _, err := service.AuthenticateUser(ctx, domain.UserLoginRequest{Email: email, Password: "x"}); !errors.Is(err, ErrAuthFailed) {
+			t.Fatalf("guess %d: expected unknown emails to fail like wrong passwords, got %v", i, err)
+		}
+	}
+
+	if _, err := service.AuthenticateUser(ctx, domain.UserLoginRequest{Email: user.Email, Password: "correct"}); !errors.Is(err, ErrLoginThrottled) {
+		t.Fatalf("expected the locked IP to be refused, got %v", err)
+	}
+	other := correlation.WithClientIP(context.Background(), "10.0.0.2")
+	if _, err := service.AuthenticateUser(other, domain.UserLoginRequest{Email: user.Email, Password: "correct"}); err != nil {
+		t.Fatalf("expected other clients to log in, got %v", err)
+	}
+}
+
+func TestAuthenticateUser_SuccessResetsTheAccountOnly(t *testing.T) {
+	service, attempts, user := givenProtectedService(t)
+	ctx := correlation.WithClientIP(context.Background(), "10.0.0.1")
+
+	_, _ = service.AuthenticateUser(ctx, domain.UserLoginRequest{Email: user.Email, Password: "wrong"})
+	if _, err := service.AuthenticateUser(ctx, domain.UserLoginRequest{Email: user.Email, Password: "correct"}); err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+
+	if account, _ := attempts.GetLoginAttempts(ctx, domain.LoginScopeAccount, user.Email); account.Failures != 0 {
+		t.Fatalf("expected the account counter to be reset, got %d failures", account.Failures)
+	}
+	if ip, _ := attempts.GetLoginAttempts(ctx, domain.LoginScopeIP, "10.0.0.1"); ip.Failures != 1 {
+		t.Fatalf("expected the IP counter to be kept, got %d failures", ip.Failures)
+	}
+}
+
+func TestAuthenticateUser_ConcurrentGuessesCannotSkipTheDelay(t *testing.T) {
+	service, _, user := givenProtectedService(t)
+	service.publisher = nil
+	wrong := domain.UserLoginRequest{Email: user.Email, Password: "wrong"}
+
+	// every guess arrives before any of them is answered
+	const guesses = 20
+	results := make(chan error, guesses)
+	var wg sync.WaitGroup
+	for range guesses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := service.AuthenticateUser(context.Background(), wrong)
+			results <- err
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	checked := 0
+	for err := range results {
+		switch {
+		case errors.Is(err, ErrAuthFailed):
+			checked++
+		case !errors.Is(err, ErrLoginThrottled):
+			t.Fatalf("expected failed or throttled logins, got %v", err)
+		}
+	}
+	// the free attempts, plus the one whose count starts the first delay
+	if want := testLoginProtection.Account.FreeAttempts + 1; checked != want {
+		t.Fatalf("expected %d passwords to be checked, got %d", want, checked)
+	}
+}
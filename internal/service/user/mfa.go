@@ -0,0 +1,563 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ktsiligkos/xm_project/internal/auth/totp"
+	"github.com/ktsiligkos/xm_project/internal/correlation"
+	"github.com/ktsiligkos/xm_project/internal/domain"
+	mfarepository "github.com/ktsiligkos/xm_project/internal/repository/mfa"
+)
+
+// Errors returned by the MFA enrollment and the second login step
+var (
+	ErrMFANotConfigured    = errors.New("mfa is not configured")
+	ErrInvalidMFAChallenge = errors.New("invalid or expired mfa token")
+	ErrInvalidMFACode      = errors.New("invalid mfa code")
+	ErrMFAAlreadyEnabled   = errors.New("mfa is already enabled")
+	ErrMFANotEnabled       = errors.New("mfa is not enabled")
+	// ErrMFARequired refuses to turn MFA off for a user whose role requires it
+	ErrMFARequired = errors.New("mfa is required for a role of the user")
+	// ErrMFAEnrollmentRequired refuses the login of a user whose role requires MFA but who has
+	// not enrolled from a session: the password alone must not decide whose device gets bound
+	ErrMFAEnrollmentRequired = errors.New("mfa enrollment required")
+)
+
+const (
+	defaultMFAIssuer       = "XM Companies"
+	defaultMFAChallengeTTL = 5 * time.Minute
+	defaultMFAMaxAttempts  = 5
+	// mfaSkew accepts the codes of the previous and the next time step, for clocks that drift
+	mfaSkew           = 1
+	recoveryCodeCount = 10
+	// recoveryCodeBytes give 10 base32 characters, shown as two groups of five
+	recoveryCodeBytes = 5
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// MFAConfig tunes the TOTP enrollment and the second login step
+type MFAConfig struct {
+	// Issuer names the service in authenticator apps, XM Companies by default
+	Issuer string
+	// ChallengeTTL is how long the second step can be completed after the password, 5 minutes by default
+	ChallengeTTL time.Duration
+	// MaxAttempts wrong codes drop the challenge and the password has to be entered again, 5 by default
+	MaxAttempts int
+}
+
+// WithMFA lets users protect their login with TOTP codes, and admins require it for roles
+func WithMFA(repo mfarepository.Repository, cfg MFAConfig) Option {
+	return func(s *Service) {
+		if cfg.Issuer == "" {
+			cfg.Issuer = defaultMFAIssuer
+		}
+		if cfg.ChallengeTTL <= 0 {
+			cfg.ChallengeTTL = defaultMFAChallengeTTL
+		}
+		if cfg.MaxAttempts <= 0 {
+			cfg.MaxAttempts = defaultMFAMaxAttempts
+		}
+		s.mfa = repo
+		s.mfaCfg = cfg
+	}
+}
+
+// VerifyMFALogin completes a login with a TOTP code or a recovery code. Wrong codes count as
+// failed logins of the account, so guessing codes is throttled like guessing passwords.
+// A pending enrollment, started from a session before a role required MFA, is confirmed by its first code.
+func (s *Service) VerifyMFALogin(ctx context.Context, req domain.MFALoginRequest) (domain.TokenPair, error) {
+	if s.mfa == nil {
+		return domain.TokenPair{}, ErrMFANotConfigured
+	}
+
+	challenge, user, err := s.mfaChallenge(ctx, req.MFAToken)
+	if err != nil {
+		return domain.TokenPair{}, err
+	}
+	// the attempt counts as failed until the code proves right
+	reservation, err := s.reserveLogin(ctx, user.Email)
+	if err != nil {
+		s.publish(ctx, UserEvent{Operation: OperationLoginFailed, User: toEventUser(user), Reason: LoginFailedThrottled})
+		return domain.TokenPair{}, err
+	}
+
+	enrollment, err := s.mfa.GetMFAEnrollment(ctx, user.ID)
+	if err != nil {
+		s.releaseLogin(ctx, reservation)
+		if errors.Is(err, mfarepository.ErrNotFound) {
+			return domain.TokenPair{}, ErrMFANotEnabled
+		}
+		return domain.TokenPair{}, err
+	}
+
+	ok, err := s.verifyMFACode(ctx, enrollment, req.Code)
+	if err != nil {
+		s.releaseLogin(ctx, reservation)
+		return domain.TokenPair{}, err
+	}
+	if !ok {
+		if _, err := s.mfa.RecordMFAChallengeAttempt(ctx, challenge.ID); err != nil {
+			log.Printf("record mfa attempt of user %s: %v", user.ID, err)
+		}
+		s.publish(ctx, UserEvent{Operation: OperationLoginFailed, User: toEventUser(user), Reason: LoginFailedInvalidMFACode})
+		return domain.TokenPair{}, fmt.Errorf("user %s: %w", user.ID, ErrInvalidMFACode)
+	}
+	s.releaseLogin(ctx, reservation)
+
+	now := time.Now().UTC()
+	used, err := s.mfa.MarkMFAChallengeUsed(ctx, challenge.ID, now)
+	if err != nil {
+		return domain.TokenPair{}, err
+	}
+	if !used {
+		return domain.TokenPair{}, ErrInvalidMFAChallenge
+	}
+	if !enrollment.Confirmed() {
+		if err := s.mfa.ConfirmMFAEnrollment(ctx, user.ID, now); err != nil {
+			return domain.TokenPair{}, err
+		}
+		s.publish(ctx, UserEvent{Operation: OperationMFAEnabled, User: toEventUser(user)})
+	}
+
+	tokens, err := s.issueTokens(ctx, user, "", true)
+	if err != nil {
+		return domain.TokenPair{}, err
+	}
+
+	s.recordLoginSuccess(ctx, user.Email)
+	s.publish(ctx, UserEvent{Operation: OperationLoginSucceeded, User: toEventUser(user)})
+	return tokens, nil
+}
+
+// StartMFAEnrollment creates a new TOTP secret and recovery codes for the user. They stay
+// pending, and logins keep working without a code, until ConfirmMFAEnrollment.
+func (s *Service) StartMFAEnrollment(ctx context.Context, userID string) (domain.TOTPEnrollment, error) {
+	if s.mfa == nil {
+		return domain.TOTPEnrollment{}, ErrMFANotConfigured
+	}
+
+	user, err := s.GetUser(ctx, userID)
+	if err != nil {
+		return domain.TOTPEnrollment{}, err
+	}
+	return s.startMFAEnrollment(ctx, user)
+}
+
+// ConfirmMFAEnrollment turns MFA on with a first code from the authenticator app
+func (s *Service) ConfirmMFAEnrollment(ctx context.Context, userID, code string) error {
+	if s.mfa == nil {
+		return ErrMFANotConfigured
+	}
+
+	user, enrollment, err := s.mfaEnrollment(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if enrollment.Confirmed() {
+		return ErrMFAAlreadyEnabled
+	}
+
+	ok, err := s.verifyMFACode(ctx, enrollment, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidMFACode
+	}
+	if err := s.mfa.ConfirmMFAEnrollment(ctx, user.ID, time.Now().UTC()); err != nil {
+		return err
+	}
+
+	s.publish(ctx, UserEvent{Operation: OperationMFAEnabled, User: toEventUser(user)})
+	return nil
+}
+
+// DisableMFA turns MFA off after checking a TOTP or recovery code, unless a role of the user requires it
+func (s *Service) DisableMFA(ctx context.Context, userID, code string) error {
+	if s.mfa == nil {
+		return ErrMFANotConfigured
+	}
+
+	user, enrollment, err := s.mfaEnrollment(ctx, userID)
+	if err != nil {
+		return err
+	}
+	required, err := s.mfaRequired(ctx, user)
+	if err != nil {
+		return err
+	}
+	if required {
+		return ErrMFARequired
+	}
+
+	ok, err := s.verifyMFACode(ctx, enrollment, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidMFACode
+	}
+	if err := s.mfa.DeleteMFAEnrollment(ctx, user.ID); err != nil {
+		return err
+	}
+
+	if enrollment.Confirmed() {
+		s.publish(ctx, UserEvent{Operation: OperationMFADisabled, User: toEventUser(user)})
+	}
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes after checking a TOTP code
+func (s *Service) RegenerateRecoveryCodes(ctx context.Context, userID, code string) ([]string, error) {
+	if s.mfa == nil {
+		return nil, ErrMFANotConfigured
+	}
+
+	_, enrollment, err := s.mfaEnrollment(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !enrollment.Confirmed() {
+		return nil, ErrMFANotEnabled
+	}
+
+	// a recovery code must not be able to mint new ones
+	if !isTOTPCode(strings.TrimSpace(code)) {
+		return nil, ErrInvalidMFACode
+	}
+	ok, err := s.verifyMFACode(ctx, enrollment, code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	plain, codes, err := newRecoveryCodes(userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.mfa.ReplaceRecoveryCodes(ctx, userID, codes); err != nil {
+		return nil, err
+	}
+	return plain, nil
+}
+
+// MFAStatus tells the user whether MFA is on, and whether they could turn it off
+func (s *Service) MFAStatus(ctx context.Context, userID string) (domain.MFAStatus, error) {
+	if s.mfa == nil {
+		return domain.MFAStatus{}, ErrMFANotConfigured
+	}
+
+	user, err := s.GetUser(ctx, userID)
+	if err != nil {
+		return domain.MFAStatus{}, err
+	}
+	var status domain.MFAStatus
+	if status.Required, err = s.mfaRequired(ctx, user); err != nil {
+		return domain.MFAStatus{}, err
+	}
+
+	enrollment, err := s.mfa.GetMFAEnrollment(ctx, user.ID)
+	if errors.Is(err, mfarepository.ErrNotFound) {
+		return status, nil
+	}
+	if err != nil {
+		return domain.MFAStatus{}, err
+	}
+	status.Enabled = enrollment.Confirmed()
+	status.Pending = !enrollment.Confirmed()
+	if status.Enabled {
+		if status.RecoveryCodesRemaining, err = s.mfa.CountRecoveryCodes(ctx, user.ID); err != nil {
+			return domain.MFAStatus{}, err
+		}
+	}
+	return status, nil
+}
+
+// ResetMFA removes the enrollment of a user who lost their authenticator app and recovery codes.
+// When a role requires MFA the user cannot log in until the role no longer requires it.
+func (s *Service) ResetMFA(ctx context.Context, userID string) error {
+	if s.mfa == nil {
+		return ErrMFANotConfigured
+	}
+
+	user, err := s.GetUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := s.mfa.DeleteMFAEnrollment(ctx, user.ID); err != nil {
+		return err
+	}
+
+	s.publish(ctx, UserEvent{Operation: OperationMFADisabled, User: toEventUser(user)})
+	return nil
+}
+
+// MFARequiredRoles lists the roles whose users must log in with a second factor
+func (s *Service) MFARequiredRoles(ctx context.Context) ([]string, error) {
+	if s.mfa == nil {
+		return nil, ErrMFANotConfigured
+	}
+	return s.mfa.GetMFARequiredRoles(ctx)
+}
+
+// SetMFARequiredRoles replaces the roles that require MFA. It applies from the next login;
+// users of those roles who have not started an enrollment from a session are refused.
+func (s *Service) SetMFARequiredRoles(ctx context.Context, roles []string) ([]string, error) {
+	if s.mfa == nil {
+		return nil, ErrMFANotConfigured
+	}
+
+	normalized, err := normalizeRoles(roles)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.mfa.SetMFARequiredRoles(ctx, normalized); err != nil {
+		return nil, err
+	}
+	return normalized, nil
+}
+
+// PruneMFAChallenges drops the pending logins that can no longer be completed
+func (s *Service) PruneMFAChallenges(ctx context.Context) error {
+	if s.mfa == nil {
+		return nil
+	}
+	return s.mfa.DeleteExpiredMFAChallenges(ctx, time.Now().UTC())
+}
+
+// pendingMFA starts the second login step when the user enrolled or a role requires MFA,
+// it returns nil when the password is enough. A role requiring MFA of a user without an
+// enrollment refuses the login with ErrMFAEnrollmentRequired.
+func (s *Service) pendingMFA(ctx context.Context, user domain.User) (*domain.PendingMFA, error) {
+	if s.mfa == nil {
+		return nil, nil
+	}
+
+	enrolled, needed, err := s.secondFactorNeeded(ctx, user)
+	if err != nil || !needed {
+		return nil, err
+	}
+	if !enrolled {
+		// a pending enrollment was started from a session and its first code confirms it
+		if _, err := s.mfa.GetMFAEnrollment(ctx, user.ID); err != nil {
+			if errors.Is(err, mfarepository.ErrNotFound) {
+				return nil, fmt.Errorf("user %s: %w", user.ID, ErrMFAEnrollmentRequired)
+			}
+			return nil, err
+		}
+	}
+
+	token, err := newOpaqueToken()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrTokenGenerationFailed, err)
+	}
+	now := time.Now().UTC()
+	if err := s.mfa.SaveMFAChallenge(ctx, domain.MFAChallenge{
+		ID:        uuid.NewString(),
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+		ExpiresAt: now.Add(s.mfaCfg.ChallengeTTL),
+		CreatedAt: now,
+	}); err != nil {
+		return nil, err
+	}
+
+	return &domain.PendingMFA{Token: token, TTL: s.mfaCfg.ChallengeTTL}, nil
+}
+
+// mfaChallenge returns the pending login of the token and its user, while it can still be completed
+func (s *Service) mfaChallenge(ctx context.Context, token string) (domain.MFAChallenge, domain.User, error) {
+	challenge, err := s.mfa.GetMFAChallengeByHash(ctx, hashToken(token))
+	if err != nil {
+		if errors.Is(err, mfarepository.ErrNotFound) {
+			return domain.MFAChallenge{}, domain.User{}, ErrInvalidMFAChallenge
+		}
+		return domain.MFAChallenge{}, domain.User{}, err
+	}
+	switch {
+	case challenge.UsedAt != nil:
+		return domain.MFAChallenge{}, domain.User{}, fmt.Errorf("%w: used", ErrInvalidMFAChallenge)
+	case !time.Now().UTC().Before(challenge.ExpiresAt):
+		return domain.MFAChallenge{}, domain.User{}, fmt.Errorf("%w: expired", ErrInvalidMFAChallenge)
+	case challenge.Attempts >= s.mfaCfg.MaxAttempts:
+		return domain.MFAChallenge{}, domain.User{}, fmt.Errorf("%w: too many wrong codes", ErrInvalidMFAChallenge)
+	}
+
+	user, err := s.GetUser(correlation.WithAllTenants(ctx), challenge.UserID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return domain.MFAChallenge{}, domain.User{}, ErrInvalidMFAChallenge
+		}
+		return domain.MFAChallenge{}, domain.User{}, err
+	}
+	if user.Disabled() {
+		return domain.MFAChallenge{}, domain.User{}, fmt.Errorf("user %s is disabled: %w", user.ID, ErrAuthFailed)
+	}
+	return challenge, user, nil
+}
+
+// mfaEnrollment returns the user and their enrollment, ErrMFANotEnabled when there is none
+func (s *Service) mfaEnrollment(ctx context.Context, userID string) (domain.User, domain.MFAEnrollment, error) {
+	user, err := s.GetUser(ctx, userID)
+	if err != nil {
+		return domain.User{}, domain.MFAEnrollment{}, err
+	}
+	enrollment, err := s.mfa.GetMFAEnrollment(ctx, user.ID)
+	if err != nil {
+		if errors.Is(err, mfarepository.ErrNotFound) {
+			return domain.User{}, domain.MFAEnrollment{}, ErrMFANotEnabled
+		}
+		return domain.User{}, domain.MFAEnrollment{}, err
+	}
+	return user, enrollment, nil
+}
+
+func (s *Service) startMFAEnrollment(ctx context.Context, user domain.User) (domain.TOTPEnrollment, error) {
+	existing, err := s.mfa.GetMFAEnrollment(ctx, user.ID)
+	switch {
+	case err == nil && existing.Confirmed():
+		return domain.TOTPEnrollment{}, ErrMFAAlreadyEnabled
+	case err != nil && !errors.Is(err, mfarepository.ErrNotFound):
+		return domain.TOTPEnrollment{}, err
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return domain.TOTPEnrollment{}, fmt.Errorf("generate totp secret: %w", err)
+	}
+	plain, codes, err := newRecoveryCodes(user.ID)
+	if err != nil {
+		return domain.TOTPEnrollment{}, err
+	}
+	if err := s.mfa.SaveMFAEnrollment(ctx, domain.MFAEnrollment{
+		UserID:    user.ID,
+		Secret:    secret,
+		CreatedAt: time.Now().UTC(),
+	}, codes); err != nil {
+		return domain.TOTPEnrollment{}, err
+	}
+
+	return domain.TOTPEnrollment{
+		Secret:          secret,
+		ProvisioningURI: totp.ProvisioningURI(s.mfaCfg.Issuer, user.Email, secret),
+		RecoveryCodes:   plain,
+	}, nil
+}
+
+// verifyMFACode accepts a TOTP code once, or an unused recovery code of a confirmed enrollment
+func (s *Service) verifyMFACode(ctx context.Context, enrollment domain.MFAEnrollment, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	if isTOTPCode(code) {
+		step, ok := totp.Validate(enrollment.Secret, code, time.Now(), mfaSkew)
+		if !ok {
+			return false, nil
+		}
+		return s.mfa.UseTOTPStep(ctx, enrollment.UserID, step)
+	}
+
+	// recovery codes only stand in for an authenticator app the user has proven to hold
+	if !enrollment.Confirmed() {
+		return false, nil
+	}
+	used, err := s.mfa.UseRecoveryCode(ctx, enrollment.UserID, hashToken(normalizeRecoveryCode(code)), time.Now().UTC())
+	if err != nil {
+		return false, err
+	}
+	if used {
+		log.Printf("user %s used a recovery code", enrollment.UserID)
+	}
+	return used, nil
+}
+
+// secondFactorNeeded reports whether the user confirmed an enrollment, and whether logins
+// need a second factor because of it or because a role of the user requires MFA
+func (s *Service) secondFactorNeeded(ctx context.Context, user domain.User) (enrolled bool, needed bool, err error) {
+	if s.mfa == nil {
+		return false, false, nil
+	}
+
+	enrollment, err := s.mfa.GetMFAEnrollment(ctx, user.ID)
+	switch {
+	case err == nil:
+		enrolled = enrollment.Confirmed()
+	case !errors.Is(err, mfarepository.ErrNotFound):
+		return false, false, fmt.Errorf("load mfa enrollment: %w", err)
+	}
+	if enrolled {
+		return true, true, nil
+	}
+
+	required, err := s.mfaRequired(ctx, user)
+	if err != nil {
+		return false, false, err
+	}
+	return false, required, nil
+}
+
+// mfaRequired reports whether a role of the user requires MFA
+func (s *Service) mfaRequired(ctx context.Context, user domain.User) (bool, error) {
+	roles, err := s.mfa.GetMFARequiredRoles(ctx)
+	if err != nil {
+		return false, fmt.Errorf("load mfa required roles: %w", err)
+	}
+	for _, role := range user.Roles {
+		if slices.Contains(roles, role) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// newRecoveryCodes returns the codes to show once and their stored form
+func newRecoveryCodes(userID string) ([]string, []domain.MFARecoveryCode, error) {
+	now := time.Now().UTC()
+	plain := make([]string, 0, recoveryCodeCount)
+	codes := make([]domain.MFARecoveryCode, 0, recoveryCodeCount)
+	for range recoveryCodeCount {
+		buf := make([]byte, recoveryCodeBytes)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, fmt.Errorf("generate recovery code: %w", err)
+		}
+		code := strings.ToLower(recoveryCodeEncoding.EncodeToString(buf))
+		plain = append(plain, code[:5]+"-"+code[5:])
+		codes = append(codes, domain.MFARecoveryCode{
+			ID:        uuid.NewString(),
+			UserID:    userID,
+			CodeHash:  hashToken(code),
+			CreatedAt: now,
+		})
+	}
+	return plain, codes, nil
+}
+
+// normalizeRecoveryCode accepts the code with or without the dash, in any case
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// isTOTPCode tells TOTP codes, which are all digits, from recovery codes
+func isTOTPCode(code string) bool {
+	if len(code) != totp.Digits {
+		return false
+	}
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,376 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ktsiligkos/xm_project/internal/auth"
+	"github.com/ktsiligkos/xm_project/internal/auth/totp"
+	"github.com/ktsiligkos/xm_project/internal/domain"
+	mfarepository "github.com/ktsiligkos/xm_project/internal/repository/mfa"
+)
+
+// memoryMFA keeps enrollments by user ID and challenges by ID
+type memoryMFA struct {
+	enrollments   map[string]domain.MFAEnrollment
+	recoveryCodes map[string][]domain.MFARecoveryCode
+	challenges    map[string]domain.MFAChallenge
+	requiredRoles []string
+}
+
+func newMemoryMFA() *memoryMFA {
+	return &memoryMFA{
+		enrollments:   map[string]domain.MFAEnrollment{},
+		recoveryCodes: map[string][]domain.MFARecoveryCode{},
+		challenges:    map[string]domain.MFAChallenge{},
+	}
+}
+
+func (m *memoryMFA) GetMFAEnrollment(_ context.Context, userID string) (domain.MFAEnrollment, error) {
+	enrollment, ok := m.enrollments[userID]
+	if !ok {
+		return domain.MFAEnrollment{}, mfarepository.ErrNotFound
+	}
+	return enrollment, nil
+}
+
+func (m *memoryMFA) SaveMFAEnrollment(_ context.Context, enrollment domain.MFAEnrollment, codes []domain.MFARecoveryCode) error {
+	m.enrollments[enrollment.UserID] = enrollment
+	m.recoveryCodes[enrollment.UserID] = codes
+	return nil
+}
+
+func (m *memoryMFA) ConfirmMFAEnrollment(_ context.Context, userID string, at time.Time) error {
+	enrollment := m.enrollments[userID]
+	enrollment.ConfirmedAt = &at
+	m.enrollments[userID] = enrollment
+	return nil
+}
+
+func (m *memoryMFA) DeleteMFAEnrollment(_ context.Context, userID string) error {
+	delete(m.enrollments, userID)
+	delete(m.recoveryCodes, userID)
+	return nil
+}
+
+func (m *memoryMFA) UseTOTPStep(_ context.Context, userID string, step int64) (bool, error) {
+	enrollment, ok := m.enrollments[userID]
+	if !ok || enrollment.LastUsedStep >= step {
+		return false, nil
+	}
+	enrollment.LastUsedStep = step
+	m.enrollments[userID] = enrollment
+	return true, nil
+}
+
+func (m *memoryMFA) ReplaceRecoveryCodes(_ context.Context, userID string, codes []domain.MFARecoveryCode) error {
+	m.recoveryCodes[userID] = codes
+	return nil
+}
+
+func (m *memoryMFA) UseRecoveryCode(_ context.Context, userID, codeHash string, at time.Time) (bool, error) {
+	for i, code := range m.recoveryCodes[userID] {
+		if code.CodeHash == codeHash && code.UsedAt == nil {
+			m.recoveryCodes[userID][i].UsedAt = &at
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *memoryMFA) CountRecoveryCodes(_ context.Context, userID string) (int, error) {
+	count := 0
+	for _, code := range m.recoveryCodes[userID] {
+		if code.UsedAt == nil {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *memoryMFA) SaveMFAChallenge(_ context.Context, challenge domain.MFAChallenge) error {
+	m.challenges[challenge.ID] = challenge
+	return nil
+}
+
+func (m *memoryMFA) GetMFAChallengeByHash(_ context.Context, tokenHash string) (domain.MFAChallenge, error) {
+	for _, challenge := range m.challenges {
+		if challenge.TokenHash == tokenHash {
+			return challenge, nil
+		}
+	}
+	return domain.MFAChallenge{}, mfarepository.ErrNotFound
+}
+
+func (m *memoryMFA) RecordMFAChallengeAttempt(_ context.Context, id string) (int, error) {
+	challenge := m.challenges[id]
+	challenge.Attempts++
+	m.challenges[id] = challenge
+	return challenge.Attempts, nil
+}
+
+func (m *memoryMFA) MarkMFAChallengeUsed(_ context.Context, id string, at time.Time) (bool, error) {
+	challenge, ok := m.challenges[id]
+	if !ok || challenge.UsedAt != nil {
+		return false, nil
+	}
+	challenge.UsedAt = &at
+	m.challenges[id] = challenge
+	return true, nil
+}
+
+func (m *memoryMFA) DeleteExpiredMFAChallenges(_ context.Context, before time.Time) error {
+	for id, challenge := range m.challenges {
+		if challenge.ExpiresAt.Before(before) {
+			delete(m.challenges, id)
+		}
+	}
+	return nil
+}
+
+func (m *memoryMFA) GetMFARequiredRoles(context.Context) ([]string, error) {
+	return m.requiredRoles, nil
+}
+
+func (m *memoryMFA) SetMFARequiredRoles(_ context.Context, roles []string) error {
+	m.requiredRoles = roles
+	return nil
+}
+
+func givenMFAService(t *testing.T, roles ...string) (*Service, *memoryMFA, domain.User) {
+	t.Helper()
+	service, _, user := givenServiceWithUser(t, "correct horse")
+	repo := service.repo.(*memoryRepository)
+	user.Roles = roles
+	repo.users[user.ID] = user
+
+	mfa := newMemoryMFA()
+	WithMFA(mfa, MFAConfig{MaxAttempts: 3})(service)
+	return service, mfa, user
+}
+
+// codeAt returns the TOTP code of the secret for the current time step plus offset
+func codeAt(t *testing.T, secret string, offset int64) string {
+	t.Helper()
+	code, err := totp.Code(secret, totp.Step(time.Now())+offset)
+	if err != nil {
+		t.Fatalf("totp code: %v", err)
+	}
+	return code
+}
+
+func login(t *testing.T, service *Service, user domain.User) domain.TokenPair {
+	t.Helper()
+	tokens, err := service.AuthenticateUser(context.Background(), domain.UserLoginRequest{Email: user.Email, Password: "correct horse"})
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	return tokens
+}
+
+func TestMFA_EnrolledUsersNeedASecondFactor(t *testing.T) {
+	service, _, user := givenMFAService(t)
+	ctx := context.Background()
+
+	enrollment, err := service.StartMFAEnrollment(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("StartMFAEnrollment returned error: %v", err)
+	}
+	if len(enrollment.RecoveryCodes) != recoveryCodeCount || enrollment.ProvisioningURI == "" {
+		t.Fatalf("unexpected enrollment %+v", enrollment)
+	}
+	if tokens := login(t, service, user); tokens.MFA != nil || tokens.AccessToken == "" {
+		t.Fatalf("a pending enrollment must not change the login, got %+v", tokens)
+	}
+
+	if err := service.ConfirmMFAEnrollment(ctx, user.ID, "000000"); !errors.Is(err, ErrInvalidMFACode) {
+		t.Fatalf("expected a wrong code to be rejected, got %v", err)
+	}
+	if err := service.ConfirmMFAEnrollment(ctx, user.ID, codeAt(t, enrollment.Secret, -1)); err != nil {
+		t.Fatalf("ConfirmMFAEnrollment returned error: %v", err)
+	}
+
+	tokens := login(t, service, user)
+	if tokens.MFA == nil || tokens.AccessToken != "" {
+		t.Fatalf("expected an mfa challenge instead of tokens, got %+v", tokens)
+	}
+	code := codeAt(t, enrollment.Secret, 0)
+	verified, err := service.VerifyMFALogin(ctx, domain.MFALoginRequest{MFAToken: tokens.MFA.Token, Code: code})
+	if err != nil || verified.AccessToken == "" {
+		t.Fatalf("expected tokens for the right code, got %+v, %v", verified, err)
+	}
+	if _, err := service.VerifyMFALogin(ctx, domain.MFALoginRequest{MFAToken: tokens.MFA.Token, Code: code}); !errors.Is(err, ErrInvalidMFAChallenge) {
+		t.Fatalf("expected the challenge to be single use, got %v", err)
+	}
+
+	// the code was used, a second login in the same time step needs the next code
+	again := login(t, service, user)
+	if _, err := service.VerifyMFALogin(ctx, domain.MFALoginRequest{MFAToken: again.MFA.Token, Code: code}); !errors.Is(err, ErrInvalidMFACode) {
+		t.Fatalf("expected a replayed code to be rejected, got %v", err)
+	}
+}
+
+func TestMFA_RecoveryCodesWorkOnce(t *testing.T) {
+	service, mfa, user := givenMFAService(t)
+	ctx := context.Background()
+	enrollment, _ := service.StartMFAEnrollment(ctx, user.ID)
+	_ = service.ConfirmMFAEnrollment(ctx, user.ID, codeAt(t, enrollment.Secret, 0))
+
+	recovery := enrollment.RecoveryCodes[0]
+	first := login(t, service, user)
+	if _, err := service.VerifyMFALogin(ctx, domain.MFALoginRequest{MFAToken: first.MFA.Token, Code: " " + recovery + " "}); err != nil {
+		t.Fatalf("expected the recovery code to be accepted: %v", err)
+	}
+	second := login(t, service, user)
+	if _, err := service.VerifyMFALogin(ctx, domain.MFALoginRequest{MFAToken: second.MFA.Token, Code: recovery}); !errors.Is(err, ErrInvalidMFACode) {
+		t.Fatalf("expected a used recovery code to be rejected, got %v", err)
+	}
+
+	status, err := service.MFAStatus(ctx, user.ID)
+	if err != nil || !status.Enabled || status.RecoveryCodesRemaining != recoveryCodeCount-1 {
+		t.Fatalf("unexpected status %+v, %v", status, err)
+	}
+
+	if _, err := service.RegenerateRecoveryCodes(ctx, user.ID, enrollment.RecoveryCodes[1]); !errors.Is(err, ErrInvalidMFACode) {
+		t.Fatalf("a recovery code must not regenerate the codes, got %v", err)
+	}
+	codes, err := service.RegenerateRecoveryCodes(ctx, user.ID, codeAt(t, enrollment.Secret, 1))
+	if err != nil || len(codes) != recoveryCodeCount {
+		t.Fatalf("RegenerateRecoveryCodes: %v, %v", codes, err)
+	}
+	if count, _ := mfa.CountRecoveryCodes(ctx, user.ID); count != recoveryCodeCount {
+		t.Fatalf("expected a fresh set of codes, %d are unused", count)
+	}
+}
+
+func TestMFA_WrongCodesDropTheChallenge(t *testing.T) {
+	service, _, user := givenMFAService(t)
+	ctx := context.Background()
+	enrollment, _ := service.StartMFAEnrollment(ctx, user.ID)
+	_ = service.ConfirmMFAEnrollment(ctx, user.ID, codeAt(t, enrollment.Secret, 0))
+
+	tokens := login(t, service, user)
+	for range 3 {
+		if _, err := service.VerifyMFALogin(ctx, domain.MFALoginRequest{MFAToken: tokens.MFA.Token, Code: "not-a-code"}); !errors.Is(err, ErrInvalidMFACode) {
+			t.Fatalf("expected ErrInvalidMFACode, got %v", err)
+		}
+	}
+	_, err := service.VerifyMFALogin(ctx, domain.MFALoginRequest{MFAToken: tokens.MFA.Token, Code: codeAt(t, enrollment.Secret, 1)})
+	if !errors.Is(err, ErrInvalidMFAChallenge) {
+		t.Fatalf("expected the challenge to be dropped after too many wrong codes, got %v", err)
+	}
+}
+
+func TestMFA_RequiredRolesRefuseLoginsWithoutAnEnrollment(t *testing.T) {
+	service, _, user := givenMFAService(t, auth.RoleAdmin)
+	ctx := context.Background()
+	password := domain.UserLoginRequest{Email: user.Email, Password: "correct horse"}
+
+	if _, err := service.SetMFARequiredRoles(ctx, []string{"superuser"}); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected unknown roles to be rejected, got %v", err)
+	}
+	roles, err := service.SetMFARequiredRoles(ctx, []string{" Admin ", auth.RoleManager})
+	if err != nil || !slices.Equal(roles, []string{auth.RoleAdmin, auth.RoleManager}) {
+		t.Fatalf("SetMFARequiredRoles: %v, %v", roles, err)
+	}
+
+	// the password alone must not be enough to bind a device
+	if tokens, err := service.AuthenticateUser(ctx, password); !errors.Is(err, ErrMFAEnrollmentRequired) {
+		t.Fatalf("expected the login to require an enrollment first, got %+v, %v", tokens, err)
+	}
+
+	// an enrollment started from a session is completed by the first code of the login
+	enrollment, err := service.StartMFAEnrollment(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("StartMFAEnrollment returned error: %v", err)
+	}
+	tokens := login(t, service, user)
+	if tokens.MFA == nil {
+		t.Fatalf("expected an mfa challenge, got %+v", tokens)
+	}
+	verified, err := service.VerifyMFALogin(ctx, domain.MFALoginRequest{MFAToken: tokens.MFA.Token, Code: codeAt(t, enrollment.Secret, 0)})
+	if err != nil || verified.AccessToken == "" {
+		t.Fatalf("expected the first code to complete the login, got %+v, %v", verified, err)
+	}
+
+	if status, _ := service.MFAStatus(ctx, user.ID); !status.Enabled || !status.Required {
+		t.Fatalf("expected mfa to be enabled and required, got %+v", status)
+	}
+	if err := service.DisableMFA(ctx, user.ID, codeAt(t, enrollment.Secret, 1)); !errors.Is(err, ErrMFARequired) {
+		t.Fatalf("expected required mfa to stay on, got %v", err)
+	}
+
+	if err := service.ResetMFA(ctx, user.ID); err != nil {
+		t.Fatalf("ResetMFA returned error: %v", err)
+	}
+	if _, err := service.AuthenticateUser(ctx, password); !errors.Is(err, ErrMFAEnrollmentRequired) {
+		t.Fatalf("expected a reset user to be refused until enrolled, got %v", err)
+	}
+}
+
+func TestMFA_DisableNeedsACode(t *testing.T) {
+	service, _, user := givenMFAService(t)
+	ctx := context.Background()
+	enrollment, _ := service.StartMFAEnrollment(ctx, user.ID)
+	_ = service.ConfirmMFAEnrollment(ctx, user.ID, codeAt(t, enrollment.Secret, 0))
+
+	if err := service.DisableMFA(ctx, user.ID, "000000"); !errors.Is(err, ErrInvalidMFACode) {
+		t.Fatalf("expected a wrong code to be rejected, got %v", err)
+	}
+	if err := service.DisableMFA(ctx, user.ID, enrollment.RecoveryCodes[0]); err != nil {
+		t.Fatalf("DisableMFA returned error: %v", err)
+	}
+	if tokens := login(t, service, user); tokens.MFA != nil {
+		t.Fatalf("expected the password to be enough again, got %+v", tokens)
+	}
+}
+
+func TestMFA_PasswordOnlySessionsEndOnceMFAIsNeeded(t *testing.T) {
+	service, _, user := givenMFAService(t)
+	WithRefreshTokens(&memoryRefreshTokens{tokens: map[string]domain.RefreshToken{}}, time.Hour)(service)
+	ctx := context.Background()
+
+	// a session from before the enrollment
+	passwordOnly := login(t, service, user)
+	enrollment, _ := service.StartMFAEnrollment(ctx, user.ID)
+	if _, err := service.Refresh(ctx, passwordOnly.RefreshToken); err != nil {
+		t.Fatalf("a pending enrollment must not end the session: %v", err)
+	}
+	passwordOnly = login(t, service, user)
+	_ = service.ConfirmMFAEnrollment(ctx, user.ID, codeAt(t, enrollment.Secret, 0))
+
+	if _, err := service.Refresh(ctx, passwordOnly.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("expected the password-only session to end, got %v", err)
+	}
+
+	challenge := login(t, service, user)
+	verified, err := service.VerifyMFALogin(ctx, domain.MFALoginRequest{MFAToken: challenge.MFA.Token, Code: codeAt(t, enrollment.Secret, 1)})
+	if err != nil {
+		t.Fatalf("VerifyMFALogin returned error: %v", err)
+	}
+	refreshed, err := service.Refresh(ctx, verified.RefreshToken)
+	if err != nil {
+		t.Fatalf("a verified session should keep refreshing: %v", err)
+	}
+	if _, err := service.Refresh(ctx, refreshed.RefreshToken); err != nil {
+		t.Fatalf("rotated tokens should stay verified: %v", err)
+	}
+}
+
+func TestMFA_PasswordOnlySessionsEndOnceARoleRequiresMFA(t *testing.T) {
+	service, _, user := givenMFAService(t, auth.RoleAdmin)
+	WithRefreshTokens(&memoryRefreshTokens{tokens: map[string]domain.RefreshToken{}}, time.Hour)(service)
+	ctx := context.Background()
+
+	passwordOnly := login(t, service, user)
+	if _, err := service.SetMFARequiredRoles(ctx, []string{auth.RoleAdmin}); err != nil {
+		t.Fatalf("SetMFARequiredRoles returned error: %v", err)
+	}
+
+	if _, err := service.Refresh(ctx, passwordOnly.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("expected the password-only session to end, got %v", err)
+	}
+}
@@ -0,0 +1,248 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ktsiligkos/xm_project/internal/correlation"
+	"github.com/ktsiligkos/xm_project/internal/domain"
+	"github.com/ktsiligkos/xm_project/internal/mail"
+	passwordresetrepository "github.com/ktsiligkos/xm_project/internal/repository/passwordreset"
+	userrepository "github.com/ktsiligkos/xm_project/internal/repository/user"
+)
+
+// Errors returned when a password cannot be reset
+var (
+	ErrInvalidResetToken          = errors.New("invalid or expired password reset token")
+	ErrPasswordResetNotConfigured = errors.New("password reset is not configured")
+	ErrPasswordResetThrottled     = errors.New("too many password reset requests")
+)
+
+const (
+	defaultPasswordResetTTL = time.Hour
+	// resetMailTimeout bounds the lookup and the mail of a reset, which run after the request returned
+	resetMailTimeout = 30 * time.Second
+
+	defaultResetRequestsPerEmail = 3
+	defaultResetRequestsPerIP    = 20
+	defaultResetRequestWindow    = time.Hour
+)
+
+// PasswordResetConfig tunes the reset tokens and the mail that carries them
+type PasswordResetConfig struct {
+	// TokenTTL is how long a reset link stays valid, 1 hour by default
+	TokenTTL time.Duration
+	// ResetURL is the page users open to choose a new password, the token is added as the token query parameter
+	ResetURL string
+	// RequestsPerEmail and RequestsPerIP are the requests accepted per RequestWindow, 3 and 20 per hour
+	// by default. They are counted with the login attempts, so they need WithLoginProtection.
+	RequestsPerEmail int
+	RequestsPerIP    int
+	RequestWindow    time.Duration
+}
+
+// WithPasswordReset lets users reset a forgotten password with a single-use token mailed to them
+func WithPasswordReset(repo passwordresetrepository.Repository, mailer mail.Mailer, cfg PasswordResetConfig) Option {
+	return func(s *Service) {
+		if cfg.TokenTTL <= 0 {
+			cfg.TokenTTL = defaultPasswordResetTTL
+		}
+		if cfg.RequestsPerEmail <= 0 {
+			cfg.RequestsPerEmail = defaultResetRequestsPerEmail
+		}
+		if cfg.RequestsPerIP <= 0 {
+			cfg.RequestsPerIP = defaultResetRequestsPerIP
+		}
+		if cfg.RequestWindow <= 0 {
+			cfg.RequestWindow = defaultResetRequestWindow
+		}
+		s.passwordResets = repo
+		s.mailer = mailer
+		s.passwordResetCfg = cfg
+	}
+}
+
+// RequestPasswordReset mails a reset link when the address belongs to an active user. Requests
+// are throttled per address and per client IP whether or not an account uses the address. The
+// lookup, the token and the mail all happen in the background, so neither the result nor the
+// response time tells whether an account exists.
+func (s *Service) RequestPasswordReset(ctx context.Context, email string) error {
+	if s.passwordResets == nil || s.mailer == nil {
+		return ErrPasswordResetNotConfigured
+	}
+	if err := s.countPasswordResetRequest(ctx, email); err != nil {
+		return err
+	}
+
+	go func() {
+		resetCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), resetMailTimeout)
+		defer cancel()
+		if err := s.sendPasswordReset(resetCtx, email); err != nil {
+			log.Printf("send password reset: %v", err)
+		}
+	}()
+	return nil
+}
+
+// countPasswordResetRequest refuses the request once the address or the client IP used up its
+// requests of the window. Like the login protection it fails open when the counters cannot be written.
+func (s *Service) countPasswordResetRequest(ctx context.Context, email string) error {
+	if s.loginAttempts == nil {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	limits := map[string]int{domain.ResetScopeAccount: s.passwordResetCfg.RequestsPerEmail}
+	keys := map[string]string{domain.ResetScopeAccount: accountKey(email)}
+	if ip := correlation.ClientIP(ctx); ip != "" {
+		limits[domain.ResetScopeIP] = s.passwordResetCfg.RequestsPerIP
+		keys[domain.ResetScopeIP] = ip
+	}
+
+	// every request counts in both scopes, also when one of them refuses it
+	throttled := false
+	for scope, key := range keys {
+		requests, err := s.loginAttempts.RecordLoginFailure(ctx, scope, key, now, now.Add(-s.passwordResetCfg.RequestWindow))
+		if err != nil {
+			log.Printf("count %s password reset request: %v", scope, err)
+			continue
+		}
+		throttled = throttled || requests > limits[scope]
+	}
+
+	if throttled {
+		return ErrPasswordResetThrottled
+	}
+	return nil
+}
+
+// sendPasswordReset replaces the reset tokens of the user behind the address and mails the new link,
+// unknown and disabled addresses are ignored
+func (s *Service) sendPasswordReset(ctx context.Context, email string) error {
+	user, err := s.repo.GetUserByEmail(correlation.WithAllTenants(ctx), accountKey(email))
+	if err != nil {
+		if errors.Is(err, userrepository.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	if user.Disabled() {
+		return nil
+	}
+
+	token, err := newOpaqueToken()
+	if err != nil {
+		return fmt.Errorf("generate password reset token: %w", err)
+	}
+	now := time.Now().UTC()
+	// only the latest link works, an older mail may have reached the wrong hands
+	if err := s.passwordResets.InvalidateUserPasswordResetTokens(ctx, user.ID, now); err != nil {
+		return err
+	}
+	if err := s.passwordResets.SavePasswordResetToken(ctx, domain.PasswordResetToken{
+		ID:        uuid.NewString(),
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+		ExpiresAt: now.Add(s.passwordResetCfg.TokenTTL),
+		CreatedAt: now,
+	}); err != nil {
+		return err
+	}
+
+	msg := mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body:    s.passwordResetBody(user, token),
+	}
+	if err := s.mailer.Send(ctx, msg); err != nil {
+		return fmt.Errorf("mail user %s: %w", user.ID, err)
+	}
+	return nil
+}
+
+// ConfirmPasswordReset sets the new password and retires the token. Every session of the
+// user is revoked, and a login lockout of the account is lifted.
+func (s *Service) ConfirmPasswordReset(ctx context.Context, req domain.ConfirmPasswordResetRequest) error {
+	if s.passwordResets == nil {
+		return ErrPasswordResetNotConfigured
+	}
+
+	stored, err := s.passwordResets.GetPasswordResetTokenByHash(ctx, hashToken(req.Token))
+	if err != nil {
+		if errors.Is(err, passwordresetrepository.ErrNotFound) {
+			return ErrInvalidResetToken
+		}
+		return err
+	}
+	now := time.Now().UTC()
+	if stored.UsedAt != nil || !now.Before(stored.ExpiresAt) {
+		return ErrInvalidResetToken
+	}
+
+	user, err := s.repo.GetUserByID(correlation.WithAllTenants(ctx), stored.UserID)
+	if err != nil {
+		if errors.Is(err, userrepository.ErrNotFound) {
+			return ErrInvalidResetToken
+		}
+		return err
+	}
+	if user.Disabled() {
+		return ErrInvalidResetToken
+	}
+	ctx = correlation.WithTenant(ctx, user.TenantID)
+
+	// a password the policy rejects must not use up the token
+	hash, err := s.hashPassword(req.Password)
+	if err != nil {
+		return err
+	}
+	used, err := s.passwordResets.MarkPasswordResetTokenUsed(ctx, stored.ID, now)
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidResetToken
+	}
+
+	if err := s.repo.UpdatePasswordHash(ctx, user.ID, hash); err != nil {
+		return err
+	}
+	if err := s.passwordResets.InvalidateUserPasswordResetTokens(ctx, user.ID, now); err != nil {
+		log.Printf("invalidate password reset tokens of user %s: %v", user.ID, err)
+	}
+	if err := s.revokeSessions(ctx, user.ID); err != nil {
+		return fmt.Errorf("revoke sessions after password reset: %w", err)
+	}
+	s.recordLoginSuccess(ctx, user.Email)
+
+	s.publish(ctx, UserEvent{Operation: OperationPasswordChanged, User: toEventUser(user), Reason: PasswordChangedReset})
+	return nil
+}
+
+func (s *Service) passwordResetBody(user domain.User, token string) string {
+	link := token
+	if s.passwordResetCfg.ResetURL != "" {
+		if resetURL, err := url.Parse(s.passwordResetCfg.ResetURL); err == nil {
+			query := resetURL.Query()
+			query.Set("token", token)
+			resetURL.RawQuery = query.Encode()
+			link = resetURL.String()
+		}
+	}
+
+	return fmt.Sprintf(`Hello %s,
+
+someone asked to reset the password of your account. To choose a new password, open:
+
+%s
+
+The link can be used once and expires in %s. If you did not ask for it, ignore this mail;
+your password stays the same.
+`, user.Name, link, s.passwordResetCfg.TokenTTL)
+}
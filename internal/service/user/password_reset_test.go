@@ -0,0 +1,202 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ktsiligkos/xm_project/internal/correlation"
+	"github.com/ktsiligkos/xm_project/internal/domain"
+	"github.com/ktsiligkos/xm_project/internal/mail"
+	passwordresetrepository "github.com/ktsiligkos/xm_project/internal/repository/passwordreset"
+)
+
+// memoryPasswordResets keeps reset tokens in a map keyed by ID
+type memoryPasswordResets struct {
+	tokens map[string]domain.PasswordResetToken
+}
+
+func (m *memoryPasswordResets) SavePasswordResetToken(_ context.Context, token domain.PasswordResetToken) error {
+	m.tokens[token.ID] = token
+	return nil
+}
+
+func (m *memoryPasswordResets) GetPasswordResetTokenByHash(_ context.Context, tokenHash string) (domain.PasswordResetToken, error) {
+	for _, token := range m.tokens {
+		if token.TokenHash == tokenHash {
+			return token, nil
+		}
+	}
+	return domain.PasswordResetToken{}, passwordresetrepository.ErrNotFound
+}
+
+func (m *memoryPasswordResets) MarkPasswordResetTokenUsed(_ context.Context, id string, at time.Time) (bool, error) {
+	token, ok := m.tokens[id]
+	if !ok || token.UsedAt != nil {
+		return false, nil
+	}
+	token.UsedAt = &at
+	m.tokens[id] = token
+	return true, nil
+}
+
+func (m *memoryPasswordResets) InvalidateUserPasswordResetTokens(_ context.Context, userID string, at time.Time) error {
+	for id, token := range m.tokens {
+		if token.UserID == userID && token.UsedAt == nil {
+			token.UsedAt = &at
+			m.tokens[id] = token
+		}
+	}
+	return nil
+}
+
+// outbox hands the sent messages to the test, mails are sent in the background
+type outbox chan mail.Message
+
+func (o outbox) Send(_ context.Context, msg mail.Message) error {
+	o <- msg
+	return nil
+}
+
+// resetToken waits for the next reset mail and returns the token of its link
+func (o outbox) resetToken(t *testing.T) string {
+	t.Helper()
+	select {
+	case msg := <-o:
+		for _, line := range strings.Split(msg.Body, "\n") {
+			if link, err := url.Parse(strings.TrimSpace(line)); err == nil && link.Query().Get("token") != "" {
+				return link.Query().Get("token")
+			}
+		}
+		t.Fatalf("the reset mail has no link:\n%s", msg.Body)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reset mail was sent")
+	}
+	return ""
+}
+
+func givenResetService(t *testing.T) (*Service, *memoryPasswordResets, outbox, domain.User) {
+	t.Helper()
+	service, _, user := givenServiceWithUser(t, "correct horse")
+	WithRefreshTokens(&memoryRefreshTokens{tokens: map[string]domain.RefreshToken{}}, time.Hour)(service)
+	resets := &memoryPasswordResets{tokens: map[string]domain.PasswordResetToken{}}
+	sent := make(outbox, 10)
+	WithPasswordReset(resets, sent, PasswordResetConfig{ResetURL: "https://app.example.com/reset-password"})(service)
+	return service, resets, sent, user
+}
+
+func TestPasswordReset_SetsTheNewPasswordAndRevokesSessions(t *testing.T) {
+	service, _, sent, user := givenResetService(t)
+	login, err := service.AuthenticateUser(context.Background(), domain.UserLoginRequest{Email: user.Email, Password: "correct horse"})
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+
+	if err := service.RequestPasswordReset(context.Background(), strings.ToUpper(user.Email)); err != nil {
+		t.Fatalf("RequestPasswordReset returned error: %v", err)
+	}
+	token := sent.resetToken(t)
+
+	if err := service.ConfirmPasswordReset(context.Background(), domain.ConfirmPasswordResetRequest{Token: token, Password: "battery staple"}); err != nil {
+		t.Fatalf("ConfirmPasswordReset returned error: %v", err)
+	}
+
+	if _, err := service.AuthenticateUser(context.Background(), domain.UserLoginRequest{Email: user.Email, Password: "battery staple"}); err != nil {
+		t.Fatalf("login with the new password failed: %v", err)
+	}
+	if _, err := service.Refresh(context.Background(), login.RefreshToken); err == nil {
+		t.Fatal("expected the sessions from before the reset to be revoked")
+	}
+	if err := service.ConfirmPasswordReset(context.Background(), domain.ConfirmPasswordResetRequest{Token: token, Password: "another password"}); !errors.Is(err, ErrInvalidResetToken) {
+		t.Fatalf("expected the token to be single use, got %v", err)
+	}
+}
+
+func TestRequestPasswordReset_IgnoresUnknownAddresses(t *testing.T) {
+	service, resets, sent, _ := givenResetService(t)
+
+	if err := service.RequestPasswordReset(context.Background(), "nobody@example.com"); err != nil {
+		t.Fatalf("expected unknown addresses to be accepted silently, got %v", err)
+	}
+	// the lookup runs in the background, give it the time a mail would take
+	time.Sleep(50 * time.Millisecond)
+	if len(resets.tokens) != 0 || len(sent) != 0 {
+		t.Fatalf("expected no token and no mail, got %d tokens and %d mails", len(resets.tokens), len(sent))
+	}
+}
+
+func TestRequestPasswordReset_ThrottlesPerAddressAndIP(t *testing.T) {
+	service, _, sent, user := givenResetService(t)
+	WithLoginProtection(newMemoryLoginAttempts(), DefaultLoginProtection)(service)
+	WithPasswordReset(service.passwordResets, sent, PasswordResetConfig{ResetURL: "https://app.example.com/reset-password", RequestsPerEmail: 2, RequestsPerIP: 3})(service)
+	ctx := correlation.WithClientIP(context.Background(), "10.0.0.1")
+
+	// Given an address that asked for two resets
+	for i := 0; i < 2; i++ {
+		if err := service.RequestPasswordReset(ctx, user.Email); err != nil {
+			t.Fatalf("request %d returned error: %v", i+1, err)
+		}
+		sent.resetToken(t)
+	}
+
+	// When it asks again, in another case
+	err := service.RequestPasswordReset(ctx, strings.ToUpper(user.Email))
+
+	// Then the request is refused
+	if !errors.Is(err, ErrPasswordResetThrottled) {
+		t.Fatalf("expected ErrPasswordResetThrottled for the address, got %v", err)
+	}
+
+	// When the same client asks for an unknown address after its third request
+	err = service.RequestPasswordReset(ctx, "nobody@example.com")
+
+	// Then the client IP is refused as well
+	if !errors.Is(err, ErrPasswordResetThrottled) {
+		t.Fatalf("expected ErrPasswordResetThrottled for the client IP, got %v", err)
+	}
+	if err := service.RequestPasswordReset(correlation.WithClientIP(context.Background(), "10.0.0.2"), "other@example.com"); err != nil {
+		t.Fatalf("expected other clients and addresses to be served, got %v", err)
+	}
+}
+
+func TestConfirmPasswordReset_RejectsInvalidTokens(t *testing.T) {
+	service, resets, sent, user := givenResetService(t)
+
+	_ = service.RequestPasswordReset(context.Background(), user.Email)
+	first := sent.resetToken(t)
+	_ = service.RequestPasswordReset(context.Background(), user.Email)
+	latest := sent.resetToken(t)
+
+	if err := service.ConfirmPasswordReset(context.Background(), domain.ConfirmPasswordResetRequest{Token: first, Password: "battery staple"}); !errors.Is(err, ErrInvalidResetToken) {
+		t.Fatalf("expected a newer link to replace the older one, got %v", err)
+	}
+	if err := service.ConfirmPasswordReset(context.Background(), domain.ConfirmPasswordResetRequest{Token: "made-up", Password: "battery staple"}); !errors.Is(err, ErrInvalidResetToken) {
+		t.Fatalf("expected unknown tokens to be rejected, got %v", err)
+	}
+
+	if err := service.ConfirmPasswordReset(context.Background(), domain.ConfirmPasswordResetRequest{Token: latest, Password: "short"}); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected the password policy to apply, got %v", err)
+	}
+
+	for id, token := range resets.tokens {
+		token.ExpiresAt = time.Now().Add(-time.Second)
+		resets.tokens[id] = token
+	}
+	if err := service.ConfirmPasswordReset(context.Background(), domain.ConfirmPasswordResetRequest{Token: latest, Password: "battery staple"}); !errors.Is(err, ErrInvalidResetToken) {
+		t.Fatalf("expected expired tokens to be rejected, got %v", err)
+	}
+}
+
+func TestConfirmPasswordReset_RejectedPasswordKeepsTheToken(t *testing.T) {
+	service, _, sent, user := givenResetService(t)
+	_ = service.RequestPasswordReset(context.Background(), user.Email)
+	token := sent.resetToken(t)
+
+	_ = service.ConfirmPasswordReset(context.Background(), domain.ConfirmPasswordResetRequest{Token: token, Password: "short"})
+	if err := service.ConfirmPasswordReset(context.Background(), domain.ConfirmPasswordResetRequest{Token: token, Password: "battery staple"}); err != nil {
+		t.Fatalf("expected the token to survive a rejected password, got %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ktsiligkos/xm_project/internal/auth"
+	"github.com/ktsiligkos/xm_project/internal/correlation"
+	"github.com/ktsiligkos/xm_project/internal/domain"
+)
+
+// TokenRevoker defines the capability needed for revoking access tokens before they expire.
+type TokenRevoker interface {
+	RevokeToken(ctx context.Context, jti, userID string, expiresAt time.Time) error
+	RevokeUser(ctx context.Context, userID string, at time.Time) error
+}
+
+// WithTokenRevoker lets admins revoke access tokens, and revokes the access tokens of a
+// user together with their refresh tokens, e.g. when the user is disabled
+func WithTokenRevoker(revoker TokenRevoker) Option {
+	return func(s *Service) {
+		s.revoker = revoker
+	}
+}
+
+// RevokeAccessToken revokes one access token, given either as the token itself or by its jti.
+// A token must belong to the tenant of ctx, a bare jti is not checked against any tenant.
+// Expired tokens need no revocation and are accepted silently.
+func (s *Service) RevokeAccessToken(ctx context.Context, req domain.RevokeTokenRequest) error {
+	if s.revoker == nil {
+		return fmt.Errorf("token revocation is not configured")
+	}
+
+	switch {
+	case req.Token != "" && req.JTI != "":
+		return fmt.Errorf("%w: give either token or jti, not both", ErrInvalidInput)
+	case req.JTI != "":
+		// without the token its expiry is unknown, no token lives longer than tokenTTL
+		return s.revoker.RevokeToken(ctx, req.JTI, "", time.Now().UTC().Add(s.tokenTTL))
+	case req.Token == "":
+		return fmt.Errorf("%w: token or jti is required", ErrInvalidInput)
+	}
+
+	claims, err := s.revocableToken(ctx, req.Token)
+	if err != nil || claims == nil {
+		return err
+	}
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		// issued before tokens carried a jti, only a user wide revocation can stop it
+		return fmt.Errorf("%w: token has no jti, revoke the tokens of user %s instead", ErrInvalidInput, claims.UserID)
+	}
+
+	return s.revoker.RevokeToken(ctx, claims.ID, claims.UserID, claims.ExpiresAt.Time)
+}
+
+// AccessTokenOwner returns the user an access token of the tenant of ctx was issued to, so
+// the caller can check it may act on them before RevokeAccessToken. Expired tokens return "".
+func (s *Service) AccessTokenOwner(ctx context.Context, token string) (string, error) {
+	claims, err := s.revocableToken(ctx, token)
+	if err != nil || claims == nil {
+		return "", err
+	}
+	return claims.UserID, nil
+}
+
+// revocableToken parses an access token of this service, nil when it expired already.
+// Tokens of other tenants are ErrNotFound, like their users.
+func (s *Service) revocableToken(ctx context.Context, token string) (*auth.Claims, error) {
+	claims, err := auth.ParseJWT(token, s.tokenKeys)
+	if err != nil {
+		if errors.Is(err, auth.ErrExpiredToken) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+
+	scope, err := correlation.TenantScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tenant := claims.TenantID
+	if tenant == "" {
+		tenant = domain.DefaultTenantID
+	}
+	if scope != "" && scope != tenant {
+		return nil, fmt.Errorf("%w: token of another tenant", ErrNotFound)
+	}
+	return claims, nil
+}
+
+// RevokeUserTokens revokes every access and refresh token issued to the user so far
+func (s *Service) RevokeUserTokens(ctx context.Context, userID string) error {
+	if _, err := s.GetUser(ctx, userID); err != nil {
+		return err
+	}
+
+	return s.revokeSessions(ctx, userID)
+}
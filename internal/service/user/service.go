@@ -4,12 +4,21 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log"
 	"time"
 
+	"github.com/google/uuid"
+
 	"github.com/ktsiligkos/xm_project/internal/auth"
+	"github.com/ktsiligkos/xm_project/internal/auth/password"
+	"github.com/ktsiligkos/xm_project/internal/correlation"
 	"github.com/ktsiligkos/xm_project/internal/domain"
+	"github.com/ktsiligkos/xm_project/internal/mail"
+	loginattemptrepository "github.com/ktsiligkos/xm_project/internal/repository/loginattempt"
+	mfarepository "github.com/ktsiligkos/xm_project/internal/repository/mfa"
+	passwordresetrepository "github.com/ktsiligkos/xm_project/internal/repository/passwordreset"
+	refreshtokenrepository "github.com/ktsiligkos/xm_project/internal/repository/refreshtoken"
 	userrepository "github.com/ktsiligkos/xm_project/internal/repository/user"
-	"golang.org/x/crypto/bcrypt"
 )
 
 // Exported errors map internal failures to business-level concerns
@@ -18,54 +27,191 @@ var (
 	ErrInvalidInput          = errors.New("invalid input")
 	ErrAuthFailed            = errors.New("authentication failed")
 	ErrTokenGenerationFailed = errors.New("token generation failed")
+	ErrEmailTaken            = errors.New("email already in use")
 )
 
 // Service orchestrates the application's business logic for user
 type Service struct {
-	repo        userrepository.Repository
-	tokenSecret []byte
-	tokenTTL    time.Duration
+	repo      userrepository.Repository
+	tokenKeys *auth.KeySet
+	tokenTTL  time.Duration
+	publisher EventPublisher
+	hasher    password.Hasher
+	policy    *password.Policy
+
+	refreshTokens   refreshtokenrepository.Repository
+	refreshTokenTTL time.Duration
+	revoker         TokenRevoker
+
+	passwordResets   passwordresetrepository.Repository
+	mailer           mail.Mailer
+	passwordResetCfg PasswordResetConfig
+
+	mfa    mfarepository.Repository
+	mfaCfg MFAConfig
+
+	loginAttempts   loginattemptrepository.Repository
+	loginProtection LoginProtection
+}
+
+// Option customises optional collaborators of the Service
+type Option func(*Service)
+
+// WithEventPublisher publishes the lifecycle events of users, such as logins
+func WithEventPublisher(publisher EventPublisher) Option {
+	return func(s *Service) {
+		s.publisher = publisher
+	}
+}
+
+// WithPasswordHasher replaces the default bcrypt hasher, existing hashes of
+// another algorithm keep working and are replaced on the next successful login
+func WithPasswordHasher(hasher password.Hasher) Option {
+	return func(s *Service) {
+		s.hasher = hasher
+	}
+}
+
+// WithPasswordPolicy replaces the default policy, which only requires a minimum length
+func WithPasswordPolicy(policy *password.Policy) Option {
+	return func(s *Service) {
+		s.policy = policy
+	}
 }
 
 // NewService creates a new user service bound to the provided repository
-func NewService(repo userrepository.Repository, tokenSecret []byte, tokenTTL time.Duration) *Service {
+func NewService(repo userrepository.Repository, tokenKeys *auth.KeySet, tokenTTL time.Duration, opts ...Option) *Service {
 	if tokenTTL <= 0 {
 		tokenTTL = time.Hour
 	}
 
-	return &Service{repo: repo, tokenSecret: tokenSecret, tokenTTL: tokenTTL}
+	s := &Service{repo: repo, tokenKeys: tokenKeys, tokenTTL: tokenTTL}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.hasher == nil {
+		// the default configuration is always valid
+		s.hasher, _ = password.NewHasher(password.Config{Algorithm: password.AlgorithmBcrypt})
+	}
+	if s.policy == nil {
+		s.policy, _ = password.NewPolicy(password.DefaultMinLength, "")
+	}
+	return s
 }
 
-// Get retrieves a single user in order to authenticate the user
-func (s *Service) AuthenticateUser(ctx context.Context, user domain.UserLoginRequest) (string, error) {
+// AuthenticateUser checks the credentials and issues an access token, plus a refresh token when enabled.
+// Unknown emails, wrong passwords and disabled users all fail with ErrAuthFailed after the same work,
+// so the response does not tell whether an account exists. Users with MFA get no tokens yet but
+// a TokenPair whose MFA names the challenge to complete with VerifyMFALogin.
+func (s *Service) AuthenticateUser(ctx context.Context, user domain.UserLoginRequest) (domain.TokenPair, error) {
 	//TODO: add validatin validation for the contents of the UserLoginRequest
-	userFromDB, err := s.repo.GetUserByEmail(ctx, user.Email)
+	// the attempt counts as failed until the password proves right
+	reservation, err := s.reserveLogin(ctx, user.Email)
+	if err != nil {
+		s.publish(ctx, UserEvent{Operation: OperationLoginFailed, User: EventUser{Email: user.Email}, Reason: LoginFailedThrottled})
+		return domain.TokenPair{}, err
+	}
+
+	// the email decides the tenant, so the lookup spans all of them
+	userFromDB, err := s.repo.GetUserByEmail(correlation.WithAllTenants(ctx), user.Email)
 	if err != nil {
 		if errors.Is(err, userrepository.ErrNotFound) {
-			return "", ErrNotFound
+			s.hasher.VerifyDummy(user.Password)
+			s.publish(ctx, UserEvent{Operation: OperationLoginFailed, User: EventUser{Email: user.Email}, Reason: LoginFailedUnknownEmail})
+			return domain.TokenPair{}, fmt.Errorf("unknown email %s: %w", user.Email, ErrAuthFailed)
 		}
 
-		return "", err
+		s.releaseLogin(ctx, reservation)
+		return domain.TokenPair{}, err
 	}
+	ctx = correlation.WithTenant(ctx, userFromDB.TenantID)
 
-	verified := checkPasswordHash(user.Password, userFromDB.Password)
+	verified, needsRehash, err := s.hasher.Verify(user.Password, userFromDB.Password)
+	if err != nil {
+		log.Printf("verify password of user %s: %v", userFromDB.ID, err)
+	}
 	if !verified {
-		return "", fmt.Errorf("invalid password for email %s: %w", user.Email, ErrAuthFailed)
+		s.publish(ctx, UserEvent{Operation: OperationLoginFailed, User: toEventUser(userFromDB), Reason: LoginFailedWrongPassword})
+		return domain.TokenPair{}, fmt.Errorf("invalid password for email %s: %w", user.Email, ErrAuthFailed)
 	}
+	s.releaseLogin(ctx, reservation)
 
-	if len(s.tokenSecret) == 0 {
-		return "", fmt.Errorf("token secret not configured")
+	if userFromDB.Disabled() {
+		s.publish(ctx, UserEvent{Operation: OperationLoginFailed, User: toEventUser(userFromDB), Reason: LoginFailedDisabled})
+		return domain.TokenPair{}, fmt.Errorf("user %s is disabled: %w", userFromDB.ID, ErrAuthFailed)
 	}
 
-	token, err := auth.GenerateJWT(userFromDB.ID, s.tokenSecret, s.tokenTTL)
+	pending, err := s.pendingMFA(ctx, userFromDB)
 	if err != nil {
-		return "", fmt.Errorf("%w: %v", ErrTokenGenerationFailed, err)
+		if errors.Is(err, ErrMFAEnrollmentRequired) {
+			s.publish(ctx, UserEvent{Operation: OperationLoginFailed, User: toEventUser(userFromDB), Reason: LoginFailedMFAEnrollmentRequired})
+		}
+		return domain.TokenPair{}, err
+	}
+	if needsRehash {
+		s.rehash(ctx, userFromDB.ID, user.Password)
+	}
+	if pending != nil {
+		// failures stay counted until the second factor is right, so codes cannot be guessed
+		// by entering the known password again after every few tries
+		return domain.TokenPair{MFA: pending}, nil
+	}
+
+	tokens, err := s.issueTokens(ctx, userFromDB, "", false)
+	if err != nil {
+		return domain.TokenPair{}, err
 	}
 
-	return token, nil
+	s.recordLoginSuccess(ctx, user.Email)
+	s.publish(ctx, UserEvent{Operation: OperationLoginSucceeded, User: toEventUser(userFromDB)})
+	return tokens, nil
 }
 
-func checkPasswordHash(password, hashed string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hashed), []byte(password))
-	return err == nil
+func (s *Service) publish(ctx context.Context, event UserEvent) {
+	if s.publisher == nil {
+		return
+	}
+
+	event.EventID = uuid.NewString()
+	event.OccurredAt = time.Now().UTC()
+	event.Metadata = EventMetadata{
+		RequestID:   correlation.RequestID(ctx),
+		TraceParent: correlation.TraceParent(ctx),
+		ActorID:     correlation.UserID(ctx),
+		ClientIP:    correlation.ClientIP(ctx),
+	}
+
+	if err := s.publisher.PublishUserEvent(ctx, event); err != nil {
+		log.Printf("publish user event failed: %v", err)
+	}
+}
+
+// toEventUser keeps the public fields only, the password hash stays behind
+func toEventUser(user domain.User) EventUser {
+	return EventUser{ID: user.ID, TenantID: user.TenantID, Name: user.Name, Email: user.Email}
+}
+
+// rehash replaces a hash with outdated parameters while the plain password is at hand.
+// A failure only postpones the migration to the next login.
+func (s *Service) rehash(ctx context.Context, userID, plain string) {
+	hash, err := s.hasher.Hash(plain)
+	if err != nil {
+		log.Printf("rehash password of user %s: %v", userID, err)
+		return
+	}
+	if err := s.repo.UpdatePasswordHash(ctx, userID, hash); err != nil {
+		log.Printf("store rehashed password of user %s: %v", userID, err)
+	}
+}
+
+// hashPassword checks a new password against the policy before hashing it
+func (s *Service) hashPassword(plain string) (string, error) {
+	if err := s.policy.Validate(plain); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+	hash, err := s.hasher.Hash(plain)
+	if err != nil {
+		return "", fmt.Errorf("hash password: %w", err)
+	}
+	return hash, nil
 }
@@ -0,0 +1,212 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/ktsiligkos/xm_project/internal/auth"
+	"github.com/ktsiligkos/xm_project/internal/auth/password"
+	"github.com/ktsiligkos/xm_project/internal/correlation"
+	"github.com/ktsiligkos/xm_project/internal/domain"
+	userrepository "github.com/ktsiligkos/xm_project/internal/repository/user"
+)
+
+// memoryRepository keeps users in a map keyed by ID
+type memoryRepository struct {
+	users map[string]domain.User
+}
+
+func newMemoryRepository(users ...domain.User) *memoryRepository {
+	r := &memoryRepository{users: map[string]domain.User{}}
+	for _, user := range users {
+		r.users[user.ID] = user
+	}
+	return r
+}
+
+func (r *memoryRepository) GetUserByEmail(_ context.Context, email string) (domain.User, error) {
+	for _, user := range r.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return domain.User{}, userrepository.ErrNotFound
+}
+
+func (r *memoryRepository) GetUserByID(_ context.Context, id string) (domain.User, error) {
+	user, ok := r.users[id]
+	if !ok {
+		return domain.User{}, userrepository.ErrNotFound
+	}
+	return user, nil
+}
+
+func (r *memoryRepository) CreateUser(ctx context.Context, user domain.User) (domain.User, error) {
+	if _, err := r.GetUserByEmail(ctx, user.Email); err == nil {
+		return domain.User{}, userrepository.ErrDuplicateEmail
+	}
+	r.users[user.ID] = user
+	return user, nil
+}
+
+func (r *memoryRepository) ListUsers(_ context.Context, afterID string, limit int) ([]domain.User, error) {
+	users := make([]domain.User, 0, len(r.users))
+	for _, user := range r.users {
+		if user.ID > afterID {
+			users = append(users, user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	if len(users) > limit {
+		users = users[:limit]
+	}
+	return users, nil
+}
+
+func (r *memoryRepository) UpdateUser(ctx context.Context, user domain.User) error {
+	if _, ok := r.users[user.ID]; !ok {
+		return userrepository.ErrNotFound
+	}
+	if existing, err := r.GetUserByEmail(ctx, user.Email); err == nil && existing.ID != user.ID {
+		return userrepository.ErrDuplicateEmail
+	}
+	r.users[user.ID] = user
+	return nil
+}
+
+func (r *memoryRepository) UpdatePasswordHash(_ context.Context, id string, hash string) error {
+	user, ok := r.users[id]
+	if !ok {
+		return userrepository.ErrNotFound
+	}
+	user.Password = hash
+	r.users[id] = user
+	return nil
+}
+
+func (r *memoryRepository) DisableUser(_ context.Context, id string, at time.Time) error {
+	user, ok := r.users[id]
+	if !ok {
+		return userrepository.ErrNotFound
+	}
+	user.DisabledAt = &at
+	r.users[id] = user
+	return nil
+}
+
+// Holds the published events
+type stubPublisher struct {
+	events []UserEvent
+}
+
+func (s *stubPublisher) PublishUserEvent(_ context.Context, event UserEvent) error {
+	s.events = append(s.events, event)
+	return nil
+}
+
+// cheapHasher keeps logins fast in tests, unknown emails also verify a dummy hash
+func cheapHasher() Option {
+	hasher, _ := password.NewHasher(password.Config{
+		Algorithm:  password.AlgorithmBcrypt,
+		BcryptCost: bcrypt.MinCost,
+		Argon2:     password.Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1},
+	})
+	return WithPasswordHasher(hasher)
+}
+
+func givenServiceWithUser(t *testing.T, password string) (*Service, *stubPublisher, domain.User) {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	user := domain.User{ID: "u1", Name: "John", Email: "john@example.com", Password: string(hash)}
+	publisher := &stubPublisher{}
+	service := NewService(newMemoryRepository(user), auth.NewHMACKeySet([]byte("secret")), time.Hour, WithEventPublisher(publisher), cheapHasher())
+	return service, publisher, user
+}
+
+func TestAuthenticateUser_PublishesLoginEvents(t *testing.T) {
+	service, publisher, user := givenServiceWithUser(t, "correct")
+	ctx := correlation.WithClientIP(correlation.WithRequestID(context.Background(), "req-1"), "10.0.0.1")
+
+	if _, err := service.AuthenticateUser(ctx, domain.UserLoginRequest{Email: user.Email, Password: "correct"}); err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	if _, err := service.AuthenticateUser(ctx, domain.UserLoginRequest{Email: user.Email, Password: "wrong"}); !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("expected ErrAuthFailed, got %v", err)
+	}
+	if _, err := service.AuthenticateUser(ctx, domain.UserLoginRequest{Email: "nobody@example.com", Password: "x"}); !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("expected unknown emails to fail like wrong passwords, got %v", err)
+	}
+
+	want := []struct{ operation, reason, userID string }{
+		{OperationLoginSucceeded, "", "u1"},
+		{OperationLoginFailed, LoginFailedWrongPassword, "u1"},
+		{OperationLoginFailed, LoginFailedUnknownEmail, ""},
+	}
+	if len(publisher.events) != len(want) {
+		t.Fatalf("expected %d events, got %d", len(want), len(publisher.events))
+	}
+	for i, w := range want {
+		event := publisher.events[i]
+		if event.Operation != w.operation || event.Reason != w.reason || event.User.ID != w.userID {
+			t.Fatalf("event %d: want %+v, got %+v", i, w, event)
+		}
+		if event.EventID == "" || event.Metadata.RequestID != "req-1" || event.Metadata.ClientIP != "10.0.0.1" {
+			t.Fatalf("event %d is missing its identity or metadata: %+v", i, event)
+		}
+	}
+}
+
+func TestAuthenticateUser_EventsNeverContainThePasswordHash(t *testing.T) {
+	service, publisher, user := givenServiceWithUser(t, "correct")
+
+	_, _ = service.AuthenticateUser(context.Background(), domain.UserLoginRequest{Email: user.Email, Password: "correct"})
+	_, _ = service.AuthenticateUser(context.Background(), domain.UserLoginRequest{Email: user.Email, Password: "wrong"})
+
+	for _, event := range publisher.events {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			t.Fatalf("encode event: %v", err)
+		}
+		if strings.Contains(string(payload), user.Password) || strings.Contains(string(payload), `"password`) {
+			t.Fatalf("event payload leaks the password: %s", payload)
+		}
+	}
+}
+
+func TestAuthenticateUser_RehashesOutdatedHashes(t *testing.T) {
+	service, _, user := givenServiceWithUser(t, "correct horse")
+	argon2id, err := password.NewHasher(password.Config{
+		Algorithm: password.AlgorithmArgon2id,
+		Argon2:    password.Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1},
+	})
+	if err != nil {
+		t.Fatalf("new hasher: %v", err)
+	}
+	WithPasswordHasher(argon2id)(service)
+	repo := service.repo.(*memoryRepository)
+
+	if _, err := service.AuthenticateUser(context.Background(), domain.UserLoginRequest{Email: user.Email, Password: "correct horse"}); err != nil {
+		t.Fatalf("login with the bcrypt hash failed: %v", err)
+	}
+	rehashed := repo.users[user.ID].Password
+	if !strings.HasPrefix(rehashed, "$argon2id$") {
+		t.Fatalf("expected the hash to be migrated to argon2id, got %q", rehashed)
+	}
+
+	if _, err := service.AuthenticateUser(context.Background(), domain.UserLoginRequest{Email: user.Email, Password: "correct horse"}); err != nil {
+		t.Fatalf("login with the argon2id hash failed: %v", err)
+	}
+	if repo.users[user.ID].Password != rehashed {
+		t.Fatal("an up to date hash must not be replaced again")
+	}
+}
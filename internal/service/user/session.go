@@ -0,0 +1,212 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ktsiligkos/xm_project/internal/auth"
+	"github.com/ktsiligkos/xm_project/internal/correlation"
+	"github.com/ktsiligkos/xm_project/internal/domain"
+	refreshtokenrepository "github.com/ktsiligkos/xm_project/internal/repository/refreshtoken"
+)
+
+// Errors returned when a refresh token cannot be exchanged
+var (
+	ErrInvalidRefreshToken  = errors.New("invalid refresh token")
+	ErrRefreshTokenReused   = errors.New("refresh token reused")
+	ErrRefreshNotConfigured = errors.New("refresh tokens are not configured")
+)
+
+const (
+	defaultRefreshTokenTTL = 30 * 24 * time.Hour
+	opaqueTokenBytes       = 32
+)
+
+// WithRefreshTokens issues a refresh token next to every access token. Each refresh
+// rotates the token; presenting a used token again revokes its whole family.
+func WithRefreshTokens(repo refreshtokenrepository.Repository, ttl time.Duration) Option {
+	return func(s *Service) {
+		if ttl <= 0 {
+			ttl = defaultRefreshTokenTTL
+		}
+		s.refreshTokens = repo
+		s.refreshTokenTTL = ttl
+	}
+}
+
+// Refresh exchanges a refresh token for a new token pair and retires the old one
+func (s *Service) Refresh(ctx context.Context, refreshToken string) (domain.TokenPair, error) {
+	if s.refreshTokens == nil {
+		return domain.TokenPair{}, ErrRefreshNotConfigured
+	}
+
+	stored, err := s.refreshTokens.GetRefreshTokenByHash(ctx, hashToken(refreshToken))
+	if err != nil {
+		if errors.Is(err, refreshtokenrepository.ErrNotFound) {
+			return domain.TokenPair{}, ErrInvalidRefreshToken
+		}
+		return domain.TokenPair{}, err
+	}
+
+	now := time.Now().UTC()
+	switch {
+	case stored.RevokedAt != nil:
+		return domain.TokenPair{}, fmt.Errorf("%w: revoked", ErrInvalidRefreshToken)
+	case stored.UsedAt != nil:
+		return domain.TokenPair{}, s.refreshTokenReused(ctx, stored)
+	case !now.Before(stored.ExpiresAt):
+		return domain.TokenPair{}, fmt.Errorf("%w: expired", ErrInvalidRefreshToken)
+	}
+
+	won, err := s.refreshTokens.MarkRefreshTokenUsed(ctx, stored.ID, now)
+	if err != nil {
+		return domain.TokenPair{}, err
+	}
+	if !won {
+		// another request rotated the token in the meantime
+		return domain.TokenPair{}, s.refreshTokenReused(ctx, stored)
+	}
+
+	user, err := s.repo.GetUserByID(correlation.WithAllTenants(ctx), stored.UserID)
+	if err != nil {
+		return domain.TokenPair{}, fmt.Errorf("load user of refresh token: %w", err)
+	}
+	if user.Disabled() {
+		if err := s.refreshTokens.RevokeRefreshTokenFamily(ctx, stored.FamilyID, now); err != nil {
+			log.Printf("revoke refresh tokens of disabled user %s: %v", user.ID, err)
+		}
+		return domain.TokenPair{}, fmt.Errorf("user %s is disabled: %w", user.ID, ErrAuthFailed)
+	}
+	if !stored.MFAVerified {
+		// the user enrolled or a role started to require MFA after this password-only login
+		_, needed, err := s.secondFactorNeeded(ctx, user)
+		if err != nil {
+			return domain.TokenPair{}, err
+		}
+		if needed {
+			if err := s.refreshTokens.RevokeRefreshTokenFamily(ctx, stored.FamilyID, now); err != nil {
+				log.Printf("revoke password-only refresh tokens of user %s: %v", user.ID, err)
+			}
+			return domain.TokenPair{}, fmt.Errorf("%w: log in again with the second factor", ErrInvalidRefreshToken)
+		}
+	}
+
+	return s.issueTokens(ctx, user, stored.FamilyID, stored.MFAVerified)
+}
+
+// Logout revokes the refresh token and every token rotated from the same login.
+// Unknown tokens are ignored, so logging out twice is not an error.
+func (s *Service) Logout(ctx context.Context, refreshToken string) error {
+	if s.refreshTokens == nil {
+		return ErrRefreshNotConfigured
+	}
+
+	stored, err := s.refreshTokens.GetRefreshTokenByHash(ctx, hashToken(refreshToken))
+	if err != nil {
+		if errors.Is(err, refreshtokenrepository.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	return s.refreshTokens.RevokeRefreshTokenFamily(ctx, stored.FamilyID, time.Now().UTC())
+}
+
+// issueTokens signs an access token and, when enabled, stores a new refresh token.
+// An empty familyID starts a new family, as happens at login; mfaVerified records whether
+// that login completed a second factor, rotations keep the value of their family.
+func (s *Service) issueTokens(ctx context.Context, user domain.User, familyID string, mfaVerified bool) (domain.TokenPair, error) {
+	if s.tokenKeys == nil {
+		return domain.TokenPair{}, fmt.Errorf("token signing keys not configured")
+	}
+
+	accessToken, err := auth.GenerateJWT(user.ID, user.TenantID, user.Roles, s.tokenKeys, s.tokenTTL)
+	if err != nil {
+		return domain.TokenPair{}, fmt.Errorf("%w: %v", ErrTokenGenerationFailed, err)
+	}
+	pair := domain.TokenPair{AccessToken: accessToken, AccessTokenTTL: s.tokenTTL}
+	if s.refreshTokens == nil {
+		return pair, nil
+	}
+
+	plain, err := newOpaqueToken()
+	if err != nil {
+		return domain.TokenPair{}, fmt.Errorf("%w: %v", ErrTokenGenerationFailed, err)
+	}
+	if familyID == "" {
+		familyID = uuid.NewString()
+	}
+	now := time.Now().UTC()
+	if err := s.refreshTokens.SaveRefreshToken(ctx, domain.RefreshToken{
+		ID:          uuid.NewString(),
+		FamilyID:    familyID,
+		UserID:      user.ID,
+		TokenHash:   hashToken(plain),
+		ExpiresAt:   now.Add(s.refreshTokenTTL),
+		CreatedAt:   now,
+		MFAVerified: mfaVerified,
+	}); err != nil {
+		return domain.TokenPair{}, fmt.Errorf("%w: %v", ErrTokenGenerationFailed, err)
+	}
+
+	pair.RefreshToken = plain
+	return pair, nil
+}
+
+// refreshTokenReused revokes the family of a token that was presented after it had been used,
+// since either the legitimate client or an attacker holds a stolen copy
+func (s *Service) refreshTokenReused(ctx context.Context, stored domain.RefreshToken) error {
+	if err := s.refreshTokens.RevokeRefreshTokenFamily(ctx, stored.FamilyID, time.Now().UTC()); err != nil {
+		return fmt.Errorf("revoke reused refresh token family: %w", err)
+	}
+
+	user := EventUser{ID: stored.UserID}
+	if found, err := s.repo.GetUserByID(correlation.WithAllTenants(ctx), stored.UserID); err == nil {
+		user = toEventUser(found)
+	}
+	s.publish(ctx, UserEvent{Operation: OperationRefreshTokenReused, User: user})
+
+	return ErrRefreshTokenReused
+}
+
+// revokeSessions logs the user out everywhere, e.g. after a password change: their refresh
+// tokens are revoked and, when a revoker is configured, so are the access tokens issued so far
+func (s *Service) revokeSessions(ctx context.Context, userID string) error {
+	now := time.Now().UTC()
+	if s.refreshTokens != nil {
+		if err := s.refreshTokens.RevokeUserRefreshTokens(ctx, userID, now); err != nil {
+			return err
+		}
+	}
+	if s.revoker != nil {
+		if err := s.revoker.RevokeUser(ctx, userID, now); err != nil {
+			return fmt.Errorf("revoke access tokens: %w", err)
+		}
+	}
+	return nil
+}
+
+// newOpaqueToken returns a random token, used for refresh tokens, reset links, MFA challenges and recovery codes
+func newOpaqueToken() (string, error) {
+	buf := make([]byte, opaqueTokenBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashToken is what the repositories store of an opaque token. It needs no salt or
+// work factor, the token is random and long enough
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,282 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ktsiligkos/xm_project/internal/auth"
+	"github.com/ktsiligkos/xm_project/internal/correlation"
+	"github.com/ktsiligkos/xm_project/internal/domain"
+	refreshtokenrepository "github.com/ktsiligkos/xm_project/internal/repository/refreshtoken"
+)
+
+// memoryRefreshTokens keeps refresh tokens in a map keyed by hash
+type memoryRefreshTokens struct {
+	mu     sync.Mutex
+	tokens map[string]domain.RefreshToken
+}
+
+func (m *memoryRefreshTokens) SaveRefreshToken(_ context.Context, token domain.RefreshToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tokens[token.TokenHash] = token
+	return nil
+}
+
+func (m *memoryRefreshTokens) GetRefreshTokenByHash(_ context.Context, tokenHash string) (domain.RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	token, ok := m.tokens[tokenHash]
+	if !ok {
+		return domain.RefreshToken{}, refreshtokenrepository.ErrNotFound
+	}
+	return token, nil
+}
+
+func (m *memoryRefreshTokens) MarkRefreshTokenUsed(_ context.Context, id string, at time.Time) (bool, error) {
+	return m.update(func(token *domain.RefreshToken) bool {
+		if token.ID != id || token.UsedAt != nil || token.RevokedAt != nil {
+			return false
+		}
+		token.UsedAt = &at
+		return true
+	}), nil
+}
+
+func (m *memoryRefreshTokens) RevokeRefreshTokenFamily(_ context.Context, familyID string, at time.Time) error {
+	m.update(func(token *domain.RefreshToken) bool {
+		if token.FamilyID != familyID || token.RevokedAt != nil {
+			return false
+		}
+		token.RevokedAt = &at
+		return true
+	})
+	return nil
+}
+
+func (m *memoryRefreshTokens) RevokeUserRefreshTokens(_ context.Context, userID string, at time.Time) error {
+	m.update(func(token *domain.RefreshToken) bool {
+		if token.UserID != userID || token.RevokedAt != nil {
+			return false
+		}
+		token.RevokedAt = &at
+		return true
+	})
+	return nil
+}
+
+// update applies fn to every token and reports whether it changed any
+func (m *memoryRefreshTokens) update(fn func(token *domain.RefreshToken) bool) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	changed := false
+	for hash, token := range m.tokens {
+		if fn(&token) {
+			m.tokens[hash] = token
+			changed = true
+		}
+	}
+	return changed
+}
+
+func givenSessionService(t *testing.T) (*Service, *stubPublisher, domain.TokenPair) {
+	t.Helper()
+	service, publisher, user := givenServiceWithUser(t, "correct horse")
+	WithRefreshTokens(&memoryRefreshTokens{tokens: map[string]domain.RefreshToken{}}, time.Hour)(service)
+
+	tokens, err := service.AuthenticateUser(context.Background(), domain.UserLoginRequest{Email: user.Email, Password: "correct horse"})
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if tokens.AccessToken == "" || tokens.RefreshToken == "" || tokens.AccessTokenTTL != time.Hour {
+		t.Fatalf("expected an access and a refresh token, got %+v", tokens)
+	}
+	return service, publisher, tokens
+}
+
+func TestRefresh_RotatesTheRefreshToken(t *testing.T) {
+	service, _, login := givenSessionService(t)
+
+	refreshed, err := service.Refresh(context.Background(), login.RefreshToken)
+	if err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if refreshed.RefreshToken == "" || refreshed.RefreshToken == login.RefreshToken {
+		t.Fatal("expected a new refresh token")
+	}
+
+	if _, err := service.Refresh(context.Background(), refreshed.RefreshToken); err != nil {
+		t.Fatalf("the rotated token should be usable: %v", err)
+	}
+	if _, err := service.Refresh(context.Background(), "unknown"); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("expected ErrInvalidRefreshToken, got %v", err)
+	}
+}
+
+func TestRefresh_ReuseRevokesTheWholeFamily(t *testing.T) {
+	service, publisher, login := givenSessionService(t)
+
+	refreshed, err := service.Refresh(context.Background(), login.RefreshToken)
+	if err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+
+	if _, err := service.Refresh(context.Background(), login.RefreshToken); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("expected ErrRefreshTokenReused, got %v", err)
+	}
+	if _, err := service.Refresh(context.Background(), refreshed.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("the newest token of a reused family must be revoked too, got %v", err)
+	}
+
+	last := publisher.events[len(publisher.events)-1]
+	if last.Operation != OperationRefreshTokenReused || last.User.ID != "u1" {
+		t.Fatalf("expected a user.refresh_token_reused event, got %+v", last)
+	}
+}
+
+func TestLogout_RevokesTheFamily(t *testing.T) {
+	service, _, login := givenSessionService(t)
+	refreshed, err := service.Refresh(context.Background(), login.RefreshToken)
+	if err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+
+	if err := service.Logout(context.Background(), refreshed.RefreshToken); err != nil {
+		t.Fatalf("logout: %v", err)
+	}
+	if _, err := service.Refresh(context.Background(), refreshed.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("expected the token to be revoked, got %v", err)
+	}
+	if err := service.Logout(context.Background(), "unknown"); err != nil {
+		t.Fatalf("logging out with an unknown token should be a no-op, got %v", err)
+	}
+}
+
+func TestDisableUser_RevokesRefreshTokens(t *testing.T) {
+	service, _, login := givenSessionService(t)
+
+	if err := service.DisableUser(context.Background(), "u1"); err != nil {
+		t.Fatalf("disable user: %v", err)
+	}
+	if _, err := service.Refresh(context.Background(), login.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("expected the token of a disabled user to be revoked, got %v", err)
+	}
+}
+
+// stubRevoker records the access token revocations
+type stubRevoker struct {
+	tokens map[string]string
+	users  map[string]time.Time
+}
+
+func (s *stubRevoker) RevokeToken(_ context.Context, jti, userID string, _ time.Time) error {
+	s.tokens[jti] = userID
+	return nil
+}
+
+func (s *stubRevoker) RevokeUser(_ context.Context, userID string, at time.Time) error {
+	s.users[userID] = at
+	return nil
+}
+
+func TestRevokeAccessToken(t *testing.T) {
+	service, _, login := givenSessionService(t)
+	revoker := &stubRevoker{tokens: map[string]string{}, users: map[string]time.Time{}}
+	WithTokenRevoker(revoker)(service)
+
+	claims, err := auth.ParseJWT(login.AccessToken, service.tokenKeys)
+	if err != nil {
+		t.Fatalf("parse token: %v", err)
+	}
+	if claims.ID == "" {
+		t.Fatal("expected the access token to carry a jti")
+	}
+
+	other := correlation.WithTenant(context.Background(), "tenant-2")
+	if err := service.RevokeAccessToken(other, domain.RevokeTokenRequest{Token: login.AccessToken}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected a token of another tenant to be unknown, got %v", err)
+	}
+	if len(revoker.tokens) != 0 {
+		t.Fatalf("expected nothing to be revoked for another tenant, got %v", revoker.tokens)
+	}
+
+	ctx := correlation.WithTenant(context.Background(), domain.DefaultTenantID)
+	if owner, err := service.AccessTokenOwner(ctx, login.AccessToken); err != nil || owner != "u1" {
+		t.Fatalf("expected the token to belong to u1, got %q, %v", owner, err)
+	}
+	if err := service.RevokeAccessToken(ctx, domain.RevokeTokenRequest{Token: login.AccessToken}); err != nil {
+		t.Fatalf("revoke by token: %v", err)
+	}
+	if revoker.tokens[claims.ID] != "u1" {
+		t.Fatalf("expected jti %s of u1 to be revoked, got %v", claims.ID, revoker.tokens)
+	}
+
+	if err := service.RevokeAccessToken(ctx, domain.RevokeTokenRequest{JTI: "other"}); err != nil {
+		t.Fatalf("revoke by jti: %v", err)
+	}
+	if _, ok := revoker.tokens["other"]; !ok {
+		t.Fatal("expected the jti to be revoked")
+	}
+
+	for _, req := range []domain.RevokeTokenRequest{{}, {Token: "garbage"}, {Token: login.AccessToken, JTI: "both"}} {
+		if err := service.RevokeAccessToken(ctx, req); !errors.Is(err, ErrInvalidInput) {
+			t.Fatalf("expected ErrInvalidInput for %+v, got %v", req, err)
+		}
+	}
+}
+
+func TestRevokeUserTokens_RevokesAccessAndRefreshTokens(t *testing.T) {
+	service, _, login := givenSessionService(t)
+	revoker := &stubRevoker{tokens: map[string]string{}, users: map[string]time.Time{}}
+	WithTokenRevoker(revoker)(service)
+
+	if err := service.RevokeUserTokens(context.Background(), "u1"); err != nil {
+		t.Fatalf("revoke user tokens: %v", err)
+	}
+	if _, ok := revoker.users["u1"]; !ok {
+		t.Fatal("expected the access tokens of the user to be revoked")
+	}
+	if _, err := service.Refresh(context.Background(), login.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("expected the refresh token to be revoked, got %v", err)
+	}
+	if err := service.RevokeUserTokens(context.Background(), "missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+// scopedRepository refuses user lookups whose context names no tenant, as the MySQL repository does
+type scopedRepository struct {
+	*memoryRepository
+}
+
+func (r scopedRepository) GetUserByEmail(ctx context.Context, email string) (domain.User, error) {
+	if _, err := correlation.TenantScope(ctx); err != nil {
+		return domain.User{}, err
+	}
+	return r.memoryRepository.GetUserByEmail(ctx, email)
+}
+
+func (r scopedRepository) GetUserByID(ctx context.Context, id string) (domain.User, error) {
+	if _, err := correlation.TenantScope(ctx); err != nil {
+		return domain.User{}, err
+	}
+	return r.memoryRepository.GetUserByID(ctx, id)
+}
+
+func TestLoginAndRefresh_FindUsersOfEveryTenant(t *testing.T) {
+	service, _, user := givenServiceWithUser(t, "correct horse")
+	service.repo = scopedRepository{service.repo.(*memoryRepository)}
+	WithRefreshTokens(&memoryRefreshTokens{tokens: map[string]domain.RefreshToken{}}, time.Hour)(service)
+
+	// neither request is authenticated, so their contexts carry no tenant
+	tokens, err := service.AuthenticateUser(context.Background(), domain.UserLoginRequest{Email: user.Email, Password: "correct horse"})
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if _, err := service.Refresh(context.Background(), tokens.RefreshToken); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+}
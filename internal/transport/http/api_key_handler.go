@@ -0,0 +1,131 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/ktsiligkos/xm_project/internal/auth"
+	"github.com/ktsiligkos/xm_project/internal/domain"
+	apikeyservice "github.com/ktsiligkos/xm_project/internal/service/apikey"
+)
+
+// APIKeyService captures the API key capabilities needed by the HTTP layer.
+type APIKeyService interface {
+	CreateAPIKey(ctx context.Context, req domain.CreateAPIKeyRequest) (domain.CreatedAPIKey, error)
+	ListAPIKeys(ctx context.Context) ([]domain.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id string) error
+}
+
+// APIKeysHandler exposes the admin endpoints for API keys.
+type APIKeysHandler struct {
+	service APIKeyService
+	users   UserLookup
+	logger  *zap.Logger
+}
+
+// NewAPIKeysHandler wires a service into the HTTP handler, users finds the owners of new keys.
+func NewAPIKeysHandler(service APIKeyService, users UserLookup, logger *zap.Logger) *APIKeysHandler {
+	return &APIKeysHandler{service: service, users: users, logger: logger}
+}
+
+// Create issues a key, the response is the only place the key is shown.
+func (h *APIKeysHandler) Create(c *gin.Context) {
+	logger := requestLogger(h.logger, c)
+
+	var payload domain.CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		if logger != nil {
+			logger.Info("invalid create api key request body", zap.Error(err))
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	// a key never grants more than its creator holds, whoever it is issued to;
+	// unknown scopes are left to the service, which rejects them with 400
+	scopes := make([]string, 0, len(payload.Scopes))
+	for _, scope := range payload.Scopes {
+		if scope = strings.TrimSpace(scope); auth.IsPermission(scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	if missing, ok := lackedPermission(c, scopes); !ok {
+		if logger != nil {
+			logger.Warn("api key scope refused", zap.String("permission", missing))
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": "scopes may only name permissions you hold", "permission": missing})
+		return
+	}
+	if payload.UserID != "" && !mayManageUser(c, logger, h.users, payload.UserID) {
+		return
+	}
+
+	key, err := h.service.CreateAPIKey(c.Request.Context(), payload)
+	if err != nil {
+		h.writeError(c, logger, err, "failed to create api key")
+		return
+	}
+
+	if logger != nil {
+		logger.Info("api key created", zap.String("api_key_id", key.ID), zap.String("user_id", key.UserID), zap.Strings("scopes", key.Scopes))
+	}
+
+	c.JSON(http.StatusCreated, key)
+}
+
+// List returns every key without the key itself.
+func (h *APIKeysHandler) List(c *gin.Context) {
+	logger := requestLogger(h.logger, c)
+
+	keys, err := h.service.ListAPIKeys(c.Request.Context())
+	if err != nil {
+		h.writeError(c, logger, err, "failed to list api keys")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"api_keys": keys})
+}
+
+// Revoke stops the key identified by the route param from authenticating.
+func (h *APIKeysHandler) Revoke(c *gin.Context) {
+	logger := requestLogger(h.logger, c)
+	id := c.Param("id")
+	if logger != nil {
+		logger = logger.With(zap.String("api_key_id", id))
+	}
+
+	if err := h.service.RevokeAPIKey(c.Request.Context(), id); err != nil {
+		h.writeError(c, logger, err, "failed to revoke api key")
+		return
+	}
+
+	if logger != nil {
+		logger.Info("api key revoked")
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+func (h *APIKeysHandler) writeError(c *gin.Context, logger *zap.Logger, err error, message string) {
+	switch {
+	case errors.Is(err, apikeyservice.ErrInvalidInput):
+		if logger != nil {
+			logger.Info("invalid api key request", zap.Error(err))
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, apikeyservice.ErrNotFound):
+		if logger != nil {
+			logger.Info("api key not found", zap.Error(err))
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "api key not found"})
+	default:
+		if logger != nil {
+			logger.Error(message, zap.Error(err), zap.Stack("stack"))
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
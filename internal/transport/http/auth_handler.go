@@ -3,7 +3,9 @@ package http
 import (
 	"context"
 	"errors"
+	"math"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
@@ -14,7 +16,11 @@ import (
 
 // CompanyService captures the service capabilities needed by the HTTP layer.
 type UsersService interface {
-	AuthenticateUser(ctx context.Context, user domain.UserLoginRequest) (token string, err error)
+	AuthenticateUser(ctx context.Context, user domain.UserLoginRequest) (domain.TokenPair, error)
+	Refresh(ctx context.Context, refreshToken string) (domain.TokenPair, error)
+	Logout(ctx context.Context, refreshToken string) error
+	RequestPasswordReset(ctx context.Context, email string) error
+	ConfirmPasswordReset(ctx context.Context, req domain.ConfirmPasswordResetRequest) error
 }
 
 // CompaniesHandler exposes company endpoints.
@@ -46,23 +52,28 @@ func (h *UsersHandler) Login(c *gin.Context) {
 		return
 	}
 
-	token, err := h.service.AuthenticateUser(c.Request.Context(), payload)
+	tokens, err := h.service.AuthenticateUser(c.Request.Context(), payload)
 	if err != nil {
 		if logger != nil {
 			logger = logger.With(zap.String("email", payload.Email))
 		}
 
 		switch {
-		case errors.Is(err, userservice.ErrNotFound):
+		case errors.Is(err, userservice.ErrLoginThrottled):
 			if logger != nil {
-				logger.Warn("user not found", zap.Error(err))
+				logger.Warn("login throttled", zap.Error(err))
 			}
-			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			writeThrottled(c, err)
 		case errors.Is(err, userservice.ErrAuthFailed):
 			if logger != nil {
 				logger.Info("authentication failed", zap.Error(err))
 			}
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+		case errors.Is(err, userservice.ErrMFAEnrollmentRequired):
+			if logger != nil {
+				logger.Info("login refused until mfa enrollment", zap.Error(err))
+			}
+			c.JSON(http.StatusForbidden, gin.H{"error": "mfa enrollment required, ask an administrator"})
 		case errors.Is(err, userservice.ErrTokenGenerationFailed):
 			if logger != nil {
 				logger.Error("token generation failed", zap.Error(err), zap.Stack("stack"))
@@ -78,11 +89,170 @@ func (h *UsersHandler) Login(c *gin.Context) {
 	}
 
 	if logger != nil {
-		logger.Info("user authenticated")
+		if tokens.MFA != nil {
+			logger.Info("password accepted, mfa pending")
+		} else {
+			logger.Info("user authenticated")
+		}
+	}
+
+	c.JSON(http.StatusOK, tokenResponse(tokens))
+}
+
+// Refresh exchanges a refresh token for a new token pair.
+func (h *UsersHandler) Refresh(c *gin.Context) {
+	logger := requestLogger(h.logger, c)
+
+	var payload domain.RefreshTokenRequest
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		if logger != nil {
+			logger.Info("invalid refresh request body", zap.Error(err))
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	tokens, err := h.service.Refresh(c.Request.Context(), payload.RefreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, userservice.ErrRefreshTokenReused):
+			if logger != nil {
+				logger.Warn("refresh token reused, token family revoked", zap.Error(err))
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh token"})
+		case errors.Is(err, userservice.ErrInvalidRefreshToken), errors.Is(err, userservice.ErrAuthFailed):
+			if logger != nil {
+				logger.Info("refresh rejected", zap.Error(err))
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh token"})
+		default:
+			if logger != nil {
+				logger.Error("refresh failed", zap.Error(err), zap.Stack("stack"))
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to refresh token"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, tokenResponse(tokens))
+}
+
+// Logout revokes the refresh token and every token rotated from the same login.
+func (h *UsersHandler) Logout(c *gin.Context) {
+	logger := requestLogger(h.logger, c)
+
+	var payload domain.RefreshTokenRequest
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		if logger != nil {
+			logger.Info("invalid logout request body", zap.Error(err))
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"status": "success",
-		"token":  token,
-	})
+	if err := h.service.Logout(c.Request.Context(), payload.RefreshToken); err != nil {
+		if logger != nil {
+			logger.Error("logout failed", zap.Error(err), zap.Stack("stack"))
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to log out"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+// RequestPasswordReset mails a reset link to the address. It answers 202 whether or not
+// the address belongs to a user, and also when the address or client IP asked too often
+// and no mail is sent, so the answer does not tell which requests got through. Refusals
+// and other failures are only logged.
+func (h *UsersHandler) RequestPasswordReset(c *gin.Context) {
+	logger := requestLogger(h.logger, c)
+
+	var payload domain.PasswordResetRequest
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		if logger != nil {
+			logger.Info("invalid password reset request body", zap.Error(err))
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	if err := h.service.RequestPasswordReset(c.Request.Context(), payload.Email); err != nil {
+		if errors.Is(err, userservice.ErrPasswordResetThrottled) {
+			if logger != nil {
+				logger.Warn("password reset throttled, no mail sent", zap.String("email", payload.Email))
+			}
+		} else if logger != nil {
+			logger.Error("password reset request failed", zap.Error(err), zap.Stack("stack"))
+		}
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"status": "accepted"})
+}
+
+// ConfirmPasswordReset sets a new password with the token from a reset mail.
+func (h *UsersHandler) ConfirmPasswordReset(c *gin.Context) {
+	logger := requestLogger(h.logger, c)
+
+	var payload domain.ConfirmPasswordResetRequest
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		if logger != nil {
+			logger.Info("invalid password reset confirmation body", zap.Error(err))
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	if err := h.service.ConfirmPasswordReset(c.Request.Context(), payload); err != nil {
+		switch {
+		case errors.Is(err, userservice.ErrInvalidResetToken):
+			if logger != nil {
+				logger.Info("password reset rejected", zap.Error(err))
+			}
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired reset token"})
+		case errors.Is(err, userservice.ErrInvalidInput):
+			if logger != nil {
+				logger.Info("new password rejected", zap.Error(err))
+			}
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			if logger != nil {
+				logger.Error("password reset failed", zap.Error(err), zap.Stack("stack"))
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reset password"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+// writeThrottled answers a refused login with 429 and the seconds to wait in Retry-After
+func writeThrottled(c *gin.Context, err error) {
+	var throttled *userservice.LoginThrottledError
+	if errors.As(err, &throttled) {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+	}
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many failed login attempts, retry later"})
+}
+
+// tokenResponse renders the tokens, or the pending second step of a login with MFA
+func tokenResponse(tokens domain.TokenPair) gin.H {
+	if tokens.MFA != nil {
+		return gin.H{
+			"status":     "mfa_required",
+			"mfa_token":  tokens.MFA.Token,
+			"expires_in": int(tokens.MFA.TTL.Seconds()),
+		}
+	}
+	response := gin.H{
+		"status":     "success",
+		"token":      tokens.AccessToken,
+		"token_type": "Bearer",
+		"expires_in": int(tokens.AccessTokenTTL.Seconds()),
+	}
+	if tokens.RefreshToken != "" {
+		response["refresh_token"] = tokens.RefreshToken
+	}
+	return response
 }
@@ -0,0 +1,356 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+
+	"github.com/ktsiligkos/xm_project/internal/correlation"
+	"github.com/ktsiligkos/xm_project/internal/platform/events/broadcast"
+	companyservice "github.com/ktsiligkos/xm_project/internal/service/company"
+	"github.com/ktsiligkos/xm_project/internal/transport/http/middleware"
+)
+
+// Message types of the WebSocket protocol
+const (
+	socketSubscribe    = "subscribe"
+	socketUnsubscribe  = "unsubscribe"
+	socketPing         = "ping"
+	socketSubscribed   = "subscribed"
+	socketUnsubscribed = "unsubscribed"
+	socketPong         = "pong"
+	socketEvent        = "event"
+	socketError        = "error"
+)
+
+var errSlowConsumer = errors.New("slow consumer")
+
+// SocketOptions limits what a single WebSocket connection may use
+type SocketOptions struct {
+	// MaxSubscriptions caps the company IDs a connection follows at once
+	MaxSubscriptions int
+	// SendBuffer is how many outgoing messages may queue up before the client counts as slow
+	SendBuffer int
+	// WriteTimeout is how long a single write may block on the client
+	WriteTimeout time.Duration
+	// PingInterval is how often the server pings, a client that does not pong within two intervals is dropped
+	PingInterval    time.Duration
+	MaxMessageBytes int64
+	// AllowedOrigins lists the origins of other sites whose pages may connect, e.g.
+	// https://app.example.com. Pages of the same origin and clients that send no Origin always may.
+	AllowedOrigins []string
+}
+
+func (o SocketOptions) withDefaults() SocketOptions {
+	if o.MaxSubscriptions <= 0 {
+		o.MaxSubscriptions = 100
+	}
+	if o.SendBuffer <= 0 {
+		o.SendBuffer = 64
+	}
+	if o.WriteTimeout <= 0 {
+		o.WriteTimeout = 10 * time.Second
+	}
+	if o.PingInterval <= 0 {
+		o.PingInterval = 30 * time.Second
+	}
+	if o.MaxMessageBytes <= 0 {
+		o.MaxMessageBytes = 4096
+	}
+	return o
+}
+
+// socketRequest is a message sent by the client
+type socketRequest struct {
+	Type       string   `json:"type"`
+	ID         string   `json:"id,omitempty"`
+	CompanyIDs []string `json:"company_ids,omitempty"`
+}
+
+// socketMessage is a message sent by the server, ID echoes the request it answers
+type socketMessage struct {
+	Type       string                       `json:"type"`
+	ID         string                       `json:"id,omitempty"`
+	CompanyIDs []string                     `json:"company_ids,omitempty"`
+	Event      *companyservice.CompanyEvent `json:"event,omitempty"`
+	Error      string                       `json:"error,omitempty"`
+}
+
+// CompanySocketHandler lets clients follow individual companies over a WebSocket.
+type CompanySocketHandler struct {
+	source   CompanyEventSource
+	opts     SocketOptions
+	upgrader websocket.Upgrader
+	logger   *zap.Logger
+}
+
+// NewCompanySocketHandler wires an event source into the WebSocket handler.
+func NewCompanySocketHandler(source CompanyEventSource, opts SocketOptions, logger *zap.Logger) *CompanySocketHandler {
+	return &CompanySocketHandler{
+		source: source,
+		opts:   opts.withDefaults(),
+		upgrader: websocket.Upgrader{
+			// browsers send the token as a subprotocol, which the handshake has to accept
+			Subprotocols: []string{middleware.SocketTokenProtocol},
+			// browsers let any site open a socket, this keeps other sites from using a visitor's session
+			CheckOrigin: originChecker(opts.AllowedOrigins),
+		},
+		logger: logger,
+	}
+}
+
+// originChecker accepts requests without an Origin header, from the origin of the service itself and from allowed
+func originChecker(allowed []string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		for _, candidate := range allowed {
+			if strings.EqualFold(origin, strings.TrimRight(candidate, "/")) {
+				return true
+			}
+		}
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+}
+
+// Serve upgrades the request and runs the subscribe/unsubscribe/ping protocol until either side closes.
+func (h *CompanySocketHandler) Serve(c *gin.Context) {
+	logger := requestLogger(h.logger, c)
+
+	ws, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// the upgrader has already written the error response
+		if logger != nil {
+			logger.Info("websocket upgrade failed", zap.Error(err))
+		}
+		return
+	}
+
+	sub, _, _ := h.source.Subscribe(broadcast.Filter{Tenant: correlation.Tenant(c.Request.Context())}, "")
+	conn := &socketConn{
+		ws:     ws,
+		opts:   h.opts,
+		send:   make(chan socketMessage, h.opts.SendBuffer),
+		done:   make(chan struct{}),
+		follow: map[string]bool{},
+	}
+
+	if logger != nil {
+		logger.Info("company socket opened")
+	}
+
+	go conn.writeLoop()
+	go conn.forward(sub)
+	conn.readLoop()
+
+	sub.Close()
+	if logger != nil {
+		logger.Info("company socket closed", zap.String("reason", conn.reason))
+	}
+}
+
+// socketConn is the state of one WebSocket connection. Only writeLoop writes
+// data frames, the other goroutines hand their messages to it through send.
+type socketConn struct {
+	ws   *websocket.Conn
+	opts SocketOptions
+	send chan socketMessage
+
+	closeOnce sync.Once
+	done      chan struct{}
+	reason    string
+
+	mu     sync.Mutex
+	follow map[string]bool
+}
+
+func (s *socketConn) readLoop() {
+	s.ws.SetReadLimit(s.opts.MaxMessageBytes)
+	_ = s.ws.SetReadDeadline(time.Now().Add(2 * s.opts.PingInterval))
+	s.ws.SetPongHandler(func(string) error {
+		return s.ws.SetReadDeadline(time.Now().Add(2 * s.opts.PingInterval))
+	})
+
+	for {
+		var (
+			req   socketRequest
+			reply socketMessage
+		)
+		err := s.ws.ReadJSON(&req)
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case err == nil:
+			reply = s.handle(req)
+		case errors.As(err, &syntaxErr), errors.As(err, &typeErr):
+			// the frame was read completely, so the connection is still usable
+			reply = socketMessage{Type: socketError, Error: "invalid message"}
+		case errors.Is(err, websocket.ErrReadLimit):
+			s.close(websocket.CloseMessageTooBig, "message too big")
+			return
+		default:
+			s.close(websocket.CloseNormalClosure, "")
+			return
+		}
+
+		if err := s.enqueue(reply); err != nil {
+			s.close(websocket.ClosePolicyViolation, err.Error())
+			return
+		}
+	}
+}
+
+func (s *socketConn) handle(req socketRequest) socketMessage {
+	switch req.Type {
+	case socketPing:
+		return socketMessage{Type: socketPong, ID: req.ID}
+	case socketSubscribe:
+		if len(req.CompanyIDs) == 0 {
+			return socketMessage{Type: socketError, ID: req.ID, Error: "company_ids required"}
+		}
+		following, err := s.subscribe(req.CompanyIDs)
+		if err != nil {
+			return socketMessage{Type: socketError, ID: req.ID, Error: err.Error()}
+		}
+		return socketMessage{Type: socketSubscribed, ID: req.ID, CompanyIDs: following}
+	case socketUnsubscribe:
+		return socketMessage{Type: socketUnsubscribed, ID: req.ID, CompanyIDs: s.unsubscribe(req.CompanyIDs)}
+	default:
+		return socketMessage{Type: socketError, ID: req.ID, Error: fmt.Sprintf("unknown message type %q", req.Type)}
+	}
+}
+
+// subscribe adds the IDs and returns everything followed, all or nothing when the limit would be exceeded
+func (s *socketConn) subscribe(ids []string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	added := map[string]bool{}
+	for _, id := range ids {
+		if id == "" {
+			return nil, errors.New("company_ids must not be empty")
+		}
+		if !s.follow[id] {
+			added[id] = true
+		}
+	}
+	if len(s.follow)+len(added) > s.opts.MaxSubscriptions {
+		return nil, fmt.Errorf("subscription limit of %d companies reached", s.opts.MaxSubscriptions)
+	}
+
+	for id := range added {
+		s.follow[id] = true
+	}
+	return s.followingLocked(), nil
+}
+
+// unsubscribe removes the IDs, none means all, and returns what is still followed
+func (s *socketConn) unsubscribe(ids []string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(ids) == 0 {
+		s.follow = map[string]bool{}
+	}
+	for _, id := range ids {
+		delete(s.follow, id)
+	}
+	return s.followingLocked()
+}
+
+func (s *socketConn) following(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.follow[id]
+}
+
+func (s *socketConn) followingLocked() []string {
+	ids := make([]string, 0, len(s.follow))
+	for id := range s.follow {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// forward passes the followed companies' events to the client
+func (s *socketConn) forward(sub *broadcast.Subscription) {
+	for {
+		select {
+		case <-s.done:
+			return
+		case ev, ok := <-sub.C():
+			if !ok {
+				// dropped by the broadcaster for falling behind, or the server is shutting down
+				s.close(websocket.CloseTryAgainLater, "event stream ended, reconnect")
+				return
+			}
+			if !s.following(ev.Event.Company.ID) {
+				continue
+			}
+			event := ev.Event
+			if err := s.enqueue(socketMessage{Type: socketEvent, Event: &event}); err != nil {
+				s.close(websocket.ClosePolicyViolation, err.Error())
+				return
+			}
+		}
+	}
+}
+
+// enqueue never blocks, a client that does not keep up is disconnected
+func (s *socketConn) enqueue(msg socketMessage) error {
+	select {
+	case <-s.done:
+		return nil
+	case s.send <- msg:
+		return nil
+	default:
+		return errSlowConsumer
+	}
+}
+
+func (s *socketConn) writeLoop() {
+	ticker := time.NewTicker(s.opts.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case msg := <-s.send:
+			_ = s.ws.SetWriteDeadline(time.Now().Add(s.opts.WriteTimeout))
+			if err := s.ws.WriteJSON(msg); err != nil {
+				s.close(websocket.ClosePolicyViolation, errSlowConsumer.Error())
+				return
+			}
+		case <-ticker.C:
+			if err := s.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(s.opts.WriteTimeout)); err != nil {
+				s.close(websocket.CloseGoingAway, "")
+				return
+			}
+		}
+	}
+}
+
+// close sends a close frame with the reason and tears the connection down, only the first call counts
+func (s *socketConn) close(code int, reason string) {
+	s.closeOnce.Do(func() {
+		s.reason = reason
+		close(s.done)
+		_ = s.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(s.opts.WriteTimeout))
+		_ = s.ws.Close()
+	})
+}
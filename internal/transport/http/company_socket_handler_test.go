@@ -0,0 +1,204 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+
+	"github.com/ktsiligkos/xm_project/internal/auth"
+	"github.com/ktsiligkos/xm_project/internal/platform/events/broadcast"
+	companyservice "github.com/ktsiligkos/xm_project/internal/service/company"
+	"github.com/ktsiligkos/xm_project/internal/transport/http/middleware"
+)
+
+var socketKeys = auth.NewHMACKeySet([]byte("socket-secret"))
+
+func startSocketServer(t *testing.T, b *broadcast.Broadcaster, opts SocketOptions) string {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/companies/ws", middleware.RequireSocketAuth(socketKeys, nil), middleware.RequirePermission(auth.PermissionCompaniesRead), NewCompanySocketHandler(b, opts, nil).Serve)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http") + "/companies/ws"
+}
+
+// dialSocket connects like a browser does, with the token as subprotocol
+func dialSocket(t *testing.T, url string) *websocket.Conn {
+	t.Helper()
+	conn, resp, err := socketDialer(t).Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if got := resp.Header.Get("Sec-WebSocket-Protocol"); got != middleware.SocketTokenProtocol {
+		t.Fatalf("expected the handshake to accept the %q subprotocol, got %q", middleware.SocketTokenProtocol, got)
+	}
+	return conn
+}
+
+func socketDialer(t *testing.T) *websocket.Dialer {
+	t.Helper()
+	token, err := auth.GenerateJWT("user-1", "tenant-1", []string{auth.RoleViewer}, socketKeys, time.Minute)
+	if err != nil {
+		t.Fatalf("GenerateJWT returned error: %v", err)
+	}
+	return &websocket.Dialer{Subprotocols: []string{middleware.SocketTokenProtocol, token}}
+}
+
+func roundTrip(t *testing.T, conn *websocket.Conn, req socketRequest) socketMessage {
+	t.Helper()
+	if err := conn.WriteJSON(req); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	return readMessage(t, conn)
+}
+
+func readMessage(t *testing.T, conn *websocket.Conn) socketMessage {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var msg socketMessage
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	return msg
+}
+
+func publishCompany(b *broadcast.Broadcaster, id string) {
+	publishTenantCompany(b, "tenant-1", id)
+}
+
+func publishTenantCompany(b *broadcast.Broadcaster, tenant, id string) {
+	_ = b.PublishCompanyEvent(context.Background(), companyservice.CompanyEvent{
+		Operation: companyservice.OperationPatched,
+		Company:   companyservice.EventCompany{ID: id, TenantID: tenant, Name: "Name-" + id},
+		Metadata:  companyservice.EventMetadata{Tenant: tenant},
+	})
+}
+
+func TestCompanySocket_DeliversOnlySubscribedCompanies(t *testing.T) {
+	Given(t, "a connected client following company a")
+	b := broadcast.NewBroadcaster(broadcast.Options{})
+	conn := dialSocket(t, startSocketServer(t, b, SocketOptions{}))
+
+	reply := roundTrip(t, conn, socketRequest{Type: socketSubscribe, ID: "1", CompanyIDs: []string{"a", "b"}})
+	if reply.Type != socketSubscribed || reply.ID != "1" || len(reply.CompanyIDs) != 2 {
+		t.Fatalf("unexpected subscribe reply: %+v", reply)
+	}
+	reply = roundTrip(t, conn, socketRequest{Type: socketUnsubscribe, ID: "2", CompanyIDs: []string{"b"}})
+	if reply.Type != socketUnsubscribed || len(reply.CompanyIDs) != 1 || reply.CompanyIDs[0] != "a" {
+		t.Fatalf("unexpected unsubscribe reply: %+v", reply)
+	}
+
+	When(t, "changes for b, a of another tenant and a are published")
+	publishCompany(b, "b")
+	publishTenantCompany(b, "tenant-2", "a")
+	publishCompany(b, "a")
+
+	Then(t, "only the change for a of the client's tenant arrives")
+	msg := readMessage(t, conn)
+	if msg.Type != socketEvent || msg.Event == nil || msg.Event.Company.ID != "a" || msg.Event.Metadata.Tenant != "tenant-1" {
+		t.Fatalf("unexpected message: %+v", msg)
+	}
+
+	reply = roundTrip(t, conn, socketRequest{Type: socketPing, ID: "3"})
+	if reply.Type != socketPong || reply.ID != "3" {
+		t.Fatalf("unexpected ping reply: %+v", reply)
+	}
+}
+
+func TestCompanySocket_EnforcesSubscriptionLimit(t *testing.T) {
+	b := broadcast.NewBroadcaster(broadcast.Options{})
+	conn := dialSocket(t, startSocketServer(t, b, SocketOptions{MaxSubscriptions: 2}))
+
+	reply := roundTrip(t, conn, socketRequest{Type: socketSubscribe, CompanyIDs: []string{"a", "b", "c"}})
+	if reply.Type != socketError || !strings.Contains(reply.Error, "limit") {
+		t.Fatalf("expected a limit error, got %+v", reply)
+	}
+
+	reply = roundTrip(t, conn, socketRequest{Type: socketSubscribe, CompanyIDs: []string{"a", "a", "b"}})
+	if reply.Type != socketSubscribed || len(reply.CompanyIDs) != 2 {
+		t.Fatalf("duplicates must count once, got %+v", reply)
+	}
+}
+
+func TestCompanySocket_ClosesSlowConsumer(t *testing.T) {
+	Given(t, "a client that follows a company but stops reading")
+	b := broadcast.NewBroadcaster(broadcast.Options{SubscriberBuffer: 1000})
+	conn := dialSocket(t, startSocketServer(t, b, SocketOptions{SendBuffer: 1, WriteTimeout: 50 * time.Millisecond}))
+	roundTrip(t, conn, socketRequest{Type: socketSubscribe, CompanyIDs: []string{"a"}})
+
+	When(t, "more changes are published than the connection can queue")
+	for i := 0; i < 500; i++ {
+		publishCompany(b, "a")
+	}
+
+	Then(t, "the server closes the connection as a policy violation")
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+			t.Fatalf("want a policy violation close, got %v", err)
+		}
+		return
+	}
+}
+
+func TestCompanySocket_RequiresToken(t *testing.T) {
+	url := startSocketServer(t, broadcast.NewBroadcaster(broadcast.Options{}), SocketOptions{})
+
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil {
+		t.Fatal("expected the handshake to be rejected")
+	}
+	if resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("want 401, got %+v", resp)
+	}
+}
+
+func TestCompanySocket_RejectsPagesOfOtherSites(t *testing.T) {
+	Given(t, "a socket server that allows https://app.example.com")
+	url := startSocketServer(t, broadcast.NewBroadcaster(broadcast.Options{}), SocketOptions{AllowedOrigins: []string{"https://app.example.com"}})
+
+	cases := []struct {
+		origin string
+		allow  bool
+	}{
+		{"https://app.example.com", true},
+		{"https://evil.example.com", false},
+		{"", true},
+	}
+	for _, tc := range cases {
+		When(t, "a client connects with the origin %q", tc.origin)
+		header := http.Header{}
+		if tc.origin != "" {
+			header.Set("Origin", tc.origin)
+		}
+		conn, resp, err := socketDialer(t).Dial(url, header)
+
+		Then(t, "the connection is allowed: %v", tc.allow)
+		if tc.allow {
+			if err != nil {
+				t.Fatalf("origin %q: dial failed: %v", tc.origin, err)
+			}
+			conn.Close()
+			continue
+		}
+		if err == nil {
+			conn.Close()
+			t.Fatalf("origin %q: expected the handshake to be refused", tc.origin)
+		}
+		if resp == nil || resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("origin %q: expected 403, got %v", tc.origin, resp)
+		}
+	}
+}
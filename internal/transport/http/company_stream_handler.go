@@ -0,0 +1,180 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/ktsiligkos/xm_project/internal/correlation"
+	"github.com/ktsiligkos/xm_project/internal/domain"
+	"github.com/ktsiligkos/xm_project/internal/platform/events/broadcast"
+)
+
+const (
+	defaultHeartbeatInterval = 15 * time.Second
+	// how long a browser waits before reconnecting a dropped stream
+	streamRetryMillis = 3000
+)
+
+// CompanyEventSource captures the subscription capabilities needed by the stream endpoint.
+type CompanyEventSource interface {
+	Subscribe(filter broadcast.Filter, lastEventID string) (*broadcast.Subscription, []broadcast.Event, bool)
+}
+
+// CompanyStreamHandler streams company changes to clients as Server-Sent Events.
+type CompanyStreamHandler struct {
+	source    CompanyEventSource
+	heartbeat time.Duration
+	logger    *zap.Logger
+}
+
+// NewCompanyStreamHandler wires an event source into the HTTP handler.
+// A comment line is sent every heartbeat so proxies keep idle streams open.
+func NewCompanyStreamHandler(source CompanyEventSource, heartbeat time.Duration, logger *zap.Logger) *CompanyStreamHandler {
+	if heartbeat <= 0 {
+		heartbeat = defaultHeartbeatInterval
+	}
+	return &CompanyStreamHandler{source: source, heartbeat: heartbeat, logger: logger}
+}
+
+// Stream sends company create/patch/delete notifications until the client disconnects.
+// Clients may filter with the type and id query params (repeated or comma separated)
+// and resume with the Last-Event-ID header, or the last_event_id query param.
+func (h *CompanyStreamHandler) Stream(c *gin.Context) {
+	logger := requestLogger(h.logger, c)
+
+	filter, err := streamFilter(c)
+	if err != nil {
+		if logger != nil {
+			logger.Info("invalid stream filter", zap.Error(err))
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+
+	sub, replay, resync := h.source.Subscribe(filter, lastEventID)
+	defer sub.Close()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	w := c.Writer
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", streamRetryMillis); err != nil {
+		return
+	}
+	if resync {
+		// the requested position is gone, the client has to reload before following the stream
+		if err := writeSSE(w, "", "resync", []byte("{}")); err != nil {
+			return
+		}
+	}
+	for _, ev := range replay {
+		if err := writeEvent(w, ev); err != nil {
+			return
+		}
+	}
+	w.Flush()
+
+	if logger != nil {
+		logger.Info("company stream opened", zap.Int("replayed", len(replay)), zap.Bool("resync", resync))
+	}
+
+	ticker := time.NewTicker(h.heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			if logger != nil {
+				logger.Info("company stream closed by client")
+			}
+			return
+		case ev, ok := <-sub.C():
+			if !ok {
+				// dropped for falling behind or shutting down, the client reconnects with Last-Event-ID
+				if logger != nil {
+					logger.Info("company stream ended by server")
+				}
+				return
+			}
+			if err := writeEvent(w, ev); err != nil {
+				return
+			}
+			w.Flush()
+		case <-ticker.C:
+			if _, err := io.WriteString(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			w.Flush()
+		}
+	}
+}
+
+func writeEvent(w io.Writer, ev broadcast.Event) error {
+	data, err := json.Marshal(ev.Event)
+	if err != nil {
+		return err
+	}
+	return writeSSE(w, ev.ID, ev.Event.Operation, data)
+}
+
+func writeSSE(w io.Writer, id, event string, data []byte) error {
+	var b strings.Builder
+	if id != "" {
+		fmt.Fprintf(&b, "id: %s\n", id)
+	}
+	fmt.Fprintf(&b, "event: %s\ndata: %s\n\n", event, data)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func streamFilter(c *gin.Context) (broadcast.Filter, error) {
+	// clients only see the companies of their own tenant
+	filter := broadcast.Filter{Tenant: correlation.Tenant(c.Request.Context())}
+
+	for _, t := range queryList(c, "type") {
+		if !domain.CompanyType(t).IsValid() {
+			return filter, fmt.Errorf("unknown company type %q", t)
+		}
+		if filter.Types == nil {
+			filter.Types = map[string]bool{}
+		}
+		filter.Types[t] = true
+	}
+
+	for _, id := range queryList(c, "id") {
+		if filter.CompanyIDs == nil {
+			filter.CompanyIDs = map[string]bool{}
+		}
+		filter.CompanyIDs[id] = true
+	}
+
+	return filter, nil
+}
+
+// queryList accepts both ?key=a&key=b and ?key=a,b
+func queryList(c *gin.Context, key string) []string {
+	var values []string
+	for _, raw := range c.QueryArray(key) {
+		for _, v := range strings.Split(raw, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+	}
+	return values
+}
@@ -0,0 +1,100 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ktsiligkos/xm_project/internal/platform/events/broadcast"
+	companyservice "github.com/ktsiligkos/xm_project/internal/service/company"
+)
+
+func startStreamServer(t *testing.T, b *broadcast.Broadcaster) *httptest.Server {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/companies/stream", NewCompanyStreamHandler(b, 20*time.Millisecond, nil).Stream)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// readFields collects SSE field lines until want lines are read or the timeout hits
+func readFields(t *testing.T, resp *http.Response, want int) []string {
+	t.Helper()
+	lines := make(chan string, 100)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if line := scanner.Text(); line != "" {
+				lines <- line
+			}
+		}
+		close(lines)
+	}()
+
+	var got []string
+	timeout := time.After(2 * time.Second)
+	for len(got) < want {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				return got
+			}
+			got = append(got, line)
+		case <-timeout:
+			t.Fatalf("timed out after reading %v", got)
+		}
+	}
+	return got
+}
+
+func TestCompanyStream_ReplaysFilteredEventsAndHeartbeats(t *testing.T) {
+	Given(t, "a broadcaster that already saw events of two types")
+	b := broadcast.NewBroadcaster(broadcast.Options{})
+	seen, _, _ := b.Subscribe(broadcast.Filter{}, "")
+	_ = b.PublishCompanyEvent(context.Background(), companyservice.CompanyEvent{Operation: companyservice.OperationCreated, Company: companyservice.EventCompany{ID: "a", Type: "NonProfit"}})
+	lastEventID := (<-seen.C()).ID
+	seen.Close()
+	_ = b.PublishCompanyEvent(context.Background(), companyservice.CompanyEvent{Operation: companyservice.OperationCreated, Company: companyservice.EventCompany{ID: "b", Type: "Corporations"}})
+	_ = b.PublishCompanyEvent(context.Background(), companyservice.CompanyEvent{Operation: companyservice.OperationDeleted, Company: companyservice.EventCompany{ID: "c", Type: "NonProfit"}})
+	server := startStreamServer(t, b)
+
+	When(t, "a client resumes the NonProfit stream after the first event")
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/companies/stream?type=NonProfit", nil)
+	req.Header.Set("Last-Event-ID", lastEventID)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	Then(t, "only the later NonProfit event is replayed, followed by heartbeats")
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	got := readFields(t, resp, 5)
+	if got[0] != "retry: 3000" || !strings.HasPrefix(got[1], "id: ") || got[2] != "event: company.deleted" || !strings.Contains(got[3], `"id":"c"`) || got[4] != ": heartbeat" {
+		t.Fatalf("unexpected stream: %v", got)
+	}
+}
+
+func TestCompanyStream_RejectsUnknownType(t *testing.T) {
+	server := startStreamServer(t, broadcast.NewBroadcaster(broadcast.Options{}))
+
+	resp, err := http.Get(server.URL + "/companies/stream?type=Bank")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("want 400, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,217 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/ktsiligkos/xm_project/internal/domain"
+	deadletterservice "github.com/ktsiligkos/xm_project/internal/service/deadletter"
+)
+
+// DeadLetterService captures the dead letter capabilities needed by the HTTP layer.
+type DeadLetterService interface {
+	List(ctx context.Context, afterID int64, limit int) ([]domain.DeadLetter, error)
+	Get(ctx context.Context, id int64) (domain.DeadLetter, error)
+	Replay(ctx context.Context, id int64) error
+	ReplayAll(ctx context.Context) (deadletterservice.ReplayResult, error)
+	Discard(ctx context.Context, id int64) error
+	DiscardAll(ctx context.Context) (int64, error)
+}
+
+// DeadLettersHandler exposes the admin endpoints for failed company events.
+type DeadLettersHandler struct {
+	service DeadLetterService
+	logger  *zap.Logger
+}
+
+// NewDeadLettersHandler wires a service into the HTTP handler.
+func NewDeadLettersHandler(service DeadLetterService, logger *zap.Logger) *DeadLettersHandler {
+	return &DeadLettersHandler{service: service, logger: logger}
+}
+
+// List returns a page of dead letters, paginated with after_id and limit.
+func (h *DeadLettersHandler) List(c *gin.Context) {
+	logger := requestLogger(h.logger, c)
+
+	afterID, err := queryInt64(c, "after_id")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "after_id must be an integer"})
+		return
+	}
+	limit, err := queryInt64(c, "limit")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be an integer"})
+		return
+	}
+
+	deadLetters, err := h.service.List(c.Request.Context(), afterID, int(limit))
+	if err != nil {
+		if logger != nil {
+			logger.Error("failed to list dead letters", zap.Error(err), zap.Stack("stack"))
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list dead letters"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"dead_letters": deadLetters})
+}
+
+// Get returns a single dead letter identified by the route param.
+func (h *DeadLettersHandler) Get(c *gin.Context) {
+	logger := requestLogger(h.logger, c)
+
+	id, ok := deadLetterID(c)
+	if !ok {
+		return
+	}
+
+	deadLetter, err := h.service.Get(c.Request.Context(), id)
+	if err != nil {
+		h.writeError(c, logger, err, "failed to fetch dead letter")
+		return
+	}
+
+	c.JSON(http.StatusOK, deadLetter)
+}
+
+// Replay publishes a single dead letter again.
+func (h *DeadLettersHandler) Replay(c *gin.Context) {
+	logger := requestLogger(h.logger, c)
+
+	id, ok := deadLetterID(c)
+	if !ok {
+		return
+	}
+	if logger != nil {
+		logger = logger.With(zap.Int64("dead_letter_id", id))
+	}
+
+	if err := h.service.Replay(c.Request.Context(), id); err != nil {
+		h.writeError(c, logger, err, "failed to replay dead letter")
+		return
+	}
+
+	if logger != nil {
+		logger.Info("dead letter replayed")
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+// ReplayAll publishes every stored dead letter again.
+func (h *DeadLettersHandler) ReplayAll(c *gin.Context) {
+	logger := requestLogger(h.logger, c)
+
+	result, err := h.service.ReplayAll(c.Request.Context())
+	if err != nil {
+		if logger != nil {
+			logger.Error("failed to replay dead letters", zap.Error(err), zap.Stack("stack"))
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to replay dead letters"})
+		return
+	}
+
+	if logger != nil {
+		logger.Info("dead letters replayed", zap.Int("replayed", result.Replayed), zap.Int("failed", result.Failed))
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// Discard removes a single dead letter without publishing it.
+func (h *DeadLettersHandler) Discard(c *gin.Context) {
+	logger := requestLogger(h.logger, c)
+
+	id, ok := deadLetterID(c)
+	if !ok {
+		return
+	}
+	if logger != nil {
+		logger = logger.With(zap.Int64("dead_letter_id", id))
+	}
+
+	if err := h.service.Discard(c.Request.Context(), id); err != nil {
+		h.writeError(c, logger, err, "failed to discard dead letter")
+		return
+	}
+
+	if logger != nil {
+		logger.Info("dead letter discarded")
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+// DiscardAll removes every stored dead letter.
+func (h *DeadLettersHandler) DiscardAll(c *gin.Context) {
+	logger := requestLogger(h.logger, c)
+
+	discarded, err := h.service.DiscardAll(c.Request.Context())
+	if err != nil {
+		if logger != nil {
+			logger.Error("failed to discard dead letters", zap.Error(err), zap.Stack("stack"))
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to discard dead letters"})
+		return
+	}
+
+	if logger != nil {
+		logger.Info("dead letters discarded", zap.Int64("discarded", discarded))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"discarded": discarded})
+}
+
+func (h *DeadLettersHandler) writeError(c *gin.Context, logger *zap.Logger, err error, message string) {
+	switch {
+	case errors.Is(err, deadletterservice.ErrNotFound):
+		if logger != nil {
+			logger.Info("dead letter not found", zap.Error(err))
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "dead letter not found"})
+	case errors.Is(err, deadletterservice.ErrReplayFailed):
+		if logger != nil {
+			logger.Warn("dead letter replay failed", zap.Error(err))
+		}
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+	default:
+		if logger != nil {
+			logger.Error(message, zap.Error(err), zap.Stack("stack"))
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
+
+func deadLetterID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "dead letter id must be a positive integer"})
+		return 0, false
+	}
+	return id, true
+}
+
+func queryInt64(c *gin.Context, key string) (int64, error) {
+	value := c.Query(key)
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+func requestLogger(logger *zap.Logger, c *gin.Context) *zap.Logger {
+	if logger == nil {
+		return nil
+	}
+
+	if reqID := c.GetString("request_id"); reqID != "" {
+		logger = logger.With(zap.String("request_id", reqID))
+	}
+
+	return logger
+}
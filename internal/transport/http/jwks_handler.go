@@ -0,0 +1,22 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ktsiligkos/xm_project/internal/auth"
+)
+
+// jwksMaxAge lets verifiers cache the key set, a new signing key is published as a
+// verification key first so caches pick it up before tokens are signed with it
+const jwksMaxAge = "public, max-age=300"
+
+// JWKS serves the public keys access tokens are verified with. The set is empty
+// while tokens are signed with the shared HS256 secret.
+func JWKS(keys *auth.KeySet) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", jwksMaxAge)
+		c.JSON(http.StatusOK, keys.JWKS())
+	}
+}
@@ -0,0 +1,280 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/ktsiligkos/xm_project/internal/domain"
+	userservice "github.com/ktsiligkos/xm_project/internal/service/user"
+)
+
+// MFAService captures the MFA capabilities needed by the HTTP layer.
+type MFAService interface {
+	VerifyMFALogin(ctx context.Context, req domain.MFALoginRequest) (domain.TokenPair, error)
+	MFAStatus(ctx context.Context, userID string) (domain.MFAStatus, error)
+	StartMFAEnrollment(ctx context.Context, userID string) (domain.TOTPEnrollment, error)
+	ConfirmMFAEnrollment(ctx context.Context, userID, code string) error
+	DisableMFA(ctx context.Context, userID, code string) error
+	RegenerateRecoveryCodes(ctx context.Context, userID, code string) ([]string, error)
+	ResetMFA(ctx context.Context, userID string) error
+	GetUser(ctx context.Context, id string) (domain.User, error)
+	MFARequiredRoles(ctx context.Context) ([]string, error)
+	SetMFARequiredRoles(ctx context.Context, roles []string) ([]string, error)
+}
+
+// MFAHandler exposes the second login step, the MFA settings of the caller and the MFA admin endpoints.
+type MFAHandler struct {
+	service MFAService
+	logger  *zap.Logger
+}
+
+// NewMFAHandler wires a service into the HTTP handler.
+func NewMFAHandler(service MFAService, logger *zap.Logger) *MFAHandler {
+	return &MFAHandler{service: service, logger: logger}
+}
+
+// VerifyLogin exchanges the mfa_token of a login and a TOTP or recovery code for the tokens.
+func (h *MFAHandler) VerifyLogin(c *gin.Context) {
+	logger := requestLogger(h.logger, c)
+
+	var payload domain.MFALoginRequest
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		if logger != nil {
+			logger.Info("invalid mfa login request body", zap.Error(err))
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	tokens, err := h.service.VerifyMFALogin(c.Request.Context(), payload)
+	if err != nil {
+		switch {
+		case errors.Is(err, userservice.ErrLoginThrottled):
+			if logger != nil {
+				logger.Warn("mfa login throttled", zap.Error(err))
+			}
+			writeThrottled(c, err)
+		case errors.Is(err, userservice.ErrInvalidMFACode):
+			if logger != nil {
+				logger.Info("mfa code rejected", zap.Error(err))
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid mfa code"})
+		case errors.Is(err, userservice.ErrInvalidMFAChallenge), errors.Is(err, userservice.ErrAuthFailed):
+			if logger != nil {
+				logger.Info("mfa token rejected", zap.Error(err))
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired mfa token"})
+		case errors.Is(err, userservice.ErrTokenGenerationFailed):
+			if logger != nil {
+				logger.Error("token generation failed", zap.Error(err), zap.Stack("stack"))
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+		default:
+			h.writeError(c, logger, err, "failed to authenticate")
+		}
+		return
+	}
+
+	if logger != nil {
+		logger.Info("user authenticated with mfa")
+	}
+
+	c.JSON(http.StatusOK, tokenResponse(tokens))
+}
+
+// Status tells the caller whether MFA is on for their account.
+func (h *MFAHandler) Status(c *gin.Context) {
+	logger := requestLogger(h.logger, c)
+
+	status, err := h.service.MFAStatus(c.Request.Context(), c.GetString("user_id"))
+	if err != nil {
+		h.writeError(c, logger, err, "failed to fetch mfa status")
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+// Enroll creates a TOTP secret and recovery codes for the caller, pending until Confirm.
+func (h *MFAHandler) Enroll(c *gin.Context) {
+	logger := requestLogger(h.logger, c)
+
+	enrollment, err := h.service.StartMFAEnrollment(c.Request.Context(), c.GetString("user_id"))
+	if err != nil {
+		h.writeError(c, logger, err, "failed to start mfa enrollment")
+		return
+	}
+
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// Confirm turns MFA on with a first code from the authenticator app.
+func (h *MFAHandler) Confirm(c *gin.Context) {
+	logger := requestLogger(h.logger, c)
+
+	var payload domain.MFACodeRequest
+	if !h.bindCode(c, logger, &payload) {
+		return
+	}
+
+	if err := h.service.ConfirmMFAEnrollment(c.Request.Context(), c.GetString("user_id"), payload.Code); err != nil {
+		h.writeError(c, logger, err, "failed to confirm mfa enrollment")
+		return
+	}
+
+	if logger != nil {
+		logger.Info("mfa enabled")
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+// Disable turns MFA off after checking a TOTP or recovery code.
+func (h *MFAHandler) Disable(c *gin.Context) {
+	logger := requestLogger(h.logger, c)
+
+	var payload domain.MFACodeRequest
+	if !h.bindCode(c, logger, &payload) {
+		return
+	}
+
+	if err := h.service.DisableMFA(c.Request.Context(), c.GetString("user_id"), payload.Code); err != nil {
+		h.writeError(c, logger, err, "failed to disable mfa")
+		return
+	}
+
+	if logger != nil {
+		logger.Info("mfa disabled")
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the caller after checking a TOTP code.
+func (h *MFAHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	logger := requestLogger(h.logger, c)
+
+	var payload domain.MFACodeRequest
+	if !h.bindCode(c, logger, &payload) {
+		return
+	}
+
+	codes, err := h.service.RegenerateRecoveryCodes(c.Request.Context(), c.GetString("user_id"), payload.Code)
+	if err != nil {
+		h.writeError(c, logger, err, "failed to regenerate recovery codes")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// Reset removes the MFA enrollment of a user, for admins helping a user who lost their device.
+func (h *MFAHandler) Reset(c *gin.Context) {
+	logger := requestLogger(h.logger, c)
+	id := c.Param("id")
+	if logger != nil {
+		logger = logger.With(zap.String("user_id", id))
+	}
+
+	if !mayManageUser(c, logger, h.service, id) {
+		return
+	}
+	if err := h.service.ResetMFA(c.Request.Context(), id); err != nil {
+		h.writeError(c, logger, err, "failed to reset mfa")
+		return
+	}
+
+	if logger != nil {
+		logger.Info("mfa reset")
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+// RequiredRoles lists the roles whose users must log in with a second factor.
+func (h *MFAHandler) RequiredRoles(c *gin.Context) {
+	logger := requestLogger(h.logger, c)
+
+	roles, err := h.service.MFARequiredRoles(c.Request.Context())
+	if err != nil {
+		h.writeError(c, logger, err, "failed to fetch mfa required roles")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"roles": roles})
+}
+
+// SetRequiredRoles replaces the roles whose users must log in with a second factor.
+func (h *MFAHandler) SetRequiredRoles(c *gin.Context) {
+	logger := requestLogger(h.logger, c)
+
+	var payload domain.MFARequiredRolesRequest
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		if logger != nil {
+			logger.Info("invalid mfa required roles body", zap.Error(err))
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	roles, err := h.service.SetMFARequiredRoles(c.Request.Context(), payload.Roles)
+	if err != nil {
+		h.writeError(c, logger, err, "failed to set mfa required roles")
+		return
+	}
+
+	if logger != nil {
+		logger.Info("mfa required roles changed", zap.Strings("roles", roles))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"roles": roles})
+}
+
+func (h *MFAHandler) bindCode(c *gin.Context, logger *zap.Logger, payload *domain.MFACodeRequest) bool {
+	if err := c.ShouldBindJSON(payload); err != nil {
+		if logger != nil {
+			logger.Info("invalid mfa code body", zap.Error(err))
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return false
+	}
+	return true
+}
+
+func (h *MFAHandler) writeError(c *gin.Context, logger *zap.Logger, err error, message string) {
+	switch {
+	case errors.Is(err, userservice.ErrMFANotConfigured):
+		c.JSON(http.StatusNotFound, gin.H{"error": "mfa is not available"})
+	case errors.Is(err, userservice.ErrInvalidInput):
+		if logger != nil {
+			logger.Info("invalid mfa request", zap.Error(err))
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, userservice.ErrInvalidMFACode):
+		if logger != nil {
+			logger.Info("mfa code rejected", zap.Error(err))
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid mfa code"})
+	case errors.Is(err, userservice.ErrNotFound):
+		if logger != nil {
+			logger.Info("user not found", zap.Error(err))
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+	case errors.Is(err, userservice.ErrMFAAlreadyEnabled),
+		errors.Is(err, userservice.ErrMFANotEnabled),
+		errors.Is(err, userservice.ErrMFARequired):
+		if logger != nil {
+			logger.Info("mfa state conflict", zap.Error(err))
+		}
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		if logger != nil {
+			logger.Error(message, zap.Error(err), zap.Stack("stack"))
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
@@ -0,0 +1,21 @@
+package middleware
+
+import "testing"
+
+func TestRedactPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/api/v1/companies/c1", "/api/v1/companies/c1"},
+		{"/api/v1/companies/stream?last_event_id=7", "/api/v1/companies/stream?last_event_id=7"},
+		{"/api/v1/companies/ws?access_token=eyJ.secret.sig", "/api/v1/companies/ws?access_token=REDACTED"},
+		{"/reset?token=abc&lang=en", "/reset?lang=en&token=REDACTED"},
+		{"/broken?%zz", "/broken?REDACTED"},
+	}
+	for _, tc := range tests {
+		if got := redactPath(tc.path); got != tc.want {
+			t.Errorf("redactPath(%q) = %q, want %q", tc.path, got, tc.want)
+		}
+	}
+}
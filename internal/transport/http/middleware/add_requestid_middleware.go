@@ -3,6 +3,8 @@ package middleware
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+
+	"github.com/ktsiligkos/xm_project/internal/correlation"
 )
 
 func RequestID() gin.HandlerFunc {
@@ -12,6 +14,8 @@ func RequestID() gin.HandlerFunc {
 			id = uuid.NewString() // github.com/google/uuid
 		}
 		c.Set("request_id", id)
+		// services only see the request context, so the ID is carried there as well
+		c.Request = c.Request.WithContext(correlation.WithRequestID(c.Request.Context(), id))
 		c.Writer.Header().Set("X-Request-ID", id)
 		c.Next()
 	}
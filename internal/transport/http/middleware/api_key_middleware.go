@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ktsiligkos/xm_project/internal/auth"
+	"github.com/ktsiligkos/xm_project/internal/domain"
+	apikeyservice "github.com/ktsiligkos/xm_project/internal/service/apikey"
+)
+
+// APIKeyHeader carries an API key instead of the Authorization header
+const APIKeyHeader = "X-API-Key"
+
+// APIKeyAuthenticator resolves the key sent in the X-API-Key header
+type APIKeyAuthenticator interface {
+	AuthenticateAPIKey(ctx context.Context, key string) (domain.APIKey, error)
+}
+
+// RequireAuthOrAPIKey accepts an X-API-Key header as an alternative to the Bearer token
+// checked by RequireAuth. Either way the same user_id and claims are set, the permissions
+// of an API key being its scopes.
+func RequireAuthOrAPIKey(verifier auth.TokenVerifier, revocations RevocationChecker, keys APIKeyAuthenticator) gin.HandlerFunc {
+	bearer := RequireAuth(verifier, revocations)
+	return func(c *gin.Context) {
+		key := c.GetHeader(APIKeyHeader)
+		if key == "" || keys == nil {
+			bearer(c)
+			return
+		}
+		if c.GetHeader("Authorization") != "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "send either an api key or a bearer token, not both"})
+			return
+		}
+
+		apiKey, err := keys.AuthenticateAPIKey(c.Request.Context(), key)
+		if err != nil {
+			if errors.Is(err, apikeyservice.ErrInvalidKey) {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid api key"})
+				return
+			}
+			_ = c.Error(err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to check api key"})
+			return
+		}
+
+		c.Set("api_key_id", apiKey.ID)
+		setPrincipal(c, &auth.Claims{UserID: apiKey.UserID, TenantID: apiKey.TenantID, Permissions: apiKey.Scopes})
+		c.Next()
+	}
+}
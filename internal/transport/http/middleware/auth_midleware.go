@@ -1,18 +1,26 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 
 	"github.com/ktsiligkos/xm_project/internal/auth"
+	"github.com/ktsiligkos/xm_project/internal/correlation"
+	"github.com/ktsiligkos/xm_project/internal/domain"
 )
 
-// Validates if the given token is valid
-func RequireAuth(secret []byte) gin.HandlerFunc {
+// RevocationChecker tells whether a valid token was revoked before it expired
+type RevocationChecker interface {
+	IsRevoked(claims *auth.Claims) bool
+}
+
+// Validates if the given token is valid and, when revocations is not nil, not revoked
+func RequireAuth(verifier auth.TokenVerifier, revocations RevocationChecker) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if len(secret) == 0 {
+		if verifier == nil {
 			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "authentication not configured"})
 			return
 		}
@@ -23,20 +31,101 @@ func RequireAuth(secret []byte) gin.HandlerFunc {
 			return
 		}
 
-		parts := strings.SplitN(header, " ", 2)
-		if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		token, ok := bearerToken(header)
+		if !ok {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "authorization header must be Bearer token"})
 			return
 		}
 
-		claims, err := auth.ParseJWT(parts[1], secret)
-		if err != nil {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired token"})
+		authenticate(c, token, verifier, revocations)
+	}
+}
+
+// SocketTokenProtocol is the WebSocket subprotocol that announces an access token.
+// Browsers cannot set headers on WebSocket handshakes, so they pass the token as the
+// subprotocol after it: new WebSocket(url, ["access_token", token]). Unlike a query
+// parameter it does not end up in access logs.
+const SocketTokenProtocol = "access_token"
+
+// RequireSocketAuth validates the same JWT as RequireAuth, taken from the Authorization
+// header or from the Sec-WebSocket-Protocol header, see SocketTokenProtocol
+func RequireSocketAuth(verifier auth.TokenVerifier, revocations RevocationChecker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if verifier == nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "authentication not configured"})
 			return
 		}
 
-		c.Set("user_id", claims.UserID)
-		c.Set("jwt_claims", claims)
-		c.Next()
+		token := socketProtocolToken(c.GetHeader("Sec-WebSocket-Protocol"))
+		if header := c.GetHeader("Authorization"); header != "" {
+			var ok bool
+			if token, ok = bearerToken(header); !ok {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "authorization header must be Bearer token"})
+				return
+			}
+		}
+		if token == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "authorization header or access_token subprotocol required"})
+			return
+		}
+
+		authenticate(c, token, verifier, revocations)
+	}
+}
+
+// socketProtocolToken returns the subprotocol following SocketTokenProtocol, if any
+func socketProtocolToken(header string) string {
+	protocols := strings.Split(header, ",")
+	for i := 0; i+1 < len(protocols); i++ {
+		if strings.TrimSpace(protocols[i]) == SocketTokenProtocol {
+			return strings.TrimSpace(protocols[i+1])
+		}
+	}
+	return ""
+}
+
+func bearerToken(header string) (string, bool) {
+	parts := strings.SplitN(header, " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return "", false
+	}
+	return parts[1], true
+}
+
+func authenticate(c *gin.Context, token string, verifier auth.TokenVerifier, revocations RevocationChecker) {
+	claims, err := verifier.VerifyToken(c.Request.Context(), token)
+	if err != nil {
+		if !errors.Is(err, auth.ErrInvalidToken) && !errors.Is(err, auth.ErrExpiredToken) {
+			// e.g. the keys of the identity provider could not be fetched
+			_ = c.Error(err)
+		}
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired token"})
+		return
 	}
+	if revocations != nil && revocations.IsRevoked(claims) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "token has been revoked"})
+		return
+	}
+	// only our own tokens carry no issuer, a provider token must name its tenant
+	if claims.TenantID == "" && claims.Issuer != "" {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired token"})
+		return
+	}
+
+	setPrincipal(c, claims)
+	c.Next()
+}
+
+// setPrincipal stores who is calling and what they may do for the handlers and RequirePermission.
+// The tenant scopes the repositories, tokens this service issued before tenants existed act for the default one.
+func setPrincipal(c *gin.Context, claims *auth.Claims) {
+	if claims.TenantID == "" {
+		claims.TenantID = domain.DefaultTenantID
+	}
+
+	c.Set("user_id", claims.UserID)
+	c.Set("tenant_id", claims.TenantID)
+	c.Set("jwt_claims", claims)
+	ctx := correlation.WithUserID(c.Request.Context(), claims.UserID)
+	c.Request = c.Request.WithContext(correlation.WithTenant(ctx, claims.TenantID))
 }
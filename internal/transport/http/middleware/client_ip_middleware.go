@@ -0,0 +1,16 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/ktsiligkos/xm_project/internal/correlation"
+)
+
+// ClientIP makes the client address resolved by gin available to the services,
+// which record it on security relevant events such as logins.
+func ClientIP() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(correlation.WithClientIP(c.Request.Context(), c.ClientIP()))
+		c.Next()
+	}
+}
@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ktsiligkos/xm_project/internal/auth"
+)
+
+// RequirePermission lets only tokens granting permission through, it must run after RequireAuth
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, ok := c.Get("jwt_claims")
+		claims, isClaims := value.(*auth.Claims)
+		if !ok || !isClaims {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+			return
+		}
+
+		if !claims.HasPermission(permission) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "missing permission " + permission, "permission": permission})
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,19 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/ktsiligkos/xm_project/internal/correlation"
+)
+
+// TraceContext continues the caller's W3C trace (or starts a new one) and
+// makes the resulting traceparent available to the services through the request context.
+func TraceContext() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		traceParent := correlation.ChildTraceParent(c.GetHeader("traceparent"))
+		c.Set("traceparent", traceParent)
+		c.Request = c.Request.WithContext(correlation.WithTraceParent(c.Request.Context(), traceParent))
+		c.Writer.Header().Set("traceparent", traceParent)
+		c.Next()
+	}
+}
@@ -5,28 +5,115 @@ import (
 
 	"github.com/gin-gonic/gin"
 
+	"github.com/ktsiligkos/xm_project/internal/auth"
 	"github.com/ktsiligkos/xm_project/internal/transport/http/middleware"
 )
 
+// Handlers groups the HTTP handlers mounted by the router.
+type Handlers struct {
+	Companies     *CompaniesHandler
+	CompanyStream *CompanyStreamHandler
+	CompanySocket *CompanySocketHandler
+	Users         *UsersHandler
+	UserAdmin     *UserAdminHandler
+	DeadLetters   *DeadLettersHandler
+	APIKeys       *APIKeysHandler
+	MFA           *MFAHandler
+	Tenants       *TenantsHandler
+}
+
+// AuthOptions configures how callers of the secured routes are authenticated.
+type AuthOptions struct {
+	// Keys sign the access tokens of this service, their public part is served as the JWKS
+	Keys *auth.KeySet
+	// Verifier checks access tokens, nil verifies them with Keys only
+	Verifier auth.TokenVerifier
+	// Revocations rejects revoked access tokens, nil skips the check
+	Revocations middleware.RevocationChecker
+	// APIKeys accepts the X-API-Key header as an alternative to access tokens, nil disables API keys
+	APIKeys middleware.APIKeyAuthenticator
+}
+
 // NewRouter sets up the gin engine with core middleware and routes.
-func NewRouter(companiesHandler *CompaniesHandler, usersHandler *UsersHandler, authSecret []byte) *gin.Engine {
+// Every company route requires a permission of the access token or API key, and every
+// request acts for a single tenant, the one of the caller.
+func NewRouter(handlers Handlers, authOpts AuthOptions) *gin.Engine {
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.New()
-	router.Use(gin.Logger(), gin.Recovery(), middleware.RequestID())
+	// the client IP counts failed logins, forwarded headers are ignored until the proxies are configured
+	_ = router.SetTrustedProxies(nil)
+	router.Use(middleware.AccessLog(), gin.Recovery(), middleware.RequestID(), middleware.TraceContext(), middleware.ClientIP())
+
+	verifier := authOpts.Verifier
+	if verifier == nil && authOpts.Keys != nil {
+		verifier = authOpts.Keys
+	}
+
+	// other services verify access tokens with the published public keys
+	router.GET("/.well-known/jwks.json", JWKS(authOpts.Keys))
 
 	v1 := router.Group("/api/v1")
 	v1.GET("/healthz", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"status": "ok"})
 	})
 
-	v1.GET("/companies/:uuid", companiesHandler.Get)
-	v1.POST("/login", usersHandler.Login)
+	v1.GET("/companies/ws", middleware.RequireSocketAuth(verifier, authOpts.Revocations), middleware.RequirePermission(auth.PermissionCompaniesRead), handlers.CompanySocket.Serve)
+	v1.POST("/login", handlers.Users.Login)
+	v1.POST("/login/mfa", handlers.MFA.VerifyLogin)
+	v1.POST("/token/refresh", handlers.Users.Refresh)
+	v1.POST("/logout", handlers.Users.Logout)
+	v1.POST("/password-reset", handlers.Users.RequestPasswordReset)
+	v1.POST("/password-reset/confirm", handlers.Users.ConfirmPasswordReset)
+
+	// MFA settings belong to a person, API keys cannot change them
+	account := v1.Group("/mfa")
+	account.Use(middleware.RequireAuth(verifier, authOpts.Revocations))
+	account.GET("", handlers.MFA.Status)
+	account.POST("/totp", handlers.MFA.Enroll)
+	account.POST("/totp/confirm", handlers.MFA.Confirm)
+	account.POST("/disable", handlers.MFA.Disable)
+	account.POST("/recovery-codes", handlers.MFA.RegenerateRecoveryCodes)
 
 	secured := v1.Group("/")
-	secured.Use(middleware.RequireAuth(authSecret))
-	secured.POST("/companies", companiesHandler.Create)
-	secured.DELETE("/companies/:uuid", companiesHandler.Delete)
-	secured.PATCH("/companies/:uuid", companiesHandler.Patch)
+	secured.Use(middleware.RequireAuthOrAPIKey(verifier, authOpts.Revocations, authOpts.APIKeys))
+	secured.GET("/companies/stream", middleware.RequirePermission(auth.PermissionCompaniesRead), handlers.CompanyStream.Stream)
+	secured.GET("/companies/:uuid", middleware.RequirePermission(auth.PermissionCompaniesRead), handlers.Companies.Get)
+	secured.POST("/companies", middleware.RequirePermission(auth.PermissionCompaniesWrite), handlers.Companies.Create)
+	secured.DELETE("/companies/:uuid", middleware.RequirePermission(auth.PermissionCompaniesDelete), handlers.Companies.Delete)
+	secured.PATCH("/companies/:uuid", middleware.RequirePermission(auth.PermissionCompaniesWrite), handlers.Companies.Patch)
+
+	admin := secured.Group("/admin")
+	admin.Use(middleware.RequirePermission(auth.PermissionUsersAdmin))
+	admin.POST("/users", handlers.UserAdmin.Create)
+	admin.GET("/users", handlers.UserAdmin.List)
+	admin.GET("/users/:id", handlers.UserAdmin.Get)
+	admin.PATCH("/users/:id", handlers.UserAdmin.Update)
+	admin.POST("/users/:id/disable", handlers.UserAdmin.Disable)
+	admin.POST("/users/:id/revoke-tokens", handlers.UserAdmin.RevokeTokens)
+	admin.POST("/users/:id/unlock", handlers.UserAdmin.Unlock)
+	admin.POST("/users/:id/reset-mfa", handlers.MFA.Reset)
+	admin.POST("/tokens/revoke", handlers.UserAdmin.RevokeToken)
+	admin.POST("/api-keys", handlers.APIKeys.Create)
+	admin.GET("/api-keys", handlers.APIKeys.List)
+	admin.DELETE("/api-keys/:id", handlers.APIKeys.Revoke)
+
+	// dead letters and the MFA policy span tenants, like the tenants themselves
+	operator := admin.Group("/")
+	operator.Use(middleware.RequirePermission(auth.PermissionTenantsAdmin))
+	operator.GET("/dead-letters", handlers.DeadLetters.List)
+	operator.POST("/dead-letters/replay", handlers.DeadLetters.ReplayAll)
+	operator.DELETE("/dead-letters", handlers.DeadLetters.DiscardAll)
+	operator.GET("/dead-letters/:id", handlers.DeadLetters.Get)
+	operator.POST("/dead-letters/:id/replay", handlers.DeadLetters.Replay)
+	operator.DELETE("/dead-letters/:id", handlers.DeadLetters.Discard)
+	operator.GET("/mfa/required-roles", handlers.MFA.RequiredRoles)
+	operator.PUT("/mfa/required-roles", handlers.MFA.SetRequiredRoles)
+	operator.POST("/tenants", handlers.Tenants.Create)
+	operator.GET("/tenants", handlers.Tenants.List)
+	operator.GET("/tenants/:id", handlers.Tenants.Get)
+	operator.PATCH("/tenants/:id", handlers.Tenants.Update)
+	operator.DELETE("/tenants/:id", handlers.Tenants.Delete)
+	operator.POST("/tenants/:id/users", handlers.Tenants.CreateUser)
 
 	return router
 }
@@ -0,0 +1,489 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ktsiligkos/xm_project/internal/auth"
+	"github.com/ktsiligkos/xm_project/internal/correlation"
+	"github.com/ktsiligkos/xm_project/internal/domain"
+	apikeyservice "github.com/ktsiligkos/xm_project/internal/service/apikey"
+	userservice "github.com/ktsiligkos/xm_project/internal/service/user"
+)
+
+var routerKeys = auth.NewHMACKeySet([]byte("router-secret"))
+
+func TestRouter_RequiresPermissionsPerRoute(t *testing.T) {
+	deleted := false
+	router := NewRouter(Handlers{
+		Companies: NewCompaniesHandler(stubCompanyService{
+			deleteFn: func(context.Context, string) error {
+				deleted = true
+				return nil
+			},
+		}, nil),
+	}, AuthOptions{Keys: routerKeys})
+
+	cases := []struct {
+		name           string
+		role           string
+		method, path   string
+		wantStatus     int
+		wantPermission string
+	}{
+		{"viewer cannot create", auth.RoleViewer, http.MethodPost, "/api/v1/companies", http.StatusForbidden, auth.PermissionCompaniesWrite},
+		{"editor cannot delete", auth.RoleEditor, http.MethodDelete, "/api/v1/companies/c1", http.StatusForbidden, auth.PermissionCompaniesDelete},
+		{"manager cannot administer", auth.RoleManager, http.MethodGet, "/api/v1/admin/users", http.StatusForbidden, auth.PermissionUsersAdmin},
+		{"admin cannot manage tenants", auth.RoleAdmin, http.MethodGet, "/api/v1/admin/tenants", http.StatusForbidden, auth.PermissionTenantsAdmin},
+		{"admin cannot read dead letters", auth.RoleAdmin, http.MethodGet, "/api/v1/admin/dead-letters", http.StatusForbidden, auth.PermissionTenantsAdmin},
+		{"manager can delete", auth.RoleManager, http.MethodDelete, "/api/v1/companies/c1", http.StatusOK, ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			Given(t, "a token with the %s role", tc.role)
+			token, err := auth.GenerateJWT("user-1", "tenant-1", []string{tc.role}, routerKeys, time.Minute)
+			if err != nil {
+				t.Fatalf("GenerateJWT returned error: %v", err)
+			}
+
+			When(t, "calling %s %s", tc.method, tc.path)
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			Then(t, "the response status is %d", tc.wantStatus)
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tc.wantStatus, rec.Code, rec.Body.String())
+			}
+			if tc.wantPermission == "" {
+				return
+			}
+			var body map[string]string
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decode body: %v", err)
+			}
+			if body["permission"] != tc.wantPermission {
+				t.Fatalf("expected the missing permission %q to be named, got %v", tc.wantPermission, body)
+			}
+		})
+	}
+
+	if !deleted {
+		t.Fatal("expected the manager to reach the delete handler")
+	}
+}
+
+func TestRouter_GuardsEveryDeadLetterRoute(t *testing.T) {
+	router := NewRouter(Handlers{}, AuthOptions{Keys: routerKeys})
+	routes := []struct{ method, path string }{
+		{http.MethodGet, "/api/v1/admin/dead-letters"},
+		{http.MethodPost, "/api/v1/admin/dead-letters/replay"},
+		{http.MethodDelete, "/api/v1/admin/dead-letters"},
+		{http.MethodGet, "/api/v1/admin/dead-letters/d1"},
+		{http.MethodPost, "/api/v1/admin/dead-letters/d1/replay"},
+		{http.MethodDelete, "/api/v1/admin/dead-letters/d1"},
+	}
+
+	Given(t, "an authenticated admin who is not an operator")
+	token, err := auth.GenerateJWT("user-1", "tenant-1", []string{auth.RoleAdmin}, routerKeys, time.Minute)
+	if err != nil {
+		t.Fatalf("GenerateJWT returned error: %v", err)
+	}
+
+	for _, route := range routes {
+		When(t, "calling %s %s with and without the token", route.method, route.path)
+		anonymous := httptest.NewRecorder()
+		router.ServeHTTP(anonymous, httptest.NewRequest(route.method, route.path, nil))
+
+		req := httptest.NewRequest(route.method, route.path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		admin := httptest.NewRecorder()
+		router.ServeHTTP(admin, req)
+
+		Then(t, "anonymous callers are rejected with 401 and the admin with 403")
+		if anonymous.Code != http.StatusUnauthorized {
+			t.Fatalf("%s %s: expected 401 without a token, got %d", route.method, route.path, anonymous.Code)
+		}
+		if admin.Code != http.StatusForbidden {
+			t.Fatalf("%s %s: expected 403 for the admin, got %d", route.method, route.path, admin.Code)
+		}
+	}
+}
+
+type stubAPIKeys map[string]domain.APIKey
+
+func (s stubAPIKeys) AuthenticateAPIKey(_ context.Context, key string) (domain.APIKey, error) {
+	apiKey, ok := s[key]
+	if !ok {
+		return domain.APIKey{}, apikeyservice.ErrInvalidKey
+	}
+	return apiKey, nil
+}
+
+func TestRouter_AcceptsAPIKeys(t *testing.T) {
+	var deletedBy string
+	router := NewRouter(Handlers{
+		Companies: NewCompaniesHandler(stubCompanyService{
+			deleteFn: func(ctx context.Context, _ string) error {
+				deletedBy = correlation.UserID(ctx)
+				return nil
+			},
+		}, nil),
+	}, AuthOptions{Keys: routerKeys, APIKeys: stubAPIKeys{
+		"xmk_delete": {ID: "k1", UserID: "batch", Scopes: []string{auth.PermissionCompaniesDelete}},
+		"xmk_read":   {ID: "k2", UserID: "batch", Scopes: []string{auth.PermissionCompaniesRead}},
+	}})
+
+	cases := []struct {
+		name       string
+		key        string
+		wantStatus int
+	}{
+		{"unknown key", "xmk_unknown", http.StatusUnauthorized},
+		{"key without the scope", "xmk_read", http.StatusForbidden},
+		{"key with the scope", "xmk_delete", http.StatusOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodDelete, "/api/v1/companies/c1", nil)
+			req.Header.Set("X-API-Key", tc.key)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tc.wantStatus, rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	if deletedBy != "batch" {
+		t.Fatalf("expected the request to act as the owner of the key, got %q", deletedBy)
+	}
+}
+
+// stubMFAService answers the status of every caller, the other methods are not used
+type stubMFAService struct {
+	MFAService
+	calledBy string
+}
+
+func (s *stubMFAService) MFAStatus(_ context.Context, userID string) (domain.MFAStatus, error) {
+	s.calledBy = userID
+	return domain.MFAStatus{Enabled: true}, nil
+}
+
+func TestRouter_MFASettingsNeedABearerToken(t *testing.T) {
+	service := &stubMFAService{}
+	router := NewRouter(Handlers{MFA: NewMFAHandler(service, nil)}, AuthOptions{Keys: routerKeys, APIKeys: stubAPIKeys{
+		"xmk_admin": {ID: "k1", UserID: "batch", Scopes: []string{auth.PermissionUsersAdmin}},
+	}})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/mfa", nil)
+	req.Header.Set("X-API-Key", "xmk_admin")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected API keys to be refused, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	token, err := auth.GenerateJWT("user-1", "tenant-1", []string{auth.RoleViewer}, routerKeys, time.Minute)
+	if err != nil {
+		t.Fatalf("GenerateJWT returned error: %v", err)
+	}
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/mfa", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || service.calledBy != "user-1" {
+		t.Fatalf("expected the status of the caller, got %d for %q: %s", rec.Code, service.calledBy, rec.Body.String())
+	}
+}
+
+func TestRouter_RequiresCompaniesReadForEveryRead(t *testing.T) {
+	router := NewRouter(Handlers{}, AuthOptions{Keys: routerKeys, APIKeys: stubAPIKeys{
+		"xmk_delete": {ID: "k1", UserID: "batch", TenantID: "tenant-2", Scopes: []string{auth.PermissionCompaniesDelete}},
+	}})
+
+	for _, path := range []string{"/api/v1/companies/c1", "/api/v1/companies/stream"} {
+		When(t, "reading %s anonymously and with a key that may only delete", path)
+		anonymous := httptest.NewRecorder()
+		router.ServeHTTP(anonymous, httptest.NewRequest(http.MethodGet, path, nil))
+
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-API-Key", "xmk_delete")
+		deleteOnly := httptest.NewRecorder()
+		router.ServeHTTP(deleteOnly, req)
+
+		Then(t, "the anonymous read is rejected with 401 and the key with 403")
+		if anonymous.Code != http.StatusUnauthorized {
+			t.Fatalf("%s: expected 401 without credentials, got %d", path, anonymous.Code)
+		}
+		if deleteOnly.Code != http.StatusForbidden {
+			t.Fatalf("%s: expected 403 without companies:read, got %d", path, deleteOnly.Code)
+		}
+	}
+}
+
+func TestRouter_IgnoresForwardedForByDefault(t *testing.T) {
+	var clientIP string
+	router := NewRouter(Handlers{
+		Companies: NewCompaniesHandler(stubCompanyService{
+			getFn: func(ctx context.Context, id string) (domain.Company, error) {
+				clientIP = correlation.ClientIP(ctx)
+				return domain.Company{ID: id}, nil
+			},
+		}, nil),
+	}, AuthOptions{Keys: routerKeys})
+	token, err := auth.GenerateJWT("user-1", "tenant-1", []string{auth.RoleViewer}, routerKeys, time.Minute)
+	if err != nil {
+		t.Fatalf("GenerateJWT returned error: %v", err)
+	}
+
+	Given(t, "a client that claims another address in X-Forwarded-For")
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/companies/c1", nil)
+	req.RemoteAddr = "198.51.100.7:40000"
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("X-Forwarded-For", "203.0.113.9")
+
+	When(t, "no proxy is trusted")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	Then(t, "the request is attributed to the address of the connection")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if clientIP != "198.51.100.7" {
+		t.Fatalf("expected the remote address, got %q", clientIP)
+	}
+}
+
+func TestRouter_ScopesRequestsToATenant(t *testing.T) {
+	var tenants []string
+	router := NewRouter(Handlers{
+		Companies: NewCompaniesHandler(stubCompanyService{
+			getFn: func(ctx context.Context, id string) (domain.Company, error) {
+				tenants = append(tenants, correlation.Tenant(ctx))
+				return domain.Company{ID: id}, nil
+			},
+			deleteFn: func(ctx context.Context, _ string) error {
+				tenants = append(tenants, correlation.Tenant(ctx))
+				return nil
+			},
+		}, nil),
+	}, AuthOptions{Keys: routerKeys, APIKeys: stubAPIKeys{
+		"xmk_delete": {ID: "k1", UserID: "batch", TenantID: "tenant-2", Scopes: []string{auth.PermissionCompaniesDelete}},
+	}})
+	token, err := auth.GenerateJWT("user-1", "tenant-1", []string{auth.RoleManager}, routerKeys, time.Minute)
+	if err != nil {
+		t.Fatalf("GenerateJWT returned error: %v", err)
+	}
+	legacy, err := auth.GenerateJWT("user-1", "", []string{auth.RoleManager}, routerKeys, time.Minute)
+	if err != nil {
+		t.Fatalf("GenerateJWT returned error: %v", err)
+	}
+
+	cases := []struct {
+		name       string
+		method     string
+		header     string
+		value      string
+		wantTenant string
+	}{
+		{"authenticated read", http.MethodGet, "Authorization", "Bearer " + token, "tenant-1"},
+		{"token without a tenant", http.MethodDelete, "Authorization", "Bearer " + legacy, domain.DefaultTenantID},
+		{"api key", http.MethodDelete, "X-API-Key", "xmk_delete", "tenant-2"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tenants = nil
+			req := httptest.NewRequest(tc.method, "/api/v1/companies/c1", nil)
+			if tc.header != "" {
+				req.Header.Set(tc.header, tc.value)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+			}
+			if len(tenants) != 1 || tenants[0] != tc.wantTenant {
+				t.Fatalf("expected the request to act for %q, got %v", tc.wantTenant, tenants)
+			}
+		})
+	}
+}
+
+func TestRouter_OnlyOperatorsGrantTheOperatorRole(t *testing.T) {
+	router := NewRouter(Handlers{UserAdmin: NewUserAdminHandler(nil, nil)}, AuthOptions{Keys: routerKeys})
+	token, err := auth.GenerateJWT("user-1", "tenant-1", []string{auth.RoleAdmin}, routerKeys, time.Minute)
+	if err != nil {
+		t.Fatalf("GenerateJWT returned error: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPatch, "/api/v1/admin/users/u2", strings.NewReader(`{"roles":["Operator"]}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected an admin to be refused, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+// stubUserAdmin knows an operator and a viewer and records the users it changed
+type stubUserAdmin struct {
+	UserAdminService
+	MFAService
+	APIKeyService
+	changed []string
+}
+
+func (s *stubUserAdmin) GetUser(_ context.Context, id string) (domain.User, error) {
+	roles := map[string][]string{"op": {auth.RoleOperator}, "viewer": {auth.RoleViewer}}[id]
+	return domain.User{ID: id, Roles: roles}, nil
+}
+
+func (s *stubUserAdmin) UpdateUser(_ context.Context, id string, _ domain.UpdateUserRequest) (domain.User, error) {
+	s.changed = append(s.changed, id)
+	return domain.User{ID: id}, nil
+}
+
+func (s *stubUserAdmin) ResetMFA(_ context.Context, id string) error {
+	s.changed = append(s.changed, id)
+	return nil
+}
+
+// AccessTokenOwner treats the token as the ID of its user
+func (s *stubUserAdmin) AccessTokenOwner(_ context.Context, token string) (string, error) {
+	return token, nil
+}
+
+func (s *stubUserAdmin) RevokeAccessToken(_ context.Context, req domain.RevokeTokenRequest) error {
+	s.changed = append(s.changed, req.Token+req.JTI)
+	return nil
+}
+
+func (s *stubUserAdmin) CreateAPIKey(_ context.Context, req domain.CreateAPIKeyRequest) (domain.CreatedAPIKey, error) {
+	s.changed = append(s.changed, req.UserID)
+	return domain.CreatedAPIKey{APIKey: domain.APIKey{UserID: req.UserID}}, nil
+}
+
+func TestRouter_AdminsCannotTakeOverMorePrivilegedUsers(t *testing.T) {
+	Given(t, "an admin who lacks the tenants:admin permission of operators")
+	service := &stubUserAdmin{}
+	router := NewRouter(Handlers{
+		UserAdmin: NewUserAdminHandler(service, nil),
+		MFA:       NewMFAHandler(service, nil),
+		APIKeys:   NewAPIKeysHandler(service, service, nil),
+	}, AuthOptions{Keys: routerKeys})
+	token, err := auth.GenerateJWT("admin-1", "tenant-1", []string{auth.RoleAdmin}, routerKeys, time.Minute)
+	if err != nil {
+		t.Fatalf("GenerateJWT returned error: %v", err)
+	}
+
+	cases := []struct {
+		name, method, path, body string
+		want                     int
+	}{
+		{"set the password of an operator", http.MethodPatch, "/api/v1/admin/users/op", `{"password":"new password"}`, http.StatusForbidden},
+		{"reset the mfa of an operator", http.MethodPost, "/api/v1/admin/users/op/reset-mfa", ``, http.StatusForbidden},
+		{"issue a key to an operator", http.MethodPost, "/api/v1/admin/api-keys", `{"name":"k","user_id":"op","scopes":["companies:read"]}`, http.StatusForbidden},
+		{"issue a key with a scope the admin lacks", http.MethodPost, "/api/v1/admin/api-keys", `{"name":"k","scopes":["tenants:admin"]}`, http.StatusForbidden},
+		{"revoke a token of an operator", http.MethodPost, "/api/v1/admin/tokens/revoke", `{"token":"op"}`, http.StatusForbidden},
+		{"revoke a token by its jti alone", http.MethodPost, "/api/v1/admin/tokens/revoke", `{"jti":"5a0c3c1e"}`, http.StatusForbidden},
+		{"set the password of a viewer", http.MethodPatch, "/api/v1/admin/users/viewer", `{"password":"new password"}`, http.StatusOK},
+		{"reset the mfa of a viewer", http.MethodPost, "/api/v1/admin/users/viewer/reset-mfa", ``, http.StatusOK},
+		{"issue a key to a viewer", http.MethodPost, "/api/v1/admin/api-keys", `{"name":"k","user_id":"viewer","scopes":["companies:read"]}`, http.StatusCreated},
+		{"revoke a token of a viewer", http.MethodPost, "/api/v1/admin/tokens/revoke", `{"token":"viewer"}`, http.StatusOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			service.changed = nil
+
+			When(t, "the admin tries to %s", tc.name)
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			req.Header.Set("Authorization", "Bearer "+token)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			Then(t, "the request is answered with %d", tc.want)
+			if rec.Code != tc.want {
+				t.Fatalf("expected status %d, got %d: %s", tc.want, rec.Code, rec.Body.String())
+			}
+			if refused := tc.want == http.StatusForbidden; refused != (len(service.changed) == 0) {
+				t.Fatalf("refused requests must not reach the service, changed %v", service.changed)
+			}
+		})
+	}
+}
+
+// stubUsersService answers password reset requests, the other methods are not used
+type stubUsersService struct {
+	UsersService
+	resetErr error
+}
+
+func (s stubUsersService) RequestPasswordReset(context.Context, string) error {
+	return s.resetErr
+}
+
+func TestRouter_ThrottledResetRequestsLookAccepted(t *testing.T) {
+	router := NewRouter(Handlers{
+		Users: NewUsersHandler(stubUsersService{resetErr: userservice.ErrPasswordResetThrottled}, nil),
+	}, AuthOptions{Keys: routerKeys})
+
+	Given(t, "an address that asked for too many reset mails")
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/password-reset", strings.NewReader(`{"email":"john@example.com"}`))
+	req.Header.Set("Content-Type", "application/json")
+
+	When(t, "it asks again")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	Then(t, "the answer is the same as for any other request")
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("expected status 202, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+// stubVerifier accepts every token with the same claims
+type stubVerifier struct {
+	claims auth.Claims
+}
+
+func (v stubVerifier) VerifyToken(context.Context, string) (*auth.Claims, error) {
+	claims := v.claims
+	return &claims, nil
+}
+
+func TestRouter_RejectsProviderTokensWithoutATenant(t *testing.T) {
+	claims := auth.Claims{UserID: "jane", Permissions: []string{auth.PermissionCompaniesRead}}
+	claims.Issuer = "https://idp.example.com"
+	router := NewRouter(Handlers{
+		Companies: NewCompaniesHandler(stubCompanyService{
+			getFn: func(_ context.Context, id string) (domain.Company, error) {
+				return domain.Company{ID: id}, nil
+			},
+		}, nil),
+	}, AuthOptions{Keys: routerKeys, Verifier: stubVerifier{claims: claims}})
+
+	Given(t, "a token of an identity provider that names no tenant")
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/companies/c1", nil)
+	req.Header.Set("Authorization", "Bearer provider-token")
+
+	When(t, "it is used")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	Then(t, "it is not put into the default tenant")
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
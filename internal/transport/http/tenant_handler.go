@@ -0,0 +1,192 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/ktsiligkos/xm_project/internal/domain"
+	tenantservice "github.com/ktsiligkos/xm_project/internal/service/tenant"
+	userservice "github.com/ktsiligkos/xm_project/internal/service/user"
+)
+
+// TenantService captures the tenant capabilities needed by the HTTP layer.
+type TenantService interface {
+	CreateTenant(ctx context.Context, req domain.CreateTenantRequest) (domain.Tenant, error)
+	GetTenant(ctx context.Context, id string) (domain.Tenant, error)
+	ListTenants(ctx context.Context) ([]domain.Tenant, error)
+	RenameTenant(ctx context.Context, id string, req domain.UpdateTenantRequest) (domain.Tenant, error)
+	DeleteTenant(ctx context.Context, id string) error
+	CreateTenantUser(ctx context.Context, tenantID string, req domain.CreateUserRequest) (domain.User, error)
+}
+
+// TenantsHandler exposes the operator endpoints for tenants.
+type TenantsHandler struct {
+	service TenantService
+	logger  *zap.Logger
+}
+
+// NewTenantsHandler wires a service into the HTTP handler.
+func NewTenantsHandler(service TenantService, logger *zap.Logger) *TenantsHandler {
+	return &TenantsHandler{service: service, logger: logger}
+}
+
+// Create adds a tenant.
+func (h *TenantsHandler) Create(c *gin.Context) {
+	logger := requestLogger(h.logger, c)
+
+	var payload domain.CreateTenantRequest
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		if logger != nil {
+			logger.Info("invalid create tenant request body", zap.Error(err))
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	tenant, err := h.service.CreateTenant(c.Request.Context(), payload)
+	if err != nil {
+		h.writeError(c, logger, err, "failed to create tenant")
+		return
+	}
+
+	if logger != nil {
+		logger.Info("tenant created", zap.String("tenant_id", tenant.ID))
+	}
+
+	c.JSON(http.StatusCreated, tenant)
+}
+
+// List returns every tenant.
+func (h *TenantsHandler) List(c *gin.Context) {
+	logger := requestLogger(h.logger, c)
+
+	tenants, err := h.service.ListTenants(c.Request.Context())
+	if err != nil {
+		h.writeError(c, logger, err, "failed to list tenants")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tenants": tenants})
+}
+
+// Get returns a single tenant identified by the route param.
+func (h *TenantsHandler) Get(c *gin.Context) {
+	logger := requestLogger(h.logger, c)
+
+	tenant, err := h.service.GetTenant(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.writeError(c, logger, err, "failed to fetch tenant")
+		return
+	}
+
+	c.JSON(http.StatusOK, tenant)
+}
+
+// Update renames a tenant.
+func (h *TenantsHandler) Update(c *gin.Context) {
+	logger := requestLogger(h.logger, c)
+	id := c.Param("id")
+	if logger != nil {
+		logger = logger.With(zap.String("tenant_id", id))
+	}
+
+	var payload domain.UpdateTenantRequest
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		if logger != nil {
+			logger.Info("invalid update tenant request body", zap.Error(err))
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	tenant, err := h.service.RenameTenant(c.Request.Context(), id, payload)
+	if err != nil {
+		h.writeError(c, logger, err, "failed to update tenant")
+		return
+	}
+
+	if logger != nil {
+		logger.Info("tenant renamed")
+	}
+
+	c.JSON(http.StatusOK, tenant)
+}
+
+// Delete removes a tenant that no longer has users or companies.
+func (h *TenantsHandler) Delete(c *gin.Context) {
+	logger := requestLogger(h.logger, c)
+	id := c.Param("id")
+	if logger != nil {
+		logger = logger.With(zap.String("tenant_id", id))
+	}
+
+	if err := h.service.DeleteTenant(c.Request.Context(), id); err != nil {
+		h.writeError(c, logger, err, "failed to delete tenant")
+		return
+	}
+
+	if logger != nil {
+		logger.Info("tenant deleted")
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+// CreateUser registers a user in the tenant, typically its first admin.
+func (h *TenantsHandler) CreateUser(c *gin.Context) {
+	logger := requestLogger(h.logger, c)
+	id := c.Param("id")
+	if logger != nil {
+		logger = logger.With(zap.String("tenant_id", id))
+	}
+
+	var payload domain.CreateUserRequest
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		if logger != nil {
+			logger.Info("invalid create user request body", zap.Error(err))
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	user, err := h.service.CreateTenantUser(c.Request.Context(), id, payload)
+	if err != nil {
+		h.writeError(c, logger, err, "failed to create user")
+		return
+	}
+
+	if logger != nil {
+		logger.Info("user created", zap.String("user_id", user.ID))
+	}
+
+	c.JSON(http.StatusCreated, user)
+}
+
+func (h *TenantsHandler) writeError(c *gin.Context, logger *zap.Logger, err error, message string) {
+	switch {
+	case errors.Is(err, tenantservice.ErrInvalidInput), errors.Is(err, userservice.ErrInvalidInput):
+		if logger != nil {
+			logger.Info("invalid tenant request", zap.Error(err))
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, tenantservice.ErrNotFound):
+		if logger != nil {
+			logger.Info("tenant not found", zap.Error(err))
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "tenant not found"})
+	case errors.Is(err, tenantservice.ErrNameTaken), errors.Is(err, tenantservice.ErrInUse), errors.Is(err, userservice.ErrEmailTaken):
+		if logger != nil {
+			logger.Info("tenant request conflicts", zap.Error(err))
+		}
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		if logger != nil {
+			logger.Error(message, zap.Error(err), zap.Stack("stack"))
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
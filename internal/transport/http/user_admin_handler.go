@@ -0,0 +1,343 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/ktsiligkos/xm_project/internal/auth"
+	"github.com/ktsiligkos/xm_project/internal/domain"
+	userservice "github.com/ktsiligkos/xm_project/internal/service/user"
+)
+
+// UserAdminService captures the user administration capabilities needed by the HTTP layer.
+type UserAdminService interface {
+	CreateUser(ctx context.Context, req domain.CreateUserRequest) (domain.User, error)
+	GetUser(ctx context.Context, id string) (domain.User, error)
+	ListUsers(ctx context.Context, afterID string, limit int) ([]domain.User, error)
+	UpdateUser(ctx context.Context, id string, req domain.UpdateUserRequest) (domain.User, error)
+	DisableUser(ctx context.Context, id string) error
+	RevokeUserTokens(ctx context.Context, id string) error
+	UnlockUser(ctx context.Context, id string) error
+	RevokeAccessToken(ctx context.Context, req domain.RevokeTokenRequest) error
+	AccessTokenOwner(ctx context.Context, token string) (string, error)
+}
+
+// UserLookup finds the user an admin action targets, see mayManageUser.
+type UserLookup interface {
+	GetUser(ctx context.Context, id string) (domain.User, error)
+}
+
+// UserAdminHandler exposes the admin endpoints for users.
+type UserAdminHandler struct {
+	service UserAdminService
+	logger  *zap.Logger
+}
+
+// NewUserAdminHandler wires a service into the HTTP handler.
+func NewUserAdminHandler(service UserAdminService, logger *zap.Logger) *UserAdminHandler {
+	return &UserAdminHandler{service: service, logger: logger}
+}
+
+// Create registers a new user.
+func (h *UserAdminHandler) Create(c *gin.Context) {
+	logger := requestLogger(h.logger, c)
+
+	var payload domain.CreateUserRequest
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		if logger != nil {
+			logger.Info("invalid create user request body", zap.Error(err))
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	if !mayGrantRoles(c, logger, payload.Roles) {
+		return
+	}
+
+	user, err := h.service.CreateUser(c.Request.Context(), payload)
+	if err != nil {
+		h.writeError(c, logger, err, "failed to create user")
+		return
+	}
+
+	if logger != nil {
+		logger.Info("user created", zap.String("user_id", user.ID))
+	}
+
+	c.JSON(http.StatusCreated, user)
+}
+
+// List returns a page of users, paginated with after_id and limit.
+func (h *UserAdminHandler) List(c *gin.Context) {
+	logger := requestLogger(h.logger, c)
+
+	limit, err := queryInt64(c, "limit")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be an integer"})
+		return
+	}
+
+	users, err := h.service.ListUsers(c.Request.Context(), c.Query("after_id"), int(limit))
+	if err != nil {
+		h.writeError(c, logger, err, "failed to list users")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"users": users})
+}
+
+// Get returns a single user identified by the route param.
+func (h *UserAdminHandler) Get(c *gin.Context) {
+	logger := requestLogger(h.logger, c)
+
+	user, err := h.service.GetUser(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.writeError(c, logger, err, "failed to fetch user")
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// Update changes the fields present in the body.
+func (h *UserAdminHandler) Update(c *gin.Context) {
+	logger := requestLogger(h.logger, c)
+	id := c.Param("id")
+	if logger != nil {
+		logger = logger.With(zap.String("user_id", id))
+	}
+
+	var payload domain.UpdateUserRequest
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		if logger != nil {
+			logger.Info("invalid update user request body", zap.Error(err))
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	if payload.Roles != nil && !mayGrantRoles(c, logger, *payload.Roles) {
+		return
+	}
+	if !mayManageUser(c, logger, h.service, id) {
+		return
+	}
+
+	user, err := h.service.UpdateUser(c.Request.Context(), id, payload)
+	if err != nil {
+		h.writeError(c, logger, err, "failed to update user")
+		return
+	}
+
+	if logger != nil {
+		logger.Info("user updated")
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// Disable keeps the user from logging in again.
+func (h *UserAdminHandler) Disable(c *gin.Context) {
+	logger := requestLogger(h.logger, c)
+	id := c.Param("id")
+	if logger != nil {
+		logger = logger.With(zap.String("user_id", id))
+	}
+
+	if !mayManageUser(c, logger, h.service, id) {
+		return
+	}
+	if err := h.service.DisableUser(c.Request.Context(), id); err != nil {
+		h.writeError(c, logger, err, "failed to disable user")
+		return
+	}
+
+	if logger != nil {
+		logger.Info("user disabled")
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+// RevokeTokens revokes every access and refresh token issued to the user so far.
+func (h *UserAdminHandler) RevokeTokens(c *gin.Context) {
+	logger := requestLogger(h.logger, c)
+	id := c.Param("id")
+	if logger != nil {
+		logger = logger.With(zap.String("user_id", id))
+	}
+
+	if !mayManageUser(c, logger, h.service, id) {
+		return
+	}
+	if err := h.service.RevokeUserTokens(c.Request.Context(), id); err != nil {
+		h.writeError(c, logger, err, "failed to revoke tokens")
+		return
+	}
+
+	if logger != nil {
+		logger.Info("user tokens revoked")
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+// Unlock lifts the login delay or lockout of the user's account.
+func (h *UserAdminHandler) Unlock(c *gin.Context) {
+	logger := requestLogger(h.logger, c)
+	id := c.Param("id")
+	if logger != nil {
+		logger = logger.With(zap.String("user_id", id))
+	}
+
+	if !mayManageUser(c, logger, h.service, id) {
+		return
+	}
+	if err := h.service.UnlockUser(c.Request.Context(), id); err != nil {
+		h.writeError(c, logger, err, "failed to unlock user")
+		return
+	}
+
+	if logger != nil {
+		logger.Info("user unlocked")
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+// RevokeToken revokes a single access token, given as the token or its jti. A token is
+// checked like the other actions on its user, a bare jti names neither user nor tenant
+// and needs tenants:admin.
+func (h *UserAdminHandler) RevokeToken(c *gin.Context) {
+	logger := requestLogger(h.logger, c)
+
+	var payload domain.RevokeTokenRequest
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		if logger != nil {
+			logger.Info("invalid revoke token request body", zap.Error(err))
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	switch {
+	case payload.Token != "" && payload.JTI != "":
+		// rejected by the service as invalid input
+	case payload.JTI != "":
+		if missing, ok := lackedPermission(c, []string{auth.PermissionTenantsAdmin}); !ok {
+			if logger != nil {
+				logger.Warn("revocation by jti refused", zap.String("permission", missing))
+			}
+			c.JSON(http.StatusForbidden, gin.H{"error": "revoking by jti needs tenants:admin, send the token instead", "permission": missing})
+			return
+		}
+	case payload.Token != "":
+		owner, err := h.service.AccessTokenOwner(c.Request.Context(), payload.Token)
+		if err != nil {
+			h.writeError(c, logger, err, "failed to revoke token")
+			return
+		}
+		if owner != "" && !mayManageUser(c, logger, h.service, owner) {
+			return
+		}
+	}
+
+	if err := h.service.RevokeAccessToken(c.Request.Context(), payload); err != nil {
+		h.writeError(c, logger, err, "failed to revoke token")
+		return
+	}
+
+	if logger != nil {
+		logger.Info("access token revoked")
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+// mayGrantRoles keeps admins from handing out roles that grant more than they hold, such as
+// the operator role which spans tenants. Otherwise 403 is written.
+func mayGrantRoles(c *gin.Context, logger *zap.Logger, roles []string) bool {
+	normalized := make([]string, 0, len(roles))
+	for _, role := range roles {
+		normalized = append(normalized, strings.ToLower(strings.TrimSpace(role)))
+	}
+
+	missing, ok := lackedPermission(c, auth.PermissionsForRoles(normalized))
+	if ok {
+		return true
+	}
+	if logger != nil {
+		logger.Warn("role grant refused", zap.String("permission", missing))
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": "roles may only grant permissions you hold", "permission": missing})
+	return false
+}
+
+// mayManageUser keeps admins away from users holding permissions the admin lacks, such as
+// operators: setting their password or email, resetting their MFA or issuing them API keys
+// would hand those permissions over. Otherwise 403 is written; unknown users are left to
+// the action, which answers 404.
+func mayManageUser(c *gin.Context, logger *zap.Logger, users UserLookup, id string) bool {
+	user, err := users.GetUser(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, userservice.ErrNotFound) {
+			return true
+		}
+		if logger != nil {
+			logger.Error("failed to fetch the target user", zap.Error(err))
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch user"})
+		return false
+	}
+
+	missing, ok := lackedPermission(c, auth.PermissionsForRoles(user.Roles))
+	if ok {
+		return true
+	}
+	if logger != nil {
+		logger.Warn("action on a more privileged user refused", zap.String("permission", missing))
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": "the user holds permissions you lack", "permission": missing})
+	return false
+}
+
+// lackedPermission returns the first of permissions the caller does not hold, ok when it holds all of them
+func lackedPermission(c *gin.Context, permissions []string) (string, bool) {
+	value, _ := c.Get("jwt_claims")
+	claims, _ := value.(*auth.Claims)
+	for _, permission := range permissions {
+		if claims == nil || !claims.HasPermission(permission) {
+			return permission, false
+		}
+	}
+	return "", true
+}
+
+func (h *UserAdminHandler) writeError(c *gin.Context, logger *zap.Logger, err error, message string) {
+	switch {
+	case errors.Is(err, userservice.ErrInvalidInput):
+		if logger != nil {
+			logger.Info("invalid user request", zap.Error(err))
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, userservice.ErrNotFound):
+		if logger != nil {
+			logger.Info("user not found", zap.Error(err))
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+	case errors.Is(err, userservice.ErrEmailTaken):
+		if logger != nil {
+			logger.Info("email already in use", zap.Error(err))
+		}
+		c.JSON(http.StatusConflict, gin.H{"error": "email already in use"})
+	default:
+		if logger != nil {
+			logger.Error(message, zap.Error(err), zap.Stack("stack"))
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
@@ -0,0 +1,243 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/ktsiligkos/xm_project/internal/domain"
+	companyservice "github.com/ktsiligkos/xm_project/internal/service/company"
+)
+
+// Command types accepted on the commands topic
+const (
+	CommandCreate = "company.create"
+	CommandPatch  = "company.patch"
+	CommandDelete = "company.delete"
+)
+
+// Reply statuses and error codes written to the replies topic
+const (
+	StatusSuccess = "success"
+	StatusError   = "error"
+
+	CodeValidation = "validation_error"
+	CodeConflict   = "conflict"
+	CodeNotFound   = "not_found"
+	CodeInternal   = "internal_error"
+)
+
+// companyIDNamespace names the company IDs derived from create commands without a company_id
+var companyIDNamespace = uuid.MustParse("bb56938e-cb46-4add-a5cd-5c7c13e8402e")
+
+// errMalformed marks commands that cannot be understood and go to the dead letter topic
+var errMalformed = errors.New("malformed command")
+
+// CompanyService captures the service capabilities needed to execute commands.
+type CompanyService interface {
+	CreateCompany(ctx context.Context, company domain.Company) (domain.Company, error)
+	DeleteCompanyByID(ctx context.Context, companyID string) error
+	PatchCompanyByID(ctx context.Context, patchCompanyRequest domain.PatchCompanyRequest, uuid string) error
+}
+
+// Command is a request to change a company, produced by an upstream system.
+// The correlation ID and the tenant may also be sent as the correlation_id and
+// tenant_id message headers. The command only sees the companies of its tenant.
+type Command struct {
+	CorrelationID string          `json:"correlation_id"`
+	TenantID      string          `json:"tenant_id"`
+	Type          string          `json:"type"`
+	CompanyID     string          `json:"company_id,omitempty"`
+	Payload       json.RawMessage `json:"payload,omitempty"`
+}
+
+// createPayload uses pointers so a missing field can be told apart from a zero value
+type createPayload struct {
+	Name              *string             `json:"name"`
+	Description       *string             `json:"description,omitempty"`
+	AmountOfEmployees *int                `json:"amount_of_employees"`
+	Registered        *bool               `json:"registered"`
+	Type              *domain.CompanyType `json:"type"`
+}
+
+// Reply reports the outcome of a command, keyed by its correlation ID
+type Reply struct {
+	CorrelationID string          `json:"correlation_id"`
+	CommandType   string          `json:"command_type"`
+	Status        string          `json:"status"`
+	CompanyID     string          `json:"company_id,omitempty"`
+	Company       *domain.Company `json:"company,omitempty"`
+	Error         *ReplyError     `json:"error,omitempty"`
+}
+
+// ReplyError describes why a command was rejected
+type ReplyError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// decodeCommand parses a message from the commands topic, every error wraps errMalformed
+func decodeCommand(msg kafka.Message) (Command, error) {
+	var cmd Command
+	if err := json.Unmarshal(msg.Value, &cmd); err != nil {
+		return Command{}, fmt.Errorf("%w: %v", errMalformed, err)
+	}
+
+	if cmd.CorrelationID == "" {
+		cmd.CorrelationID = header(msg, "correlation_id")
+	}
+	if cmd.CorrelationID == "" {
+		return Command{}, fmt.Errorf("%w: correlation_id is required", errMalformed)
+	}
+	if cmd.TenantID == "" {
+		cmd.TenantID = header(msg, "tenant_id")
+	}
+	if _, err := uuid.Parse(cmd.TenantID); err != nil {
+		return Command{}, fmt.Errorf("%w: tenant_id must be a UUID", errMalformed)
+	}
+
+	switch cmd.Type {
+	case CommandCreate:
+		if cmd.CompanyID != "" {
+			if _, err := uuid.Parse(cmd.CompanyID); err != nil {
+				return Command{}, fmt.Errorf("%w: company_id must be a UUID", errMalformed)
+			}
+		}
+		if len(cmd.Payload) == 0 {
+			return Command{}, fmt.Errorf("%w: payload is required for %s", errMalformed, cmd.Type)
+		}
+	case CommandPatch:
+		if cmd.CompanyID == "" {
+			return Command{}, fmt.Errorf("%w: company_id is required for %s", errMalformed, cmd.Type)
+		}
+		if len(cmd.Payload) == 0 {
+			return Command{}, fmt.Errorf("%w: payload is required for %s", errMalformed, cmd.Type)
+		}
+	case CommandDelete:
+		if cmd.CompanyID == "" {
+			return Command{}, fmt.Errorf("%w: company_id is required for %s", errMalformed, cmd.Type)
+		}
+	default:
+		return Command{}, fmt.Errorf("%w: unknown command type %q", errMalformed, cmd.Type)
+	}
+
+	return cmd, nil
+}
+
+// execute runs the command through the service. Business failures become error
+// replies, any other error is returned so the caller can retry the command.
+func execute(ctx context.Context, service CompanyService, cmd Command) (Reply, error) {
+	reply := Reply{CorrelationID: cmd.CorrelationID, CommandType: cmd.Type, CompanyID: cmd.CompanyID}
+
+	var err error
+	switch cmd.Type {
+	case CommandCreate:
+		var company domain.Company
+		company, err = createCompany(ctx, service, cmd)
+		if err == nil {
+			reply.CompanyID = company.ID
+			reply.Company = &company
+		}
+	case CommandPatch:
+		var patch domain.PatchCompanyRequest
+		if err = decodePayload(cmd.Payload, &patch); err == nil {
+			err = service.PatchCompanyByID(ctx, patch, cmd.CompanyID)
+		}
+	case CommandDelete:
+		err = service.DeleteCompanyByID(ctx, cmd.CompanyID)
+	}
+
+	if err == nil {
+		reply.Status = StatusSuccess
+		return reply, nil
+	}
+
+	code := errorCode(err)
+	if code == CodeInternal {
+		return reply, err
+	}
+	reply.Status = StatusError
+	reply.Error = &ReplyError{Code: code, Message: err.Error()}
+	return reply, nil
+}
+
+func createCompany(ctx context.Context, service CompanyService, cmd Command) (domain.Company, error) {
+	var payload createPayload
+	if err := decodePayload(cmd.Payload, &payload); err != nil {
+		return domain.Company{}, err
+	}
+
+	// the same fields the HTTP endpoint requires
+	var missing []string
+	if payload.Name == nil {
+		missing = append(missing, "name")
+	}
+	if payload.AmountOfEmployees == nil {
+		missing = append(missing, "amount_of_employees")
+	}
+	if payload.Registered == nil {
+		missing = append(missing, "registered")
+	}
+	if payload.Type == nil {
+		missing = append(missing, "type")
+	}
+	if len(missing) > 0 {
+		return domain.Company{}, fmt.Errorf("%w: missing %s", companyservice.ErrValidationError, strings.Join(missing, ", "))
+	}
+
+	id := cmd.CompanyID
+	if id == "" {
+		id = derivedCompanyID(cmd)
+	}
+
+	return service.CreateCompany(ctx, domain.Company{
+		ID:                id,
+		Name:              *payload.Name,
+		Description:       payload.Description,
+		AmountOfEmployees: *payload.AmountOfEmployees,
+		Registered:        *payload.Registered,
+		Type:              *payload.Type,
+	})
+}
+
+// derivedCompanyID names the company after the tenant and correlation ID of the command,
+// so a retried or redelivered create hits the company it already made instead of adding another
+func derivedCompanyID(cmd Command) string {
+	return uuid.NewSHA1(companyIDNamespace, []byte(cmd.TenantID+"/"+cmd.CorrelationID)).String()
+}
+
+func decodePayload(payload json.RawMessage, v any) error {
+	decoder := json.NewDecoder(strings.NewReader(string(payload)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("%w: invalid payload: %v", companyservice.ErrValidationError, err)
+	}
+	return nil
+}
+
+func errorCode(err error) string {
+	switch {
+	case errors.Is(err, companyservice.ErrValidationError), errors.Is(err, companyservice.ErrInvalidInput):
+		return CodeValidation
+	case errors.Is(err, companyservice.ErrUniquenessViolation):
+		return CodeConflict
+	case errors.Is(err, companyservice.ErrNotFound):
+		return CodeNotFound
+	default:
+		return CodeInternal
+	}
+}
+
+func header(msg kafka.Message, key string) string {
+	for _, h := range msg.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
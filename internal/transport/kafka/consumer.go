@@ -0,0 +1,224 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+
+	"github.com/ktsiligkos/xm_project/internal/correlation"
+)
+
+const (
+	defaultMaxAttempts  = 3
+	defaultRetryBackoff = 500 * time.Millisecond
+)
+
+// Options names the topics and consumer group used for command ingestion
+type Options struct {
+	CommandsTopic   string
+	GroupID         string
+	RepliesTopic    string
+	DeadLetterTopic string
+	// MaxAttempts bounds how often a command is retried after an unexpected service error
+	MaxAttempts  int
+	RetryBackoff time.Duration
+}
+
+type messageReader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+type messageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// Consumer executes company commands read from Kafka and writes their outcome
+// to the replies topic. Offsets are committed only after the reply (or the dead
+// letter) has been written, so every command is answered at least once.
+type Consumer struct {
+	service CompanyService
+	opts    Options
+	reader  messageReader
+	writer  messageWriter
+	logger  *zap.Logger
+}
+
+// NewConsumer creates a consumer in the configured group
+func NewConsumer(brokers []string, opts Options, service CompanyService, logger *zap.Logger) *Consumer {
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers: brokers,
+		GroupID: opts.GroupID,
+		Topic:   opts.CommandsTopic,
+	})
+	writer := &kafka.Writer{
+		Addr: kafka.TCP(brokers...),
+		// the topic is set per message, replies and dead letters share the writer
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireOne,
+		BatchTimeout: 10 * time.Millisecond,
+	}
+	return newConsumer(reader, writer, opts, service, logger)
+}
+
+func newConsumer(reader messageReader, writer messageWriter, opts Options, service CompanyService, logger *zap.Logger) *Consumer {
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = defaultMaxAttempts
+	}
+	if opts.RetryBackoff <= 0 {
+		opts.RetryBackoff = defaultRetryBackoff
+	}
+	return &Consumer{service: service, opts: opts, reader: reader, writer: writer, logger: logger}
+}
+
+// Run processes commands until ctx is cancelled
+func (c *Consumer) Run(ctx context.Context) error {
+	for {
+		msg, err := c.reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("fetch command: %w", err)
+		}
+
+		// a command whose reply cannot be written is retried until the broker recovers or we stop
+		for {
+			err := c.process(ctx, msg)
+			if err == nil {
+				break
+			}
+			if ctx.Err() != nil {
+				return nil
+			}
+			if c.logger != nil {
+				c.logger.Error("process command failed", zap.Int64("offset", msg.Offset), zap.Error(err))
+			}
+			if !sleep(ctx, c.opts.RetryBackoff) {
+				return nil
+			}
+		}
+
+		if err := c.reader.CommitMessages(ctx, msg); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("commit command offset: %w", err)
+		}
+	}
+}
+
+// process answers a single message with a reply or a dead letter
+func (c *Consumer) process(ctx context.Context, msg kafka.Message) error {
+	cmd, err := decodeCommand(msg)
+	if err != nil {
+		if c.logger != nil {
+			c.logger.Warn("malformed command", zap.Int64("offset", msg.Offset), zap.Error(err))
+		}
+		return c.writer.WriteMessages(ctx, c.deadLetter(msg, err))
+	}
+
+	logger := c.logger
+	if logger != nil {
+		logger = logger.With(zap.String("correlation_id", cmd.CorrelationID), zap.String("command_type", cmd.Type))
+	}
+
+	ctx = commandContext(ctx, msg, cmd)
+	reply, err := c.execute(ctx, cmd)
+	if err != nil {
+		if logger != nil {
+			logger.Error("command failed", zap.Error(err))
+		}
+		reply.Status = StatusError
+		reply.Error = &ReplyError{Code: CodeInternal, Message: "command could not be executed"}
+	} else if logger != nil {
+		logger.Info("command executed", zap.String("status", reply.Status), zap.String("company_id", reply.CompanyID))
+	}
+
+	value, err := json.Marshal(reply)
+	if err != nil {
+		return fmt.Errorf("encode reply: %w", err)
+	}
+	return c.writer.WriteMessages(ctx, kafka.Message{
+		Topic: c.opts.RepliesTopic,
+		Key:   []byte(cmd.CorrelationID),
+		Value: value,
+		Headers: []kafka.Header{
+			{Key: "correlation_id", Value: []byte(cmd.CorrelationID)},
+		},
+	})
+}
+
+// execute retries unexpected errors, such as a database outage, before giving up
+func (c *Consumer) execute(ctx context.Context, cmd Command) (Reply, error) {
+	var (
+		reply Reply
+		err   error
+	)
+	for attempt := 1; attempt <= c.opts.MaxAttempts; attempt++ {
+		reply, err = execute(ctx, c.service, cmd)
+		if err == nil {
+			return reply, nil
+		}
+		if attempt < c.opts.MaxAttempts && !sleep(ctx, c.opts.RetryBackoff*time.Duration(attempt)) {
+			break
+		}
+	}
+	return reply, err
+}
+
+// deadLetter keeps the original message and records why it was rejected
+func (c *Consumer) deadLetter(msg kafka.Message, reason error) kafka.Message {
+	headers := append([]kafka.Header(nil), msg.Headers...)
+	headers = append(headers,
+		kafka.Header{Key: "dlq_error", Value: []byte(reason.Error())},
+		kafka.Header{Key: "dlq_source_topic", Value: []byte(msg.Topic)},
+		kafka.Header{Key: "dlq_partition", Value: []byte(strconv.Itoa(msg.Partition))},
+		kafka.Header{Key: "dlq_offset", Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+	)
+
+	return kafka.Message{
+		Topic:   c.opts.DeadLetterTopic,
+		Key:     msg.Key,
+		Value:   msg.Value,
+		Headers: headers,
+	}
+}
+
+// commandContext scopes the command to its tenant and carries its correlation data into the events it causes
+func commandContext(ctx context.Context, msg kafka.Message, cmd Command) context.Context {
+	ctx = correlation.WithTenant(ctx, cmd.TenantID)
+	ctx = correlation.WithRequestID(ctx, cmd.CorrelationID)
+	ctx = correlation.WithTraceParent(ctx, correlation.ChildTraceParent(header(msg, "traceparent")))
+	if userID := header(msg, "user_id"); userID != "" {
+		ctx = correlation.WithUserID(ctx, userID)
+	}
+	return ctx
+}
+
+// Close releases the reader and writer
+func (c *Consumer) Close() error {
+	if c == nil {
+		return nil
+	}
+	return errors.Join(c.reader.Close(), c.writer.Close())
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
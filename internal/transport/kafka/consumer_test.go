@@ -0,0 +1,282 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/ktsiligkos/xm_project/internal/correlation"
+	"github.com/ktsiligkos/xm_project/internal/domain"
+	companyservice "github.com/ktsiligkos/xm_project/internal/service/company"
+)
+
+// fakeReader hands out the queued messages, then blocks until ctx ends
+type fakeReader struct {
+	mu        sync.Mutex
+	messages  []kafka.Message
+	committed []int64
+}
+
+func (r *fakeReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	r.mu.Lock()
+	if len(r.messages) > 0 {
+		msg := r.messages[0]
+		r.messages = r.messages[1:]
+		r.mu.Unlock()
+		return msg, nil
+	}
+	r.mu.Unlock()
+	<-ctx.Done()
+	return kafka.Message{}, ctx.Err()
+}
+
+func (r *fakeReader) CommitMessages(_ context.Context, msgs ...kafka.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, msg := range msgs {
+		r.committed = append(r.committed, msg.Offset)
+	}
+	return nil
+}
+
+func (r *fakeReader) Close() error { return nil }
+
+type fakeWriter struct {
+	mu      sync.Mutex
+	written []kafka.Message
+}
+
+func (w *fakeWriter) WriteMessages(_ context.Context, msgs ...kafka.Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.written = append(w.written, msgs...)
+	return nil
+}
+
+func (w *fakeWriter) Close() error { return nil }
+
+type stubService struct {
+	createFn func(ctx context.Context, company domain.Company) (domain.Company, error)
+	deleteFn func(ctx context.Context, companyID string) error
+	patchFn  func(ctx context.Context, req domain.PatchCompanyRequest, uuid string) error
+}
+
+func (s stubService) CreateCompany(ctx context.Context, company domain.Company) (domain.Company, error) {
+	return s.createFn(ctx, company)
+}
+
+func (s stubService) DeleteCompanyByID(ctx context.Context, companyID string) error {
+	return s.deleteFn(ctx, companyID)
+}
+
+func (s stubService) PatchCompanyByID(ctx context.Context, req domain.PatchCompanyRequest, uuid string) error {
+	return s.patchFn(ctx, req, uuid)
+}
+
+var testOptions = Options{
+	CommandsTopic:   "commands",
+	RepliesTopic:    "replies",
+	DeadLetterTopic: "commands-dlq",
+	RetryBackoff:    time.Millisecond,
+}
+
+// runUntilCommitted consumes the messages and stops once all of them are committed
+func runUntilCommitted(t *testing.T, service CompanyService, msgs ...kafka.Message) (*fakeReader, *fakeWriter) {
+	t.Helper()
+	for i := range msgs {
+		msgs[i].Offset = int64(i)
+		msgs[i].Topic = testOptions.CommandsTopic
+	}
+	reader := &fakeReader{messages: msgs}
+	writer := &fakeWriter{}
+	consumer := newConsumer(reader, writer, testOptions, service, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- consumer.Run(ctx) }()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		reader.mu.Lock()
+		n := len(reader.committed)
+		reader.mu.Unlock()
+		if n == len(msgs) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if len(reader.committed) != len(msgs) {
+		t.Fatalf("expected %d commits, got %v", len(msgs), reader.committed)
+	}
+	return reader, writer
+}
+
+const testTenant = "7c9e6679-7425-40de-944b-e07fc1f90ae7"
+
+// commandMessage encodes the command, which acts for testTenant unless it names a tenant
+func commandMessage(t *testing.T, cmd Command) kafka.Message {
+	t.Helper()
+	if cmd.TenantID == "" {
+		cmd.TenantID = testTenant
+	}
+	value, err := json.Marshal(cmd)
+	if err != nil {
+		t.Fatalf("encode command: %v", err)
+	}
+	return kafka.Message{Value: value}
+}
+
+func decodeReply(t *testing.T, msg kafka.Message) Reply {
+	t.Helper()
+	var reply Reply
+	if err := json.Unmarshal(msg.Value, &reply); err != nil {
+		t.Fatalf("decode reply: %v", err)
+	}
+	return reply
+}
+
+func TestConsumer_CreateRepliesWithCompany(t *testing.T) {
+	var gotRequestID, gotTenant string
+	service := stubService{createFn: func(ctx context.Context, company domain.Company) (domain.Company, error) {
+		gotRequestID = correlation.RequestID(ctx)
+		gotTenant = correlation.Tenant(ctx)
+		return company, nil
+	}}
+
+	_, writer := runUntilCommitted(t, service, commandMessage(t, Command{
+		CorrelationID: "corr-1",
+		Type:          CommandCreate,
+		Payload:       json.RawMessage(`{"name":"Acme","amount_of_employees":5,"registered":true,"type":"NonProfit"}`),
+	}))
+
+	if len(writer.written) != 1 || writer.written[0].Topic != "replies" || string(writer.written[0].Key) != "corr-1" {
+		t.Fatalf("expected one reply keyed by the correlation id, got %+v", writer.written)
+	}
+	reply := decodeReply(t, writer.written[0])
+	if reply.Status != StatusSuccess || reply.Company == nil || reply.Company.Name != "Acme" || reply.CompanyID == "" {
+		t.Fatalf("unexpected reply: %+v", reply)
+	}
+	if gotRequestID != "corr-1" {
+		t.Fatalf("the correlation id should reach the service as request id, got %q", gotRequestID)
+	}
+	if gotTenant != testTenant {
+		t.Fatalf("the command should act for its tenant, got %q", gotTenant)
+	}
+}
+
+func TestConsumer_TenantMayComeFromTheHeader(t *testing.T) {
+	var gotTenant string
+	service := stubService{deleteFn: func(ctx context.Context, _ string) error {
+		gotTenant = correlation.Tenant(ctx)
+		return nil
+	}}
+	value, _ := json.Marshal(Command{CorrelationID: "corr-1", Type: CommandDelete, CompanyID: "c1"})
+
+	runUntilCommitted(t, service, kafka.Message{Value: value, Headers: []kafka.Header{{Key: "tenant_id", Value: []byte(testTenant)}}})
+
+	if gotTenant != testTenant {
+		t.Fatalf("expected the tenant of the header, got %q", gotTenant)
+	}
+}
+
+func TestConsumer_BusinessErrorsBecomeErrorReplies(t *testing.T) {
+	service := stubService{
+		createFn: func(context.Context, domain.Company) (domain.Company, error) {
+			return domain.Company{}, companyservice.ErrUniquenessViolation
+		},
+		deleteFn: func(context.Context, string) error { return companyservice.ErrNotFound },
+	}
+
+	_, writer := runUntilCommitted(t, service,
+		commandMessage(t, Command{CorrelationID: "missing-fields", Type: CommandCreate, Payload: json.RawMessage(`{"name":"Acme"}`)}),
+		commandMessage(t, Command{CorrelationID: "duplicate", Type: CommandCreate, Payload: json.RawMessage(`{"name":"Acme","amount_of_employees":5,"registered":true,"type":"NonProfit"}`)}),
+		commandMessage(t, Command{CorrelationID: "gone", Type: CommandDelete, CompanyID: "c1"}),
+	)
+
+	want := []string{CodeValidation, CodeConflict, CodeNotFound}
+	if len(writer.written) != len(want) {
+		t.Fatalf("expected %d replies, got %d", len(want), len(writer.written))
+	}
+	for i, code := range want {
+		reply := decodeReply(t, writer.written[i])
+		if reply.Status != StatusError || reply.Error == nil || reply.Error.Code != code {
+			t.Fatalf("reply %d: want code %s, got %+v", i, code, reply)
+		}
+	}
+}
+
+func TestConsumer_MalformedCommandsGoToDeadLetterTopic(t *testing.T) {
+	withoutTenant, _ := json.Marshal(Command{CorrelationID: "corr", Type: CommandDelete, CompanyID: "c1"})
+	_, writer := runUntilCommitted(t, stubService{},
+		kafka.Message{Value: []byte("not json")},
+		commandMessage(t, Command{Type: CommandDelete, CompanyID: "c1"}),
+		commandMessage(t, Command{CorrelationID: "corr", Type: "company.rename"}),
+		kafka.Message{Value: withoutTenant},
+		commandMessage(t, Command{CorrelationID: "corr", TenantID: "default", Type: CommandDelete, CompanyID: "c1"}),
+	)
+
+	if len(writer.written) != 5 {
+		t.Fatalf("expected five dead letters, got %d", len(writer.written))
+	}
+	for _, msg := range writer.written {
+		if msg.Topic != "commands-dlq" || header(msg, "dlq_error") == "" || header(msg, "dlq_source_topic") != "commands" {
+			t.Fatalf("unexpected dead letter: %+v", msg)
+		}
+	}
+}
+
+func TestConsumer_RetriesUnexpectedErrors(t *testing.T) {
+	calls := 0
+	service := stubService{patchFn: func(context.Context, domain.PatchCompanyRequest, string) error {
+		calls++
+		return errors.New("database down")
+	}}
+
+	_, writer := runUntilCommitted(t, service, commandMessage(t, Command{
+		CorrelationID: "corr-1",
+		Type:          CommandPatch,
+		CompanyID:     "c1",
+		Payload:       json.RawMessage(`{"name":"New"}`),
+	}))
+
+	if calls != defaultMaxAttempts {
+		t.Fatalf("expected %d attempts, got %d", defaultMaxAttempts, calls)
+	}
+	reply := decodeReply(t, writer.written[0])
+	if reply.Status != StatusError || reply.Error.Code != CodeInternal {
+		t.Fatalf("unexpected reply: %+v", reply)
+	}
+}
+
+func TestConsumer_RedeliveredCreateKeepsItsCompanyID(t *testing.T) {
+	var ids []string
+	service := stubService{createFn: func(_ context.Context, company domain.Company) (domain.Company, error) {
+		ids = append(ids, company.ID)
+		return company, nil
+	}}
+	create := Command{
+		CorrelationID: "corr-1",
+		Type:          CommandCreate,
+		Payload:       json.RawMessage(`{"name":"Acme","amount_of_employees":5,"registered":true,"type":"NonProfit"}`),
+	}
+	otherTenant := create
+	otherTenant.TenantID = "9b2f1c4e-3d5a-4e6f-8a7b-1c2d3e4f5a6b"
+
+	runUntilCommitted(t, service, commandMessage(t, create), commandMessage(t, create), commandMessage(t, otherTenant))
+
+	if len(ids) != 3 || ids[0] == "" || ids[0] != ids[1] {
+		t.Fatalf("a redelivered create should reuse the company id, got %v", ids)
+	}
+	if ids[2] == ids[0] {
+		t.Fatalf("the same correlation id in another tenant should name another company, got %v", ids)
+	}
+}
@@ -1,17 +1,146 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"time"
+)
+
+// Mail transports, see MAILER
+const (
+	MailerFile = "file"
+	MailerSMTP = "smtp"
+)
+
+// Company storage backends, see COMPANY_STORAGE
+const (
+	CompanyStorageMySQL      = "mysql"
+	CompanyStorageEventStore = "eventstore"
 )
 
 // Config represents the runtime configuration values for the service.
 type Config struct {
-	HTTPAddr     string
-	MySQLDSN     string
-	JWTSecret    string
+	HTTPAddr  string
+	MySQLDSN  string
+	JWTSecret string
+	// JWTSigningAlgorithm is HS256 (signed with JWTSecret), RS256, ES256 or EdDSA (signed with
+	// JWTSigningKeyFile). JWTVerificationKeyFiles keep tokens of earlier keys valid during a rotation.
+	JWTSigningAlgorithm     string
+	JWTSigningKeyFile       string
+	JWTVerificationKeyFiles []string
+	// OIDCIssuerURL enables access tokens of an external identity provider, empty disables them.
+	// Its tokens must carry OIDCAudience; exp, nbf and iat are checked with OIDCLeeway.
+	OIDCIssuerURL string
+	OIDCAudience  string
+	OIDCLeeway    time.Duration
+	// OIDCUserIDClaim and OIDCRolesClaim name the claims mapped to user_id and roles,
+	// OIDCRoleMapping translates role names of the identity provider (OIDC_ROLE_MAPPING=idp-role=role,...)
+	OIDCUserIDClaim string
+	OIDCRolesClaim  string
+	OIDCRoleMapping map[string]string
+	// OIDCTenantClaim names the claim mapped to tenant_id, tokens without it are rejected.
+	// OIDCTenant instead puts every provider token into one tenant; OIDC needs exactly one of them.
+	OIDCTenantClaim string
+	OIDCTenant      string
+	// OIDCJWKSCacheTTL is how long the keys of the identity provider are cached
+	OIDCJWKSCacheTTL time.Duration
+	// OIDCAcceptLocalTokens keeps accepting the tokens issued by POST /login next to the ones of the identity provider
+	OIDCAcceptLocalTokens bool
+	// Login protection: failures tolerated per email address and per client IP before logins
+	// are delayed (LoginBaseDelay, doubling up to LoginMaxDelay) and locked for LoginLockoutDuration
+	LoginProtectionEnabled  bool
+	LoginFreeAttempts       int
+	LoginLockoutThreshold   int
+	LoginIPFreeAttempts     int
+	LoginIPLockoutThreshold int
+	LoginBaseDelay          time.Duration
+	LoginMaxDelay           time.Duration
+	LoginLockoutDuration    time.Duration
+	// PasswordResetTokenTTL bounds the life of a reset link, PasswordResetURL is the page it points to
+	PasswordResetTokenTTL time.Duration
+	PasswordResetURL      string
+	// PasswordResetRequestsPerEmail and PasswordResetRequestsPerIP are accepted per PasswordResetRequestWindow
+	PasswordResetRequestsPerEmail int
+	PasswordResetRequestsPerIP    int
+	PasswordResetRequestWindow    time.Duration
+	// Mailer is file (messages are written to MailOutboxDir) or smtp (sent through SMTPAddr)
+	Mailer        string
+	MailFrom      string
+	MailOutboxDir string
+	SMTPAddr      string
+	SMTPUsername  string
+	SMTPPassword  string
+	// MFAEnabled offers TOTP enrollment; MFAIssuer names the service in authenticator apps, and
+	// the second login step must be completed within MFAChallengeTTL and MFAMaxAttempts codes
+	MFAEnabled      bool
+	MFAIssuer       string
+	MFAChallengeTTL time.Duration
+	MFAMaxAttempts  int
+	// AccessTokenTTL bounds the life of a JWT, RefreshTokenTTL that of the refresh token used to renew it
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	// TokenRevocationSyncInterval is how often revocations made by other instances are loaded
+	TokenRevocationSyncInterval time.Duration
+	// CompanyStorage selects how companies are persisted: mysql (rows) or eventstore (event log with a projection)
+	CompanyStorage string
+	// CompanySnapshotInterval is the number of events between two snapshots of a company in eventstore mode
+	CompanySnapshotInterval int
+
 	KafkaBrokers []string
 	KafkaTopic   string
+	// KafkaStateTopic is the log-compacted topic holding the latest state of every company, empty disables it
+	KafkaStateTopic string
+	// KafkaUsersTopic receives user lifecycle events (creation, logins, password changes), empty disables them
+	KafkaUsersTopic string
+
+	// Command ingestion, an empty KafkaCommandsTopic disables the consumer
+	KafkaCommandsTopic    string
+	KafkaCommandsGroup    string
+	KafkaRepliesTopic     string
+	KafkaCommandsDLQTopic string
+
+	// Topic routing and provisioning, see KAFKA_TOPICS_CONFIG
+	KafkaRoutes                 []KafkaRoute
+	KafkaTopics                 []KafkaTopic
+	KafkaProvisionTopics        bool
+	KafkaTopicPartitions        int
+	KafkaTopicReplicationFactor int
+
+	// Asynchronous event publication
+	EventsAsync          bool
+	EventsQueueSize      int
+	EventsOverflowPolicy string
+	EventsSpillPath      string
+	// EventsUserSpillPath is the spill file of user events, it must differ from EventsSpillPath
+	EventsUserSpillPath    string
+	EventsBreakerThreshold int
+	EventsBreakerCooldown  time.Duration
+	EventsDrainTimeout     time.Duration
+
+	// Live change feed served at /api/v1/companies/stream
+	StreamReplayBuffer      int
+	StreamHeartbeatInterval time.Duration
+
+	// WebSocket subscriptions served at /api/v1/companies/ws
+	SocketMaxSubscriptions int
+	SocketSendBuffer       int
+	// SocketAllowedOrigins are the other sites whose pages may open the WebSocket
+	SocketAllowedOrigins []string
+
+	// TrustedProxies are the addresses or CIDRs of the reverse proxies whose X-Forwarded-For
+	// is believed, none by default so clients cannot pick the IP their logins are counted for
+	TrustedProxies []string
+
+	// Password hashing, new hashes use PasswordHashAlgorithm (bcrypt or argon2id)
+	PasswordHashAlgorithm string
+	PasswordBcryptCost    int
+	PasswordArgon2Memory  int
+	PasswordArgon2Time    int
+	PasswordArgon2Threads int
+	PasswordMinLength     int
+	PasswordBreachedList  string
 }
 
 // Load reads configuration from the environment, applying sane defaults.
@@ -55,11 +184,260 @@ func Load() (Config, error) {
 		kafkaTopic = "company-events"
 	}
 
-	return Config{
+	cfg := Config{
 		HTTPAddr:     addr,
 		MySQLDSN:     connString,
 		JWTSecret:    secret,
 		KafkaBrokers: brokers,
 		KafkaTopic:   kafkaTopic,
-	}, nil
+	}
+
+	cfg.JWTSigningAlgorithm = envString("JWT_SIGNING_ALGORITHM", "HS256")
+	cfg.JWTSigningKeyFile = envString("JWT_SIGNING_KEY_FILE", "")
+	cfg.JWTVerificationKeyFiles = envList("JWT_VERIFICATION_KEY_FILES")
+
+	var err error
+	cfg.OIDCIssuerURL = envString("OIDC_ISSUER_URL", "")
+	cfg.OIDCAudience = envString("OIDC_AUDIENCE", "")
+	if cfg.OIDCIssuerURL != "" && cfg.OIDCAudience == "" {
+		return Config{}, fmt.Errorf("OIDC_AUDIENCE is required when OIDC_ISSUER_URL is set")
+	}
+	if cfg.OIDCLeeway, err = envDuration("OIDC_LEEWAY", 30*time.Second); err != nil {
+		return Config{}, err
+	}
+	cfg.OIDCUserIDClaim = envString("OIDC_USER_ID_CLAIM", "sub")
+	cfg.OIDCRolesClaim = envString("OIDC_ROLES_CLAIM", "roles")
+	cfg.OIDCTenantClaim = envString("OIDC_TENANT_CLAIM", "")
+	cfg.OIDCTenant = envString("OIDC_TENANT", "")
+	if cfg.OIDCIssuerURL != "" && (cfg.OIDCTenantClaim == "") == (cfg.OIDCTenant == "") {
+		return Config{}, fmt.Errorf("exactly one of OIDC_TENANT_CLAIM and OIDC_TENANT is required when OIDC_ISSUER_URL is set")
+	}
+	cfg.OIDCRoleMapping = map[string]string{}
+	for _, pair := range envList("OIDC_ROLE_MAPPING") {
+		from, to, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(from) == "" || strings.TrimSpace(to) == "" {
+			return Config{}, fmt.Errorf("OIDC_ROLE_MAPPING entries must look like idp-role=role, got %q", pair)
+		}
+		cfg.OIDCRoleMapping[strings.TrimSpace(from)] = strings.TrimSpace(to)
+	}
+	if cfg.OIDCJWKSCacheTTL, err = envDuration("OIDC_JWKS_CACHE_TTL", time.Hour); err != nil {
+		return Config{}, err
+	}
+	if cfg.OIDCAcceptLocalTokens, err = envBool("OIDC_ACCEPT_LOCAL_TOKENS", true); err != nil {
+		return Config{}, err
+	}
+
+	if cfg.AccessTokenTTL, err = envDuration("ACCESS_TOKEN_TTL", 15*time.Minute); err != nil {
+		return Config{}, err
+	}
+	if cfg.RefreshTokenTTL, err = envDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour); err != nil {
+		return Config{}, err
+	}
+	if cfg.TokenRevocationSyncInterval, err = envDuration("TOKEN_REVOCATION_SYNC_INTERVAL", 30*time.Second); err != nil {
+		return Config{}, err
+	}
+
+	if cfg.LoginProtectionEnabled, err = envBool("LOGIN_PROTECTION_ENABLED", true); err != nil {
+		return Config{}, err
+	}
+	if cfg.LoginFreeAttempts, err = envInt("LOGIN_FREE_ATTEMPTS", 3); err != nil {
+		return Config{}, err
+	}
+	if cfg.LoginLockoutThreshold, err = envInt("LOGIN_LOCKOUT_THRESHOLD", 10); err != nil {
+		return Config{}, err
+	}
+	if cfg.LoginIPFreeAttempts, err = envInt("LOGIN_IP_FREE_ATTEMPTS", 20); err != nil {
+		return Config{}, err
+	}
+	if cfg.LoginIPLockoutThreshold, err = envInt("LOGIN_IP_LOCKOUT_THRESHOLD", 100); err != nil {
+		return Config{}, err
+	}
+	if cfg.LoginBaseDelay, err = envDuration("LOGIN_BASE_DELAY", time.Second); err != nil {
+		return Config{}, err
+	}
+	if cfg.LoginMaxDelay, err = envDuration("LOGIN_MAX_DELAY", time.Minute); err != nil {
+		return Config{}, err
+	}
+	if cfg.LoginLockoutDuration, err = envDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute); err != nil {
+		return Config{}, err
+	}
+
+	if cfg.PasswordResetTokenTTL, err = envDuration("PASSWORD_RESET_TOKEN_TTL", time.Hour); err != nil {
+		return Config{}, err
+	}
+	cfg.PasswordResetURL = envString("PASSWORD_RESET_URL", "")
+	if cfg.PasswordResetRequestsPerEmail, err = envInt("PASSWORD_RESET_REQUESTS_PER_EMAIL", 3); err != nil {
+		return Config{}, err
+	}
+	if cfg.PasswordResetRequestsPerIP, err = envInt("PASSWORD_RESET_REQUESTS_PER_IP", 20); err != nil {
+		return Config{}, err
+	}
+	if cfg.PasswordResetRequestWindow, err = envDuration("PASSWORD_RESET_REQUEST_WINDOW", time.Hour); err != nil {
+		return Config{}, err
+	}
+	cfg.Mailer = envString("MAILER", MailerFile)
+	if cfg.Mailer != MailerFile && cfg.Mailer != MailerSMTP {
+		return Config{}, fmt.Errorf("MAILER must be %q or %q, got %q", MailerFile, MailerSMTP, cfg.Mailer)
+	}
+	cfg.MailFrom = envString("MAIL_FROM", "no-reply@localhost")
+	cfg.MailOutboxDir = envString("MAIL_OUTBOX_DIR", "mail-outbox")
+	cfg.SMTPAddr = envString("SMTP_ADDR", "localhost:25")
+	cfg.SMTPUsername = envString("SMTP_USERNAME", "")
+	cfg.SMTPPassword = os.Getenv("SMTP_PASSWORD")
+
+	if cfg.MFAEnabled, err = envBool("MFA_ENABLED", true); err != nil {
+		return Config{}, err
+	}
+	cfg.MFAIssuer = envString("MFA_ISSUER", "XM Companies")
+	if cfg.MFAChallengeTTL, err = envDuration("MFA_CHALLENGE_TTL", 5*time.Minute); err != nil {
+		return Config{}, err
+	}
+	if cfg.MFAMaxAttempts, err = envInt("MFA_MAX_ATTEMPTS", 5); err != nil {
+		return Config{}, err
+	}
+
+	cfg.CompanyStorage = envString("COMPANY_STORAGE", CompanyStorageMySQL)
+	if cfg.CompanyStorage != CompanyStorageMySQL && cfg.CompanyStorage != CompanyStorageEventStore {
+		return Config{}, fmt.Errorf("COMPANY_STORAGE must be %q or %q, got %q", CompanyStorageMySQL, CompanyStorageEventStore, cfg.CompanyStorage)
+	}
+	if cfg.CompanySnapshotInterval, err = envInt("COMPANY_SNAPSHOT_INTERVAL", 20); err != nil {
+		return Config{}, err
+	}
+
+	cfg.KafkaStateTopic = envString("KAFKA_STATE_TOPIC", "")
+	cfg.KafkaUsersTopic = envString("KAFKA_USERS_TOPIC", "")
+
+	cfg.KafkaCommandsTopic = envString("KAFKA_COMMANDS_TOPIC", "")
+	cfg.KafkaCommandsGroup = envString("KAFKA_COMMANDS_GROUP", "xm-company-commands")
+	cfg.KafkaRepliesTopic = envString("KAFKA_REPLIES_TOPIC", "company-command-replies")
+	cfg.KafkaCommandsDLQTopic = envString("KAFKA_COMMANDS_DLQ_TOPIC", "company-commands-dlq")
+
+	if path := envString("KAFKA_TOPICS_CONFIG", ""); path != "" {
+		topicsFile, err := loadKafkaTopicsFile(path)
+		if err != nil {
+			return Config{}, err
+		}
+		cfg.KafkaRoutes = topicsFile.Routes
+		cfg.KafkaTopics = topicsFile.Topics
+	}
+	if cfg.KafkaProvisionTopics, err = envBool("KAFKA_PROVISION_TOPICS", false); err != nil {
+		return Config{}, err
+	}
+	if cfg.KafkaTopicPartitions, err = envInt("KAFKA_TOPIC_PARTITIONS", 1); err != nil {
+		return Config{}, err
+	}
+	if cfg.KafkaTopicReplicationFactor, err = envInt("KAFKA_TOPIC_REPLICATION_FACTOR", 1); err != nil {
+		return Config{}, err
+	}
+
+	if cfg.EventsAsync, err = envBool("EVENTS_ASYNC", true); err != nil {
+		return Config{}, err
+	}
+	if cfg.EventsQueueSize, err = envInt("EVENTS_QUEUE_SIZE", 1024); err != nil {
+		return Config{}, err
+	}
+	cfg.EventsOverflowPolicy = envString("EVENTS_OVERFLOW_POLICY", "block")
+	cfg.EventsSpillPath = envString("EVENTS_SPILL_PATH", "company-events.spill")
+	cfg.EventsUserSpillPath = envString("EVENTS_USER_SPILL_PATH", "user-events.spill")
+	if cfg.EventsUserSpillPath == cfg.EventsSpillPath {
+		return Config{}, fmt.Errorf("EVENTS_USER_SPILL_PATH must differ from EVENTS_SPILL_PATH, both are %q", cfg.EventsSpillPath)
+	}
+	if cfg.EventsBreakerThreshold, err = envInt("EVENTS_BREAKER_THRESHOLD", 5); err != nil {
+		return Config{}, err
+	}
+	if cfg.EventsBreakerCooldown, err = envDuration("EVENTS_BREAKER_COOLDOWN", 30*time.Second); err != nil {
+		return Config{}, err
+	}
+	if cfg.EventsDrainTimeout, err = envDuration("EVENTS_DRAIN_TIMEOUT", 10*time.Second); err != nil {
+		return Config{}, err
+	}
+
+	if cfg.StreamReplayBuffer, err = envInt("STREAM_REPLAY_BUFFER", 1000); err != nil {
+		return Config{}, err
+	}
+	if cfg.StreamHeartbeatInterval, err = envDuration("STREAM_HEARTBEAT_INTERVAL", 15*time.Second); err != nil {
+		return Config{}, err
+	}
+	if cfg.SocketMaxSubscriptions, err = envInt("WS_MAX_SUBSCRIPTIONS", 100); err != nil {
+		return Config{}, err
+	}
+	if cfg.SocketSendBuffer, err = envInt("WS_SEND_BUFFER", 64); err != nil {
+		return Config{}, err
+	}
+	cfg.SocketAllowedOrigins = envList("WS_ALLOWED_ORIGINS")
+	cfg.TrustedProxies = envList("TRUSTED_PROXIES")
+
+	cfg.PasswordHashAlgorithm = envString("PASSWORD_HASH_ALGORITHM", "argon2id")
+	if cfg.PasswordBcryptCost, err = envInt("PASSWORD_BCRYPT_COST", 12); err != nil {
+		return Config{}, err
+	}
+	if cfg.PasswordArgon2Memory, err = envInt("PASSWORD_ARGON2_MEMORY_KIB", 64*1024); err != nil {
+		return Config{}, err
+	}
+	if cfg.PasswordArgon2Time, err = envInt("PASSWORD_ARGON2_ITERATIONS", 3); err != nil {
+		return Config{}, err
+	}
+	if cfg.PasswordArgon2Threads, err = envInt("PASSWORD_ARGON2_PARALLELISM", 2); err != nil {
+		return Config{}, err
+	}
+	if cfg.PasswordMinLength, err = envInt("PASSWORD_MIN_LENGTH", 8); err != nil {
+		return Config{}, err
+	}
+	cfg.PasswordBreachedList = envString("PASSWORD_BREACHED_LIST", "")
+
+	return cfg, nil
+}
+
+func envString(key, fallback string) string {
+	if value := strings.TrimSpace(os.Getenv(key)); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// envList splits a comma separated value, dropping empty entries
+func envList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if trimmed := strings.TrimSpace(value); trimmed != "" {
+			values = append(values, trimmed)
+		}
+	}
+	return values
+}
+
+func envInt(key string, fallback int) (int, error) {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be an integer: %w", key, err)
+	}
+	return parsed, nil
+}
+
+func envBool(key string, fallback bool) (bool, error) {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%s must be a boolean: %w", key, err)
+	}
+	return parsed, nil
+}
+
+func envDuration(key string, fallback time.Duration) (time.Duration, error) {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a duration such as 30s: %w", key, err)
+	}
+	return parsed, nil
 }
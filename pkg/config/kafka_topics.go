@@ -0,0 +1,72 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// KafkaRoute sends the events matching every non-empty criterion to Topic
+type KafkaRoute struct {
+	Operation string `json:"operation,omitempty"`
+	Type      string `json:"type,omitempty"`
+	Tenant    string `json:"tenant,omitempty"`
+	Topic     string `json:"topic"`
+}
+
+// KafkaTopic describes how a topic is created when topic provisioning is enabled
+type KafkaTopic struct {
+	Name              string            `json:"name"`
+	Partitions        int               `json:"partitions,omitempty"`
+	ReplicationFactor int               `json:"replication_factor,omitempty"`
+	CleanupPolicy     string            `json:"cleanup_policy,omitempty"`
+	RetentionMs       *int64            `json:"retention_ms,omitempty"`
+	Configs           map[string]string `json:"configs,omitempty"`
+}
+
+// kafkaTopicsFile is the layout of the file referenced by KAFKA_TOPICS_CONFIG
+type kafkaTopicsFile struct {
+	Routes []KafkaRoute `json:"routes"`
+	Topics []KafkaTopic `json:"topics"`
+}
+
+func loadKafkaTopicsFile(path string) (kafkaTopicsFile, error) {
+	var file kafkaTopicsFile
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return file, fmt.Errorf("read kafka topics config: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &file); err != nil {
+		return file, fmt.Errorf("decode kafka topics config %s: %w", path, err)
+	}
+
+	for i, route := range file.Routes {
+		if route.Topic == "" {
+			return file, fmt.Errorf("kafka route %d: topic is required", i)
+		}
+		if route.Operation == "" && route.Type == "" && route.Tenant == "" {
+			return file, fmt.Errorf("kafka route %d: at least one of operation, type or tenant is required", i)
+		}
+	}
+
+	seen := make(map[string]bool, len(file.Topics))
+	for i, topic := range file.Topics {
+		if topic.Name == "" {
+			return file, fmt.Errorf("kafka topic %d: name is required", i)
+		}
+		if seen[topic.Name] {
+			return file, fmt.Errorf("kafka topic %q is declared twice", topic.Name)
+		}
+		seen[topic.Name] = true
+
+		switch topic.CleanupPolicy {
+		case "", "delete", "compact", "compact,delete", "delete,compact":
+		default:
+			return file, fmt.Errorf("kafka topic %q: unknown cleanup_policy %q", topic.Name, topic.CleanupPolicy)
+		}
+	}
+
+	return file, nil
+}
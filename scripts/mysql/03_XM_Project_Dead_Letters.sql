@@ -0,0 +1,15 @@
+USE xm_companies;
+
+-- Company events that could not be published to Kafka
+CREATE TABLE company_event_dead_letters (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    operation VARCHAR(64) NOT NULL,
+    company_id CHAR(36) NOT NULL,
+    payload JSON NOT NULL,
+    last_error TEXT NOT NULL,
+    attempts INT NOT NULL,
+    created_at DATETIME(6) NOT NULL,
+    last_attempt_at DATETIME(6) NOT NULL,
+    PRIMARY KEY (id),
+    INDEX idx_dead_letters_company (company_id)
+);
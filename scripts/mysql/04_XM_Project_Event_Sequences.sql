@@ -0,0 +1,8 @@
+USE xm_companies;
+
+-- Per-company counter used to order the events of each company
+CREATE TABLE company_event_sequences (
+    company_id CHAR(36) NOT NULL,
+    last_sequence BIGINT NOT NULL,
+    PRIMARY KEY (company_id)
+);
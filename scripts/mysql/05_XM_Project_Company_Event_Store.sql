@@ -0,0 +1,23 @@
+USE xm_companies;
+
+-- Append-only log of company changes, the source of truth when COMPANY_STORAGE=eventstore
+CREATE TABLE company_events (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    company_id CHAR(36) NOT NULL,
+    version BIGINT NOT NULL,
+    event_type VARCHAR(32) NOT NULL,
+    payload JSON NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    UNIQUE KEY uq_company_events_company_version (company_id, version)
+);
+
+-- Folded state of a company at a given version, so loading it skips the older events
+CREATE TABLE company_snapshots (
+    company_id CHAR(36) NOT NULL,
+    version BIGINT NOT NULL,
+    state JSON NOT NULL,
+    deleted BOOLEAN NOT NULL DEFAULT FALSE,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (company_id)
+);
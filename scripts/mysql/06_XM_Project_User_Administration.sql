@@ -0,0 +1,19 @@
+USE xm_companies;
+
+-- Users get UUIDs like companies do, the seeded numeric IDs are replaced
+ALTER TABLE users MODIFY id CHAR(36) NOT NULL;
+UPDATE users SET id = UUID() WHERE id NOT LIKE '%-%';
+
+-- Emails identify users at login, so they must be unique
+ALTER TABLE users
+    MODIFY name VARCHAR(255) NOT NULL,
+    MODIFY email VARCHAR(255) NOT NULL,
+    MODIFY password_hash VARCHAR(255) NOT NULL,
+    ADD COLUMN is_admin BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN disabled_at DATETIME(6) NULL,
+    ADD COLUMN created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    ADD COLUMN updated_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    ADD UNIQUE INDEX idx_users_email (email);
+
+-- The seeded user administers the others
+UPDATE users SET is_admin = TRUE WHERE email = 'john_doe@example.com';
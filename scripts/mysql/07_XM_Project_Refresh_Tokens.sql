@@ -0,0 +1,18 @@
+USE xm_companies;
+
+-- Long-lived refresh tokens, only the SHA-256 of the token is stored.
+-- Tokens issued by rotating one another share a family_id.
+CREATE TABLE refresh_tokens (
+    id CHAR(36) NOT NULL,
+    family_id CHAR(36) NOT NULL,
+    user_id CHAR(36) NOT NULL,
+    token_hash CHAR(64) NOT NULL,
+    expires_at DATETIME(6) NOT NULL,
+    created_at DATETIME(6) NOT NULL,
+    used_at DATETIME(6) NULL,
+    revoked_at DATETIME(6) NULL,
+    PRIMARY KEY (id),
+    UNIQUE INDEX idx_refresh_tokens_hash (token_hash),
+    INDEX idx_refresh_tokens_family (family_id),
+    INDEX idx_refresh_tokens_user (user_id)
+);
@@ -0,0 +1,19 @@
+USE xm_companies;
+
+-- Access tokens revoked before they expire, keyed by the jti claim.
+-- Rows can be deleted once expires_at has passed.
+CREATE TABLE revoked_tokens (
+    jti CHAR(36) NOT NULL,
+    user_id CHAR(36) NULL,
+    expires_at DATETIME(6) NOT NULL,
+    revoked_at DATETIME(6) NOT NULL,
+    PRIMARY KEY (jti),
+    INDEX idx_revoked_tokens_expires (expires_at)
+);
+
+-- Every access token of the user issued before revoked_before is rejected.
+CREATE TABLE user_token_revocations (
+    user_id CHAR(36) NOT NULL,
+    revoked_before DATETIME(6) NOT NULL,
+    PRIMARY KEY (user_id)
+);
@@ -0,0 +1,14 @@
+USE xm_companies;
+
+-- Roles replace the admin flag, see internal/auth/permissions.go for what each role grants
+CREATE TABLE user_roles (
+    user_id CHAR(36) NOT NULL,
+    role VARCHAR(32) NOT NULL,
+    PRIMARY KEY (user_id, role)
+);
+
+-- Every user could change companies so far, they keep that ability
+INSERT INTO user_roles (user_id, role) SELECT id, 'manager' FROM users;
+INSERT INTO user_roles (user_id, role) SELECT id, 'admin' FROM users WHERE is_admin;
+
+ALTER TABLE users DROP COLUMN is_admin;
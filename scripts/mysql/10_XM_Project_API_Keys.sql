@@ -0,0 +1,19 @@
+USE xm_companies;
+
+-- API keys act as a user with a fixed set of permissions (scopes, comma separated).
+-- Only the SHA-256 of the key is stored, prefix identifies it in listings.
+CREATE TABLE api_keys (
+    id CHAR(36) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    prefix VARCHAR(16) NOT NULL,
+    key_hash CHAR(64) NOT NULL,
+    user_id CHAR(36) NOT NULL,
+    scopes VARCHAR(255) NOT NULL,
+    expires_at DATETIME(6) NULL,
+    created_at DATETIME(6) NOT NULL,
+    last_used_at DATETIME(6) NULL,
+    revoked_at DATETIME(6) NULL,
+    PRIMARY KEY (id),
+    UNIQUE INDEX idx_api_keys_hash (key_hash),
+    INDEX idx_api_keys_user (user_id)
+);
@@ -0,0 +1,13 @@
+USE xm_companies;
+
+-- Failed logins per email address (scope account) and per client IP (scope ip).
+-- blocked_until refuses further logins of the key until then, see the login protection settings.
+CREATE TABLE login_attempts (
+    scope VARCHAR(16) NOT NULL,
+    attempt_key VARCHAR(255) NOT NULL,
+    failures INT NOT NULL,
+    last_failure_at DATETIME(6) NOT NULL,
+    blocked_until DATETIME(6) NULL,
+    PRIMARY KEY (scope, attempt_key),
+    INDEX idx_login_attempts_last_failure (last_failure_at)
+);
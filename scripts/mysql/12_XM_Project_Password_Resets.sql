@@ -0,0 +1,14 @@
+USE xm_companies;
+
+-- Single-use password reset tokens mailed to users, only the SHA-256 of the token is stored.
+CREATE TABLE password_reset_tokens (
+    id CHAR(36) NOT NULL,
+    user_id CHAR(36) NOT NULL,
+    token_hash CHAR(64) NOT NULL,
+    expires_at DATETIME(6) NOT NULL,
+    created_at DATETIME(6) NOT NULL,
+    used_at DATETIME(6) NULL,
+    PRIMARY KEY (id),
+    UNIQUE INDEX idx_password_reset_tokens_hash (token_hash),
+    INDEX idx_password_reset_tokens_user (user_id)
+);
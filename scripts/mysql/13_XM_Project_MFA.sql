@@ -0,0 +1,43 @@
+USE xm_companies;
+
+-- TOTP secrets of users. confirmed_at stays NULL until the first code proves the authenticator
+-- app holds the secret; last_used_step keeps a code from being accepted twice.
+CREATE TABLE user_mfa (
+    user_id CHAR(36) NOT NULL,
+    secret VARCHAR(64) NOT NULL,
+    confirmed_at DATETIME(6) NULL,
+    last_used_step BIGINT NOT NULL DEFAULT 0,
+    created_at DATETIME(6) NOT NULL,
+    PRIMARY KEY (user_id)
+);
+
+-- Single-use recovery codes, only the SHA-256 of the code is stored.
+CREATE TABLE mfa_recovery_codes (
+    id CHAR(36) NOT NULL,
+    user_id CHAR(36) NOT NULL,
+    code_hash CHAR(64) NOT NULL,
+    created_at DATETIME(6) NOT NULL,
+    used_at DATETIME(6) NULL,
+    PRIMARY KEY (id),
+    UNIQUE INDEX idx_mfa_recovery_codes_user_hash (user_id, code_hash)
+);
+
+-- Logins whose password was right and that wait for the second factor.
+CREATE TABLE mfa_challenges (
+    id CHAR(36) NOT NULL,
+    user_id CHAR(36) NOT NULL,
+    token_hash CHAR(64) NOT NULL,
+    expires_at DATETIME(6) NOT NULL,
+    created_at DATETIME(6) NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    used_at DATETIME(6) NULL,
+    PRIMARY KEY (id),
+    UNIQUE INDEX idx_mfa_challenges_hash (token_hash),
+    INDEX idx_mfa_challenges_expires (expires_at)
+);
+
+-- Roles whose users must log in with a second factor.
+CREATE TABLE mfa_required_roles (
+    role VARCHAR(32) NOT NULL,
+    PRIMARY KEY (role)
+);
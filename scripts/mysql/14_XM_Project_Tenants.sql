@@ -0,0 +1,31 @@
+USE xm_companies;
+
+-- Business units sharing the deployment, each only sees its own companies and users
+CREATE TABLE tenants (
+    id CHAR(36) NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    updated_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    PRIMARY KEY (id),
+    UNIQUE INDEX idx_tenants_name (name)
+);
+
+-- Everything that existed so far belongs to the default tenant, see domain.DefaultTenantID
+INSERT INTO tenants (id, name) VALUES ('00000000-0000-0000-0000-000000000001', 'Default');
+
+ALTER TABLE users
+    ADD COLUMN tenant_id CHAR(36) NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' AFTER id,
+    ADD INDEX idx_users_tenant (tenant_id, id),
+    ADD CONSTRAINT fk_users_tenant FOREIGN KEY (tenant_id) REFERENCES tenants (id);
+ALTER TABLE users ALTER COLUMN tenant_id DROP DEFAULT;
+
+-- Company names are unique within a tenant, two tenants may each have an 'XM'
+ALTER TABLE companies
+    ADD COLUMN tenant_id CHAR(36) NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' AFTER id,
+    DROP INDEX name,
+    ADD UNIQUE INDEX idx_companies_tenant_name (tenant_id, name),
+    ADD CONSTRAINT fk_companies_tenant FOREIGN KEY (tenant_id) REFERENCES tenants (id);
+ALTER TABLE companies ALTER COLUMN tenant_id DROP DEFAULT;
+
+-- Operators manage the tenants, the existing admins run the deployment so they become operators
+INSERT INTO user_roles (user_id, role) SELECT user_id, 'operator' FROM user_roles WHERE role = 'admin';
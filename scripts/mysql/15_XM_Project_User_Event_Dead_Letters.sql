@@ -0,0 +1,6 @@
+USE xm_companies;
+
+-- User events that could not be published are kept next to the company events,
+-- the stream decides which topic a replay goes to
+ALTER TABLE company_event_dead_letters
+    ADD COLUMN stream VARCHAR(16) NOT NULL DEFAULT 'company' AFTER id;
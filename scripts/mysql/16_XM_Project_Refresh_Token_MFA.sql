@@ -0,0 +1,6 @@
+USE xm_companies;
+
+-- Refresh tokens remember whether their login passed a second factor, so a session started
+-- with the password alone ends once the user enrolls or a role starts to require MFA
+ALTER TABLE refresh_tokens
+    ADD COLUMN mfa_verified BOOLEAN NOT NULL DEFAULT FALSE AFTER revoked_at;